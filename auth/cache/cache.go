@@ -0,0 +1,182 @@
+// Package cache holds the in-memory caches the auth server keeps in front of its database. They are
+// safe for concurrent use and know nothing about clients or tokens, so other services can reuse them
+package cache
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type entry[V any] struct {
+	value     V
+	expiresAt time.Time    // zero when the cache has no TTL
+	pinned    bool         // kept until expiresAt whatever EvictLRU is asked for
+	usedAt    atomic.Int64 // unix nanoseconds of the last Get or Set, for EvictLRU
+}
+
+// TTL is a map whose entries expire ttl after they were last set. A TTL of zero or less keeps entries
+// until they are deleted. Expired entries are dropped on lookup and by CleanExpired
+type TTL[K comparable, V any] struct {
+	mu      sync.RWMutex
+	entries map[K]*entry[V]
+	ttl     atomic.Int64 // nanoseconds
+}
+
+// NewTTL returns an empty cache
+func NewTTL[K comparable, V any](ttl time.Duration) *TTL[K, V] {
+	c := &TTL[K, V]{entries: make(map[K]*entry[V])}
+	c.ttl.Store(int64(ttl))
+	return c
+}
+
+// TTL returns how long entries live
+func (c *TTL[K, V]) TTL() time.Duration {
+	return time.Duration(c.ttl.Load())
+}
+
+// SetTTL changes how long entries set from now on live. Entries already cached keep their expiry
+func (c *TTL[K, V]) SetTTL(ttl time.Duration) {
+	c.ttl.Store(int64(ttl))
+}
+
+// Get returns the value cached for key, if any and not expired
+func (c *TTL[K, V]) Get(key K) (V, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	now := time.Now()
+	if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+		c.mu.Lock()
+		// Only drop it if it wasn't set again meanwhile
+		if current, ok := c.entries[key]; ok && current == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		var zero V
+		return zero, false
+	}
+	e.usedAt.Store(now.UnixNano())
+	return e.value, true
+}
+
+// Set caches value under key, restarting its TTL
+func (c *TTL[K, V]) Set(key K, value V) {
+	now := time.Now()
+	e := &entry[V]{value: value}
+	if ttl := c.TTL(); ttl > 0 {
+		e.expiresAt = now.Add(ttl)
+	}
+	e.usedAt.Store(now.UnixNano())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+}
+
+// Pin makes key live until until instead of its TTL and exempts it from EvictLRU, for entries the cache
+// is the only copy of. Setting key again unpins it. It reports whether key was cached
+func (c *TTL[K, V]) Pin(key K, until time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	// Get reads expiresAt without the lock, so the entry is replaced rather than changed
+	pinned := &entry[V]{value: e.value, expiresAt: until, pinned: true}
+	pinned.usedAt.Store(e.usedAt.Load())
+	c.entries[key] = pinned
+	return true
+}
+
+// Delete removes key, reporting whether it was cached
+func (c *TTL[K, V]) Delete(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	delete(c.entries, key)
+	return ok
+}
+
+// Update calls fn for every entry under the write lock. fn returns the value to keep, which doesn't
+// restart its TTL, or false to delete the entry. fn must not call back into the cache
+func (c *TTL[K, V]) Update(fn func(key K, value V) (V, bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		value, keep := fn(key, e.value)
+		if !keep {
+			delete(c.entries, key)
+			continue
+		}
+		e.value = value
+	}
+}
+
+// Clear removes every entry and returns how many there were
+func (c *TTL[K, V]) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clearLocked()
+}
+
+func (c *TTL[K, V]) clearLocked() int {
+	n := len(c.entries)
+	c.entries = make(map[K]*entry[V])
+	return n
+}
+
+// Len returns the number of entries, including expired ones not yet cleaned
+func (c *TTL[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// CleanExpired removes expired entries and returns how many were removed
+func (c *TTL[K, V]) CleanExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	now := time.Now()
+	for key, e := range c.entries {
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// EvictLRU removes up to n of the least recently used entries, those longest without a Get or Set,
+// and returns how many were removed. Pinned entries are never evicted
+func (c *TTL[K, V]) EvictLRU(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type use struct {
+		key    K
+		usedAt int64
+	}
+	uses := make([]use, 0, len(c.entries))
+	for key, e := range c.entries {
+		if !e.pinned {
+			uses = append(uses, use{key: key, usedAt: e.usedAt.Load()})
+		}
+	}
+	n = min(n, len(uses))
+	slices.SortFunc(uses, func(a, b use) int { return cmp.Compare(a.usedAt, b.usedAt) })
+	for _, u := range uses[:n] {
+		delete(c.entries, u.key)
+	}
+	return n
+}
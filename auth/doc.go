@@ -0,0 +1,10 @@
+// Package auth holds no code of its own; the token server is split into packages under it:
+//
+//   - auth/server: the token server, its configuration, handlers and maintenance commands
+//   - auth/store: the Store interface and the client, endpoint and token models it exchanges
+//   - auth/cache: concurrent TTL caches
+//   - auth/metrics: the Prometheus registry, registering each metric once by name
+//   - auth/middleware: security headers, HTTPS redirect and per-client rate limiting
+//
+// Every package but auth/server can be imported on its own by other services
+package auth
@@ -1,4 +1,7 @@
-package auth
+// Package metrics is the auth server's Prometheus registry. Metrics are registered once by name:
+// registering a name again returns the existing collector, so components can register what they
+// use without coordinating
+package metrics
 
 import (
 	"fmt"
@@ -8,10 +11,13 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-const metricNamespace = "auth_server"
+// Namespace prefixes the vector metrics registered without one
+const Namespace = "auth_server"
 
-type globalMetricCollector struct {
+// Collector is a Prometheus registry that remembers what it registered by name
+type Collector struct {
 	reg             *prometheus.Registry
+	registerer      prometheus.Registerer // reg, adding the constant labels to every metric
 	gaugeMap        map[string]prometheus.Gauge
 	counterMap      map[string]prometheus.Counter
 	histogramMap    map[string]prometheus.Histogram
@@ -22,15 +28,24 @@ type globalMetricCollector struct {
 }
 
 var (
-	once sync.Once
-	reg  *globalMetricCollector
+	once        sync.Once
+	reg         *Collector
+	constLabels prometheus.Labels
 )
 
-func getMetricCollector() *globalMetricCollector {
+// SetConstLabels sets labels added to every metric. It only has an effect before the first metric
+// is registered
+func SetConstLabels(labels prometheus.Labels) {
+	constLabels = labels
+}
+
+// Default returns the process-wide collector
+func Default() *Collector {
 	once.Do(func() {
 		if reg == nil {
-			reg = new(globalMetricCollector)
+			reg = new(Collector)
 			reg.reg = prometheus.NewRegistry()
+			reg.registerer = prometheus.WrapRegistererWith(constLabels, reg.reg)
 			reg.gaugeMap = make(map[string]prometheus.Gauge)
 			reg.counterMap = make(map[string]prometheus.Counter)
 			reg.histogramMap = make(map[string]prometheus.Histogram)
@@ -44,12 +59,14 @@ func getMetricCollector() *globalMetricCollector {
 	return reg
 }
 
-func getMetricRegistry() *prometheus.Registry {
-	return getMetricCollector().reg
+// Registry returns the registry to serve, e.g. with promhttp.HandlerFor
+func Registry() *prometheus.Registry {
+	return Default().reg
 }
 
-func RegisterGaugeMetric(name, help, namespace string) (prometheus.Gauge, error) {
-	reg := getMetricCollector()
+// RegisterGauge registers a gauge, or returns the one already registered under name
+func RegisterGauge(name, help, namespace string) (prometheus.Gauge, error) {
+	reg := Default()
 	reg.lock.Lock()
 	defer reg.lock.Unlock()
 
@@ -63,7 +80,7 @@ func RegisterGaugeMetric(name, help, namespace string) (prometheus.Gauge, error)
 		Help:      help,
 		Namespace: namespace,
 	})
-	if err := reg.reg.Register(v); err != nil {
+	if err := reg.registerer.Register(v); err != nil {
 		return nil, fmt.Errorf("failed to register gauge metric: %w", err)
 	}
 	reg.gaugeMap[name] = v
@@ -71,8 +88,9 @@ func RegisterGaugeMetric(name, help, namespace string) (prometheus.Gauge, error)
 	return v, nil
 }
 
-func RegisterCounterMetric(name, help, namespace string) (prometheus.Counter, error) {
-	reg := getMetricCollector()
+// RegisterCounter registers a counter, or returns the one already registered under name
+func RegisterCounter(name, help, namespace string) (prometheus.Counter, error) {
+	reg := Default()
 	reg.lock.Lock()
 	defer reg.lock.Unlock()
 
@@ -86,7 +104,7 @@ func RegisterCounterMetric(name, help, namespace string) (prometheus.Counter, er
 		Help:      help,
 		Namespace: namespace,
 	})
-	if err := reg.reg.Register(v); err != nil {
+	if err := reg.registerer.Register(v); err != nil {
 		return nil, fmt.Errorf("failed to register counter metric: %w", err)
 	}
 	reg.counterMap[name] = v
@@ -94,8 +112,9 @@ func RegisterCounterMetric(name, help, namespace string) (prometheus.Counter, er
 	return v, nil
 }
 
-func RegisterHistogramMetric(name, help, namespace string, buckets []float64) (prometheus.Histogram, error) {
-	reg := getMetricCollector()
+// RegisterHistogram registers a histogram, or returns the one already registered under name
+func RegisterHistogram(name, help, namespace string, buckets []float64) (prometheus.Histogram, error) {
+	reg := Default()
 	reg.lock.Lock()
 	defer reg.lock.Unlock()
 
@@ -110,7 +129,7 @@ func RegisterHistogramMetric(name, help, namespace string, buckets []float64) (p
 		Namespace: namespace,
 		Buckets:   buckets,
 	})
-	if err := reg.reg.Register(v); err != nil {
+	if err := reg.registerer.Register(v); err != nil {
 		return nil, fmt.Errorf("failed to register histogram metric: %w", err)
 	}
 	reg.histogramMap[name] = v
@@ -118,8 +137,10 @@ func RegisterHistogramMetric(name, help, namespace string, buckets []float64) (p
 	return v, nil
 }
 
-func registerGaugeVecMetric(name, help, namespace string, labels []string) (*prometheus.GaugeVec, error) {
-	reg := getMetricCollector()
+// RegisterGaugeVec registers a gauge vector, or returns the one already registered under name. An
+// empty namespace means Namespace
+func RegisterGaugeVec(name, help, namespace string, labels []string) (*prometheus.GaugeVec, error) {
+	reg := Default()
 	reg.lock.Lock()
 	defer reg.lock.Unlock()
 
@@ -129,7 +150,7 @@ func registerGaugeVecMetric(name, help, namespace string, labels []string) (*pro
 	}
 
 	if namespace == "" {
-		namespace = metricNamespace
+		namespace = Namespace
 	}
 
 	v := prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -138,7 +159,7 @@ func registerGaugeVecMetric(name, help, namespace string, labels []string) (*pro
 		Namespace: namespace,
 	}, labels)
 
-	if err := reg.reg.Register(v); err != nil {
+	if err := reg.registerer.Register(v); err != nil {
 		log.Error().Err(err).Str("metric", name).Msg("Failed to register gauge vector metric")
 		return nil, fmt.Errorf("failed to register gauge vec metric '%s': %w", name, err)
 	}
@@ -148,8 +169,10 @@ func registerGaugeVecMetric(name, help, namespace string, labels []string) (*pro
 	return v, nil
 }
 
-func registerCounterVecMetric(name, help, namespace string, labels []string) (*prometheus.CounterVec, error) {
-	reg := getMetricCollector()
+// RegisterCounterVec registers a counter vector, or returns the one already registered under name. An
+// empty namespace means Namespace
+func RegisterCounterVec(name, help, namespace string, labels []string) (*prometheus.CounterVec, error) {
+	reg := Default()
 	reg.lock.Lock()
 	defer reg.lock.Unlock()
 
@@ -159,7 +182,7 @@ func registerCounterVecMetric(name, help, namespace string, labels []string) (*p
 	}
 
 	if namespace == "" {
-		namespace = metricNamespace
+		namespace = Namespace
 	}
 
 	v := prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -168,7 +191,7 @@ func registerCounterVecMetric(name, help, namespace string, labels []string) (*p
 		Namespace: namespace,
 	}, labels)
 
-	if err := reg.reg.Register(v); err != nil {
+	if err := reg.registerer.Register(v); err != nil {
 		log.Error().Err(err).Str("metric", name).Msg("Failed to register counter vector metric")
 		return nil, fmt.Errorf("failed to register counter vec metric '%s': %w", name, err)
 	}
@@ -178,8 +201,10 @@ func registerCounterVecMetric(name, help, namespace string, labels []string) (*p
 	return v, nil
 }
 
-func registerHistogramVecMetric(name, help, namespace string, buckets []float64, labels []string) (*prometheus.HistogramVec, error) {
-	reg := getMetricCollector()
+// RegisterHistogramVec registers a histogram vector, or returns the one already registered under
+// name. An empty namespace means Namespace
+func RegisterHistogramVec(name, help, namespace string, buckets []float64, labels []string) (*prometheus.HistogramVec, error) {
+	reg := Default()
 	reg.lock.Lock()
 	defer reg.lock.Unlock()
 
@@ -189,7 +214,7 @@ func registerHistogramVecMetric(name, help, namespace string, buckets []float64,
 	}
 
 	if namespace == "" {
-		namespace = metricNamespace
+		namespace = Namespace
 	}
 
 	v := prometheus.NewHistogramVec(prometheus.HistogramOpts{
@@ -199,7 +224,7 @@ func registerHistogramVecMetric(name, help, namespace string, buckets []float64,
 		Buckets:   buckets,
 	}, labels)
 
-	if err := reg.reg.Register(v); err != nil {
+	if err := reg.registerer.Register(v); err != nil {
 		log.Error().Err(err).Str("metric", name).Msg("Failed to register histogram vector metric")
 		return nil, fmt.Errorf("failed to register histogram vec metric '%s': %w", name, err)
 	}
@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// SECURITY FIX: Rate limiting to prevent DDoS and brute force attacks
+
+// RateLimiter keeps a token bucket per client
+type RateLimiter struct {
+	clients     map[string]*rate.Limiter
+	mu          sync.RWMutex
+	ticker      *time.Ticker
+	done        chan bool
+	clientRPS   int
+	clientBurst int
+}
+
+// NewRateLimiter creates a new rate limiter with specified per-client limits
+func NewRateLimiter(clientRPS int, clientBurst int) *RateLimiter {
+	rl := &RateLimiter{
+		clients:     make(map[string]*rate.Limiter),
+		done:        make(chan bool),
+		clientRPS:   clientRPS,
+		clientBurst: clientBurst,
+	}
+
+	// Clean up old limiters every 10 minutes
+	rl.ticker = time.NewTicker(10 * time.Minute)
+	go rl.cleanupOldClients()
+
+	return rl
+}
+
+// cleanupOldClients removes client limiters that haven't been used recently
+func (rl *RateLimiter) cleanupOldClients() {
+	for range rl.ticker.C {
+		rl.mu.Lock()
+		for clientID := range rl.clients {
+			// Keep removing old entries to prevent unbounded memory growth
+			if len(rl.clients) > 1000 {
+				delete(rl.clients, clientID)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// Stop stops the rate limiter cleanup goroutine
+func (rl *RateLimiter) Stop() {
+	rl.ticker.Stop()
+	close(rl.done)
+}
+
+// Limiter gets or creates a rate limiter for a client based on configured limits
+func (rl *RateLimiter) Limiter(clientID string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	limiter, exists := rl.clients[clientID]
+	if !exists {
+		// Create limiter with configured RPS and burst values
+		limiter = rate.NewLimiter(rate.Limit(rl.clientRPS), rl.clientBurst)
+		rl.clients[clientID] = limiter
+	}
+	return limiter
+}
+
+// SetLimits changes the per-client limits, including for clients already being limited, whose
+// buckets keep the tokens they have left
+func (rl *RateLimiter) SetLimits(clientRPS int, clientBurst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.clientRPS = clientRPS
+	rl.clientBurst = clientBurst
+	for _, limiter := range rl.clients {
+		limiter.SetLimit(rate.Limit(clientRPS))
+		limiter.SetBurst(clientBurst)
+	}
+}
+
+// Snapshot returns the clients whose buckets are not full at now
+func (rl *RateLimiter) Snapshot(now time.Time) []BucketState {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return DepletedBuckets(rl.clients, now)
+}
+
+// Restore recreates saved buckets, refilled for the time since they were saved. Clients already
+// seen by this limiter keep their current bucket
+func (rl *RateLimiter) Restore(states []BucketState, now time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for _, state := range states {
+		if _, exists := rl.clients[state.Key]; exists {
+			continue
+		}
+		limiter := rate.NewLimiter(rate.Limit(rl.clientRPS), rl.clientBurst)
+		RestoreBucket(limiter, state, now)
+		rl.clients[state.Key] = limiter
+	}
+}
+
+// BucketState is one client's token bucket at a point in time, so budgets can outlive a restart
+type BucketState struct {
+	Key    string    `json:"-"`
+	Tokens float64   `json:"tokens"`
+	At     time.Time `json:"at"`
+	FullAt time.Time `json:"-"` // when the bucket will have refilled; after that it is the same as a new one
+}
+
+// DepletedBuckets returns the limiters below their burst at now. Full buckets are left out since a
+// new limiter starts full anyway
+func DepletedBuckets(limiters map[string]*rate.Limiter, now time.Time) []BucketState {
+	var states []BucketState
+	for key, limiter := range limiters {
+		tokens := limiter.TokensAt(now)
+		missing := float64(limiter.Burst()) - tokens
+		if missing <= 0 || limiter.Limit() <= 0 {
+			continue
+		}
+		refill := time.Duration(missing / float64(limiter.Limit()) * float64(time.Second))
+		states = append(states, BucketState{Key: key, Tokens: tokens, At: now, FullAt: now.Add(refill)})
+	}
+	return states
+}
+
+// RestoreBucket spends from a new limiter until it holds what state held, plus what it has refilled
+// since. Rounding goes against the client
+func RestoreBucket(limiter *rate.Limiter, state BucketState, now time.Time) {
+	tokens := state.Tokens + now.Sub(state.At).Seconds()*float64(limiter.Limit())
+	spend := math.Ceil(float64(limiter.Burst()) - tokens)
+	if spend < 1 {
+		return
+	}
+	limiter.ReserveN(now, min(int(spend), limiter.Burst()))
+}
+
+// GlobalRateLimit applies global rate limiting (100 req/s global)
+func GlobalRateLimit(globalLimiter *rate.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !globalLimiter.Allow() {
+			log.Warn().
+				Str("client_ip", c.ClientIP()).
+				Msg("Global rate limit exceeded")
+			rejectRateLimited(c, "Too many requests. Please try again later.")
+			return
+		}
+		c.Next()
+	}
+}
+
+// PerClientRateLimit applies per-client rate limiting (10 req/s per client)
+func PerClientRateLimit(rl *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Extract client ID from query parameters first (doesn't consume body)
+		clientID := c.Query("client_id")
+
+		// If not in query, try to extract from Authorization header (X-Client-ID)
+		if clientID == "" {
+			clientID = c.GetHeader("X-Client-ID")
+		}
+
+		// Fallback to IP address if no client_id found in request
+		if clientID == "" {
+			clientID = c.ClientIP()
+		}
+
+		limiter := rl.Limiter(clientID)
+		if !limiter.Allow() {
+			log.Warn().
+				Str("client_id", clientID).
+				Msg("Per-client rate limit exceeded")
+			rejectRateLimited(c, "Too many requests from this client. Please try again later.")
+			return
+		}
+		c.Next()
+	}
+}
+
+// rejectRateLimited answers 429 with the API's error body, carrying the request ID the logging
+// middleware assigned so the caller can quote it
+func rejectRateLimited(c *gin.Context, description string) {
+	body := gin.H{
+		"error":             "rate_limit_exceeded",
+		"error_description": description,
+	}
+	if requestID := c.GetString("request_id"); requestID != "" {
+		body["request_id"] = requestID
+	}
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, body)
+}
@@ -1,12 +1,14 @@
-package auth
+// Package middleware holds the gin middleware of the auth server that doesn't depend on its
+// configuration or database, so other services can put the same protections in front of their routes
+package middleware
 
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
 
-// SecurityHeadersMiddleware adds security headers to all responses
-func SecurityHeadersMiddleware() gin.HandlerFunc {
+// SecurityHeaders adds security headers to all responses
+func SecurityHeaders() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// HSTS - HTTP Strict-Transport-Security
 		// Tells browsers to only use HTTPS for this domain for max-age seconds
@@ -42,8 +44,8 @@ func SecurityHeadersMiddleware() gin.HandlerFunc {
 	}
 }
 
-// TLSRedirectMiddleware redirects HTTP requests to HTTPS on non-metrics routes
-func TLSRedirectMiddleware() gin.HandlerFunc {
+// TLSRedirect redirects HTTP requests to HTTPS on non-metrics routes while httpsEnabled
+func TLSRedirect(httpsEnabled bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Don't redirect metrics endpoint (runs on separate port)
 		if c.Request.Host == "localhost:7071" || c.Request.Host == "127.0.0.1:7071" {
@@ -52,7 +54,7 @@ func TLSRedirectMiddleware() gin.HandlerFunc {
 		}
 
 		// If not already HTTPS and HTTPS is enabled
-		if !AppConfig.HTTPSEnabled {
+		if !httpsEnabled {
 			c.Next()
 			return
 		}
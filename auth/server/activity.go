@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds on the activity tracker and the activity view
+const (
+	maxActivityClients   = 10000 // beyond this the least recently active client is dropped
+	maxActivityIPs       = 20    // per client; the least recently seen address is dropped
+	activityUsageDays    = 7     // daily usage included in the activity view
+	activityRecentTokens = 10    // latest stored tokens included in the activity view
+)
+
+// SeenIP is an address a client requested tokens from
+type SeenIP struct {
+	IP        string    `json:"ip"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Requests  int64     `json:"requests"`
+}
+
+// ClientActivity summarizes a client's recent activity for support investigations. The token store
+// and daily usage cover every instance: active and recent tokens, with when and why they were
+// revoked, and the issued, validated, denied and revoked counts the audit events are counted into.
+// Validation and failure timestamps, failed authentication and IPs are only this instance's
+// observations since TrackedSince
+type ClientActivity struct {
+	ClientID          string          `json:"client_id"`
+	TrackedSince      time.Time       `json:"tracked_since"`
+	LastTokenIssuedAt *time.Time      `json:"last_token_issued_at,omitempty"` // latest of the stored tokens and this instance's
+	LastValidatedAt   *time.Time      `json:"last_validated_at,omitempty"`
+	LastFailureAt     *time.Time      `json:"last_failure_at,omitempty"`
+	FailedAuth        int64           `json:"failed_auth"`        // token requests rejected for bad credentials
+	DeniedValidations int64           `json:"denied_validations"` // validations of its tokens refused for scope
+	IPs               []SeenIP        `json:"ips"`                // addresses the client requested tokens from, most recent first
+	ActiveTokens      int64           `json:"active_tokens"`
+	RecentTokens      []*LineageToken `json:"recent_tokens"` // the last activityRecentTokens tokens issued, newest first
+	Usage             []*ClientUsage  `json:"usage"`         // daily counters for the last activityUsageDays days
+}
+
+type clientActivityRecord struct {
+	lastSeen      time.Time // of any activity, to evict the least recently active client
+	lastIssued    time.Time
+	lastValidated time.Time
+	lastFailure   time.Time
+	failedAuth    int64
+	denied        int64
+	ips           map[string]*SeenIP
+}
+
+// clientActivityTracker keeps the in-memory part of each client's activity view. Callers only report
+// registered client IDs
+type clientActivityTracker struct {
+	mu      sync.Mutex
+	started time.Time
+	clients map[string]*clientActivityRecord
+}
+
+func newClientActivityTracker() *clientActivityTracker {
+	return &clientActivityTracker{
+		started: time.Now(),
+		clients: make(map[string]*clientActivityRecord),
+	}
+}
+
+// record applies update to clientID's record, creating it if needed
+func (at *clientActivityTracker) record(clientID string, update func(*clientActivityRecord, time.Time)) {
+	if at == nil || clientID == "" {
+		return
+	}
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	record, exists := at.clients[clientID]
+	if !exists {
+		if len(at.clients) >= maxActivityClients {
+			at.evictLeastRecent()
+		}
+		record = &clientActivityRecord{ips: make(map[string]*SeenIP)}
+		at.clients[clientID] = record
+	}
+	now := time.Now()
+	record.lastSeen = now
+	update(record, now)
+}
+
+// evictLeastRecent forgets the client whose last activity is oldest
+func (at *clientActivityTracker) evictLeastRecent() {
+	var oldestID string
+	var oldest time.Time
+	for clientID, record := range at.clients {
+		if oldestID == "" || record.lastSeen.Before(oldest) {
+			oldestID, oldest = clientID, record.lastSeen
+		}
+	}
+	delete(at.clients, oldestID)
+}
+
+func (r *clientActivityRecord) seen(ip string, now time.Time) {
+	if ip == "" {
+		return
+	}
+	if seen, exists := r.ips[ip]; exists {
+		seen.LastSeen = now
+		seen.Requests++
+		return
+	}
+	if len(r.ips) >= maxActivityIPs {
+		var oldest *SeenIP
+		for _, seen := range r.ips {
+			if oldest == nil || seen.LastSeen.Before(oldest.LastSeen) {
+				oldest = seen
+			}
+		}
+		delete(r.ips, oldest.IP)
+	}
+	r.ips[ip] = &SeenIP{IP: ip, FirstSeen: now, LastSeen: now, Requests: 1}
+}
+
+func (at *clientActivityTracker) TokenIssued(clientID, ip string) {
+	at.record(clientID, func(r *clientActivityRecord, now time.Time) {
+		r.lastIssued = now
+		r.seen(ip, now)
+	})
+}
+
+func (at *clientActivityTracker) AuthFailed(clientID, ip string) {
+	at.record(clientID, func(r *clientActivityRecord, now time.Time) {
+		r.failedAuth++
+		r.lastFailure = now
+		r.seen(ip, now)
+	})
+}
+
+func (at *clientActivityTracker) Validated(clientID string) {
+	at.record(clientID, func(r *clientActivityRecord, now time.Time) { r.lastValidated = now })
+}
+
+func (at *clientActivityTracker) Denied(clientID string) {
+	at.record(clientID, func(r *clientActivityRecord, now time.Time) {
+		r.lastValidated = now
+		r.lastFailure = now
+		r.denied++
+	})
+}
+
+// Snapshot returns the tracked part of clientID's activity
+func (at *clientActivityTracker) Snapshot(clientID string) ClientActivity {
+	activity := ClientActivity{ClientID: clientID, IPs: make([]SeenIP, 0)}
+	if at == nil {
+		return activity
+	}
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	activity.TrackedSince = at.started
+	record, exists := at.clients[clientID]
+	if !exists {
+		return activity
+	}
+	activity.LastTokenIssuedAt = timePtr(record.lastIssued)
+	activity.LastValidatedAt = timePtr(record.lastValidated)
+	activity.LastFailureAt = timePtr(record.lastFailure)
+	activity.FailedAuth = record.failedAuth
+	activity.DeniedValidations = record.denied
+	for _, seen := range record.ips {
+		activity.IPs = append(activity.IPs, *seen)
+	}
+	slices.SortFunc(activity.IPs, func(a, b SeenIP) int { return b.LastSeen.Compare(a.LastSeen) })
+	return activity
+}
+
+// timePtr returns nil for the zero time so unset timestamps are omitted
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// activeTokenCount counts clientID's unrevoked, unexpired tokens
+func (as *authServer) activeTokenCount(clientID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	var count int64
+	query := "SELECT COUNT(*) FROM tokens WHERE client_id = :1 AND revoked = 0 AND expires_at > :2"
+	err := as.db.QueryRowContext(ctx, query, clientID, time.Now()).Scan(&count)
+	return count, err
+}
+
+// Client activity handler (admin): recent activity of one client
+func (as *authServer) clientActivityHandler(c *gin.Context) {
+	clientID := c.Param("client_id")
+	if _, err := as.clientByID(c.Request.Context(), clientID); err != nil {
+		RespondWithError(c, ErrNotFoundError("Client not found").WithOriginalError(err))
+		return
+	}
+
+	activity := as.activity.Snapshot(clientID)
+
+	// Include tokens still queued for the batch writer
+	if err := as.tokenBatcher.FlushNow(); err != nil {
+		logger := GetRequestLogger(c)
+		logger.Warn().Err(err).Msg("Failed to write pending tokens before reading client activity")
+	}
+	var err error
+	if activity.ActiveTokens, err = as.activeTokenCount(clientID); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	if activity.RecentTokens, err = as.recentTokens(c.Request.Context(), clientID, activityRecentTokens); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	// Another instance may have issued a token since this one last did
+	if len(activity.RecentTokens) > 0 {
+		if issuedAt := activity.RecentTokens[0].IssuedAt; activity.LastTokenIssuedAt == nil || issuedAt.After(*activity.LastTokenIssuedAt) {
+			activity.LastTokenIssuedAt = &issuedAt
+		}
+	}
+
+	// Include counters still buffered in memory
+	as.usage.Flush()
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if activity.Usage, err = as.clientUsage(to.AddDate(0, 0, -(activityUsageDays-1)), to, clientID); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	c.JSON(http.StatusOK, activity)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// adminListenAddress is where the internal admin listener binds. Without admin.listen_address it
+// takes over the metrics port so existing scrape configs keep working
+func adminListenAddress() string {
+	if AppConfig.Admin.ListenAddress != "" {
+		return AppConfig.Admin.ListenAddress
+	}
+	return ":" + strconv.Itoa(AppConfig.MetricPort)
+}
+
+// AdminNetworkMiddleware rejects connections to the internal listener from outside admin.allowed_networks.
+// The TCP peer address is used rather than forwarded headers, which callers could forge
+func AdminNetworkMiddleware(cidrs []string) gin.HandlerFunc {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Error().Err(err).Str("cidr", cidr).Msg("Invalid admin allowed network, skipping")
+			continue
+		}
+		networks = append(networks, network)
+	}
+
+	return func(c *gin.Context) {
+		if len(cidrs) == 0 {
+			c.Next()
+			return
+		}
+		ip := net.ParseIP(c.RemoteIP())
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				c.Next()
+				return
+			}
+		}
+		RespondWithError(c, ErrForbiddenError("Admin listener is not reachable from this network"))
+		c.Abort()
+	}
+}
+
+// AdminAuthMiddleware restricts admin routes to callers presenting the configured admin token or,
+// with admin.allow_role_tokens, an access token whose roles allow the route
+func (as *authServer) AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !adminEnabled() {
+			// Admin API is disabled until a credential is configured
+			RespondWithError(c, ErrForbiddenError("Admin API is disabled"))
+			c.Abort()
+			return
+		}
+
+		provided := c.GetHeader("X-Admin-Token")
+		if authHeader := c.GetHeader("Authorization"); provided == "" && authHeader != "" && AppConfig.Admin.AllowRoleTokens {
+			if apiErr := as.authenticateAdminToken(c, authHeader); apiErr != nil {
+				RespondWithError(c, apiErr)
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		expected := AppConfig.Admin.Token
+		if expected == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			RespondWithError(c, ErrUnauthorizedError("Invalid admin token"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Auth event types exported to the analytics warehouse
+const (
+	AuthEventTokenIssued       = "token_issued"
+	AuthEventAuthFailed        = "auth_failed"
+	AuthEventValidationAllowed = "validation_allowed"
+	AuthEventValidationDenied  = "validation_denied"
+	AuthEventTokenRevoked      = "token_revoked"
+)
+
+// Outcomes counted by analytics_events_total
+const (
+	analyticsResultExported  = "exported"
+	analyticsResultQueueFull = "dropped_queue_full"   // the queue was full when the event was recorded
+	analyticsResultFailed    = "dropped_export_error" // the sink kept failing and the batch was given up
+)
+
+// AuthEvent is one row in the analytics warehouse
+type AuthEvent struct {
+	EventID   string    `json:"event_id"` // lets sinks deduplicate retried batches
+	Time      time.Time `json:"event_time"`
+	Type      string    `json:"event_type"`
+	ClientID  string    `json:"client_id"`
+	TokenID   string    `json:"token_id"`
+	TokenType string    `json:"token_type"`
+	IP        string    `json:"ip"`
+	Region    string    `json:"region"` // of the instance that saw the event
+	Zone      string    `json:"zone"`
+}
+
+// analyticsSink writes batches of auth events to a warehouse
+type analyticsSink interface {
+	Name() string
+	EnsureSchema(ctx context.Context) error // creates the events table if it does not exist
+	Write(ctx context.Context, events []AuthEvent) error
+}
+
+// analyticsExporter batches auth events into an analytics sink in the background. Request paths only
+// ever do a non-blocking send: when the sink falls behind, the bounded queue fills and further events
+// are dropped and counted rather than slowing down issuance or validation
+type analyticsExporter struct {
+	sink     analyticsSink
+	cfg      analytics
+	events   chan AuthEvent
+	done     chan struct{}
+	stopped  chan struct{}
+	counts   *prometheus.CounterVec // analytics_events_total by sink and result
+	schemaOK bool                   // only touched by the export goroutine
+}
+
+func newAnalyticsExporter(cfg analytics) *analyticsExporter {
+	if !cfg.Enabled {
+		return nil
+	}
+	var sink analyticsSink
+	var err error
+	switch cfg.Sink {
+	case "clickhouse":
+		sink, err = newClickHouseSink(cfg.ClickHouse)
+	case "bigquery":
+		sink, err = newBigQuerySink(cfg.BigQuery)
+	default:
+		err = fmt.Errorf("unknown sink %q, expected clickhouse or bigquery", cfg.Sink)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("analytics exporter disabled")
+		return nil
+	}
+	return newAnalyticsExporterWithSink(sink, cfg)
+}
+
+func newAnalyticsExporterWithSink(sink analyticsSink, cfg analytics) *analyticsExporter {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushIntervalMs <= 0 {
+		cfg.FlushIntervalMs = 5000
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	return &analyticsExporter{
+		sink:    sink,
+		cfg:     cfg,
+		events:  make(chan AuthEvent, cfg.QueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start exports in the background until Stop is called. Metrics must be registered first
+func (ae *analyticsExporter) Start(counts *prometheus.CounterVec) {
+	if ae == nil {
+		return
+	}
+	ae.counts = counts
+	go ae.run()
+}
+
+// Stop exports whatever is still queued and waits for the exporter to finish
+func (ae *analyticsExporter) Stop() {
+	if ae == nil {
+		return
+	}
+	close(ae.done)
+	<-ae.stopped
+}
+
+// Record queues an event for export without blocking
+func (ae *analyticsExporter) Record(event AuthEvent) {
+	if ae == nil {
+		return
+	}
+	if event.EventID == "" {
+		event.EventID = uuid.NewString()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.Region == "" {
+		event.Region, event.Zone = AppConfig.Region.Name, AppConfig.Region.Zone
+	}
+	select {
+	case ae.events <- event:
+	default:
+		ae.count(analyticsResultQueueFull, 1)
+	}
+}
+
+func (ae *analyticsExporter) count(result string, n int) {
+	if ae.counts != nil {
+		ae.counts.WithLabelValues(ae.sink.Name(), result).Add(float64(n))
+	}
+}
+
+func (ae *analyticsExporter) run() {
+	defer close(ae.stopped)
+	ticker := time.NewTicker(time.Duration(ae.cfg.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	batch := make([]AuthEvent, 0, ae.cfg.BatchSize)
+	for {
+		select {
+		case event := <-ae.events:
+			batch = append(batch, event)
+			if len(batch) >= ae.cfg.BatchSize {
+				ae.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				ae.export(batch)
+				batch = batch[:0]
+			}
+		case <-ae.done:
+			for {
+				select {
+				case event := <-ae.events:
+					batch = append(batch, event)
+					if len(batch) >= ae.cfg.BatchSize {
+						ae.export(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						ae.export(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// export writes one batch, retrying with exponential backoff before giving it up
+func (ae *analyticsExporter) export(batch []AuthEvent) {
+	backoff := 200 * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= ae.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ae.done:
+				// Shutting down: one last attempt without waiting
+			}
+			backoff *= 2
+		}
+		if err = ae.write(batch); err == nil {
+			ae.count(analyticsResultExported, len(batch))
+			return
+		}
+		log.Warn().Err(err).Str("sink", ae.sink.Name()).Int("batch_size", len(batch)).Int("attempt", attempt+1).Msg("Analytics export failed")
+	}
+	log.Error().Err(err).Str("sink", ae.sink.Name()).Int("batch_size", len(batch)).Msg("Dropping analytics batch after repeated failures")
+	ae.count(analyticsResultFailed, len(batch))
+}
+
+func (ae *analyticsExporter) write(batch []AuthEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if !ae.schemaOK {
+		if err := ae.sink.EnsureSchema(ctx); err != nil {
+			return fmt.Errorf("ensuring schema: %w", err)
+		}
+		ae.schemaOK = true
+	}
+	return ae.sink.Write(ctx, batch)
+}
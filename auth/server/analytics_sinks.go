@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warehouseIdentifier guards database, dataset and table names interpolated into DDL and URLs
+var warehouseIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// doWarehouseRequest sends a request and turns non-2xx answers into errors carrying the response body
+func doWarehouseRequest(client *http.Client, req *http.Request) ([]byte, int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		return body, resp.StatusCode, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, resp.StatusCode, nil
+}
+
+// clickHouseSink inserts events over ClickHouse's HTTP interface using JSONEachRow
+type clickHouseSink struct {
+	cfg    analytics_clickhouse
+	client *http.Client
+}
+
+func newClickHouseSink(cfg analytics_clickhouse) (*clickHouseSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("analytics.clickhouse.url is required")
+	}
+	if cfg.Database == "" {
+		cfg.Database = "default"
+	}
+	if cfg.Table == "" {
+		cfg.Table = "auth_events"
+	}
+	if !warehouseIdentifier.MatchString(cfg.Database) || !warehouseIdentifier.MatchString(cfg.Table) {
+		return nil, fmt.Errorf("analytics.clickhouse database and table must be plain identifiers")
+	}
+	return &clickHouseSink{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (s *clickHouseSink) Name() string { return "clickhouse" }
+
+func (s *clickHouseSink) query(ctx context.Context, query string, body []byte) error {
+	params := url.Values{"query": {query}, "date_time_input_format": {"best_effort"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.cfg.URL, "/")+"/?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if s.cfg.User != "" {
+		req.Header.Set("X-ClickHouse-User", s.cfg.User)
+		req.Header.Set("X-ClickHouse-Key", s.cfg.Password)
+	}
+	_, _, err = doWarehouseRequest(s.client, req)
+	return err
+}
+
+func (s *clickHouseSink) table() string {
+	return s.cfg.Database + "." + s.cfg.Table
+}
+
+func (s *clickHouseSink) EnsureSchema(ctx context.Context) error {
+	if err := s.query(ctx, `CREATE TABLE IF NOT EXISTS `+s.table()+` (
+    event_id String,
+    event_time DateTime64(3, 'UTC'),
+    event_type LowCardinality(String),
+    client_id String,
+    token_id String,
+    token_type LowCardinality(String),
+    ip String,
+    region LowCardinality(String),
+    zone LowCardinality(String)
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(event_time)
+ORDER BY (client_id, event_time, event_id)`, nil); err != nil {
+		return err
+	}
+	// Tables created before events carried a region
+	return s.query(ctx, `ALTER TABLE `+s.table()+` ADD COLUMN IF NOT EXISTS region LowCardinality(String), ADD COLUMN IF NOT EXISTS zone LowCardinality(String)`, nil)
+}
+
+func (s *clickHouseSink) Write(ctx context.Context, events []AuthEvent) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return s.query(ctx, "INSERT INTO "+s.table()+" FORMAT JSONEachRow", body.Bytes())
+}
+
+// bigQuerySink streams events into BigQuery through the REST API's tabledata.insertAll
+type bigQuerySink struct {
+	cfg    analytics_bigquery
+	client *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// GCE metadata server endpoint handing out tokens for the instance's service account
+const gceTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+func newBigQuerySink(cfg analytics_bigquery) (*bigQuerySink, error) {
+	if cfg.ProjectID == "" || cfg.Dataset == "" {
+		return nil, fmt.Errorf("analytics.bigquery.project_id and dataset are required")
+	}
+	if cfg.Table == "" {
+		cfg.Table = "auth_events"
+	}
+	if !warehouseIdentifier.MatchString(cfg.Dataset) || !warehouseIdentifier.MatchString(cfg.Table) {
+		return nil, fmt.Errorf("analytics.bigquery dataset and table must be plain identifiers")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://bigquery.googleapis.com/bigquery/v2"
+	}
+	return &bigQuerySink{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (s *bigQuerySink) Name() string { return "bigquery" }
+
+func (s *bigQuerySink) datasetURL() string {
+	return fmt.Sprintf("%s/projects/%s/datasets/%s", strings.TrimSuffix(s.cfg.Endpoint, "/"), url.PathEscape(s.cfg.ProjectID), s.cfg.Dataset)
+}
+
+// accessToken returns analytics.bigquery.access_token, or a cached token from the metadata server
+func (s *bigQuerySink) accessToken(ctx context.Context) (string, error) {
+	if s.cfg.AccessToken != "" {
+		return s.cfg.AccessToken, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gceTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	body, _, err := doWarehouseRequest(s.client, req)
+	if err != nil {
+		return "", fmt.Errorf("fetching access token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("decoding access token: %w", err)
+	}
+	s.token = token.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn-60) * time.Second)
+	return s.token, nil
+}
+
+func (s *bigQuerySink) call(ctx context.Context, method, url string, payload any) ([]byte, int, error) {
+	var body io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	return doWarehouseRequest(s.client, req)
+}
+
+// bigQueryEventFields is the events table schema
+var bigQueryEventFields = []map[string]string{
+	bigQueryField("event_id", "STRING"),
+	bigQueryField("event_time", "TIMESTAMP"),
+	bigQueryField("event_type", "STRING"),
+	bigQueryField("client_id", "STRING"),
+	bigQueryField("token_id", "STRING"),
+	bigQueryField("token_type", "STRING"),
+	bigQueryField("ip", "STRING"),
+	bigQueryField("region", "STRING"),
+	bigQueryField("zone", "STRING"),
+}
+
+func bigQueryField(name, typ string) map[string]string {
+	return map[string]string{"name": name, "type": typ, "mode": "NULLABLE"}
+}
+
+func (s *bigQuerySink) EnsureSchema(ctx context.Context) error {
+	tableURL := s.datasetURL() + "/tables/" + s.cfg.Table
+	body, status, err := s.call(ctx, http.MethodGet, tableURL, nil)
+	if err == nil {
+		return s.addMissingFields(ctx, tableURL, body)
+	}
+	if status != http.StatusNotFound {
+		return err
+	}
+
+	table := map[string]any{
+		"tableReference":   map[string]string{"projectId": s.cfg.ProjectID, "datasetId": s.cfg.Dataset, "tableId": s.cfg.Table},
+		"schema":           map[string]any{"fields": bigQueryEventFields},
+		"timePartitioning": map[string]string{"type": "DAY", "field": "event_time"},
+		"clustering":       map[string]any{"fields": []string{"client_id", "event_type"}},
+	}
+	_, status, err = s.call(ctx, http.MethodPost, s.datasetURL()+"/tables", table)
+	if status == http.StatusConflict {
+		// Created concurrently by another instance
+		return nil
+	}
+	return err
+}
+
+// addMissingFields patches in columns added since the table was created, e.g. region and zone
+func (s *bigQuerySink) addMissingFields(ctx context.Context, tableURL string, body []byte) error {
+	var table struct {
+		Schema struct {
+			Fields []map[string]any `json:"fields"`
+		} `json:"schema"`
+	}
+	if err := json.Unmarshal(body, &table); err != nil {
+		return fmt.Errorf("decoding table: %w", err)
+	}
+	existing := make(map[string]bool)
+	for _, field := range table.Schema.Fields {
+		existing[fmt.Sprint(field["name"])] = true
+	}
+
+	fields := table.Schema.Fields
+	for _, field := range bigQueryEventFields {
+		if !existing[field["name"]] {
+			fields = append(fields, map[string]any{"name": field["name"], "type": field["type"], "mode": field["mode"]})
+		}
+	}
+	if len(fields) == len(table.Schema.Fields) {
+		return nil
+	}
+	_, _, err := s.call(ctx, http.MethodPatch, tableURL, map[string]any{"schema": map[string]any{"fields": fields}})
+	return err
+}
+
+func (s *bigQuerySink) Write(ctx context.Context, events []AuthEvent) error {
+	type row struct {
+		InsertID string    `json:"insertId"` // BigQuery's best-effort deduplication key
+		JSON     AuthEvent `json:"json"`
+	}
+	rows := make([]row, 0, len(events))
+	for _, event := range events {
+		rows = append(rows, row{InsertID: event.EventID, JSON: event})
+	}
+
+	body, _, err := s.call(ctx, http.MethodPost, s.datasetURL()+"/tables/"+s.cfg.Table+"/insertAll", map[string]any{"rows": rows})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		InsertErrors []json.RawMessage `json:"insertErrors"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("decoding insertAll response: %w", err)
+	}
+	if len(result.InsertErrors) > 0 {
+		return fmt.Errorf("insertAll rejected %d of %d rows: %s", len(result.InsertErrors), len(events), result.InsertErrors[0])
+	}
+	return nil
+}
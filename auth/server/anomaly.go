@@ -0,0 +1,227 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Anomaly kinds reported by the detector
+const (
+	AnomalyKindIssuance   = "issuance"
+	AnomalyKindFailedAuth = "failed_auth"
+)
+
+// maxTrackedClients bounds detector memory; past it the client seen least recently is forgotten
+const maxTrackedClients = 10000
+
+// AnomalyAlert is sent to the alert webhook when a client's rate deviates from its baseline
+type AnomalyAlert struct {
+	ClientID    string    `json:"client_id"`
+	Kind        string    `json:"kind"`
+	Count       int       `json:"count"`
+	Baseline    float64   `json:"baseline"`
+	Threshold   float64   `json:"threshold"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+}
+
+type anomalyCounter struct {
+	count    int
+	baseline float64 // exponentially weighted moving average of per-window counts
+	windows  int     // windows observed, baselines need a few before alerting
+}
+
+type clientAnomalyState struct {
+	issued     anomalyCounter
+	failedAuth anomalyCounter
+	lastSeen   time.Time
+}
+
+// anomalyDetector keeps rolling per-client baselines of issuance and failed-auth rates and
+// alerts when a window's count exceeds max(min_events, baseline * multiplier). Events reach it
+// through the usage recorder, which only reports registered clients
+type anomalyDetector struct {
+	mu          sync.Mutex
+	cfg         anomaly
+	clients     map[string]*clientAnomalyState
+	windowStart time.Time
+	notify      func(AnomalyAlert)
+	done        chan struct{}
+	authServer  *authServer
+}
+
+// Baselines need this many windows of history before they can trigger alerts
+const anomalyWarmupWindows = 5
+
+func newAnomalyDetector(as *authServer, cfg anomaly) *anomalyDetector {
+	if cfg.WindowSeconds <= 0 {
+		cfg.WindowSeconds = 60
+	}
+	if cfg.Multiplier <= 0 {
+		cfg.Multiplier = 5
+	}
+	if cfg.MinEvents <= 0 {
+		cfg.MinEvents = 20
+	}
+	ad := &anomalyDetector{
+		cfg:         cfg,
+		clients:     make(map[string]*clientAnomalyState),
+		windowStart: time.Now(),
+		done:        make(chan struct{}),
+		authServer:  as,
+	}
+	ad.notify = ad.postWebhook
+	return ad
+}
+
+// Start evaluates windows in the background until Stop is called
+func (ad *anomalyDetector) Start() {
+	if ad == nil || !ad.cfg.Enabled {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(ad.cfg.WindowSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ad.done:
+				return
+			case now := <-ticker.C:
+				ad.Evaluate(now)
+			}
+		}
+	}()
+}
+
+// Stop stops background evaluation
+func (ad *anomalyDetector) Stop() {
+	if ad == nil || !ad.cfg.Enabled {
+		return
+	}
+	close(ad.done)
+}
+
+func (ad *anomalyDetector) state(clientID string) *clientAnomalyState {
+	state, exists := ad.clients[clientID]
+	if !exists {
+		if len(ad.clients) >= maxTrackedClients {
+			ad.evictLeastRecent()
+		}
+		state = &clientAnomalyState{}
+		ad.clients[clientID] = state
+	}
+	state.lastSeen = time.Now()
+	return state
+}
+
+// evictLeastRecent forgets the client whose last event is oldest, losing its baseline
+func (ad *anomalyDetector) evictLeastRecent() {
+	var oldestID string
+	var oldest time.Time
+	for clientID, state := range ad.clients {
+		if oldestID == "" || state.lastSeen.Before(oldest) {
+			oldestID, oldest = clientID, state.lastSeen
+		}
+	}
+	delete(ad.clients, oldestID)
+}
+
+// RecordIssued counts a token issued to clientID in the current window
+func (ad *anomalyDetector) RecordIssued(clientID string) {
+	if ad == nil || !ad.cfg.Enabled || clientID == "" {
+		return
+	}
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	ad.state(clientID).issued.count++
+}
+
+// RecordFailedAuth counts a failed client authentication for clientID in the current window
+func (ad *anomalyDetector) RecordFailedAuth(clientID string) {
+	if ad == nil || !ad.cfg.Enabled || clientID == "" {
+		return
+	}
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	ad.state(clientID).failedAuth.count++
+}
+
+// thresholds returns the multiplier and minimum event count for clientID
+func (ad *anomalyDetector) thresholds(clientID string) (float64, int) {
+	multiplier, minEvents := ad.cfg.Multiplier, ad.cfg.MinEvents
+	if override, ok := ad.cfg.Clients[clientID]; ok {
+		if override.Multiplier > 0 {
+			multiplier = override.Multiplier
+		}
+		if override.MinEvents > 0 {
+			minEvents = override.MinEvents
+		}
+	}
+	return multiplier, minEvents
+}
+
+// Evaluate closes the current window at now, raising alerts and folding counts into baselines
+func (ad *anomalyDetector) Evaluate(now time.Time) []AnomalyAlert {
+	ad.mu.Lock()
+	windowStart := ad.windowStart
+	ad.windowStart = now
+
+	var alerts []AnomalyAlert
+	for clientID, state := range ad.clients {
+		multiplier, minEvents := ad.thresholds(clientID)
+		for kind, counter := range map[string]*anomalyCounter{AnomalyKindIssuance: &state.issued, AnomalyKindFailedAuth: &state.failedAuth} {
+			threshold := math.Max(float64(minEvents), counter.baseline*multiplier)
+			if counter.windows >= anomalyWarmupWindows && float64(counter.count) > threshold {
+				alerts = append(alerts, AnomalyAlert{
+					ClientID:    clientID,
+					Kind:        kind,
+					Count:       counter.count,
+					Baseline:    counter.baseline,
+					Threshold:   threshold,
+					WindowStart: windowStart,
+					WindowEnd:   now,
+				})
+			}
+			counter.baseline = 0.2*float64(counter.count) + 0.8*counter.baseline
+			counter.windows++
+			counter.count = 0
+		}
+		if state.issued.baseline < 0.01 && state.failedAuth.baseline < 0.01 && state.issued.windows > anomalyWarmupWindows {
+			// Idle clients are forgotten so the tracked set doesn't grow without bound
+			delete(ad.clients, clientID)
+		}
+	}
+	ad.mu.Unlock()
+
+	for _, alert := range alerts {
+		log.Warn().
+			Str("client_id", alert.ClientID).
+			Str("kind", alert.Kind).
+			Int("count", alert.Count).
+			Float64("baseline", alert.Baseline).
+			Float64("threshold", alert.Threshold).
+			Msg("Client rate anomaly detected")
+		if ad.authServer != nil && ad.authServer.anomalyCount != nil {
+			label, ok := ad.authServer.clientLabels.Label(alert.ClientID)
+			if !ok {
+				label = otherClientsLabel
+			}
+			ad.authServer.anomalyCount.WithLabelValues(label, alert.Kind).Inc()
+		}
+		if ad.authServer != nil {
+			ad.authServer.webhooks.Notify(alert.ClientID, WebhookEventAnomaly, alert)
+		}
+		if ad.notify != nil {
+			ad.notify(alert)
+		}
+	}
+	return alerts
+}
+
+// postWebhook delivers an alert to the configured webhook without blocking evaluation
+func (ad *anomalyDetector) postWebhook(alert AnomalyAlert) {
+	postWebhook(ad.cfg.WebhookURL, "anomaly", alert)
+}
@@ -0,0 +1,452 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"auth/auth/cache"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// API keys are opaque credentials of the form ak_<key_id>.<secret>; only the SHA-256 of the full key is stored
+const (
+	apiKeyPrefix    = "ak_"
+	apiKeyTokenType = "K"
+)
+
+type APIKey struct {
+	KeyID      string     `json:"key_id"`
+	KeyHash    string     `json:"-"`
+	ClientID   string     `json:"client_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Revoked    bool       `json:"revoked"`
+}
+
+type CreateAPIKeyRequest struct {
+	ClientID      string   `json:"client_id"`
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes,omitempty"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+func (r *CreateAPIKeyRequest) Validate() error {
+	if r.ClientID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	if len(r.ClientID) > 255 {
+		return fmt.Errorf("client_id exceeds maximum length (255 characters)")
+	}
+	if len(r.Name) > 255 {
+		return fmt.Errorf("name exceeds maximum length (255 characters)")
+	}
+	if r.ExpiresInDays < 0 {
+		return fmt.Errorf("expires_in_days must not be negative")
+	}
+	return validateRequestScopes("scopes", r.Scopes)
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey string  `json:"api_key"` // only returned once, never stored
+	Key    *APIKey `json:"key"`
+}
+
+// apiKeyCacheTTL bounds how long a key read from the database is trusted before it is read again, so
+// a revocation an instance did not hear about from its peers still takes effect
+const apiKeyCacheTTL = 5 * time.Minute
+
+type apiKeyCache struct {
+	entries *cache.TTL[string, *APIKey] // key_id -> key
+}
+
+func newAPIKeyCache() *apiKeyCache {
+	return &apiKeyCache{
+		entries: cache.NewTTL[string, *APIKey](apiKeyCacheTTL),
+	}
+}
+
+func (kc *apiKeyCache) Get(keyID string) (*APIKey, bool) {
+	cached, exists := kc.entries.Get(keyID)
+	if !exists || cached == nil {
+		return nil, false
+	}
+	return cached, true
+}
+
+func (kc *apiKeyCache) Set(keyID string, key *APIKey) {
+	if key == nil {
+		log.Warn().Str("key_id", keyID).Msg("Attempted to cache nil api key, skipping")
+		return
+	}
+	kc.entries.Set(keyID, key)
+}
+
+func (kc *apiKeyCache) Invalidate(keyID string) {
+	kc.entries.Delete(keyID)
+}
+
+// InvalidateClient removes every cached key belonging to clientID
+func (kc *apiKeyCache) InvalidateClient(clientID string) {
+	kc.entries.Update(func(_ string, key *APIKey) (*APIKey, bool) {
+		return key, key.ClientID != clientID
+	})
+}
+
+func (kc *apiKeyCache) Clear() {
+	kc.entries.Clear()
+}
+
+// CleanExpired removes keys cached longer than apiKeyCacheTTL
+func (kc *apiKeyCache) CleanExpired() int {
+	return kc.entries.CleanExpired()
+}
+
+func (kc *apiKeyCache) GetSize() int {
+	return kc.entries.Len()
+}
+
+// apiKeyUsageTracker buffers last-used timestamps in memory and flushes them periodically,
+// so validating an API key never waits on a DB write
+type apiKeyUsageTracker struct {
+	mu         sync.Mutex
+	lastUsed   map[string]time.Time
+	flushTick  *time.Ticker
+	done       chan struct{}
+	authServer *authServer
+}
+
+func newAPIKeyUsageTracker(as *authServer, flushInterval time.Duration) *apiKeyUsageTracker {
+	ut := &apiKeyUsageTracker{
+		lastUsed:   make(map[string]time.Time),
+		flushTick:  time.NewTicker(flushInterval),
+		done:       make(chan struct{}),
+		authServer: as,
+	}
+	go ut.backgroundFlush()
+	return ut
+}
+
+// Touch records that keyID was used now
+func (ut *apiKeyUsageTracker) Touch(keyID string) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	ut.lastUsed[keyID] = time.Now()
+}
+
+// Flush writes buffered last-used timestamps to the database
+func (ut *apiKeyUsageTracker) Flush() {
+	ut.mu.Lock()
+	pending := ut.lastUsed
+	ut.lastUsed = make(map[string]time.Time)
+	ut.mu.Unlock()
+
+	for keyID, usedAt := range pending {
+		if err := ut.authServer.updateAPIKeyLastUsed(keyID, usedAt); err != nil {
+			log.Error().Err(err).Str("key_id", keyID).Msg("Failed to record api key usage")
+		}
+	}
+}
+
+func (ut *apiKeyUsageTracker) backgroundFlush() {
+	for {
+		select {
+		case <-ut.done:
+			ut.flushTick.Stop()
+			ut.Flush()
+			return
+		case <-ut.flushTick.C:
+			ut.Flush()
+		}
+	}
+}
+
+// Stop stops the tracker after a final flush
+func (ut *apiKeyUsageTracker) Stop() {
+	close(ut.done)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKey splits a raw key into its lookup id, reporting false if it isn't an API key
+func parseAPIKey(raw string) (string, bool) {
+	rest, ok := strings.CutPrefix(raw, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	keyID, secret, ok := strings.Cut(rest, ".")
+	if !ok || keyID == "" || secret == "" {
+		return "", false
+	}
+	return keyID, true
+}
+
+// validateAPIKey verifies a raw API key and returns claims equivalent to a token for the owning client
+func (as *authServer) validateAPIKey(ctx context.Context, raw string) (*Claims, error) {
+	keyID, ok := parseAPIKey(raw)
+	if !ok {
+		return nil, fmt.Errorf("malformed api key")
+	}
+
+	key, found := as.apiKeyCache.Get(keyID)
+	if !found {
+		if as.dbHealth.Down() {
+			return nil, errDatabaseUnavailable
+		}
+		var err error
+		key, err = as.apiKeyByID(ctx, keyID)
+		if err != nil {
+			return nil, err
+		}
+		as.apiKeyCache.Set(keyID, key)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(raw)), []byte(key.KeyHash)) != 1 {
+		return nil, fmt.Errorf("invalid api key")
+	}
+	if key.Revoked {
+		return nil, fmt.Errorf("api key has been revoked")
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, fmt.Errorf("api key has expired")
+	}
+
+	if as.apiKeyUsage != nil {
+		as.apiKeyUsage.Touch(keyID)
+	}
+
+	claims := &Claims{
+		ClientID:  key.ClientID,
+		TokenID:   key.KeyID,
+		TokenType: apiKeyTokenType,
+		Scopes:    key.Scopes,
+	}
+	if key.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*key.ExpiresAt)
+	}
+	return claims, nil
+}
+
+func (as *authServer) apiKeyByID(ctx context.Context, keyID string) (*APIKey, error) {
+	logger := GetContextLogger(ctx)
+	logger.Trace().Str("key_id", keyID).Msg("Looking up api key in database")
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var scope scopeList
+	var expiresAt, lastUsedAt sql.NullTime
+	var revokedInt int
+
+	query := "SELECT key_id, key_hash, client_id, name, scopes, created_at, expires_at, last_used_at, revoked FROM api_keys WHERE key_id = :1"
+	stmt, err := as.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if err := stmt.QueryRowContext(ctx, keyID).Scan(&key.KeyID, &key.KeyHash, &key.ClientID, &key.Name, &scope, &key.CreatedAt, &expiresAt, &lastUsedAt, &revokedInt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("apiKeyByID %s: no such key", keyID)
+		}
+		return nil, fmt.Errorf("apiKeyByID %s: %v", keyID, err)
+	}
+
+	key.Revoked = revokedInt == 1
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	key.Scopes = scope
+	return &key, nil
+}
+
+func (as *authServer) apiKeysByClient(clientID string) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	query := "SELECT key_id, client_id, name, scopes, created_at, expires_at, last_used_at, revoked FROM api_keys WHERE client_id = :1 ORDER BY created_at"
+	rows, err := as.db.QueryContext(ctx, query, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*APIKey, 0)
+	for rows.Next() {
+		key := &APIKey{}
+		var scope scopeList
+		var expiresAt, lastUsedAt sql.NullTime
+		var revokedInt int
+		if err := rows.Scan(&key.KeyID, &key.ClientID, &key.Name, &scope, &key.CreatedAt, &expiresAt, &lastUsedAt, &revokedInt); err != nil {
+			return nil, err
+		}
+		key.Revoked = revokedInt == 1
+		if expiresAt.Valid {
+			key.ExpiresAt = &expiresAt.Time
+		}
+		if lastUsedAt.Valid {
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		key.Scopes = scope
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (as *authServer) insertAPIKey(key *APIKey) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+
+	var expiresAt sql.NullTime
+	if key.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *key.ExpiresAt, Valid: true}
+	}
+
+	query := "INSERT INTO api_keys(key_id, key_hash, client_id, name, scopes, created_at, expires_at) VALUES (:1, :2, :3, :4, :5, :6, :7)"
+	if _, err := as.db.ExecContext(ctx, query, key.KeyID, key.KeyHash, key.ClientID, key.Name, string(scopes), key.CreatedAt, expiresAt); err != nil {
+		log.Error().Err(err).Str("key_id", key.KeyID).Msg("Failed to insert api key")
+		return err
+	}
+	return nil
+}
+
+func (as *authServer) revokeAPIKey(keyID string) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	query := "UPDATE api_keys SET revoked = 1, revoked_at = :1 WHERE key_id = :2"
+	result, err := as.db.ExecContext(ctx, query, now, keyID)
+	if err != nil {
+		log.Error().Err(err).Str("key_id", keyID).Msg("Failed to revoke api key")
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	event := RevocationEvent{APIKeyID: keyID, RevokedAt: now, Reason: RevocationReasonAdmin}
+	as.applyRevocation(event)
+	as.notifyRevocationPeers(event)
+	log.Info().Str("key_id", keyID).Msg("api key revoked successfully")
+	return nil
+}
+
+func (as *authServer) updateAPIKeyLastUsed(keyID string, usedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := as.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = :1 WHERE key_id = :2", usedAt, keyID)
+	return err
+}
+
+// Create API key handler (admin)
+func (as *authServer) createAPIKeyHandler(c *gin.Context) {
+	logger := GetRequestLogger(c)
+
+	var req CreateAPIKeyRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	client, err := as.clientByID(c.Request.Context(), req.ClientID)
+	if err != nil || client == nil {
+		RespondWithError(c, ErrNotFoundError("Client not found").WithOriginalError(err))
+		return
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = client.AllowedScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.AllowedScopes, scope) {
+			RespondWithError(c, NewAPIError(ErrInvalidScope, fmt.Sprintf("Scope %q is not allowed for client", scope), http.StatusBadRequest))
+			return
+		}
+	}
+
+	keyID := generateRandomString(8)
+	raw := apiKeyPrefix + keyID + "." + generateRandomString(32)
+	key := &APIKey{
+		KeyID:     keyID,
+		KeyHash:   hashAPIKey(raw),
+		ClientID:  client.ClientID,
+		Name:      req.Name,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := key.CreatedAt.AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := as.insertAPIKey(key); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+
+	logger.Info().Str("key_id", keyID).Str("client_id", key.ClientID).Msg("api key created")
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{APIKey: raw, Key: key})
+}
+
+// List API keys handler (admin)
+func (as *authServer) listAPIKeysHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		RespondWithError(c, ErrBadRequest("client_id query parameter is required"))
+		return
+	}
+
+	keys, err := as.apiKeysByClient(clientID)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// Revoke API key handler (admin)
+func (as *authServer) revokeAPIKeyHandler(c *gin.Context) {
+	keyID := c.Param("key_id")
+	if err := as.revokeAPIKey(keyID); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("API key not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// audienceHeader lets a gateway name the service a token is being validated for. Without it the
+// token's audiences are matched against the resource URL
+const audienceHeader = "X-Token-Audience"
+
+// tokenAudiences returns the aud claim of a new token: the request's space-separated audiences, each of
+// which the client must allow, or every allowed audience when none are requested. Nil leaves the token
+// usable with any service
+func tokenAudiences(client *Clients, requested string) ([]string, error) {
+	audiences := strings.Fields(requested)
+	if len(audiences) == 0 {
+		return client.AllowedAudiences, nil
+	}
+	for _, audience := range audiences {
+		if !slices.Contains(client.AllowedAudiences, audience) {
+			return nil, fmt.Errorf("audience %q is not allowed for this client", audience)
+		}
+	}
+	slices.Sort(audiences)
+	return slices.Compact(audiences), nil
+}
+
+// validForAudience reports whether a token may be used with the service being validated for: the named
+// audience when the caller gives one, otherwise the resource, which an audience matches when it is the
+// resource URL or a path prefix of it. Tokens without audiences are valid for every service
+func (claims *Claims) validForAudience(audience, resource string) bool {
+	if len(claims.Audience) == 0 {
+		return true
+	}
+	if audience != "" {
+		return slices.Contains(claims.Audience, audience)
+	}
+	for _, aud := range claims.Audience {
+		if resource == aud || strings.HasPrefix(resource, strings.TrimSuffix(aud, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
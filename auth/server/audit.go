@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Audit sinks, set by audit.sink
+const (
+	AuditSinkWebhook = "webhook" // batches POSTed as JSON to an HTTP endpoint
+	AuditSinkKafka   = "kafka"   // records produced to a topic through a Kafka REST Proxy
+)
+
+// defaultAuditEvents are the security signals published when audit.events is empty
+var defaultAuditEvents = []string{AuthEventTokenIssued, AuthEventTokenRevoked, AuthEventValidationDenied}
+
+// kafkaTopicName is what Kafka accepts as a topic name, which is also interpolated into the proxy URL
+var kafkaTopicName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,249}$`)
+
+// auditPublisher sends auth events to a SIEM in near real time. It reuses the analytics exporter's
+// batching, retries and bounded queue, so a slow or failing sink drops and counts events rather than
+// slowing down the request path
+type auditPublisher struct {
+	exporter *analyticsExporter
+	events   map[string]bool
+}
+
+func newAuditPublisher(cfg audit) *auditPublisher {
+	if !cfg.Enabled {
+		return nil
+	}
+	var sink analyticsSink
+	var err error
+	switch cfg.Sink {
+	case AuditSinkWebhook:
+		sink, err = newAuditWebhookSink(cfg.Webhook, cfg.TimeoutSeconds)
+	case AuditSinkKafka:
+		sink, err = newAuditKafkaSink(cfg.Kafka, cfg.TimeoutSeconds)
+	default:
+		err = fmt.Errorf("unknown sink %q, expected webhook or kafka", cfg.Sink)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("audit publisher disabled")
+		return nil
+	}
+	return newAuditPublisherWithSink(sink, cfg)
+}
+
+func newAuditPublisherWithSink(sink analyticsSink, cfg audit) *auditPublisher {
+	events := cfg.Events
+	if len(events) == 0 {
+		events = defaultAuditEvents
+	}
+	ap := &auditPublisher{
+		exporter: newAnalyticsExporterWithSink(sink, analytics{
+			BatchSize:       cfg.BatchSize,
+			FlushIntervalMs: cfg.FlushIntervalMs,
+			QueueSize:       cfg.QueueSize,
+			MaxRetries:      cfg.MaxRetries,
+		}),
+		events: make(map[string]bool, len(events)),
+	}
+	for _, event := range events {
+		ap.events[event] = true
+	}
+	return ap
+}
+
+// Start publishes in the background until Stop is called. Metrics must be registered first
+func (ap *auditPublisher) Start(counts *prometheus.CounterVec) {
+	if ap == nil {
+		return
+	}
+	log.Info().Str("sink", ap.exporter.sink.Name()).Int("event_types", len(ap.events)).Msg("Audit publisher started")
+	ap.exporter.Start(counts)
+}
+
+// Stop publishes whatever is still queued and waits for the publisher to finish
+func (ap *auditPublisher) Stop() {
+	if ap == nil {
+		return
+	}
+	ap.exporter.Stop()
+}
+
+// Publish queues an event without blocking, when its type is one audit.events selects
+func (ap *auditPublisher) Publish(event AuthEvent) {
+	if ap == nil || !ap.events[event.Type] {
+		return
+	}
+	ap.exporter.Record(event)
+}
+
+// recordAuthEvent hands an auth event to the analytics warehouse and the audit sink
+func (as *authServer) recordAuthEvent(event AuthEvent) {
+	if event.EventID == "" && (as.analytics != nil || as.audit != nil) {
+		// One ID for both, so the warehouse and the SIEM can be joined
+		event.EventID = uuid.NewString()
+	}
+	as.analytics.Record(event)
+	as.audit.Publish(event)
+}
+
+// AuditBatch is the body of every audit webhook delivery
+type AuditBatch struct {
+	Events []AuthEvent `json:"events"`
+}
+
+// auditWebhookSink POSTs each batch to a URL. With a secret, batches are signed the way client
+// webhooks are, in X-Audit-Signature over X-Audit-Timestamp and the body
+type auditWebhookSink struct {
+	url     string
+	headers map[string]string
+	secret  []byte
+	client  *http.Client
+}
+
+func newAuditWebhookSink(cfg audit_webhook, timeoutSeconds int) (*auditWebhookSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("audit.webhook.url is required")
+	}
+	sink := &auditWebhookSink{url: cfg.URL, headers: cfg.Headers, client: &http.Client{Timeout: auditTimeout(timeoutSeconds)}}
+	if cfg.SecretEnv != "" {
+		secret := os.Getenv(cfg.SecretEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("audit.webhook.secret_env names %s, which is not set", cfg.SecretEnv)
+		}
+		sink.secret = []byte(secret)
+	}
+	return sink, nil
+}
+
+func (s *auditWebhookSink) Name() string { return AuditSinkWebhook }
+
+func (s *auditWebhookSink) EnsureSchema(ctx context.Context) error { return nil }
+
+func (s *auditWebhookSink) Write(ctx context.Context, events []AuthEvent) error {
+	body, err := json.Marshal(AuditBatch{Events: events})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Audit-Timestamp", timestamp)
+		req.Header.Set("X-Audit-Signature", webhookSignature(s.secret, timestamp, body))
+	}
+	_, _, err = doWarehouseRequest(s.client, req)
+	return err
+}
+
+// auditKafkaSink produces events through the REST Proxy API v2 (Confluent REST Proxy, Redpanda's
+// HTTP Proxy), keyed by client ID so each client's events stay ordered within a partition
+type auditKafkaSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newAuditKafkaSink(cfg audit_kafka, timeoutSeconds int) (*auditKafkaSink, error) {
+	if cfg.RestProxyURL == "" {
+		return nil, fmt.Errorf("audit.kafka.rest_proxy_url is required")
+	}
+	if !kafkaTopicName.MatchString(cfg.Topic) {
+		return nil, fmt.Errorf("audit.kafka.topic %q is not a valid topic name", cfg.Topic)
+	}
+	return &auditKafkaSink{
+		url:     strings.TrimSuffix(cfg.RestProxyURL, "/") + "/topics/" + url.PathEscape(cfg.Topic),
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: auditTimeout(timeoutSeconds)},
+	}, nil
+}
+
+func (s *auditKafkaSink) Name() string { return AuditSinkKafka }
+
+func (s *auditKafkaSink) EnsureSchema(ctx context.Context) error { return nil }
+
+func (s *auditKafkaSink) Write(ctx context.Context, events []AuthEvent) error {
+	type record struct {
+		Key   string    `json:"key"`
+		Value AuthEvent `json:"value"`
+	}
+	records := make([]record, len(events))
+	for i, event := range events {
+		records[i] = record{Key: event.ClientID, Value: event}
+	}
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	respBody, _, err := doWarehouseRequest(s.client, req)
+	if err != nil {
+		return err
+	}
+	// The proxy answers 200 even when some records failed, reporting them per offset. The whole batch
+	// is retried, and consumers drop the records already delivered by event_id
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("kafka rejected a record: %s (error code %d)", offset.Error, *offset.ErrorCode)
+		}
+	}
+	return nil
+}
+
+func auditTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = 10
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,617 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"auth/auth/cache"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Clients cache
+func newClientCache() *clientCache {
+	cc := &clientCache{
+		entries: cache.NewTTL[string, *Clients](0),
+	}
+
+	log.Info().Msg("Client cache initialized")
+	return cc
+}
+
+// Get retrieves a client from cache if it exists
+// Returns the cached client and true if found, nil and false otherwise
+func (cc *clientCache) Get(clientID string) (*Clients, bool) {
+	cached, exists := cc.entries.Get(clientID)
+	if !exists || cached == nil {
+		cc.stats.lookup(false)
+		return nil, false
+	}
+	cc.stats.lookup(true)
+	return cached, true
+}
+
+// Set stores a client in cache
+func (cc *clientCache) Set(clientID string, client *Clients) {
+	if client == nil {
+		log.Warn().Str("client_id", clientID).Msg("Attempted to cache nil client, skipping")
+		return
+	}
+	cc.entries.Set(clientID, client)
+	cc.stats.resize(cc.entries.Len())
+}
+
+// Invalidate removes a specific client from cache (useful for forced updates)
+func (cc *clientCache) Invalidate(clientID string) {
+	if cc.entries.Delete(clientID) {
+		log.Debug().Str("client_id", clientID).Msg("Client cache entry invalidated")
+		cc.stats.evicted(cacheEvictionInvalidated, 1)
+		cc.stats.resize(cc.entries.Len())
+	}
+}
+
+// Clear removes all clients from cache (e.g., during shutdown or restart)
+func (cc *clientCache) Clear() {
+	cacheSize := cc.entries.Clear()
+	cc.stats.resize(0)
+	log.Info().Int("cleared_entries", cacheSize).Msg("Client cache cleared")
+}
+
+// EvictLRU removes the n least recently used clients; they are read from the database again when next needed
+func (cc *clientCache) EvictLRU(n int) int {
+	evicted := cc.entries.EvictLRU(n)
+	cc.stats.evicted(cacheEvictionPressure, evicted)
+	cc.stats.resize(cc.entries.Len())
+	return evicted
+}
+
+// Replace makes clients the whole cache, dropping any client not among them
+func (cc *clientCache) Replace(clients []*Clients) {
+	keep := make(map[string]bool, len(clients))
+	for _, client := range clients {
+		keep[client.ClientID] = true
+		cc.entries.Set(client.ClientID, client)
+	}
+	dropped := 0
+	cc.entries.Update(func(clientID string, client *Clients) (*Clients, bool) {
+		if !keep[clientID] {
+			dropped++
+		}
+		return client, keep[clientID]
+	})
+	cc.stats.evicted(cacheEvictionRefreshed, dropped)
+	cc.stats.resize(cc.entries.Len())
+}
+
+// All returns every cached client ordered by client ID
+func (cc *clientCache) All() []*Clients {
+	var clients []*Clients
+	cc.entries.Update(func(_ string, client *Clients) (*Clients, bool) {
+		clients = append(clients, client)
+		return client, true
+	})
+	slices.SortFunc(clients, func(a, b *Clients) int { return strings.Compare(a.ClientID, b.ClientID) })
+	return clients
+}
+
+// GetSize returns current number of entries in cache
+func (cc *clientCache) GetSize() int {
+	return cc.entries.Len()
+}
+
+func (s *authServer) populateClientCache() {
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Minute)
+	defer cancel()
+
+	query := `SELECT client_id, client_secret, access_token_ttl, allowed_scopes, default_scopes, jwt_headers, allowed_audiences, public_key, token_format, roles FROM clients WHERE deleted_at IS NULL`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to populate client cache")
+		return
+	}
+	defer rows.Close()
+
+	if s.clientCache == nil {
+		s.clientCache = newClientCache()
+	}
+
+	for rows.Next() {
+		client := &Clients{}
+		var scope, defaultScopes, audiences, roles scopeList
+		var headers jwtHeaders
+		var publicKey clientPublicKey
+		var tokenFormat sql.NullString
+		if err = rows.Scan(&client.ClientID, &client.ClientSecret, &client.AccessTokenTTL, &scope, &defaultScopes, &headers, &audiences, &publicKey, &tokenFormat, &roles); err != nil {
+			log.Error().Str("client_id", client.ClientID).Msgf("failed to retrieve row while populating client cache: %s", err)
+			continue
+		}
+		client.AllowedScopes = scope
+		client.DefaultScopes = defaultScopes
+		client.JWTHeaders = headers
+		client.AllowedAudiences = audiences
+		client.PublicKey = publicKey.key
+		client.TokenFormat = tokenFormat.String
+		client.Roles = roles
+		s.applyGroupScopes(client)
+		s.clientCache.Set(client.ClientID, client)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Error().Err(err).Msg("rows iteration error in populating client cache")
+		return
+	}
+	s.cacheRefreshes.Mark("clients")
+}
+
+func newEndpointsCache() *endpointCache {
+	return &endpointCache{
+		cache: make(map[string][]*Endpoints),
+	}
+}
+
+// Get returns every cached endpoint entry (one per method) registered for endpoint_url
+func (ec *endpointCache) Get(endpoint_url string) ([]*Endpoints, bool) {
+	ec.mu.RLock()
+	cached, exists := ec.cache[endpoint_url]
+	ec.mu.RUnlock()
+	if !exists || len(cached) == 0 {
+		ec.stats.lookup(false)
+		return nil, false
+	}
+	ec.stats.lookup(true)
+	return cached, true
+}
+
+// Set stores an endpoint in cache, replacing any existing entry for the same URL and method
+func (ec *endpointCache) Set(endpoint_url string, endpoint *Endpoints) {
+	if endpoint == nil {
+		log.Warn().Str("endpoint_url", endpoint_url).Msg("Attempted to cache nil endpoint, skipping")
+		return
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	entries := ec.cache[endpoint_url]
+	updated := make([]*Endpoints, 0, len(entries)+1)
+	for _, existing := range entries {
+		if !strings.EqualFold(normalizeMethod(existing.Method), normalizeMethod(endpoint.Method)) {
+			updated = append(updated, existing)
+		}
+	}
+	// Deactivated endpoints are removed rather than cached so they can never validate from cache
+	if endpoint.Active == 1 {
+		updated = append(updated, endpoint)
+	}
+	if len(updated) == 0 {
+		delete(ec.cache, endpoint_url)
+	} else {
+		ec.cache[endpoint_url] = updated
+	}
+	ec.stats.resize(len(ec.cache))
+}
+
+// Replace atomically swaps the cache contents, dropping entries no longer present or active
+func (ec *endpointCache) Replace(entries map[string][]*Endpoints) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	dropped := 0
+	for url := range ec.cache {
+		if _, ok := entries[url]; !ok {
+			dropped++
+		}
+	}
+	ec.cache = entries
+	ec.stats.evicted(cacheEvictionRefreshed, dropped)
+	ec.stats.resize(len(ec.cache))
+}
+
+// All returns every cached endpoint ordered by URL and method
+func (ec *endpointCache) All() []*Endpoints {
+	ec.mu.RLock()
+	defer ec.mu.RUnlock()
+
+	endpoints := make([]*Endpoints, 0, len(ec.cache))
+	for _, entries := range ec.cache {
+		endpoints = append(endpoints, entries...)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Url != endpoints[j].Url {
+			return endpoints[i].Url < endpoints[j].Url
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+	return endpoints
+}
+
+// Invalidate removes all entries for an endpoint URL (useful for forced updates)
+func (ec *endpointCache) Invalidate(endpoint_url string) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if _, ok := ec.cache[endpoint_url]; ok {
+		delete(ec.cache, endpoint_url)
+		ec.stats.evicted(cacheEvictionInvalidated, 1)
+		ec.stats.resize(len(ec.cache))
+	}
+}
+
+// Clear removes all endpoints from cache (e.g., during shutdown or restart)
+func (ec *endpointCache) Clear() {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	ec.cache = make(map[string][]*Endpoints)
+	ec.stats.resize(0)
+}
+
+// GetSize returns current number of endpoint URLs in cache
+func (ec *endpointCache) GetSize() int {
+	ec.mu.RLock()
+	defer ec.mu.RUnlock()
+	return len(ec.cache)
+}
+
+// populateEndpointsCache loads active endpoints and replaces the cache, so calling it again
+// acts as a refresh that evicts endpoints deactivated since the last load
+func (s *authServer) populateEndpointsCache() {
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Minute)
+	defer cancel()
+
+	query := `SELECT client_id, scope, method, endpoint_url, description, active, required_scopes, scope_mode FROM endpoints`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to populate endpoint cache")
+		return
+	}
+	defer rows.Close()
+
+	if s.endpointCache == nil {
+		s.endpointCache = newEndpointsCache()
+	}
+
+	loaded := newEndpointsCache()
+	skipped := 0
+	for rows.Next() {
+		endpoint := &Endpoints{}
+		var requiredScopes scopeList
+		var scopeMode sql.NullString
+		if err = rows.Scan(&endpoint.ClientID, &endpoint.Scope, &endpoint.Method, &endpoint.Url, &endpoint.Description, &endpoint.Active, &requiredScopes, &scopeMode); err != nil {
+			log.Error().Str("endpoint_url", endpoint.Url).Msgf("failed to retrieve row while populating endpoint cache: %s", err)
+			continue
+		}
+		if endpoint.Active != 1 {
+			skipped++
+			continue
+		}
+		endpoint.RequiredScopes = requiredScopes
+		endpoint.ScopeMode = scopeMode.String
+		loaded.Set(endpoint.Url, endpoint)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Error().Err(err).Msg("rows iteration error in populating endpoint cache")
+		return
+	}
+
+	s.endpointCache.Replace(loaded.cache)
+	s.cacheRefreshes.Mark("endpoints")
+	log.Info().Int("endpoints", loaded.GetSize()).Int("inactive_skipped", skipped).Msg("Endpoint cache populated")
+}
+
+// TokenBatchWriter handles asynchronous batch insertion of tokens to reduce DB load
+type TokenBatchWriter struct {
+	mu         sync.Mutex
+	tokens     []Token
+	maxBatch   int
+	flushTick  *time.Ticker
+	done       chan struct{}
+	stopped    chan struct{}  // closed once the final flush is done
+	writes     sync.WaitGroup // asynchronous batch writes in progress
+	authServer *authServer
+	lastFlush  BatchFlushResult
+}
+
+// BatchFlushResult describes the most recent token batch insert
+type BatchFlushResult struct {
+	At        time.Time `json:"at"`
+	BatchSize int       `json:"batch_size"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// NewTokenBatchWriter creates a new token batch writer with specified parameters
+// Parameters: authServer - server instance for DB access, maxBatch - size before auto-flush, flushInterval - max time before flush
+func NewTokenBatchWriter(as *authServer, maxBatch int, flushInterval time.Duration) *TokenBatchWriter {
+	if maxBatch <= 0 {
+		log.Warn().Int("max_batch", maxBatch).Msg("Invalid maxBatch, using default 1000")
+		maxBatch = 1000
+	}
+	if flushInterval <= 0 {
+		log.Warn().Dur("flush_interval", flushInterval).Msg("Invalid flushInterval, using default 5 seconds")
+		flushInterval = 5 * time.Second
+	}
+
+	tbw := &TokenBatchWriter{
+		tokens:     make([]Token, 0, maxBatch),
+		maxBatch:   maxBatch,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		authServer: as,
+		flushTick:  time.NewTicker(flushInterval),
+	}
+
+	// Start background flush goroutine
+	go tbw.backgroundFlush()
+
+	log.Info().
+		Int("max_batch", maxBatch).
+		Str("flush_interval", flushInterval.String()).
+		Msg("Token batch writer initialized")
+
+	return tbw
+}
+
+// Add queues a token for batch insertion (non-blocking)
+func (tbw *TokenBatchWriter) Add(token Token) {
+	if token.TokenID == "" || token.ClientID == "" {
+		log.Error().Msg("Attempted to add invalid token (missing TokenID or ClientID)")
+		return
+	}
+
+	tbw.mu.Lock()
+	defer tbw.mu.Unlock()
+
+	tbw.tokens = append(tbw.tokens, token)
+
+	// Flush immediately if batch is full
+	if len(tbw.tokens) >= tbw.maxBatch {
+		tbw.flushLockedAsync()
+	}
+}
+
+// Flush immediately writes pending tokens to database (blocking)
+func (tbw *TokenBatchWriter) Flush() {
+	tbw.mu.Lock()
+	defer tbw.mu.Unlock()
+
+	if len(tbw.tokens) > 0 {
+		tbw.flushLockedAsync()
+	}
+}
+
+// flushLockedAsync flushes tokens asynchronously without acquiring lock (assumes lock is held)
+func (tbw *TokenBatchWriter) flushLockedAsync() {
+	if len(tbw.tokens) == 0 {
+		return
+	}
+
+	// Copy tokens and reset buffer (prevents holding lock during DB operation)
+	batch := make([]Token, len(tbw.tokens))
+	copy(batch, tbw.tokens)
+	tbw.tokens = tbw.tokens[:0]
+
+	// Write to database asynchronously in separate goroutine
+	tbw.writes.Go(func() { tbw.write(batch) })
+}
+
+// FlushNow writes pending tokens before returning, for callers that need them readable from the database
+func (tbw *TokenBatchWriter) FlushNow() error {
+	tbw.mu.Lock()
+	batch := slices.Clone(tbw.tokens)
+	tbw.tokens = tbw.tokens[:0]
+	tbw.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return tbw.write(batch)
+}
+
+// write inserts batch and records the outcome for LastFlush
+func (tbw *TokenBatchWriter) write(batch []Token) error {
+	if tbw.authServer.faults.dropFlush() {
+		log.Warn().Int("batch_size", len(batch)).Msg("Fault injection dropped token batch flush")
+		return nil
+	}
+	err := tbw.authServer.insertTokenBatch(batch)
+	result := BatchFlushResult{At: time.Now(), BatchSize: len(batch)}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	tbw.mu.Lock()
+	tbw.lastFlush = result
+	tbw.mu.Unlock()
+
+	if err != nil {
+		// Journaled write_behind tokens are written again later; the rest of the batch is lost
+		tbw.authServer.tokenPersistence.Journal().Retry(batch)
+		log.Error().
+			Err(err).
+			Int("batch_size", len(batch)).
+			Strs("request_ids", batchRequestIDs(batch)).
+			Msg("Failed to insert token batch")
+	} else {
+		tbw.authServer.tokenPersistence.Journal().Settle(batch)
+		log.Debug().
+			Int("batch_size", len(batch)).
+			Msg("Token batch inserted successfully")
+	}
+	return err
+}
+
+// batchRequestIDs lists the requests that issued a batch's tokens, so a failed write can be traced
+// back to them
+func batchRequestIDs(batch []Token) []string {
+	requestIDs := make([]string, 0, len(batch))
+	for _, token := range batch {
+		if token.RequestID != "" {
+			requestIDs = append(requestIDs, token.RequestID)
+		}
+	}
+	return requestIDs
+}
+
+// backgroundFlush flushes tokens periodically or on shutdown (runs in background goroutine)
+func (tbw *TokenBatchWriter) backgroundFlush() {
+	defer close(tbw.stopped)
+	for {
+		select {
+		case <-tbw.done:
+			tbw.flushTick.Stop()
+			// Final flush before shutdown, waiting for earlier batches still being written
+			tbw.FlushNow()
+			tbw.writes.Wait()
+			log.Debug().Msg("Token batch writer background flush stopped")
+			return
+		case <-tbw.flushTick.C:
+			tbw.Flush()
+		}
+	}
+}
+
+// Stop gracefully stops the batch writer and returns once pending tokens have been written
+func (tbw *TokenBatchWriter) Stop() {
+	close(tbw.done)
+	<-tbw.stopped
+	log.Info().Msg("Token batch writer stopped")
+}
+
+// Running reports whether the background flush is still running, i.e. Stop has not been called
+func (tbw *TokenBatchWriter) Running() bool {
+	select {
+	case <-tbw.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// LastFlush returns the result of the most recent batch insert; At is zero before the first flush
+func (tbw *TokenBatchWriter) LastFlush() BatchFlushResult {
+	tbw.mu.Lock()
+	defer tbw.mu.Unlock()
+	return tbw.lastFlush
+}
+
+// GetPendingCount returns number of tokens currently waiting for flush
+func (tbw *TokenBatchWriter) GetPendingCount() int {
+	tbw.mu.Lock()
+	defer tbw.mu.Unlock()
+	return len(tbw.tokens)
+}
+
+// Token Cache with TTL
+
+func newTokenCache(ttl time.Duration) *tokenCache {
+	tc := &tokenCache{
+		entries: cache.NewTTL[string, *Token](ttl),
+	}
+	log.Info().Str("ttl", ttl.String()).Msg("Token cache initialized")
+	return tc
+}
+
+// SetTTL changes how long tokens cached from now on are kept
+func (tc *tokenCache) SetTTL(ttl time.Duration) {
+	tc.entries.SetTTL(ttl)
+}
+
+// Get retrieves a token from cache if it exists and hasn't expired
+func (tc *tokenCache) Get(tokenID string) (*Token, bool) {
+	token, exists := tc.entries.Get(tokenID)
+	if !exists || token == nil {
+		tc.stats.lookup(false)
+		return nil, false
+	}
+	tc.stats.lookup(true)
+	return token, true
+}
+
+// Set stores a token in cache with TTL
+func (tc *tokenCache) Set(tokenID string, token *Token) {
+	if tokenID == "" || token == nil {
+		log.Warn().Str("token_id", tokenID).Msg("Attempted to cache invalid token")
+		return
+	}
+
+	tc.entries.Set(tokenID, token)
+	tc.stats.resize(tc.entries.Len())
+}
+
+// Keep holds a token the cache is the only copy of until it expires, past the cache TTL and through
+// memory pressure eviction
+func (tc *tokenCache) Keep(tokenID string, expiresAt time.Time) {
+	tc.entries.Pin(tokenID, expiresAt)
+}
+
+// Invalidate removes a specific token from cache
+func (tc *tokenCache) Invalidate(tokenID string) {
+	if tc.entries.Delete(tokenID) {
+		log.Debug().Str("token_id", tokenID).Msg("Token cache entry invalidated")
+		tc.stats.evicted(cacheEvictionInvalidated, 1)
+		tc.stats.resize(tc.entries.Len())
+	}
+}
+
+// RevokeClient marks cached tokens of clientID as revoked by an administrator, which is how a whole
+// client's tokens get revoked. Entries cached without an owner are dropped so their revocation state
+// is re-read from the database
+func (tc *tokenCache) RevokeClient(clientID string) int {
+	affected, dropped := 0, 0
+	tc.entries.Update(func(_ string, token *Token) (*Token, bool) {
+		switch token.ClientID {
+		case clientID:
+			revoked := *token
+			revoked.Revoked = true
+			revoked.RevocationReason = RevocationReasonAdmin
+			affected++
+			return &revoked, true
+		case "":
+			dropped++
+			return nil, false
+		}
+		return token, true
+	})
+	tc.stats.evicted(cacheEvictionInvalidated, dropped)
+	tc.stats.resize(tc.entries.Len())
+	return affected
+}
+
+// Clear removes all tokens from cache
+func (tc *tokenCache) Clear() {
+	cacheSize := tc.entries.Clear()
+	tc.stats.resize(0)
+	log.Info().Int("cleared_entries", cacheSize).Msg("Token cache cleared")
+}
+
+// EvictLRU removes the n least recently used tokens; their state is read from the database again when next needed.
+// cache_only tokens, which have no database copy, are kept
+func (tc *tokenCache) EvictLRU(n int) int {
+	evicted := tc.entries.EvictLRU(n)
+	tc.stats.evicted(cacheEvictionPressure, evicted)
+	tc.stats.resize(tc.entries.Len())
+	return evicted
+}
+
+// GetSize returns current number of entries in cache
+func (tc *tokenCache) GetSize() int {
+	return tc.entries.Len()
+}
+
+// CleanExpired removes all expired entries from cache
+func (tc *tokenCache) CleanExpired() int {
+	removed := tc.entries.CleanExpired()
+	tc.stats.evicted(cacheEvictionExpired, removed)
+	tc.stats.resize(tc.entries.Len())
+	if removed > 0 {
+		log.Debug().Int("removed", removed).Msg("Cleaned expired entries from token cache")
+	}
+	return removed
+}
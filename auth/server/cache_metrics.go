@@ -0,0 +1,80 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons entries leave a cache, the reason label of cache_evictions_total
+const (
+	cacheEvictionInvalidated = "invalidated"     // removed because the record changed or was revoked
+	cacheEvictionExpired     = "expired"         // outlived the cache's TTL
+	cacheEvictionRefreshed   = "refreshed"       // gone from the database when the cache was reloaded
+	cacheEvictionPressure    = "memory_pressure" // dropped by the memory watchdog
+)
+
+// cacheStats feeds one cache's lookups, evictions and size into the cache metrics. Caches get theirs
+// once Start has registered the metrics; until then a nil *cacheStats records nothing
+type cacheStats struct {
+	hits       prometheus.Counter
+	misses     prometheus.Counter
+	legacyHits prometheus.Counter     // client_cache_hits_total or endpoint_cache_hits_total; nil for other caches
+	evictions  *prometheus.CounterVec // cache_evictions_total for this cache, by reason
+	size       prometheus.Gauge
+}
+
+func newCacheStats(name string, lookups, evictions *prometheus.CounterVec, size *prometheus.GaugeVec, legacyHits *prometheus.CounterVec) *cacheStats {
+	cs := &cacheStats{
+		hits:      lookups.WithLabelValues(name, "hit"),
+		misses:    lookups.WithLabelValues(name, "miss"),
+		evictions: evictions.MustCurryWith(prometheus.Labels{"cache_type": name}),
+		size:      size.WithLabelValues(name),
+	}
+	if legacyHits != nil {
+		cs.legacyHits = legacyHits.WithLabelValues(name)
+	}
+	return cs
+}
+
+func (cs *cacheStats) lookup(hit bool) {
+	if cs == nil {
+		return
+	}
+	if !hit {
+		cs.misses.Inc()
+		return
+	}
+	cs.hits.Inc()
+	if cs.legacyHits != nil {
+		cs.legacyHits.Inc()
+	}
+}
+
+func (cs *cacheStats) evicted(reason string, n int) {
+	if cs == nil || n <= 0 {
+		return
+	}
+	cs.evictions.WithLabelValues(reason).Add(float64(n))
+}
+
+func (cs *cacheStats) resize(n int) {
+	if cs == nil {
+		return
+	}
+	cs.size.Set(float64(n))
+}
+
+// instrumentCaches attaches the cache metrics to the client, endpoint and token caches
+func (s *authServer) instrumentCaches() {
+	if s.clientCache != nil {
+		s.clientCache.stats = newCacheStats("client", s.cacheLookups, s.cacheEvictions, s.cacheSize, s.clientCacheHitRate)
+		s.clientCache.stats.resize(s.clientCache.GetSize())
+	}
+	if s.endpointCache != nil {
+		s.endpointCache.stats = newCacheStats("endpoint", s.cacheLookups, s.cacheEvictions, s.cacheSize, s.endpointCacheHitRate)
+		s.endpointCache.stats.resize(s.endpointCache.GetSize())
+	}
+	if s.tokenCache != nil {
+		s.tokenCache.stats = newCacheStats("token", s.cacheLookups, s.cacheEvictions, s.cacheSize, nil)
+		s.tokenCache.stats.resize(s.tokenCache.GetSize())
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Canary probe steps, in the order they run. A failed run is counted under the step that failed
+const (
+	CanaryStepIssue    = "issue"    // load the canary client and sign a token
+	CanaryStepPersist  = "persist"  // write the token to the database
+	CanaryStepValidate = "validate" // validate it through the normal cache and signing key path
+	CanaryStepRevoke   = "revoke"
+	CanaryStepReject   = "reject" // validation must now fail, reading the revocation back from the database
+)
+
+// CanaryResult is the outcome of the latest canary run, reported in the detailed health view
+type CanaryResult struct {
+	At         time.Time `json:"at"`
+	OK         bool      `json:"ok"`
+	FailedStep string    `json:"failed_step,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// canaryProbe exercises the token lifecycle end to end for a dedicated client on an interval, so a
+// broken signing key, database or cache shows up in metrics before real clients hit it
+type canaryProbe struct {
+	as       *authServer
+	cfg      canary
+	done     chan struct{}
+	duration *prometheus.HistogramVec // canary_step_duration_seconds by step
+	runs     *prometheus.CounterVec   // canary_runs_total by result
+
+	mu   sync.Mutex
+	last *CanaryResult
+}
+
+func newCanaryProbe(as *authServer, cfg canary) *canaryProbe {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.ClientID == "" {
+		log.Error().Msg("canary.client_id is required, canary probe disabled")
+		return nil
+	}
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 30
+	}
+	return &canaryProbe{
+		as:   as,
+		cfg:  cfg,
+		done: make(chan struct{}),
+	}
+}
+
+// Start runs the canary in the background until Stop is called. Metrics must be registered first
+func (cp *canaryProbe) Start(duration *prometheus.HistogramVec, runs *prometheus.CounterVec) {
+	if cp == nil {
+		return
+	}
+	cp.duration = duration
+	cp.runs = runs
+	go func() {
+		ticker := time.NewTicker(time.Duration(cp.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cp.done:
+				return
+			case <-ticker.C:
+				cp.Run()
+			}
+		}
+	}()
+}
+
+// Stop stops the background canary
+func (cp *canaryProbe) Stop() {
+	if cp == nil {
+		return
+	}
+	close(cp.done)
+}
+
+// Last returns the latest run, or nil before the first one or when the canary is disabled
+func (cp *canaryProbe) Last() *CanaryResult {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.last
+}
+
+// Run performs one issue, validate, revoke cycle and records its outcome
+func (cp *canaryProbe) Run() CanaryResult {
+	start := time.Now()
+	step, err := cp.run()
+
+	result := CanaryResult{At: start, OK: err == nil, DurationMs: time.Since(start).Milliseconds()}
+	label := "ok"
+	if err != nil {
+		result.FailedStep = step
+		result.Error = err.Error()
+		label = step
+		log.Error().Err(err).Str("step", step).Str("client_id", cp.cfg.ClientID).Msg("Canary token probe failed")
+	}
+	if cp.runs != nil {
+		cp.runs.WithLabelValues(label).Inc()
+	}
+
+	cp.mu.Lock()
+	cp.last = &result
+	cp.mu.Unlock()
+	return result
+}
+
+// run returns the step that failed along with its error
+func (cp *canaryProbe) run() (string, error) {
+	var (
+		tokenString string
+		token       *Token
+	)
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{CanaryStepIssue, func() error {
+			client, err := cp.as.clientByID(cp.as.ctx, cp.cfg.ClientID)
+			if err != nil {
+				return err
+			}
+			tokenString, token, err = cp.as.generateJWT(client, "N")
+			return err
+		}},
+		{CanaryStepPersist, func() error {
+			return cp.as.tokenBatcher.FlushNow()
+		}},
+		{CanaryStepValidate, func() error {
+			claims, err := cp.as.validateJWT(cp.as.ctx, tokenString)
+			if err != nil {
+				return err
+			}
+			if claims.TokenID != token.TokenID || claims.ClientID != cp.cfg.ClientID {
+				return fmt.Errorf("validated claims do not match the issued token")
+			}
+			return nil
+		}},
+		{CanaryStepRevoke, func() error {
+			return cp.as.revokeToken(cp.as.ctx, RevokedToken{ClientID: token.ClientID, TokenID: token.TokenID, RevokedAt: time.Now(), Reason: RevocationReasonUserRequested})
+		}},
+		{CanaryStepReject, func() error {
+			if _, err := cp.as.validateJWT(cp.as.ctx, tokenString); err == nil {
+				return fmt.Errorf("revoked canary token was still accepted")
+			}
+			return nil
+		}},
+	}
+
+	for _, step := range steps {
+		start := time.Now()
+		err := step.fn()
+		if cp.duration != nil {
+			cp.duration.WithLabelValues(step.name).Observe(time.Since(start).Seconds())
+		}
+		if err != nil {
+			return step.name, err
+		}
+	}
+	return "", nil
+}
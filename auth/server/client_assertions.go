@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	go_ora "github.com/sijms/go-ora/v2"
+)
+
+// ClientAssertionTypeJWTBearer is the client_assertion_type of private_key_jwt client authentication
+// (RFC 7523 section 2.2): the client signs a short-lived JWT with its registered private key instead of
+// sending a shared secret
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionLeeway absorbs clock skew between clients and this server
+const clientAssertionLeeway = 30 * time.Second
+
+// clientPublicKey reads a client's registered PEM public key from the public_key column
+type clientPublicKey struct {
+	key crypto.PublicKey
+}
+
+// Scan implements sql.Scanner
+func (k *clientPublicKey) Scan(src any) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+		k.key = nil
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	case go_ora.Clob:
+		raw = v.String
+	case go_ora.NClob:
+		raw = v.String
+	default:
+		return fmt.Errorf("cannot read public key from %T", src)
+	}
+	if raw == "" {
+		k.key = nil
+		return nil
+	}
+	key, err := parseBundlePublicKey([]byte(raw))
+	if err != nil {
+		return fmt.Errorf("public key: %w", err)
+	}
+	k.key = key
+	return nil
+}
+
+// encodePublicKeyPEM is the PEM form of a client's public key, as stored and carried in bundles
+func encodePublicKeyPEM(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// clientAssertionCredential identifies the client presenting assertion by its sub claim. Nothing is
+// verified yet; validateClientAssertion does that once the client's key has been looked up
+func clientAssertionCredential(assertionType, assertion string) (clientCredential, error) {
+	if assertionType != ClientAssertionTypeJWTBearer {
+		return clientCredential{}, fmt.Errorf("client_assertion_type must be %s", ClientAssertionTypeJWTBearer)
+	}
+	if assertion == "" {
+		return clientCredential{}, fmt.Errorf("client_assertion is required with client_assertion_type")
+	}
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, claims); err != nil {
+		return clientCredential{}, fmt.Errorf("client_assertion is not a JWT")
+	}
+	return clientCredential{ClientID: claims.Subject, Assertion: assertion, Source: CredentialSourceAssertion}, nil
+}
+
+// verifyClientAssertion checks assertion as RFC 7523 section 3 requires: signed with the client's
+// registered key, issued by and about the client, addressed to one of audiences, unexpired and no longer
+// lived than client_assertion.max_lifetime_seconds, and not seen before
+func (as *authServer) verifyClientAssertion(client *Clients, assertion string, audiences []string) error {
+	if client.PublicKey == nil {
+		return fmt.Errorf("client has no registered public key")
+	}
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(assertion, claims, func(*jwt.Token) (any, error) {
+		return client.PublicKey, nil
+	},
+		jwt.WithValidMethods(bundleMethods(client.PublicKey)),
+		jwt.WithIssuer(client.ClientID),
+		jwt.WithSubject(client.ClientID),
+		jwt.WithAudience(audiences...),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clientAssertionLeeway))
+	if err != nil {
+		return err
+	}
+
+	maxLifetime := time.Duration(AppConfig.ClientAssertion.MaxLifetimeSeconds) * time.Second
+	if maxLifetime <= 0 {
+		maxLifetime = 5 * time.Minute
+	}
+	if time.Until(claims.ExpiresAt.Time) > maxLifetime+clientAssertionLeeway {
+		return fmt.Errorf("assertion expires more than %s ahead", maxLifetime)
+	}
+	if claims.ID == "" {
+		return fmt.Errorf("assertion has no jti")
+	}
+	if !as.usedAssertions.Use(client.ClientID, claims.ID, claims.ExpiresAt.Time.Add(clientAssertionLeeway)) {
+		return fmt.Errorf("assertion %s was already used", claims.ID)
+	}
+	return nil
+}
+
+// validateClientAssertion authenticates clientID by a private_key_jwt assertion
+func (as *authServer) validateClientAssertion(ctx context.Context, clientID, assertion string, audiences []string) (*Clients, error) {
+	logger := GetContextLogger(ctx)
+	return as.authenticateClient(ctx, clientID, func(client *Clients) bool {
+		if err := as.verifyClientAssertion(client, assertion, audiences); err != nil {
+			logger.Warn().Err(err).Str("client_id", clientID).Msg("Client assertion rejected")
+			return false
+		}
+		return true
+	})
+}
+
+// jtiCache remembers the jti of every accepted single-use JWT, a private_key_jwt assertion or a DPoP
+// proof, until it expires, so a captured one cannot be replayed against this instance
+type jtiCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time // issuer + jti -> expiry
+	pruned time.Time
+}
+
+func newJTICache() *jtiCache {
+	return &jtiCache{seen: make(map[string]time.Time)}
+}
+
+// Use records jti for issuer until expires, reporting false if it was already recorded
+func (jc *jtiCache) Use(issuer, jti string, expires time.Time) bool {
+	if jc == nil {
+		return true
+	}
+	key := issuer + "\x00" + jti
+	now := time.Now()
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	if expiry, seen := jc.seen[key]; seen && now.Before(expiry) {
+		return false
+	}
+	if now.Sub(jc.pruned) > time.Minute {
+		for k, expiry := range jc.seen {
+			if !now.Before(expiry) {
+				delete(jc.seen, k)
+			}
+		}
+		jc.pruned = now
+	}
+	jc.seen[key] = expires
+	return true
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// otherClientsLabel aggregates clients that don't get their own client_id label
+const otherClientsLabel = "other"
+
+// clientLabeler maps client ids to bounded client_id label values. With an allowlist only listed
+// clients are labelled; otherwise the first MaxClients distinct clients are, and the rest share "other"
+type clientLabeler struct {
+	mu         sync.Mutex
+	enabled    bool
+	allowlist  map[string]bool
+	maxClients int
+	labelled   map[string]bool
+}
+
+func newClientLabeler(cfg per_client_metrics) *clientLabeler {
+	cl := &clientLabeler{
+		enabled:    cfg.Enabled,
+		maxClients: cfg.MaxClients,
+		labelled:   make(map[string]bool),
+	}
+	if cl.maxClients <= 0 {
+		cl.maxClients = 50
+	}
+	if len(cfg.Allowlist) > 0 {
+		cl.allowlist = make(map[string]bool, len(cfg.Allowlist))
+		for _, clientID := range cfg.Allowlist {
+			cl.allowlist[clientID] = true
+		}
+	}
+	return cl
+}
+
+// Label returns the label value for clientID and whether per-client metrics are enabled
+func (cl *clientLabeler) Label(clientID string) (string, bool) {
+	if cl == nil || !cl.enabled || clientID == "" {
+		return "", false
+	}
+	if cl.allowlist != nil {
+		if cl.allowlist[clientID] {
+			return clientID, true
+		}
+		return otherClientsLabel, true
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.labelled[clientID] {
+		return clientID, true
+	}
+	if len(cl.labelled) < cl.maxClients {
+		cl.labelled[clientID] = true
+		log.Debug().Str("client_id", clientID).Int("labelled_clients", len(cl.labelled)).Msg("Client assigned its own metric label")
+		return clientID, true
+	}
+	return otherClientsLabel, true
+}
+
+// recordClientIssuance counts a token issued to clientID when per-client metrics are enabled
+func (as *authServer) recordClientIssuance(clientID, tokenType string) {
+	if label, ok := as.clientLabels.Label(clientID); ok && as.clientTokenCount != nil {
+		as.clientTokenCount.WithLabelValues(label, tokenType).Inc()
+	}
+}
+
+// recordClientValidation counts a validation outcome ("allowed" or "denied") for clientID
+func (as *authServer) recordClientValidation(clientID, tokenType, result string) {
+	if label, ok := as.clientLabels.Label(clientID); ok && as.clientValidateCount != nil {
+		as.clientValidateCount.WithLabelValues(label, tokenType, result).Inc()
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Client secret hash algorithms, set by client_secrets.hash_algorithm
+const (
+	SecretHashArgon2id = "argon2id" // PHC string: $argon2id$v=19$m=...,t=...,p=...$salt$hash
+	SecretHashBcrypt   = "bcrypt"   // $2a$, $2b$ or $2y$; secrets longer than 72 bytes cannot be hashed
+)
+
+// hashClientSecret hashes secret for the clients table with client_secrets.hash_algorithm
+func hashClientSecret(secret string) (string, error) {
+	cfg := AppConfig.ClientSecrets
+	if cfg.HashAlgorithm == SecretHashBcrypt {
+		cost := cfg.BcryptCost
+		if cost == 0 {
+			cost = 12
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), cost)
+		return string(hash), err
+	}
+
+	memory, iterations, parallelism := uint32(cfg.Argon2MemoryKiB), uint32(cfg.Argon2Iterations), uint8(cfg.Argon2Parallelism)
+	if memory == 0 || iterations == 0 || parallelism == 0 {
+		memory, iterations, parallelism = 64*1024, 3, 2
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(secret), salt, iterations, memory, parallelism, 32)
+	encode := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, memory, iterations, parallelism, encode(salt), encode(key)), nil
+}
+
+// isHashedClientSecret reports whether stored is a hash rather than a plaintext secret
+func isHashedClientSecret(stored string) bool {
+	return strings.HasPrefix(stored, "$argon2id$") || strings.HasPrefix(stored, "$2a$") ||
+		strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// verifyClientSecret reports whether presented matches stored, a hash or, unless
+// client_secrets.require_hashed is on, a secret not yet hashed. Every comparison is constant-time
+func verifyClientSecret(stored, presented string) bool {
+	switch {
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return verifyArgon2id(stored, presented)
+	case isHashedClientSecret(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(presented)) == nil
+	case !AppConfig.ClientSecrets.RequireHashed:
+		return stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(presented)) == 1
+	}
+	return false
+}
+
+func verifyArgon2id(stored, presented string) bool {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version int
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got := argon2.IDKey([]byte(presented), salt, iterations, memory, parallelism, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// verifiedSecrets remembers the last secret each client presented successfully, as a SHA-256 digest
+// tied to the stored hash, so repeated token requests skip the deliberately slow hash. A changed hash
+// or a different secret is verified in full again
+type verifiedSecrets struct {
+	mu      sync.Mutex
+	entries map[string]verifiedSecret
+}
+
+type verifiedSecret struct {
+	stored string
+	digest [sha256.Size]byte
+}
+
+func newVerifiedSecrets() *verifiedSecrets {
+	return &verifiedSecrets{entries: make(map[string]verifiedSecret)}
+}
+
+// Verify is verifyClientSecret for client, answered from memory when the same secret verified before
+func (vs *verifiedSecrets) Verify(client *Clients, presented string) bool {
+	if vs == nil || !isHashedClientSecret(client.ClientSecret) {
+		return verifyClientSecret(client.ClientSecret, presented)
+	}
+	digest := sha256.Sum256([]byte(presented))
+	vs.mu.Lock()
+	entry, ok := vs.entries[client.ClientID]
+	vs.mu.Unlock()
+	if ok && entry.stored == client.ClientSecret && subtle.ConstantTimeCompare(entry.digest[:], digest[:]) == 1 {
+		return true
+	}
+
+	if !verifyClientSecret(client.ClientSecret, presented) {
+		return false
+	}
+	vs.mu.Lock()
+	vs.entries[client.ClientID] = verifiedSecret{stored: client.ClientSecret, digest: digest}
+	vs.mu.Unlock()
+	return true
+}
+
+// HashClientSecrets replaces every plaintext secret in the clients table with its hash, so
+// client_secrets.require_hashed can be turned on. Secrets already hashed are left alone, and each
+// update only applies if the secret is unchanged since it was read
+func HashClientSecrets(dryRun bool, out io.Writer) error {
+	pool, err := newDbClient(databaseURL())
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer pool.Close()
+	// Through instrumentedDB, so the statements are rebound for the configured driver
+	db := newInstrumentedDB(pool, &authServer{ctx: context.Background()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT client_id, client_secret FROM clients")
+	if err != nil {
+		return fmt.Errorf("reading client secrets: %w", err)
+	}
+	plaintext := make(map[string]string)
+	for rows.Next() {
+		var clientID, secret string
+		if err := rows.Scan(&clientID, &secret); err != nil {
+			rows.Close()
+			return fmt.Errorf("reading client secrets: %w", err)
+		}
+		if !isHashedClientSecret(secret) {
+			plaintext[clientID] = secret
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading client secrets: %w", err)
+	}
+
+	hashed := 0
+	for clientID, secret := range plaintext {
+		if dryRun {
+			fmt.Fprintf(out, "would hash secret of %s\n", clientID)
+			continue
+		}
+		hash, err := hashClientSecret(secret)
+		if err != nil {
+			return fmt.Errorf("hashing secret of %s: %w", clientID, err)
+		}
+		result, err := db.ExecContext(ctx, "UPDATE clients SET client_secret = :1, updated_at = :2 WHERE client_id = :3 AND client_secret = :4",
+			hash, time.Now(), clientID, secret)
+		if err != nil {
+			return fmt.Errorf("storing hashed secret of %s: %w", clientID, err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			fmt.Fprintf(out, "skipped %s: its secret changed while hashing\n", clientID)
+			continue
+		}
+		hashed++
+	}
+	fmt.Fprintf(out, "client secrets: %d hashed, %d to hash\n", hashed, len(plaintext)-hashed)
+	return nil
+}
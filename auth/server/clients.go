@@ -0,0 +1,501 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// AdminClient is a client as shown to administrators, without its secret
+type AdminClient struct {
+	ClientID         string            `json:"client_id"`
+	Name             string            `json:"name"`
+	AccessTokenTTL   int32             `json:"access_token_ttl"`
+	AllowedScopes    []string          `json:"allowed_scopes"`
+	DefaultScopes    []string          `json:"default_scopes,omitempty"`
+	AllowedAudiences []string          `json:"allowed_audiences,omitempty"`
+	JWTHeaders       map[string]string `json:"jwt_headers,omitempty"`
+	PublicKey        string            `json:"public_key,omitempty"` // PEM, for private_key_jwt
+	TokenFormat      string            `json:"token_format"`
+	Roles            []string          `json:"roles,omitempty"`
+}
+
+// adminClientColumns are the clients columns read by scanAdminClient
+const adminClientColumns = "client_id, client_name, access_token_ttl, allowed_scopes, default_scopes, allowed_audiences, jwt_headers, public_key, token_format, roles"
+
+func scanAdminClient(row interface{ Scan(dest ...any) error }) (*AdminClient, error) {
+	client := &AdminClient{}
+	var name, publicKey, tokenFormat sql.NullString
+	var scopes, defaultScopes, audiences, roles scopeList
+	var headers jwtHeaders
+	if err := row.Scan(&client.ClientID, &name, &client.AccessTokenTTL, &scopes, &defaultScopes, &audiences, &headers, &publicKey, &tokenFormat, &roles); err != nil {
+		return nil, err
+	}
+	client.Name = name.String
+	client.AllowedScopes = scopes
+	client.DefaultScopes = defaultScopes
+	client.AllowedAudiences = audiences
+	client.JWTHeaders = headers
+	client.PublicKey = publicKey.String
+	client.TokenFormat = tokenFormat.String
+	client.Roles = roles
+	if client.TokenFormat == "" {
+		client.TokenFormat = TokenFormatJWT
+	}
+	return client, nil
+}
+
+// ClientRequest creates a client, or replaces everything but the ID and secret of an existing one
+type ClientRequest struct {
+	ClientID         string            `json:"client_id,omitempty"` // on create; generated when empty
+	Name             string            `json:"name"`
+	AccessTokenTTL   int32             `json:"access_token_ttl,omitempty"` // seconds; 0 uses the token type's lifetime
+	AllowedScopes    []string          `json:"allowed_scopes"`
+	DefaultScopes    []string          `json:"default_scopes,omitempty"`
+	AllowedAudiences []string          `json:"allowed_audiences,omitempty"`
+	JWTHeaders       map[string]string `json:"jwt_headers,omitempty"`
+	PublicKey        string            `json:"public_key,omitempty"`
+	TokenFormat      string            `json:"token_format,omitempty"`
+	Roles            []string          `json:"roles,omitempty"`
+}
+
+func (r *ClientRequest) Validate() error {
+	if len(r.ClientID) > 100 {
+		return fmt.Errorf("client_id exceeds maximum length (100 characters)")
+	}
+	if len(r.Name) > 255 {
+		return fmt.Errorf("name exceeds maximum length (255 characters)")
+	}
+	if r.AccessTokenTTL < 0 {
+		return fmt.Errorf("access_token_ttl must not be negative")
+	}
+	if len(r.AllowedScopes) == 0 {
+		return fmt.Errorf("allowed_scopes is required")
+	}
+	if err := validateRequestScopes("allowed_scopes", r.AllowedScopes); err != nil {
+		return err
+	}
+	if err := validateRequestScopes("default_scopes", r.DefaultScopes); err != nil {
+		return err
+	}
+	for _, scope := range r.DefaultScopes {
+		if !slices.Contains(r.AllowedScopes, scope) {
+			return fmt.Errorf("default_scopes: %q is not in allowed_scopes", scope)
+		}
+	}
+	if len(r.AllowedAudiences) > maxRequestScopes {
+		return fmt.Errorf("allowed_audiences exceeds maximum of %d entries", maxRequestScopes)
+	}
+	if slices.Contains(r.AllowedAudiences, "") {
+		return fmt.Errorf("allowed_audiences must not contain empty entries")
+	}
+	if err := validateJWTHeaders(r.JWTHeaders); err != nil {
+		return fmt.Errorf("jwt_headers: %v", err)
+	}
+	if r.PublicKey != "" {
+		if _, err := parseBundlePublicKey([]byte(r.PublicKey)); err != nil {
+			return fmt.Errorf("public_key: %v", err)
+		}
+	}
+	switch r.TokenFormat {
+	case "", TokenFormatJWT, TokenFormatOpaque:
+	default:
+		return fmt.Errorf("token_format must be %s or %s", TokenFormatJWT, TokenFormatOpaque)
+	}
+	return validateRequestScopes("roles", r.Roles)
+}
+
+// columns are the request's values for the clients columns it sets, lists and headers as JSON and
+// empty ones as NULL, in the order name, ttl, scopes, default scopes, audiences, headers, key, format, roles
+func (r *ClientRequest) columns() ([]any, error) {
+	scopes, err := nullableJSON(r.AllowedScopes, len(r.AllowedScopes))
+	if err != nil {
+		return nil, err
+	}
+	defaultScopes, err := nullableJSON(r.DefaultScopes, len(r.DefaultScopes))
+	if err != nil {
+		return nil, err
+	}
+	audiences, err := nullableJSON(r.AllowedAudiences, len(r.AllowedAudiences))
+	if err != nil {
+		return nil, err
+	}
+	headers, err := nullableJSON(r.JWTHeaders, len(r.JWTHeaders))
+	if err != nil {
+		return nil, err
+	}
+	roles, err := nullableJSON(r.Roles, len(r.Roles))
+	if err != nil {
+		return nil, err
+	}
+	var publicKey any
+	if r.PublicKey != "" {
+		publicKey = r.PublicKey
+	}
+	tokenFormat := r.TokenFormat
+	if tokenFormat == "" {
+		tokenFormat = TokenFormatJWT
+	}
+	return []any{r.Name, r.AccessTokenTTL, scopes, defaultScopes, audiences, headers, publicKey, tokenFormat, roles}, nil
+}
+
+// nullableJSON encodes v, a list or map of n entries, for a CLOB column; empty values are stored as NULL
+func nullableJSON(v any, n int) (any, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(v)
+	return string(encoded), err
+}
+
+// CreateClientResponse carries a new client secret, which is only ever returned here
+type CreateClientResponse struct {
+	ClientSecret string       `json:"client_secret"`
+	Client       *AdminClient `json:"client"`
+}
+
+// newClientSecret generates a client secret and the hash the clients table stores in its place
+func newClientSecret() (string, string, error) {
+	secret := generateRandomString(32)
+	hash, err := hashClientSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, hash, nil
+}
+
+// adminClient returns an active client as administrators see it
+func (as *authServer) adminClient(ctx context.Context, clientID string) (*AdminClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return scanAdminClient(as.db.QueryRowContext(ctx, "SELECT "+adminClientColumns+" FROM clients WHERE client_id = :1 AND deleted_at IS NULL", clientID))
+}
+
+// createClient inserts a client with the given secret hash. Soft-deleted clients keep their ID, so
+// it cannot be reused while they are restorable
+func (as *authServer) createClient(ctx context.Context, req *ClientRequest, secretHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	columns, err := req.columns()
+	if err != nil {
+		return err
+	}
+	var existing int
+	if err := as.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM clients WHERE client_id = :1", req.ClientID).Scan(&existing); err != nil {
+		return fmt.Errorf("createClient %s: %v", req.ClientID, err)
+	}
+	if existing > 0 {
+		return errClientExists
+	}
+	args := append([]any{req.ClientID, secretHash}, columns...)
+	if _, err := as.db.ExecContext(ctx, `INSERT INTO clients (client_id, client_secret, client_name, access_token_ttl, allowed_scopes, default_scopes, allowed_audiences, jwt_headers, public_key, token_format, roles)
+VALUES (:1, :2, :3, :4, :5, :6, :7, :8, :9, :10, :11)`, args...); err != nil {
+		return fmt.Errorf("createClient %s: %v", req.ClientID, err)
+	}
+	return nil
+}
+
+// errClientExists is returned by createClient when the client ID is taken, possibly by a deleted client
+var errClientExists = errors.New("client already exists")
+
+// updateClient replaces a client's settings. Tokens already issued keep the scopes they were signed with
+func (as *authServer) updateClient(ctx context.Context, clientID string, req *ClientRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	columns, err := req.columns()
+	if err != nil {
+		return err
+	}
+	args := append(columns, time.Now(), clientID)
+	result, err := as.db.ExecContext(ctx, `UPDATE clients SET client_name = :1, access_token_ttl = :2, allowed_scopes = :3, default_scopes = :4, allowed_audiences = :5,
+jwt_headers = :6, public_key = :7, token_format = :8, roles = :9, updated_at = :10 WHERE client_id = :11 AND deleted_at IS NULL`, args...)
+	if err != nil {
+		return fmt.Errorf("updateClient %s: %v", clientID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	as.clientCache.Invalidate(clientID)
+	return nil
+}
+
+// replaceClientSecret stores a new secret hash. Tokens issued with the old secret stay valid
+func (as *authServer) replaceClientSecret(ctx context.Context, clientID, secretHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.db.ExecContext(ctx, "UPDATE clients SET client_secret = :1, updated_at = :2 WHERE client_id = :3 AND deleted_at IS NULL", secretHash, time.Now(), clientID)
+	if err != nil {
+		return fmt.Errorf("replaceClientSecret %s: %v", clientID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	as.clientCache.Invalidate(clientID)
+	return nil
+}
+
+// DeletedClient is a soft-deleted client that can still be restored
+type DeletedClient struct {
+	ClientID  string    `json:"client_id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// deletedClientRetention is how long a soft-deleted client remains restorable
+func deletedClientRetention() time.Duration {
+	hours := AppConfig.Admin.DeletedClientRetentionHours
+	if hours <= 0 {
+		hours = 720
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// softDeleteClient marks a client deleted and revokes its tokens, refresh tokens and API keys in one transaction
+func (as *authServer) softDeleteClient(clientID string) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction for client deletion")
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, "UPDATE clients SET deleted_at = :1 WHERE client_id = :2 AND deleted_at IS NULL", now, clientID)
+	if err != nil {
+		return fmt.Errorf("softDeleteClient %s: %v", clientID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	tokensResult, err := tx.ExecContext(ctx, "UPDATE tokens SET revoked = 1, revoked_at = :1, revocation_reason = :2 WHERE client_id = :3 AND revoked = 0", now, RevocationReasonAdmin, clientID)
+	if err != nil {
+		return fmt.Errorf("softDeleteClient %s: revoking tokens: %v", clientID, err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE api_keys SET revoked = 1, revoked_at = :1 WHERE client_id = :2 AND revoked = 0", now, clientID); err != nil {
+		return fmt.Errorf("softDeleteClient %s: revoking api keys: %v", clientID, err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = 1, revoked_at = :1, revocation_reason = :2 WHERE client_id = :3 AND revoked = 0", now, RevocationReasonAdmin, clientID); err != nil {
+		return fmt.Errorf("softDeleteClient %s: revoking refresh tokens: %v", clientID, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error().Err(err).Msg("Failed to commit client deletion transaction")
+		return fmt.Errorf("failed to commit client deletion: %w", err)
+	}
+
+	as.clientCache.Invalidate(clientID)
+	as.apiKeyCache.InvalidateClient(clientID)
+	revoked := as.tokenCache.RevokeClient(clientID)
+	as.validationResults.InvalidateClient(clientID)
+	as.notifyRevocationPeers(RevocationEvent{ClientID: clientID, RevokedAt: now, Reason: RevocationReasonAdmin})
+	if n, err := tokensResult.RowsAffected(); err == nil {
+		as.countRevocations(RevocationReasonAdmin, int(n))
+	}
+
+	log.Info().Str("client_id", clientID).Int("cached_tokens_revoked", revoked).Msg("client soft-deleted")
+	return nil
+}
+
+// restoreClient undeletes a client deleted after cutoff. Revoked tokens and API keys stay revoked
+func (as *authServer) restoreClient(clientID string, cutoff time.Time) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.db.ExecContext(ctx, "UPDATE clients SET deleted_at = NULL, updated_at = :1 WHERE client_id = :2 AND deleted_at >= :3", time.Now(), clientID, cutoff)
+	if err != nil {
+		return fmt.Errorf("restoreClient %s: %v", clientID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	log.Info().Str("client_id", clientID).Msg("client restored")
+	return nil
+}
+
+func (as *authServer) deletedClients(cutoff time.Time) ([]*DeletedClient, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	query := "SELECT client_id, client_name, deleted_at FROM clients WHERE deleted_at >= :1 ORDER BY deleted_at DESC"
+	rows, err := as.db.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("deletedClients: %v", err)
+	}
+	defer rows.Close()
+
+	clients := make([]*DeletedClient, 0)
+	for rows.Next() {
+		client := &DeletedClient{}
+		var name sql.NullString
+		if err := rows.Scan(&client.ClientID, &name, &client.DeletedAt); err != nil {
+			return nil, fmt.Errorf("deletedClients: %v", err)
+		}
+		client.Name = name.String
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// Delete client handler (admin): soft-deletes the client and revokes its credentials
+func (as *authServer) deleteClientHandler(c *gin.Context) {
+	clientID := c.Param("client_id")
+	if err := as.softDeleteClient(clientID); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Client not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Client deleted successfully", "restorable_until": time.Now().Add(deletedClientRetention())})
+}
+
+// List deleted clients handler (admin): clients still within the retention window
+func (as *authServer) listDeletedClientsHandler(c *gin.Context) {
+	clients, err := as.deletedClients(time.Now().Add(-deletedClientRetention()))
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// Restore client handler (admin)
+func (as *authServer) restoreClientHandler(c *gin.Context) {
+	clientID := c.Param("client_id")
+	if err := as.restoreClient(clientID, time.Now().Add(-deletedClientRetention())); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("No restorable deleted client with that id"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Client restored successfully"})
+}
+
+// Create client handler (admin): the generated secret is only returned in this response
+func (as *authServer) createClientHandler(c *gin.Context) {
+	logger := GetRequestLogger(c)
+	var req ClientRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if req.ClientID == "" {
+		req.ClientID = generateRandomString(12)
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		RespondWithError(c, ErrInternalServerError("Failed to generate client secret").WithOriginalError(err))
+		return
+	}
+	if err := as.createClient(c.Request.Context(), &req, hash); err != nil {
+		if err == errClientExists {
+			RespondWithError(c, ErrConflictError("Client already exists").WithDetails("client IDs of deleted clients are not reused"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+	client, err := as.adminClient(c.Request.Context(), req.ClientID)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+
+	logger.Info().Str("client_id", client.ClientID).Msg("client created")
+	c.JSON(http.StatusCreated, CreateClientResponse{ClientSecret: secret, Client: client})
+}
+
+// Get client handler (admin)
+func (as *authServer) getClientHandler(c *gin.Context) {
+	client, err := as.adminClient(c.Request.Context(), c.Param("client_id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Client not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, client)
+}
+
+// Update client handler (admin): replaces the client's settings; its ID and secret are kept
+func (as *authServer) updateClientHandler(c *gin.Context) {
+	clientID := c.Param("client_id")
+	var req ClientRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if req.ClientID != "" && req.ClientID != clientID {
+		RespondWithError(c, ErrBadRequest("client_id cannot be changed"))
+		return
+	}
+
+	if err := as.updateClient(c.Request.Context(), clientID, &req); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Client not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	client, err := as.adminClient(c.Request.Context(), clientID)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	logger := GetRequestLogger(c)
+	logger.Info().Str("client_id", clientID).Msg("client updated")
+	c.JSON(http.StatusOK, client)
+}
+
+// Regenerate client secret handler (admin): the old secret stops working immediately
+func (as *authServer) regenerateClientSecretHandler(c *gin.Context) {
+	clientID := c.Param("client_id")
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		RespondWithError(c, ErrInternalServerError("Failed to generate client secret").WithOriginalError(err))
+		return
+	}
+	if err := as.replaceClientSecret(c.Request.Context(), clientID, hash); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Client not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	logger := GetRequestLogger(c)
+	logger.Info().Str("client_id", clientID).Msg("client secret regenerated")
+	c.JSON(http.StatusOK, gin.H{"client_id": clientID, "client_secret": secret})
+}
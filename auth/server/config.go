@@ -0,0 +1,781 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+type (
+	logging struct {
+		Level     int    `mapstructure:"level,omitempty"`
+		Path      string `mapstructure:"path,omitempty"`
+		MaxSizeMB int    `mapstructure:"max_size_mb,omitempty"`
+	}
+
+	cors struct {
+		AllowedOrigins []string `mapstructure:"allowed_origins"` // origins browsers may call the public API from; defaults to defaultCORSOrigins
+	}
+
+	cache_config struct {
+		TokenTTLSeconds int `mapstructure:"token_ttl_seconds"` // how long looked-up tokens are kept in memory; defaults to an hour
+	}
+
+	config_reload struct {
+		Watch bool `mapstructure:"watch"` // also reload when the config file changes, not only on SIGHUP
+	}
+
+	connection_pool struct {
+		MaxOpenConns    int `mapstructure:"max_open"`
+		MaxIdleConns    int `mapstructure:"max_idle"`
+		MaxLifetime     int `mapstructure:"max_lifetime"`
+		MaxIdleLifetime int `mapstructure:"max_idle_lifetime"`
+	}
+
+	grant_rate_limit struct {
+		RPS   int `mapstructure:"rps"`
+		Burst int `mapstructure:"burst"`
+	}
+
+	rate_limiting struct {
+		GlobalRPS           int                         `mapstructure:"global_rps"`
+		GlobalBurst         int                         `mapstructure:"global_burst"`
+		ClientRPS           int                         `mapstructure:"client_rps"`
+		ClientBurst         int                         `mapstructure:"client_burst"`
+		FailedAuthPerMinute int                         `mapstructure:"failed_auth_per_minute"` // failed token requests refilled per client_id+IP; 0 disables
+		FailedAuthBurst     int                         `mapstructure:"failed_auth_burst"`      // failed attempts allowed before throttling starts
+		Grants              map[string]grant_rate_limit `mapstructure:"grants"`                 // grant type (client_credentials, ott) -> per-client issuance limit
+		Persistence         rate_limit_persistence      `mapstructure:"persistence"`            // carry per-client budgets across restarts in Redis
+	}
+
+	rate_limit_persistence struct {
+		Enabled                 bool   `mapstructure:"enabled"`
+		SnapshotIntervalSeconds int    `mapstructure:"snapshot_interval_seconds"` // how often depleted buckets are saved; a last snapshot is taken at shutdown
+		KeyPrefix               string `mapstructure:"key_prefix"`
+	}
+
+	redis_config struct {
+		Address   string `mapstructure:"address"`                // host:port
+		Username  string `mapstructure:"username"`               // ACL user; empty authenticates as the default user
+		Password  string `mapstructure:"password" secret:"true"` // prefer the REDIS_PASSWORD environment variable
+		DB        int    `mapstructure:"db"`
+		TLS       bool   `mapstructure:"tls"`
+		TimeoutMs int    `mapstructure:"timeout_ms"` // per command, including connecting
+	}
+
+	database_health struct {
+		IntervalSeconds   int `mapstructure:"interval_seconds"`    // how often the database is pinged
+		FailureThreshold  int `mapstructure:"failure_threshold"`   // consecutive failed pings before it is marked down
+		RetryAfterSeconds int `mapstructure:"retry_after_seconds"` // Retry-After sent while down; defaults to the interval
+	}
+
+	database_standby struct {
+		Host     string `mapstructure:"host"` // empty disables failover
+		Port     int    `mapstructure:"port"`
+		Service  string `mapstructure:"service"`
+		User     string `mapstructure:"user"`                   // defaults to database.user
+		Password string `mapstructure:"password" secret:"true"` // defaults to database.password
+	}
+
+	database_failover struct {
+		ProbeIntervalSeconds int `mapstructure:"probe_interval_seconds"` // how often both databases are probed for their role
+		FailureThreshold     int `mapstructure:"failure_threshold"`      // consecutive failed probes of the active database before switching
+		FailbackThreshold    int `mapstructure:"failback_threshold"`     // consecutive healthy probes of the primary before switching back
+	}
+
+	database_tls struct {
+		Enabled        bool   `mapstructure:"enabled"`                       // connect over TCPS
+		VerifyServer   bool   `mapstructure:"verify_server"`                 // check the server certificate against the wallet
+		WalletPath     string `mapstructure:"wallet_path"`                   // directory holding cwallet.sso or ewallet.p12
+		WalletPassword string `mapstructure:"wallet_password" secret:"true"` // only needed for ewallet.p12
+	}
+
+	database struct {
+		Driver           string            `mapstructure:"driver"`    // oracle (default), postgres, or sqlite for local development
+		Path             string            `mapstructure:"path"`      // sqlite database file; empty or ":memory:" keeps it in memory
+		SeedFile         string            `mapstructure:"seed_file"` // sqlite only: JSON clients and endpoints loaded into a database without clients
+		Host             string            `mapstructure:"host"`
+		Port             int               `mapstructure:"port"`
+		Service          string            `mapstructure:"service"` // Oracle service name, or the Postgres database
+		User             string            `mapstructure:"user"`
+		Password         string            `mapstructure:"password" secret:"true"`
+		ConnTimeout      string            `mapstructure:"connection_timeout"`
+		ConnectionPool   connection_pool   `mapstructure:"connection_pool"`
+		SkipSchemaCheck  bool              `mapstructure:"skip_schema_check"`  // don't verify tables, columns and indexes on boot
+		MigrateOnStartup bool              `mapstructure:"migrate_on_startup"` // apply pending schema migrations on boot; always on for sqlite
+		Health           database_health   `mapstructure:"health"`             // while the database is down /token fails fast with 503
+		Standby          database_standby  `mapstructure:"standby"`            // Data Guard standby taken over when the primary stops serving
+		Failover         database_failover `mapstructure:"failover"`
+		Servers          []string          `mapstructure:"servers"`        // further host:port addresses of the same service, tried in order when host is unreachable
+		TLS              database_tls      `mapstructure:"tls"`            // TCPS and wallet; also used for the standby
+		Encryption       string            `mapstructure:"encryption"`     // native network encryption: accepted, rejected, requested or required
+		DataIntegrity    string            `mapstructure:"data_integrity"` // native network checksums, same values as encryption
+		TraceFile        string            `mapstructure:"trace_file"`     // go-ora protocol trace, for debugging connection problems only
+	}
+
+	scopes struct {
+		SuperScopes []string            `mapstructure:"super_scopes"`
+		Hierarchy   map[string][]string `mapstructure:"hierarchy"`
+	}
+
+	admin struct {
+		Token                       string   `mapstructure:"token" secret:"true"`
+		DeletedClientRetentionHours int      `mapstructure:"deleted_client_retention_hours"` // soft-deleted clients can be restored within this window
+		ListenAddress               string   `mapstructure:"listen_address"`                 // internal listener for admin, health, pprof and metrics; defaults to :metric_port
+		AllowedNetworks             []string `mapstructure:"allowed_networks"`               // CIDRs allowed to reach the internal listener; empty allows any
+		AllowRoleTokens             bool     `mapstructure:"allow_role_tokens"`              // accept access tokens whose roles claim holds admin, or admin:read for reads
+	}
+
+	per_client_metrics struct {
+		Enabled    bool     `mapstructure:"enabled"`
+		Allowlist  []string `mapstructure:"allowlist"`   // when set, only these clients get their own label
+		MaxClients int      `mapstructure:"max_clients"` // without an allowlist, label at most this many clients
+	}
+
+	metrics_push struct {
+		Enabled         bool              `mapstructure:"enabled"`
+		Protocol        string            `mapstructure:"protocol"` // otlp (OTLP/HTTP JSON) or pushgateway (OpenMetrics)
+		URL             string            `mapstructure:"url"`      // e.g. http://otel-collector:4318/v1/metrics or http://pushgateway:9091
+		IntervalSeconds int               `mapstructure:"interval_seconds"`
+		TimeoutSeconds  int               `mapstructure:"timeout_seconds"`
+		Job             string            `mapstructure:"job"`                   // Pushgateway job, OTLP service.name
+		Headers         map[string]string `mapstructure:"headers" secret:"true"` // e.g. an Authorization header for the collector
+	}
+
+	metrics struct {
+		PerClient per_client_metrics `mapstructure:"per_client"`
+		Push      metrics_push       `mapstructure:"push"`
+	}
+
+	tracing_config struct {
+		Enabled         bool              `mapstructure:"enabled"`
+		URL             string            `mapstructure:"url"`          // OTLP/HTTP traces endpoint, e.g. http://otel-collector:4318/v1/traces
+		ServiceName     string            `mapstructure:"service_name"` // OTLP service.name
+		SampleRatio     float64           `mapstructure:"sample_ratio"` // share of new traces recorded, 0 to 1; requests with a traceparent follow its sampled flag
+		BatchSize       int               `mapstructure:"batch_size"`   // spans per export request
+		QueueSize       int               `mapstructure:"queue_size"`   // finished spans waiting for export; spans beyond it are dropped
+		IntervalSeconds int               `mapstructure:"interval_seconds"`
+		TimeoutSeconds  int               `mapstructure:"timeout_seconds"`
+		Headers         map[string]string `mapstructure:"headers" secret:"true"` // e.g. an Authorization header for the collector
+	}
+
+	anomaly_threshold struct {
+		Multiplier float64 `mapstructure:"multiplier"`
+		MinEvents  int     `mapstructure:"min_events"`
+	}
+
+	anomaly struct {
+		Enabled       bool                         `mapstructure:"enabled"`
+		WindowSeconds int                          `mapstructure:"window_seconds"`
+		Multiplier    float64                      `mapstructure:"multiplier"` // alert when a window exceeds baseline * multiplier
+		MinEvents     int                          `mapstructure:"min_events"` // ...and at least this many events
+		WebhookURL    string                       `mapstructure:"webhook_url"`
+		Clients       map[string]anomaly_threshold `mapstructure:"clients"` // per-client overrides
+	}
+
+	usage_quota struct {
+		TokensPerHour int64 `mapstructure:"tokens_per_hour"` // tokens issued in any rolling hour; 0 is unlimited
+		TokensPerDay  int64 `mapstructure:"tokens_per_day"`  // tokens issued in any rolling 24 hours; 0 is unlimited
+	}
+
+	usage_config struct {
+		FlushIntervalSeconds int                    `mapstructure:"flush_interval_seconds"` // how often counters are merged into client_usage_daily
+		Quota                usage_quota            `mapstructure:"quota"`                  // issuance quota for every client
+		Clients              map[string]usage_quota `mapstructure:"clients"`                // per-client quotas, replacing quota entirely
+	}
+
+	webhooks struct {
+		Enabled               bool `mapstructure:"enabled"`
+		RequireHTTPS          bool `mapstructure:"require_https"`           // refuse plain http callback URLs
+		MaxPerClient          int  `mapstructure:"max_per_client"`          // pending and approved subscriptions per client
+		ExpiringWindowSeconds int  `mapstructure:"expiring_window_seconds"` // token.expiring is sent this long before a token expires
+		ScanIntervalSeconds   int  `mapstructure:"scan_interval_seconds"`   // how often expiring tokens are looked for
+		TimeoutSeconds        int  `mapstructure:"timeout_seconds"`         // per delivery attempt
+		MaxAttempts           int  `mapstructure:"max_attempts"`            // attempts per event, with exponential backoff
+		MaxConcurrent         int  `mapstructure:"max_concurrent"`          // deliveries in flight before new events are dropped
+	}
+
+	degraded_mode struct {
+		Enabled          bool `mapstructure:"enabled"`
+		FailureThreshold int  `mapstructure:"failure_threshold"` // consecutive failed revocation lookups before validation degrades
+		OpenSeconds      int  `mapstructure:"open_seconds"`      // how long to skip the lookup before trying the database again
+	}
+
+	validation struct {
+		ResourceHeaders             []string          `mapstructure:"resource_headers"`               // checked in order; defaults to X-Resource-URL, X-Original-URL
+		DisableForwardedForFallback bool              `mapstructure:"disable_forwarded_for_fallback"` // stop reading the resource URL from X-Forwarded-For
+		ResultCacheTTLSeconds       int               `mapstructure:"result_cache_ttl_seconds"`       // how long a successful token validation is reused; 0 disables
+		RevocationPeers             []string          `mapstructure:"revocation_peers"`               // admin base URLs of other instances told about revocations
+		DegradedMode                degraded_mode     `mapstructure:"degraded_mode"`                  // accept signature-valid tokens without the revocation lookup while the database is down
+		RequireResourceServerAuth   bool              `mapstructure:"require_resource_server_auth"`   // only registered resource servers may call the validation endpoint
+		ResourceServers             []resource_server `mapstructure:"resource_servers"`
+		CacheHintMaxSeconds         int               `mapstructure:"cache_hint_max_seconds"` // longest max-age gateways are told to cache a positive result for; 0 asks them not to
+	}
+
+	resource_server struct {
+		ID     string   `mapstructure:"id"`                   // also the common name of its client certificate for mTLS
+		Secret string   `mapstructure:"secret" secret:"true"` // sent in X-Resource-Server-Secret; empty allows mTLS only
+		Claims []string `mapstructure:"claims"`               // response fields it may see: client_id, scopes, expires_at, actor, token_id
+	}
+
+	request_timeout struct {
+		DefaultMs int            `mapstructure:"default_ms"` // budget for routes without an override; 0 disables
+		Routes    map[string]int `mapstructure:"routes"`     // route path (e.g. /auth-server/v1/oauth/token) -> budget in ms
+	}
+
+	shutdown_config struct {
+		DrainTimeoutSeconds int `mapstructure:"drain_timeout_seconds"` // how long requests in flight get to finish before connections are closed
+	}
+
+	recovery struct {
+		WebhookURL string `mapstructure:"webhook_url"` // notified with route and stack trace whenever a handler panics
+	}
+
+	analytics_clickhouse struct {
+		URL      string `mapstructure:"url"` // HTTP interface, e.g. http://clickhouse:8123
+		Database string `mapstructure:"database"`
+		Table    string `mapstructure:"table"`
+		User     string `mapstructure:"user"`
+		Password string `mapstructure:"password" secret:"true"` // prefer the CLICKHOUSE_PASSWORD environment variable
+	}
+
+	analytics_bigquery struct {
+		ProjectID   string `mapstructure:"project_id"`
+		Dataset     string `mapstructure:"dataset"`
+		Table       string `mapstructure:"table"`
+		AccessToken string `mapstructure:"access_token" secret:"true"` // empty fetches tokens from the GCE metadata server
+		Endpoint    string `mapstructure:"endpoint"`                   // API base URL override, e.g. for an emulator
+	}
+
+	analytics struct {
+		Enabled         bool                 `mapstructure:"enabled"`
+		Sink            string               `mapstructure:"sink"` // clickhouse or bigquery
+		BatchSize       int                  `mapstructure:"batch_size"`
+		FlushIntervalMs int                  `mapstructure:"flush_interval_ms"`
+		QueueSize       int                  `mapstructure:"queue_size"`  // events buffered before new ones are dropped
+		MaxRetries      int                  `mapstructure:"max_retries"` // per batch, with exponential backoff
+		ClickHouse      analytics_clickhouse `mapstructure:"clickhouse"`
+		BigQuery        analytics_bigquery   `mapstructure:"bigquery"`
+	}
+
+	audit_webhook struct {
+		URL       string            `mapstructure:"url"`
+		Headers   map[string]string `mapstructure:"headers" secret:"true"` // e.g. an Authorization header for the SIEM collector
+		SecretEnv string            `mapstructure:"secret_env"`            // environment variable holding the key batches are signed with; unsigned when empty
+	}
+
+	audit_kafka struct {
+		RestProxyURL string            `mapstructure:"rest_proxy_url"` // Kafka REST Proxy (API v2), e.g. http://kafka-rest:8082
+		Topic        string            `mapstructure:"topic"`
+		Headers      map[string]string `mapstructure:"headers" secret:"true"` // e.g. an Authorization header for the proxy
+	}
+
+	audit struct {
+		Enabled         bool          `mapstructure:"enabled"`
+		Sink            string        `mapstructure:"sink"`   // webhook or kafka
+		Events          []string      `mapstructure:"events"` // event types published; empty means token_issued, token_revoked and validation_denied
+		BatchSize       int           `mapstructure:"batch_size"`
+		FlushIntervalMs int           `mapstructure:"flush_interval_ms"`
+		QueueSize       int           `mapstructure:"queue_size"`  // events buffered before new ones are dropped
+		MaxRetries      int           `mapstructure:"max_retries"` // per batch, with exponential backoff
+		TimeoutSeconds  int           `mapstructure:"timeout_seconds"`
+		Webhook         audit_webhook `mapstructure:"webhook"`
+		Kafka           audit_kafka   `mapstructure:"kafka"`
+	}
+
+	region struct {
+		Name           string   `mapstructure:"name"`            // e.g. eu-west-1; stamped into tokens, metrics and audit events
+		Zone           string   `mapstructure:"zone"`            // availability zone or data center within the region
+		RestrictTokens bool     `mapstructure:"restrict_tokens"` // tokens that don't request regions are only valid in the issuing region
+		Known          []string `mapstructure:"known"`           // regions tokens may be restricted to; empty accepts any name
+	}
+
+	token_store struct {
+		DefaultPolicy        string            `mapstructure:"default_policy"`         // write_through, write_behind or cache_only
+		Policies             map[string]string `mapstructure:"policies"`               // token type (N, O) -> policy, overriding the default
+		JournalPath          string            `mapstructure:"journal_path"`           // local journal backing write_behind
+		RetryIntervalSeconds int               `mapstructure:"retry_interval_seconds"` // how often journaled tokens whose batch failed are written again
+	}
+
+	stateless_config struct {
+		Enabled                bool   `mapstructure:"enabled"`
+		BundlePath             string `mapstructure:"bundle_path"`              // signed bundle of clients and endpoints
+		BundleRedisKey         string `mapstructure:"bundle_redis_key"`         // or the Redis key holding it
+		BundleKeyFile          string `mapstructure:"bundle_key_file"`          // PEM public key bundles must be signed with
+		RefreshIntervalSeconds int    `mapstructure:"refresh_interval_seconds"` // how often the bundle is reloaded
+		RevocationKeyPrefix    string `mapstructure:"revocation_key_prefix"`    // Redis key prefix of revoked token IDs
+		RevocationTTLSeconds   int    `mapstructure:"revocation_ttl_seconds"`   // how long a revocation is kept when the token's expiry is unknown
+	}
+
+	token_state_config struct {
+		Enabled              bool   `mapstructure:"enabled"`                // share revocations and one-time token use between instances through redis
+		KeyPrefix            string `mapstructure:"key_prefix"`             // Redis key prefix of the shared entries
+		RevocationTTLSeconds int    `mapstructure:"revocation_ttl_seconds"` // how long an entry is kept when the token's expiry is unknown
+	}
+
+	jwt_header struct {
+		Name  string `mapstructure:"name"`
+		Value string `mapstructure:"value"`
+	}
+
+	jwt_headers struct {
+		Typ   string       `mapstructure:"typ"`   // replaces the default JWT, e.g. at+jwt for downstream validators that require it
+		Kid   string       `mapstructure:"kid"`   // names JWT_SECRET for validators that key off kid; managed signing keys send their own
+		Extra []jwt_header `mapstructure:"extra"` // further headers on every token; clients.jwt_headers adds to or overrides them per client
+	}
+
+	error_responses struct {
+		Verbosity   string `mapstructure:"verbosity"`     // production hides server-side details; development adds the underlying error
+		DocsBaseURL string `mapstructure:"docs_base_url"` // error_uri is this URL with the error code as fragment
+	}
+
+	fault_injection struct {
+		Enabled       bool     `mapstructure:"enabled"`         // only honored by binaries built with -tags faultinject
+		DBLatencyMs   int      `mapstructure:"db_latency_ms"`   // added before each database operation
+		DBErrorRate   float64  `mapstructure:"db_error_rate"`   // fraction of database operations failed with an injected error
+		DropFlushRate float64  `mapstructure:"drop_flush_rate"` // fraction of token batch flushes silently discarded
+		Operations    []string `mapstructure:"operations"`      // limit database faults to these operations, e.g. select:tokens; empty means all
+	}
+
+	canary struct {
+		Enabled         bool   `mapstructure:"enabled"`
+		ClientID        string `mapstructure:"client_id"` // dedicated client whose tokens the probe issues and revokes
+		IntervalSeconds int    `mapstructure:"interval_seconds"`
+	}
+
+	revocation_probe struct {
+		Enabled         bool     `mapstructure:"enabled"`
+		ClientID        string   `mapstructure:"client_id"`    // dedicated canary client; its tokens are issued and revoked every run
+		ResourceURL     string   `mapstructure:"resource_url"` // endpoint the canary's scopes grant, used for /validate
+		Method          string   `mapstructure:"method"`
+		Instances       []string `mapstructure:"instances"` // public base URLs of every instance to measure; defaults to this one
+		IntervalSeconds int      `mapstructure:"interval_seconds"`
+		TimeoutSeconds  int      `mapstructure:"timeout_seconds"` // give up on an instance after this long
+		PollIntervalMs  int      `mapstructure:"poll_interval_ms"`
+	}
+
+	token_purge struct {
+		Enabled          bool `mapstructure:"enabled"` // purge expired and revoked tokens on a schedule; one instance is enough
+		IntervalSeconds  int  `mapstructure:"interval_seconds"`
+		RetentionDays    int  `mapstructure:"retention_days"`      // keep tokens this long after they expire or are revoked
+		BatchSize        int  `mapstructure:"batch_size"`          // tokens deleted per statement
+		MaxBatchesPerRun int  `mapstructure:"max_batches_per_run"` // bounds one run's work, the rest waits for the next; 0 for no limit
+		Archive          bool `mapstructure:"archive"`             // move purged tokens, without the JWT, to tokens_archive; admin purges too
+	}
+
+	memory_watchdog struct {
+		Enabled         bool `mapstructure:"enabled"`
+		IntervalSeconds int  `mapstructure:"interval_seconds"` // how often memory is sampled
+		HeapLimitMB     int  `mapstructure:"heap_limit_mb"`    // shrink caches once the Go heap exceeds this; 0 ignores the heap
+		RSSLimitMB      int  `mapstructure:"rss_limit_mb"`     // ...or once resident memory exceeds this (Linux only); 0 ignores RSS
+		EvictPercent    int  `mapstructure:"evict_percent"`    // share of each cache's least recently used entries evicted per check over a limit
+	}
+
+	signing struct {
+		Algorithm       string           `mapstructure:"algorithm"`         // HS256, RS256 or ES256
+		PrivateKeyFile  string           `mapstructure:"private_key_file"`  // PEM key for RS256 or ES256; its public half is served at /.well-known/jwks.json
+		KeyID           string           `mapstructure:"key_id"`            // kid of issued tokens; defaults to the key's RFC 7638 thumbprint
+		AcceptHMAC      bool             `mapstructure:"accept_hmac"`       // keep accepting HS256 tokens after switching, until those issued before have expired
+		RetireJWTSecret bool             `mapstructure:"retire_jwt_secret"` // reject tokens without a managed kid once a managed key signs; set after JWT_SECRET's tokens have expired
+		Rotation        signing_rotation `mapstructure:"rotation"`
+	}
+
+	signing_rotation struct {
+		IntervalHours       int `mapstructure:"interval_hours"`        // age at which the active managed HS256 key is replaced; 0 disables rotation
+		PromoteAfterMinutes int `mapstructure:"promote_after_minutes"` // how long a new key stays pending so every instance loads it first (keys reload every 5 minutes)
+		RetireAfterHours    int `mapstructure:"retire_after_hours"`    // how long a replaced key keeps verifying; must outlast the longest token lifetime
+	}
+
+	client_secrets struct {
+		HashAlgorithm     string `mapstructure:"hash_algorithm"` // argon2id or bcrypt, for secrets this server hashes; either is verified
+		BcryptCost        int    `mapstructure:"bcrypt_cost"`
+		Argon2MemoryKiB   int    `mapstructure:"argon2_memory_kib"`
+		Argon2Iterations  int    `mapstructure:"argon2_iterations"`
+		Argon2Parallelism int    `mapstructure:"argon2_parallelism"`
+		RequireHashed     bool   `mapstructure:"require_hashed"` // reject secrets stored unhashed; turn on once `auth clients hash-secrets` has run
+	}
+
+	client_assertion struct {
+		MaxLifetimeSeconds int `mapstructure:"max_lifetime_seconds"` // longest exp - now a private_key_jwt assertion may have
+	}
+
+	token_lifetime struct {
+		MinSeconds int `mapstructure:"min_seconds"` // floor applied to clients' access_token_ttl; 0 for none
+		MaxSeconds int `mapstructure:"max_seconds"` // ceiling applied to clients' access_token_ttl; 0 for none
+	}
+
+	dpop struct {
+		Required           bool `mapstructure:"required"`              // refuse token requests without a DPoP proof, so no bearer tokens are issued
+		ProofMaxAgeSeconds int  `mapstructure:"proof_max_age_seconds"` // how old a proof's iat may be; proofs are remembered this long to refuse replays
+	}
+
+	discovery struct {
+		Issuer string `mapstructure:"issuer"` // public https base URL advertised in metadata; defaults to the scheme and host each request reached
+	}
+
+	shadow_validation struct {
+		Enabled           bool     `mapstructure:"enabled"`
+		PublicKeyFile     string   `mapstructure:"public_key_file"`     // PEM RSA, ECDSA or Ed25519 key of the candidate configuration
+		SecretEnv         string   `mapstructure:"secret_env"`          // ...or the environment variable holding its HMAC secret
+		Algorithms        []string `mapstructure:"algorithms"`          // algorithms the candidate accepts; defaults to those of its key
+		AcceptCurrentKeys bool     `mapstructure:"accept_current_keys"` // the candidate also accepts today's HMAC keys, as during a transition
+		SamplePercent     float64  `mapstructure:"sample_percent"`      // share of validations compared
+	}
+
+	configuration struct {
+		Version          string             `mapstructure:"version,omitempty"`
+		Logging          logging            `mapstructure:"logging"`
+		ServerPort       string             `mapstructure:"server_port"`
+		HTTPSServerPort  string             `mapstructure:"https_server_port"`
+		HTTPSEnabled     bool               `mapstructure:"https_enabled"`
+		CertFile         string             `mapstructure:"cert_file"`
+		KeyFile          string             `mapstructure:"key_file"`
+		MetricPort       int                `mapstructure:"metric_port"`
+		DevMode          bool               `mapstructure:"dev_mode"` // enables integrator debugging aids such as the token inspector; never in production
+		RateLimiting     rate_limiting      `mapstructure:"rate_limiting"`
+		CORS             cors               `mapstructure:"cors"`
+		Cache            cache_config       `mapstructure:"cache"`
+		ConfigReload     config_reload      `mapstructure:"config_reload"` // settings applied at runtime by SIGHUP or a config file change
+		Database         database           `mapstructure:"database"`
+		Admin            admin              `mapstructure:"admin"`
+		Scopes           scopes             `mapstructure:"scopes"`
+		Validation       validation         `mapstructure:"validation"`
+		Metrics          metrics            `mapstructure:"metrics"`
+		Tracing          tracing_config     `mapstructure:"tracing"` // OpenTelemetry spans for requests, queries and cache lookups
+		Anomaly          anomaly            `mapstructure:"anomaly"`
+		Usage            usage_config       `mapstructure:"usage"`
+		MemoryWatchdog   memory_watchdog    `mapstructure:"memory_watchdog"`
+		Webhooks         webhooks           `mapstructure:"webhooks"`
+		RequestTimeout   request_timeout    `mapstructure:"request_timeout"`
+		Shutdown         shutdown_config    `mapstructure:"shutdown"`
+		Recovery         recovery           `mapstructure:"recovery"`
+		RevocationProbe  revocation_probe   `mapstructure:"revocation_probe"`
+		TokenPurge       token_purge        `mapstructure:"token_purge"`
+		Canary           canary             `mapstructure:"canary"`
+		FaultInjection   fault_injection    `mapstructure:"fault_injection"`
+		ErrorResponses   error_responses    `mapstructure:"error_responses"`
+		JWTHeaders       jwt_headers        `mapstructure:"jwt_headers"`
+		Analytics        analytics          `mapstructure:"analytics"`
+		Audit            audit              `mapstructure:"audit"` // security events published to a SIEM webhook or Kafka topic
+		TokenStore       token_store        `mapstructure:"token_store"`
+		Region           region             `mapstructure:"region"`
+		Redis            redis_config       `mapstructure:"redis"`
+		Stateless        stateless_config   `mapstructure:"stateless"`         // no Oracle: clients from a signed bundle, revocations in Redis
+		TokenState       token_state_config `mapstructure:"token_state"`       // revocations and one-time token use shared through Redis, backed by the database
+		ShadowValidation shadow_validation  `mapstructure:"shadow_validation"` // compare a candidate verification configuration before a migration
+		Discovery        discovery          `mapstructure:"discovery"`         // RFC 8414 authorization server metadata
+		Signing          signing            `mapstructure:"signing"`
+		ClientSecrets    client_secrets     `mapstructure:"client_secrets"` // how client secrets are hashed at rest
+		ClientAssertion  client_assertion   `mapstructure:"client_assertion"`
+		DPoP             dpop               `mapstructure:"dpop"` // RFC 9449 proof-of-possession tokens
+		TokenLifetime    token_lifetime     `mapstructure:"token_lifetime"`
+	}
+)
+
+var (
+	AppConfig configuration
+)
+
+// envPrefix namespaces the environment variables that set configuration keys: AUTH_ and the key with
+// dots as underscores, e.g. AUTH_DATABASE_CONNECTION_POOL_MAX_OPEN for database.connection_pool.max_open
+const envPrefix = "AUTH"
+
+// tomlStatement matches a TOML table header or key assignment, which YAML has no equivalent of
+var tomlStatement = regexp.MustCompile(`(?m)^\s*(\[\[?[A-Za-z0-9_.-]+\]\]?\s*$|[A-Za-z0-9_.-]+\s*=)`)
+
+// ReadConfiguration loads the configuration from path, a JSON, YAML or TOML file, or when path is
+// empty from the first auth-server-config file found in the search path
+func ReadConfiguration(path string) error {
+	if err := loadConfiguration(path); err != nil {
+		return err
+	}
+
+	// Validate required fields
+	if err := validateConfiguration(); err != nil {
+		return fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	return nil
+}
+
+// loadConfiguration reads the config file into AppConfig and applies the environment overrides. An
+// empty path searches ./config, ../config and ../../config for auth-server-config.json, .toml, .yaml or
+// .yml, falling back to defaults when none exists; an explicit path must exist
+func loadConfiguration(path string) error {
+	bindEnvironment()
+	if path != "" {
+		configType, err := configFileType(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		viper.SetConfigFile(path)
+		viper.SetConfigType(configType)
+	} else {
+		viper.SetConfigName("auth-server-config")
+		viper.AddConfigPath("./config")
+		viper.AddConfigPath("../config")
+		viper.AddConfigPath("../../config")
+	}
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		if path != "" {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		log.Warn().Err(err).Msg("configuration file not found, using defaults")
+		setDefaults()
+	}
+
+	if err := viper.Unmarshal(&AppConfig); err != nil {
+		return fmt.Errorf("config unmarshal failed: %w", err)
+	}
+	applySecretEnvironment(&AppConfig)
+	if jwtSecret := os.Getenv("JWT_SECRET"); jwtSecret != "" {
+		viper.Set("jwt_secret", jwtSecret)
+	}
+
+	return nil
+}
+
+// configFileType returns the format of the config file at path, json, yaml or toml. It goes by the
+// extension, and for files without a known one, such as those rendered from deployment templates, by
+// the content: JSON is an object, TOML has table headers or key = value lines, and anything else is
+// read as YAML
+func configFileType(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json", nil
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".toml":
+		return "toml", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	switch content := bytes.TrimSpace(data); {
+	case bytes.HasPrefix(content, []byte("{")):
+		return "json", nil
+	case tomlStatement.Match(content):
+		return "toml", nil
+	default:
+		return "yaml", nil
+	}
+}
+
+// bindEnvironment lets an AUTH_ environment variable set any configuration key, over the config file.
+// viper only looks up the environment for keys it already knows, so every key is bound up front. Lists
+// are comma separated; maps can only be set in the config file
+func bindEnvironment() {
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	for _, key := range configurationKeys(reflect.TypeFor[configuration](), "") {
+		viper.BindEnv(key)
+	}
+}
+
+// configurationKeys returns the dotted keys of t's fields, descending into nested sections
+func configurationKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "" {
+			continue
+		}
+		switch kind := field.Type.Kind(); {
+		case kind == reflect.Struct:
+			keys = append(keys, configurationKeys(field.Type, prefix+name+".")...)
+		case kind == reflect.Map, kind == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			// No flat form to write them in
+		default:
+			keys = append(keys, prefix+name)
+		}
+	}
+	return keys
+}
+
+// applySecretEnvironment loads sensitive data from environment variables, overriding the config file
+func applySecretEnvironment(cfg *configuration) {
+	if dbPassword := os.Getenv("DB_PASSWORD"); dbPassword != "" {
+		cfg.Database.Password = dbPassword
+	}
+	if standbyPassword := os.Getenv("DB_STANDBY_PASSWORD"); standbyPassword != "" {
+		cfg.Database.Standby.Password = standbyPassword
+	}
+	if walletPassword := os.Getenv("DB_WALLET_PASSWORD"); walletPassword != "" {
+		cfg.Database.TLS.WalletPassword = walletPassword
+	}
+	if clickHousePassword := os.Getenv("CLICKHOUSE_PASSWORD"); clickHousePassword != "" {
+		cfg.Analytics.ClickHouse.Password = clickHousePassword
+	}
+	if redisPassword := os.Getenv("REDIS_PASSWORD"); redisPassword != "" {
+		cfg.Redis.Password = redisPassword
+	}
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		cfg.Admin.Token = adminToken
+	}
+}
+
+func setDefaults() {
+	viper.SetDefault("version", "1.0.0")
+	viper.SetDefault("server_port", 8080)
+	viper.SetDefault("metric_port", 7071)
+	viper.SetDefault("shutdown.drain_timeout_seconds", 30)
+	viper.SetDefault("dev_mode", false)
+	viper.SetDefault("jwt_secret", "")
+	viper.SetDefault("database.driver", "oracle")
+	viper.SetDefault("database.password", "")
+	viper.SetDefault("database.health.interval_seconds", 5)
+	viper.SetDefault("database.health.failure_threshold", 3)
+	viper.SetDefault("token_store.default_policy", "write_through")
+	viper.SetDefault("token_store.journal_path", "./data/token-journal.jsonl")
+	viper.SetDefault("token_store.retry_interval_seconds", 30)
+	viper.SetDefault("stateless.refresh_interval_seconds", 60)
+	viper.SetDefault("stateless.revocation_key_prefix", "auth:revoked:")
+	viper.SetDefault("stateless.revocation_ttl_seconds", 86400)
+	viper.SetDefault("token_state.key_prefix", "auth:token:")
+	viper.SetDefault("token_state.revocation_ttl_seconds", 86400)
+	viper.SetDefault("database.tls.verify_server", true)
+	viper.SetDefault("database.failover.probe_interval_seconds", 5)
+	viper.SetDefault("database.failover.failure_threshold", 3)
+	viper.SetDefault("database.failover.failback_threshold", 6)
+	viper.SetDefault("logging.level", 2)
+	viper.SetDefault("logging.path", "./logs/auth-server.log")
+	viper.SetDefault("logging.max_size_mb", 100)
+	viper.SetDefault("rate_limiting.global_rps", 100)
+	viper.SetDefault("rate_limiting.global_burst", 10)
+	viper.SetDefault("rate_limiting.client_rps", 10)
+	viper.SetDefault("rate_limiting.client_burst", 2)
+	viper.SetDefault("rate_limiting.failed_auth_per_minute", 10)
+	viper.SetDefault("rate_limiting.failed_auth_burst", 5)
+	viper.SetDefault("rate_limiting.persistence.snapshot_interval_seconds", 5)
+	viper.SetDefault("rate_limiting.persistence.key_prefix", "auth:ratelimit:")
+	viper.SetDefault("cors.allowed_origins", defaultCORSOrigins)
+	viper.SetDefault("cache.token_ttl_seconds", 3600)
+	viper.SetDefault("config_reload.watch", true)
+	viper.SetDefault("redis.timeout_ms", 2000)
+	viper.SetDefault("scopes.super_scopes", []string{"admin"})
+	viper.SetDefault("admin.deleted_client_retention_hours", 720)
+	viper.SetDefault("admin.allowed_networks", []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"})
+	viper.SetDefault("metrics.per_client.enabled", false)
+	viper.SetDefault("metrics.per_client.max_clients", 50)
+	viper.SetDefault("metrics.push.protocol", "otlp")
+	viper.SetDefault("metrics.push.interval_seconds", 15)
+	viper.SetDefault("metrics.push.timeout_seconds", 10)
+	viper.SetDefault("metrics.push.job", "auth-server")
+	viper.SetDefault("tracing.enabled", false)
+	viper.SetDefault("tracing.service_name", "auth-server")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+	viper.SetDefault("tracing.batch_size", 512)
+	viper.SetDefault("tracing.queue_size", 4096)
+	viper.SetDefault("tracing.interval_seconds", 5)
+	viper.SetDefault("tracing.timeout_seconds", 10)
+	viper.SetDefault("anomaly.window_seconds", 60)
+	viper.SetDefault("anomaly.multiplier", 5.0)
+	viper.SetDefault("anomaly.min_events", 20)
+	viper.SetDefault("usage.flush_interval_seconds", 60)
+	viper.SetDefault("memory_watchdog.interval_seconds", 10)
+	viper.SetDefault("memory_watchdog.evict_percent", 25)
+	viper.SetDefault("shadow_validation.sample_percent", 100)
+	viper.SetDefault("signing.algorithm", SigningHS256)
+	viper.SetDefault("signing.accept_hmac", true)
+	viper.SetDefault("signing.rotation.promote_after_minutes", 10)
+	viper.SetDefault("signing.rotation.retire_after_hours", 24)
+	viper.SetDefault("client_secrets.hash_algorithm", SecretHashArgon2id)
+	viper.SetDefault("client_assertion.max_lifetime_seconds", 300)
+	viper.SetDefault("dpop.proof_max_age_seconds", 60)
+	viper.SetDefault("token_lifetime.min_seconds", 60)
+	viper.SetDefault("token_lifetime.max_seconds", 24*60*60)
+	viper.SetDefault("client_secrets.bcrypt_cost", 12)
+	viper.SetDefault("client_secrets.argon2_memory_kib", 64*1024)
+	viper.SetDefault("client_secrets.argon2_iterations", 3)
+	viper.SetDefault("client_secrets.argon2_parallelism", 2)
+	viper.SetDefault("webhooks.require_https", true)
+	viper.SetDefault("webhooks.max_per_client", 5)
+	viper.SetDefault("webhooks.expiring_window_seconds", 300)
+	viper.SetDefault("webhooks.scan_interval_seconds", 60)
+	viper.SetDefault("webhooks.timeout_seconds", 5)
+	viper.SetDefault("webhooks.max_attempts", 3)
+	viper.SetDefault("webhooks.max_concurrent", 50)
+	viper.SetDefault("validation.resource_headers", defaultResourceHeaders)
+	viper.SetDefault("validation.result_cache_ttl_seconds", 30)
+	viper.SetDefault("validation.cache_hint_max_seconds", 30)
+	viper.SetDefault("validation.degraded_mode.enabled", false)
+	viper.SetDefault("validation.degraded_mode.failure_threshold", 5)
+	viper.SetDefault("validation.degraded_mode.open_seconds", 30)
+	viper.SetDefault("canary.interval_seconds", 30)
+	viper.SetDefault("revocation_probe.interval_seconds", 60)
+	viper.SetDefault("revocation_probe.timeout_seconds", 30)
+	viper.SetDefault("revocation_probe.poll_interval_ms", 50)
+	viper.SetDefault("token_purge.interval_seconds", 3600)
+	viper.SetDefault("token_purge.retention_days", 7)
+	viper.SetDefault("token_purge.batch_size", defaultPurgeBatchSize)
+	viper.SetDefault("token_purge.max_batches_per_run", 100)
+	viper.SetDefault("request_timeout.default_ms", 10000)
+	viper.SetDefault("error_responses.verbosity", ErrorVerbosityProduction)
+	viper.SetDefault("analytics.batch_size", 500)
+	viper.SetDefault("analytics.flush_interval_ms", 5000)
+	viper.SetDefault("analytics.queue_size", 10000)
+	viper.SetDefault("analytics.max_retries", 3)
+	viper.SetDefault("audit.enabled", false)
+	viper.SetDefault("audit.batch_size", 100)
+	viper.SetDefault("audit.flush_interval_ms", 1000)
+	viper.SetDefault("audit.queue_size", 10000)
+	viper.SetDefault("audit.max_retries", 5)
+	viper.SetDefault("audit.timeout_seconds", 10)
+}
+
+func validateConfiguration() error {
+	if AppConfig.ServerPort == "" {
+		return errors.New("server_port is required in configuration")
+	}
+
+	if AppConfig.Logging.Path == "" {
+		return errors.New("logging.path is required in configuration")
+	}
+
+	if AppConfig.Logging.MaxSizeMB <= 0 {
+		return errors.New("logging.max_size_mb must be greater than 0")
+	}
+
+	switch AppConfig.ErrorResponses.Verbosity {
+	case "", ErrorVerbosityProduction, ErrorVerbosityDevelopment:
+	default:
+		return fmt.Errorf("error_responses.verbosity must be %q or %q", ErrorVerbosityProduction, ErrorVerbosityDevelopment)
+	}
+
+	if err := validateJWTHeaders(configuredJWTHeaders()); err != nil {
+		return fmt.Errorf("jwt_headers.extra: %v", err)
+	}
+
+	if !validPersistencePolicy(AppConfig.TokenStore.DefaultPolicy) {
+		return fmt.Errorf("token_store.default_policy must be %q, %q or %q", persistWriteThrough, persistWriteBehind, persistCacheOnly)
+	}
+	for tokenType, policy := range AppConfig.TokenStore.Policies {
+		if !validPersistencePolicy(policy) {
+			return fmt.Errorf("token_store.policies.%s must be %q, %q or %q", tokenType, persistWriteThrough, persistWriteBehind, persistCacheOnly)
+		}
+	}
+
+	if quota := AppConfig.Usage.Quota; quota.TokensPerHour < 0 || quota.TokensPerDay < 0 {
+		return errors.New("usage.quota limits must not be negative")
+	}
+	for clientID, quota := range AppConfig.Usage.Clients {
+		if quota.TokensPerHour < 0 || quota.TokensPerDay < 0 {
+			return fmt.Errorf("usage.clients.%s limits must not be negative", clientID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,543 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Settings tagged secret:"true" have their values replaced in the printed effective configuration;
+// for header maps, only the header names are kept
+const redactedConfigValue = "***REDACTED***"
+
+// ValidateConfigFile loads a configuration file the way the server does, applies the environment
+// overrides and checks everything the server would otherwise only trip over at startup or under load.
+// It backs the "config validate" command: problems and warnings are written to out, followed by the
+// effective configuration with secrets redacted. An empty path uses the server's search path. The
+// returned error is non-nil when the configuration has problems
+func ValidateConfigFile(path string, out io.Writer) error {
+	viper.Reset()
+	AppConfig = configuration{}
+	if err := loadConfiguration(path); err != nil {
+		return err
+	}
+
+	problems, warnings := lintConfiguration()
+	for _, warning := range warnings {
+		fmt.Fprintf(out, "warning: %s\n", warning)
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(out, "error: %s\n", problem)
+	}
+
+	effective, err := json.MarshalIndent(RedactedConfig(), "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s\n", effective)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d configuration problem(s)", len(problems))
+	}
+	return nil
+}
+
+// lintConfiguration returns every problem in AppConfig rather than stopping at the first, plus
+// settings that are allowed but probably unintended
+func lintConfiguration() (problems, warnings []string) {
+	problem := func(format string, args ...any) { problems = append(problems, fmt.Sprintf(format, args...)) }
+	warning := func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+
+	if err := validateConfiguration(); err != nil {
+		problem("%v", err)
+	}
+
+	if secret := viper.GetString("jwt_secret"); len(secret) < 32 {
+		problem("jwt_secret must be at least 32 characters; set JWT_SECRET")
+	}
+
+	// Listeners
+	if port, err := strconv.Atoi(AppConfig.ServerPort); AppConfig.ServerPort != "" && (err != nil || !validPort(port)) {
+		problem("server_port %q is not a valid port", AppConfig.ServerPort)
+	}
+	if !validPort(AppConfig.MetricPort) {
+		problem("metric_port %d is not a valid port", AppConfig.MetricPort)
+	}
+	if AppConfig.HTTPSEnabled {
+		if port, err := strconv.Atoi(AppConfig.HTTPSServerPort); err != nil || !validPort(port) {
+			problem("https_server_port %q is not a valid port", AppConfig.HTTPSServerPort)
+		}
+		lintTLSFiles(AppConfig.CertFile, AppConfig.KeyFile, problem, warning)
+	}
+
+	// Database, or the bundle and revocation list that replace it
+	if stateless := AppConfig.Stateless; stateless.Enabled {
+		if stateless.BundlePath == "" && stateless.BundleRedisKey == "" {
+			problem("stateless.bundle_path or stateless.bundle_redis_key is required in stateless mode")
+		}
+		if pem, err := os.ReadFile(stateless.BundleKeyFile); err != nil {
+			problem("stateless.bundle_key_file: %v", err)
+		} else if _, err := parseBundlePublicKey(pem); err != nil {
+			problem("stateless.bundle_key_file: %v", err)
+		}
+		if _, err := newRedisClient(AppConfig.Redis); err != nil {
+			problem("stateless mode keeps revocations in redis: %v", err)
+		}
+		if AppConfig.Webhooks.Enabled || AppConfig.Canary.Enabled || AppConfig.RevocationProbe.Enabled || AppConfig.TokenPurge.Enabled {
+			warning("webhooks, canary, revocation_probe and token_purge need the database and do not work in stateless mode")
+		}
+	} else {
+		db := AppConfig.Database
+		switch db.Driver {
+		case "", DriverOracle:
+		case DriverPostgres:
+			if db.Standby.Host != "" {
+				problem("database.standby is only supported with the oracle driver")
+			}
+			if db.TLS.WalletPath != "" || db.Encryption != "" || db.DataIntegrity != "" || len(db.Servers) > 0 {
+				warning("database.tls.wallet_path, encryption, data_integrity and servers only apply to the oracle driver")
+			}
+		case DriverSQLite:
+			if !AppConfig.DevMode {
+				warning("database.driver sqlite is meant for local development; features written for Oracle SQL may fail")
+			}
+			if db.SeedFile != "" {
+				if _, err := os.Stat(db.SeedFile); err != nil {
+					problem("database.seed_file: %v", err)
+				}
+			}
+		default:
+			problem("database.driver must be oracle, postgres or sqlite, got %q", db.Driver)
+		}
+		// SQLite has no server, so there is nothing to connect to
+		if db.Driver != DriverSQLite {
+			if db.Host == "" {
+				problem("database.host is required")
+			}
+			if !validPort(db.Port) {
+				problem("database.port %d is not a valid port", db.Port)
+			}
+			if db.Service == "" {
+				problem("database.service is required")
+			}
+			if db.User == "" {
+				problem("database.user is required")
+			}
+			if db.Password == "" {
+				problem("database.password is empty; set it or DB_PASSWORD")
+			}
+		}
+		if db.ConnectionPool.MaxOpenConns <= 0 {
+			warning("database.connection_pool.max_open is %d, so connections are unlimited", db.ConnectionPool.MaxOpenConns)
+		} else if db.ConnectionPool.MaxIdleConns > db.ConnectionPool.MaxOpenConns {
+			warning("database.connection_pool.max_idle (%d) exceeds max_open (%d)", db.ConnectionPool.MaxIdleConns, db.ConnectionPool.MaxOpenConns)
+		}
+		if db.Standby.Host != "" && db.Standby.Port != 0 && !validPort(db.Standby.Port) {
+			problem("database.standby.port %d is not a valid port", db.Standby.Port)
+		}
+		for _, server := range db.Servers {
+			if _, port, err := net.SplitHostPort(server); err != nil || port == "" {
+				problem("database.servers: %q is not host:port", server)
+			}
+		}
+		if _, err := strconv.Atoi(db.ConnTimeout); db.ConnTimeout != "" && err != nil {
+			problem("database.connection_timeout %q must be a number of seconds", db.ConnTimeout)
+		}
+		for key, value := range map[string]string{"encryption": db.Encryption, "data_integrity": db.DataIntegrity} {
+			switch strings.ToLower(value) {
+			case "", "accepted", "rejected", "requested", "required":
+			default:
+				problem("database.%s must be accepted, rejected, requested or required, got %q", key, value)
+			}
+		}
+		if db.TLS.WalletPath != "" {
+			if info, err := os.Stat(db.TLS.WalletPath); err != nil || !info.IsDir() {
+				problem("database.tls.wallet_path %s is not a directory", db.TLS.WalletPath)
+			}
+		}
+		if db.TLS.Enabled && !db.TLS.VerifyServer {
+			warning("database.tls.verify_server is off, so the database server certificate is not checked")
+		}
+		if db.TraceFile != "" {
+			warning("database.trace_file is set; the protocol trace can contain query parameters, including token IDs")
+		}
+	}
+	if purge := AppConfig.TokenPurge; purge.Enabled {
+		if purge.RetentionDays < 1 {
+			problem("token_purge.retention_days must be at least 1, got %d", purge.RetentionDays)
+		}
+		if purge.BatchSize < 1 || purge.BatchSize > 100000 {
+			problem("token_purge.batch_size must be between 1 and 100000, got %d", purge.BatchSize)
+		}
+	}
+	if tokenState := AppConfig.TokenState; tokenState.Enabled {
+		if AppConfig.Stateless.Enabled {
+			warning("token_state is ignored in stateless mode, which already keeps revocations in redis")
+		} else if _, err := newRedisClient(AppConfig.Redis); err != nil {
+			problem("token_state shares token state through redis: %v", err)
+		}
+		if max := AppConfig.TokenLifetime.MaxSeconds; tokenState.RevocationTTLSeconds > 0 && max > tokenState.RevocationTTLSeconds {
+			warning("token_state.revocation_ttl_seconds (%d) is shorter than token_lifetime.max_seconds (%d); client-wide revocations can lapse before the tokens they cover", tokenState.RevocationTTLSeconds, max)
+		}
+	}
+
+	// Rate limits
+	limits := AppConfig.RateLimiting
+	if limits.GlobalRPS <= 0 || limits.GlobalBurst <= 0 {
+		problem("rate_limiting.global_rps and global_burst must be positive, got %d and %d", limits.GlobalRPS, limits.GlobalBurst)
+	}
+	if limits.ClientRPS <= 0 || limits.ClientBurst <= 0 {
+		problem("rate_limiting.client_rps and client_burst must be positive, got %d and %d", limits.ClientRPS, limits.ClientBurst)
+	}
+	if limits.ClientRPS > limits.GlobalRPS && limits.GlobalRPS > 0 {
+		warning("rate_limiting.client_rps (%d) exceeds global_rps (%d), so one client can use the whole global budget", limits.ClientRPS, limits.GlobalRPS)
+	}
+	if limits.FailedAuthPerMinute < 0 {
+		problem("rate_limiting.failed_auth_per_minute must not be negative")
+	}
+	for grant, limit := range limits.Grants {
+		if limit.RPS <= 0 || limit.Burst <= 0 {
+			problem("rate_limiting.grants.%s rps and burst must be positive, got %d and %d", grant, limit.RPS, limit.Burst)
+		}
+	}
+
+	if limits.Persistence.Enabled {
+		if _, err := newRedisClient(AppConfig.Redis); err != nil {
+			problem("rate_limiting.persistence needs redis: %v", err)
+		}
+	}
+
+	// CORS and caches
+	for _, origin := range AppConfig.CORS.AllowedOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			problem("cors.allowed_origins: %q is not an origin such as https://app.example.com", origin)
+		}
+	}
+	if AppConfig.Cache.TokenTTLSeconds < 0 {
+		problem("cache.token_ttl_seconds must not be negative")
+	}
+
+	// Validation callers
+	validation := AppConfig.Validation
+	if validation.RequireResourceServerAuth && len(validation.ResourceServers) == 0 {
+		problem("validation.require_resource_server_auth is on but no validation.resource_servers are registered, so every validation is refused")
+	}
+	resourceServerIDs := make(map[string]bool)
+	for _, server := range validation.ResourceServers {
+		if server.ID == "" {
+			problem("validation.resource_servers: every resource server needs an id")
+			continue
+		}
+		if resourceServerIDs[server.ID] {
+			problem("validation.resource_servers: %q is registered twice", server.ID)
+		}
+		resourceServerIDs[server.ID] = true
+		if server.Secret == "" {
+			warning("validation.resource_servers %q has no secret, so it can only authenticate with a client certificate", server.ID)
+		}
+		for _, claim := range server.Claims {
+			if !validIntrospectClaim(claim) {
+				problem("validation.resource_servers %q: unknown claim %q; use client_id, scopes, expires_at, actor or token_id", server.ID, claim)
+			}
+		}
+	}
+	if validation.CacheHintMaxSeconds < 0 {
+		problem("validation.cache_hint_max_seconds must not be negative, got %d", validation.CacheHintMaxSeconds)
+	}
+	if len(validation.ResourceServers) > 0 && !validation.RequireResourceServerAuth {
+		warning("validation.resource_servers are registered but require_resource_server_auth is off, so anonymous callers still see every claim")
+	}
+
+	// Admin
+	if !adminEnabled() {
+		warning("admin.token is empty and admin.allow_role_tokens is off, so the admin API is disabled; set ADMIN_TOKEN or grant clients the admin role")
+	}
+	for _, network := range AppConfig.Admin.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			problem("admin.allowed_networks: %q is not a CIDR", network)
+		}
+	}
+
+	// Optional components that disable themselves at startup on bad settings
+	if AppConfig.Analytics.Enabled {
+		switch AppConfig.Analytics.Sink {
+		case "clickhouse":
+			if _, err := newClickHouseSink(AppConfig.Analytics.ClickHouse); err != nil {
+				problem("%v", err)
+			}
+		case "bigquery":
+			if _, err := newBigQuerySink(AppConfig.Analytics.BigQuery); err != nil {
+				problem("%v", err)
+			}
+		default:
+			problem("analytics.sink must be clickhouse or bigquery, got %q", AppConfig.Analytics.Sink)
+		}
+	}
+	if audit := AppConfig.Audit; audit.Enabled {
+		switch audit.Sink {
+		case AuditSinkWebhook:
+			if _, err := newAuditWebhookSink(audit.Webhook, audit.TimeoutSeconds); err != nil {
+				problem("%v", err)
+			}
+			if audit.Webhook.SecretEnv == "" {
+				warning("audit.webhook has no secret_env, so the SIEM cannot verify audit batches came from this server")
+			}
+		case AuditSinkKafka:
+			if _, err := newAuditKafkaSink(audit.Kafka, audit.TimeoutSeconds); err != nil {
+				problem("%v", err)
+			}
+		default:
+			problem("audit.sink must be webhook or kafka, got %q", audit.Sink)
+		}
+		known := []string{AuthEventTokenIssued, AuthEventAuthFailed, AuthEventValidationAllowed, AuthEventValidationDenied, AuthEventTokenRevoked}
+		for _, event := range audit.Events {
+			if !slices.Contains(known, event) {
+				problem("audit.events: unknown event type %q", event)
+			}
+		}
+	}
+	if watchdog := AppConfig.MemoryWatchdog; watchdog.Enabled {
+		if watchdog.HeapLimitMB <= 0 && watchdog.RSSLimitMB <= 0 {
+			warning("memory_watchdog is enabled without heap_limit_mb or rss_limit_mb, so it never shrinks the caches")
+		}
+		if watchdog.EvictPercent < 0 || watchdog.EvictPercent > 100 {
+			problem("memory_watchdog.evict_percent must be between 0 and 100, got %d", watchdog.EvictPercent)
+		}
+	}
+	if issuer := AppConfig.Discovery.Issuer; issuer != "" {
+		if u, err := url.Parse(issuer); err != nil || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			problem("discovery.issuer must be an absolute URL without query or fragment, got %q", issuer)
+		} else if u.Scheme != "https" {
+			warning("discovery.issuer %q is not https, which RFC 8414 requires", issuer)
+		}
+	} else if !AppConfig.HTTPSEnabled {
+		warning("discovery.issuer is unset and https is disabled, so metadata advertises the http URL each request reached")
+	}
+	switch signing := AppConfig.Signing; signing.Algorithm {
+	case "", SigningHS256:
+		if signing.PrivateKeyFile != "" {
+			warning("signing.private_key_file is ignored while signing.algorithm is HS256")
+		}
+	case SigningRS256, SigningES256:
+		if _, err := newSigningBackend(nil, signing); err != nil {
+			problem("%v", err)
+		}
+	default:
+		problem("signing.algorithm must be HS256, RS256 or ES256, got %q", signing.Algorithm)
+	}
+	switch secrets := AppConfig.ClientSecrets; secrets.HashAlgorithm {
+	case SecretHashArgon2id, "":
+		if secrets.Argon2MemoryKiB < 0 || secrets.Argon2Iterations < 0 || secrets.Argon2Parallelism < 0 || secrets.Argon2Parallelism > 255 {
+			problem("client_secrets.argon2_memory_kib, argon2_iterations and argon2_parallelism must be positive, and parallelism at most 255")
+		} else if secrets.Argon2MemoryKiB > 0 && secrets.Argon2MemoryKiB < 19*1024 {
+			warning("client_secrets.argon2_memory_kib is %d, below the 19456 KiB OWASP recommends for argon2id", secrets.Argon2MemoryKiB)
+		}
+	case SecretHashBcrypt:
+		if secrets.BcryptCost < bcrypt.MinCost || secrets.BcryptCost > bcrypt.MaxCost {
+			problem("client_secrets.bcrypt_cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, secrets.BcryptCost)
+		} else if secrets.BcryptCost < 10 {
+			warning("client_secrets.bcrypt_cost is %d; below 10 a leaked hash is cheap to brute-force", secrets.BcryptCost)
+		}
+	default:
+		problem("client_secrets.hash_algorithm must be argon2id or bcrypt, got %q", secrets.HashAlgorithm)
+	}
+	if lifetime := AppConfig.ClientAssertion.MaxLifetimeSeconds; lifetime < 0 {
+		problem("client_assertion.max_lifetime_seconds must not be negative")
+	} else if lifetime > 3600 {
+		warning("client_assertion.max_lifetime_seconds is %d; replayed assertions are only refused while they are remembered, so keep them short-lived", lifetime)
+	}
+	if lifetime := AppConfig.TokenLifetime; lifetime.MinSeconds < 0 || lifetime.MaxSeconds < 0 {
+		problem("token_lifetime.min_seconds and max_seconds must not be negative")
+	} else if lifetime.MaxSeconds > 0 && lifetime.MinSeconds > lifetime.MaxSeconds {
+		problem("token_lifetime.min_seconds (%d) exceeds max_seconds (%d)", lifetime.MinSeconds, lifetime.MaxSeconds)
+	} else if rotation := AppConfig.Signing.Rotation; rotation.IntervalHours > 0 && lifetime.MaxSeconds > rotation.RetireAfterHours*3600 {
+		warning("token_lifetime.max_seconds outlasts signing.rotation.retire_after_hours, so long-lived tokens stop validating when their key is retired")
+	}
+	if AppConfig.DPoP.ProofMaxAgeSeconds < 0 {
+		problem("dpop.proof_max_age_seconds must not be negative")
+	} else if AppConfig.DPoP.ProofMaxAgeSeconds > 300 {
+		warning("dpop.proof_max_age_seconds is %d; proofs are meant to be created per request, and old ones widen the replay window", AppConfig.DPoP.ProofMaxAgeSeconds)
+	}
+	if !AppConfig.ClientSecrets.RequireHashed {
+		warning("client secrets stored unhashed are accepted; run `auth clients hash-secrets` and set client_secrets.require_hashed")
+	}
+	if rotation := AppConfig.Signing.Rotation; rotation.IntervalHours > 0 {
+		if algorithm := AppConfig.Signing.Algorithm; algorithm != "" && algorithm != SigningHS256 {
+			warning("signing.rotation rotates managed HS256 keys, which are not used to sign while signing.algorithm is %s", algorithm)
+		}
+		if rotation.PromoteAfterMinutes > 0 && rotation.PromoteAfterMinutes < 5 {
+			warning("signing.rotation.promote_after_minutes is %d, shorter than the 5 minute key reload; 5 is used instead", rotation.PromoteAfterMinutes)
+		}
+		if rotation.RetireAfterHours > 0 && rotation.RetireAfterHours*60 < rotation.PromoteAfterMinutes {
+			problem("signing.rotation.retire_after_hours must be longer than promote_after_minutes")
+		}
+		if rotation.RetireAfterHours > 0 && rotation.RetireAfterHours > rotation.IntervalHours {
+			warning("signing.rotation.retire_after_hours exceeds interval_hours, so several replaced keys verify at once")
+		}
+	}
+	if previous := os.Getenv("JWT_SECRET_PREVIOUS"); previous != "" {
+		if len(previous) < 32 {
+			problem("JWT_SECRET_PREVIOUS must be at least 32 characters")
+		} else if previous == viper.GetString("jwt_secret") {
+			warning("JWT_SECRET_PREVIOUS equals JWT_SECRET; set it to the secret JWT_SECRET replaced")
+		} else {
+			warning("JWT_SECRET_PREVIOUS is set: tokens it signed still validate; unset it once they have expired and every instance has restarted")
+		}
+	}
+	if shadow := AppConfig.ShadowValidation; shadow.Enabled {
+		if shadow.PublicKeyFile == "" && shadow.SecretEnv == "" && !shadow.AcceptCurrentKeys {
+			problem("shadow_validation needs a public_key_file, a secret_env or accept_current_keys")
+		}
+		if shadow.PublicKeyFile != "" && shadow.SecretEnv != "" {
+			warning("shadow_validation sets both public_key_file and secret_env; only public_key_file is used")
+		}
+		for _, alg := range shadow.Algorithms {
+			if jwt.GetSigningMethod(alg) == nil || alg == "none" {
+				problem("shadow_validation.algorithms: unknown algorithm %q", alg)
+			}
+		}
+		if shadow.SamplePercent < 0 || shadow.SamplePercent > 100 {
+			problem("shadow_validation.sample_percent must be between 0 and 100, got %g", shadow.SamplePercent)
+		}
+	}
+	if push := AppConfig.Metrics.Push; push.Enabled {
+		if push.URL == "" {
+			problem("metrics.push.url is required when metrics.push is enabled")
+		}
+		if push.Protocol != MetricsPushOTLP && push.Protocol != MetricsPushPushgateway {
+			problem("metrics.push.protocol must be otlp or pushgateway, got %q", push.Protocol)
+		}
+	}
+	if tracing := AppConfig.Tracing; tracing.Enabled {
+		if tracing.URL == "" {
+			problem("tracing.url is required when tracing is enabled")
+		}
+		if tracing.SampleRatio < 0 || tracing.SampleRatio > 1 {
+			problem("tracing.sample_ratio must be between 0 and 1, got %g", tracing.SampleRatio)
+		}
+	}
+	if AppConfig.DevMode {
+		warning("dev_mode is on, so anyone reaching the public listener can inspect tokens and list the endpoints they grant")
+	}
+	if AppConfig.Webhooks.Enabled && !AppConfig.Webhooks.RequireHTTPS {
+		warning("webhooks.require_https is off, so client webhook events can be delivered over plain http")
+	}
+	writeBehind := AppConfig.TokenStore.DefaultPolicy == persistWriteBehind
+	for _, policy := range AppConfig.TokenStore.Policies {
+		writeBehind = writeBehind || policy == persistWriteBehind
+	}
+	if writeBehind && AppConfig.TokenStore.JournalPath == "" {
+		problem("token_store.journal_path is required for write_behind")
+	}
+
+	return problems, warnings
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// lintTLSFiles checks the certificate and key exist, match, and are not expired
+func lintTLSFiles(certFile, keyFile string, problem, warning func(string, ...any)) {
+	if certFile == "" || keyFile == "" {
+		problem("https_enabled requires cert_file and key_file")
+		return
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); err != nil {
+			problem("TLS file %s: %v", file, err)
+			return
+		}
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		problem("cert_file and key_file do not form a valid key pair: %v", err)
+		return
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		problem("cert_file: %v", err)
+		return
+	}
+	switch remaining := time.Until(leaf.NotAfter); {
+	case remaining <= 0:
+		problem("certificate in %s expired on %s", certFile, leaf.NotAfter.Format(time.DateOnly))
+	case remaining < 30*24*time.Hour:
+		warning("certificate in %s expires on %s", certFile, leaf.NotAfter.Format(time.DateOnly))
+	}
+}
+
+// RedactedConfig is AppConfig keyed by config file names, with secrets replaced, for printing or logging
+func RedactedConfig() map[string]any {
+	effective := configValue(reflect.ValueOf(AppConfig)).(map[string]any)
+	effective["jwt_secret"] = redactIfSet(viper.GetString("jwt_secret"))
+	return effective
+}
+
+func configValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]any)
+		for i := 0; i < v.NumField(); i++ {
+			key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("mapstructure"), ",")
+			if key == "" {
+				continue
+			}
+			if v.Type().Field(i).Tag.Get("secret") == "true" {
+				fields[key] = redactedValue(v.Field(i))
+				continue
+			}
+			fields[key] = configValue(v.Field(i))
+		}
+		return fields
+	case reflect.Map:
+		entries := make(map[string]any)
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(iter.Key().Interface())] = configValue(iter.Value())
+		}
+		return entries
+	case reflect.Slice:
+		items := make([]any, v.Len())
+		for i := range items {
+			items[i] = configValue(v.Index(i))
+		}
+		return items
+	default:
+		return v.Interface()
+	}
+}
+
+// redactedValue is a secret setting as printed: a string or each value of a map replaced when set
+func redactedValue(v reflect.Value) any {
+	if v.Kind() != reflect.Map {
+		return redactIfSet(v.String())
+	}
+	entries := make(map[string]any)
+	iter := v.MapRange()
+	for iter.Next() {
+		entries[fmt.Sprint(iter.Key().Interface())] = redactIfSet(iter.Value().String())
+	}
+	return entries
+}
+
+func redactIfSet(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedConfigValue
+}
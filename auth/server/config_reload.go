@@ -0,0 +1,243 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// Reload triggers, the trigger label of config_reloads_total
+const (
+	reloadTriggerSignal = "sighup"
+	reloadTriggerFile   = "file"
+)
+
+// configReloader serializes reloads, reading the file included since viper is not safe for concurrent
+// use, and remembers the configuration they last applied, to tell what a new version of the file
+// changes. AppConfig keeps the values the server started with
+type configReloader struct {
+	mu      sync.Mutex
+	applied configuration
+}
+
+// tokenCacheTTL is cache.token_ttl_seconds, or an hour when unset
+func tokenCacheTTL(cfg cache_config) time.Duration {
+	if cfg.TokenTTLSeconds <= 0 {
+		return time.Hour
+	}
+	return time.Duration(cfg.TokenTTLSeconds) * time.Second
+}
+
+// watchConfiguration reloads the configuration whenever the config file changes, when
+// config_reload.watch is set. SIGHUP reloads it either way, through ReloadConfiguration. The file is
+// watched here rather than with viper.WatchConfig, which reads it on its own goroutine outside
+// configReload.mu
+func (s *authServer) watchConfiguration() {
+	if !AppConfig.ConfigReload.Watch {
+		return
+	}
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		log.Warn().Msg("No configuration file was read, so there is none to watch for changes")
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error().Err(err).Msg("Could not watch the configuration file")
+		return
+	}
+	// The directory is watched, as editors and Kubernetes config maps replace the file rather than write it
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		log.Error().Err(err).Str("path", path).Msg("Could not watch the configuration file")
+		return
+	}
+	go s.runConfigWatcher(watcher, path)
+	log.Info().Str("path", path).Msg("Watching configuration file for changes")
+}
+
+func (s *authServer) runConfigWatcher(watcher *fsnotify.Watcher, path string) {
+	defer watcher.Close()
+	target, _ := filepath.EvalSymlinks(path)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// A config map swaps the symlink the file resolves through instead of touching the file
+			current, _ := filepath.EvalSymlinks(path)
+			written := filepath.Clean(event.Name) == path && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+			if !written && (current == "" || current == target) {
+				continue
+			}
+			target = current
+			s.reloadConfiguration(reloadTriggerFile)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn().Err(err).Msg("Configuration file watcher failed")
+		}
+	}
+}
+
+// ReloadConfiguration reads the config file again and applies the settings that can change without
+// a restart: logging.level, the global, per-client and failed authentication rate limits,
+// cors.allowed_origins, cache.token_ttl_seconds and validation.result_cache_ttl_seconds. Other
+// changes are logged and take effect at the next restart. The server calls it on SIGHUP
+func (s *authServer) ReloadConfiguration() error {
+	return s.reloadConfiguration(reloadTriggerSignal)
+}
+
+func (s *authServer) reloadConfiguration(trigger string) error {
+	s.configReload.mu.Lock()
+	defer s.configReload.mu.Unlock()
+
+	next, err := readReloadedConfiguration()
+	if err != nil {
+		s.countReload(trigger, "failed")
+		log.Error().Err(err).Str("trigger", trigger).Msg("Configuration reload failed, keeping the current settings")
+		return err
+	}
+
+	previous := s.configReload.applied
+	changed := s.applyReloadable(previous, next)
+	if pending := restartRequired(previous, next); len(pending) > 0 {
+		log.Warn().Strs("sections", pending).Msg("Configuration changes need a restart to take effect")
+	}
+	s.configReload.applied = next
+
+	if len(changed) == 0 {
+		s.countReload(trigger, "unchanged")
+		log.Info().Str("trigger", trigger).Msg("Configuration reloaded, no runtime settings changed")
+		return nil
+	}
+	s.countReload(trigger, "applied")
+	log.Info().Str("trigger", trigger).Strs("settings", changed).Msg("Configuration reloaded")
+	return nil
+}
+
+// readReloadedConfiguration reads the config file again and checks the settings a reload would apply
+func readReloadedConfiguration() (configuration, error) {
+	var next configuration
+	if err := viper.ReadInConfig(); err != nil {
+		return next, fmt.Errorf("reading configuration: %w", err)
+	}
+	if err := viper.Unmarshal(&next); err != nil {
+		return next, fmt.Errorf("config unmarshal failed: %w", err)
+	}
+	applySecretEnvironment(&next)
+
+	limits := next.RateLimiting
+	switch {
+	case limits.GlobalRPS <= 0 || limits.GlobalBurst <= 0:
+		return next, fmt.Errorf("rate_limiting.global_rps and global_burst must be positive, got %d and %d", limits.GlobalRPS, limits.GlobalBurst)
+	case limits.ClientRPS <= 0 || limits.ClientBurst <= 0:
+		return next, fmt.Errorf("rate_limiting.client_rps and client_burst must be positive, got %d and %d", limits.ClientRPS, limits.ClientBurst)
+	case limits.FailedAuthPerMinute < 0:
+		return next, errors.New("rate_limiting.failed_auth_per_minute must not be negative")
+	case next.Validation.ResultCacheTTLSeconds < 0:
+		return next, errors.New("validation.result_cache_ttl_seconds must not be negative")
+	}
+	return next, nil
+}
+
+// applyReloadable applies the runtime settings that differ between previous and next, and returns
+// the names of those it changed
+func (s *authServer) applyReloadable(previous, next configuration) []string {
+	var changed []string
+	if next.Logging.Level != previous.Logging.Level {
+		zerolog.SetGlobalLevel(zerolog.Level(next.Logging.Level))
+		changed = append(changed, "logging.level")
+	}
+
+	limits, previousLimits := next.RateLimiting, previous.RateLimiting
+	if limits.GlobalRPS != previousLimits.GlobalRPS || limits.GlobalBurst != previousLimits.GlobalBurst {
+		if s.globalLimiter != nil {
+			s.globalLimiter.SetLimit(rate.Limit(limits.GlobalRPS))
+			s.globalLimiter.SetBurst(limits.GlobalBurst)
+		}
+		changed = append(changed, "rate_limiting.global_rps", "rate_limiting.global_burst")
+	}
+	if limits.ClientRPS != previousLimits.ClientRPS || limits.ClientBurst != previousLimits.ClientBurst {
+		if s.clientLimits != nil {
+			s.clientLimits.SetLimits(limits.ClientRPS, limits.ClientBurst)
+		}
+		changed = append(changed, "rate_limiting.client_rps", "rate_limiting.client_burst")
+	}
+	if failedAuthReloadable(previousLimits, limits) &&
+		(limits.FailedAuthPerMinute != previousLimits.FailedAuthPerMinute || limits.FailedAuthBurst != previousLimits.FailedAuthBurst) {
+		s.failedAuth.SetLimits(limits.FailedAuthPerMinute, max(limits.FailedAuthBurst, 1))
+		changed = append(changed, "rate_limiting.failed_auth_per_minute", "rate_limiting.failed_auth_burst")
+	}
+
+	if !slices.Equal(next.CORS.AllowedOrigins, previous.CORS.AllowedOrigins) {
+		s.corsOrigins.Set(next.CORS.AllowedOrigins)
+		changed = append(changed, "cors.allowed_origins")
+	}
+	if next.Cache.TokenTTLSeconds != previous.Cache.TokenTTLSeconds {
+		if s.tokenCache != nil {
+			s.tokenCache.SetTTL(tokenCacheTTL(next.Cache))
+		}
+		changed = append(changed, "cache.token_ttl_seconds")
+	}
+	if next.Validation.ResultCacheTTLSeconds != previous.Validation.ResultCacheTTLSeconds {
+		s.validationResults.SetTTL(time.Duration(next.Validation.ResultCacheTTLSeconds) * time.Second)
+		changed = append(changed, "validation.result_cache_ttl_seconds")
+	}
+	return changed
+}
+
+// failedAuthReloadable reports whether the failed authentication limits can change at runtime: the
+// limiter only exists when enabled at startup, so turning it on or off needs a restart
+func failedAuthReloadable(previous, next rate_limiting) bool {
+	return previous.FailedAuthPerMinute > 0 && next.FailedAuthPerMinute > 0
+}
+
+// restartRequired returns the top-level sections in which next differs from previous in settings a
+// reload does not apply
+func restartRequired(previous, next configuration) []string {
+	// With every runtime setting taken from next, whatever still differs is left for a restart
+	pending := previous
+	pending.Logging.Level = next.Logging.Level
+	pending.RateLimiting.GlobalRPS, pending.RateLimiting.GlobalBurst = next.RateLimiting.GlobalRPS, next.RateLimiting.GlobalBurst
+	pending.RateLimiting.ClientRPS, pending.RateLimiting.ClientBurst = next.RateLimiting.ClientRPS, next.RateLimiting.ClientBurst
+	if failedAuthReloadable(previous.RateLimiting, next.RateLimiting) {
+		pending.RateLimiting.FailedAuthPerMinute = next.RateLimiting.FailedAuthPerMinute
+		pending.RateLimiting.FailedAuthBurst = next.RateLimiting.FailedAuthBurst
+	}
+	pending.CORS = next.CORS
+	pending.Cache.TokenTTLSeconds = next.Cache.TokenTTLSeconds
+	pending.Validation.ResultCacheTTLSeconds = next.Validation.ResultCacheTTLSeconds
+
+	var sections []string
+	was, is := reflect.ValueOf(pending), reflect.ValueOf(next)
+	for i := range was.NumField() {
+		if !reflect.DeepEqual(was.Field(i).Interface(), is.Field(i).Interface()) {
+			name, _, _ := strings.Cut(was.Type().Field(i).Tag.Get("mapstructure"), ",")
+			sections = append(sections, name)
+		}
+	}
+	return sections
+}
+
+func (s *authServer) countReload(trigger, result string) {
+	if s.configReloads != nil {
+		s.configReloads.WithLabelValues(trigger, result).Inc()
+	}
+}
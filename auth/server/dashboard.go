@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dashboardFiles is the admin dashboard: a static page that browses clients, endpoints, recent tokens
+// and cache/batcher status through the admin API, so small teams need no separate frontend
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// Recent tokens listed by default and at most
+const (
+	defaultRecentTokens = 50
+	maxRecentTokens     = 500
+)
+
+func (as *authServer) activeClients(ctx context.Context) ([]*AdminClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := as.db.QueryContext(ctx, "SELECT "+adminClientColumns+" FROM clients WHERE deleted_at IS NULL ORDER BY client_id")
+	if err != nil {
+		return nil, fmt.Errorf("activeClients: %v", err)
+	}
+	defer rows.Close()
+
+	clients := make([]*AdminClient, 0)
+	for rows.Next() {
+		client, err := scanAdminClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("activeClients: %v", err)
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// allEndpoints returns every endpoint mapping, inactive ones included, unlike the endpoint cache
+func (as *authServer) allEndpoints(ctx context.Context) ([]*Endpoints, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := as.db.QueryContext(ctx, "SELECT "+endpointColumns+" FROM endpoints ORDER BY endpoint_url, method")
+	if err != nil {
+		return nil, fmt.Errorf("allEndpoints: %v", err)
+	}
+	defer rows.Close()
+
+	endpoints := make([]*Endpoints, 0)
+	for rows.Next() {
+		endpoint, err := scanEndpoint(rows)
+		if err != nil {
+			return nil, fmt.Errorf("allEndpoints: %v", err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, rows.Err()
+}
+
+// rowLimit limits a query to the number of rows bound to marker. FETCH FIRST is Oracle's; SQLite
+// only takes LIMIT, as Postgres also does
+func rowLimit(marker string) string {
+	if databaseDriver() == DriverOracle {
+		return " FETCH FIRST " + marker + " ROWS ONLY"
+	}
+	return " LIMIT " + marker
+}
+
+// recentTokens returns the latest issued access tokens, optionally of one client, newest first
+func (as *authServer) recentTokens(ctx context.Context, clientID string, limit int) ([]*LineageToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var rows *sql.Rows
+	var err error
+	if clientID != "" {
+		rows, err = as.db.QueryContext(ctx, "SELECT "+lineageTokenColumns+" FROM tokens WHERE client_id = :1 ORDER BY issued_at DESC"+rowLimit(":2"), clientID, limit)
+	} else {
+		rows, err = as.db.QueryContext(ctx, "SELECT "+lineageTokenColumns+" FROM tokens ORDER BY issued_at DESC"+rowLimit(":1"), limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recentTokens: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]*LineageToken, 0)
+	for rows.Next() {
+		token, err := scanLineageToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("recentTokens: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// List clients handler (admin)
+func (as *authServer) listClientsHandler(c *gin.Context) {
+	clients, err := as.activeClients(c.Request.Context())
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// List endpoints handler (admin)
+func (as *authServer) listEndpointsHandler(c *gin.Context) {
+	endpoints, err := as.allEndpoints(c.Request.Context())
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"endpoints": endpoints})
+}
+
+// List recent tokens handler (admin): ?client_id= narrows to one client, ?limit= caps the count
+func (as *authServer) listRecentTokensHandler(c *gin.Context) {
+	limit := defaultRecentTokens
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxRecentTokens {
+			RespondWithError(c, ErrBadRequest(fmt.Sprintf("limit must be between 1 and %d", maxRecentTokens)))
+			return
+		}
+		limit = n
+	}
+
+	tokens, err := as.recentTokens(c.Request.Context(), c.Query("client_id"), limit)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// dashboardHandler serves the dashboard's static files. They hold no data and a browser cannot send
+// X-Admin-Token when loading a page, so they are served to anyone who can reach the admin listener;
+// the page asks for the admin token and everything it shows comes from admin API calls made with it.
+// Like the admin API, the dashboard is off until admin.token is set
+func dashboardHandler() gin.HandlerFunc {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	fileServer := http.StripPrefix("/auth-server/admin", http.FileServer(http.FS(files)))
+	return func(c *gin.Context) {
+		if !adminEnabled() {
+			RespondWithError(c, ErrForbiddenError("Admin API is disabled"))
+			return
+		}
+		c.Header("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Cache-Control", "no-store")
+		if c.Param("filepath") == "" {
+			// Serve index.html for /auth-server/admin itself rather than redirecting to a trailing slash
+			c.Request.URL.Path = "/auth-server/admin/"
+		}
+		fileServer.ServeHTTP(c.Writer, c.Request)
+	}
+}
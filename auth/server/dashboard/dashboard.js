@@ -0,0 +1,195 @@
+// Admin dashboard: every view is read from the admin API with the token entered on sign-in, which is
+// kept in sessionStorage so it is forgotten when the tab closes.
+(function () {
+  "use strict";
+
+  const api = "/auth-server/v1/admin";
+  const tokenKey = "auth-admin-token";
+  const loaders = { clients: loadClients, endpoints: loadEndpoints, tokens: loadTokens, status: loadStatus };
+
+  const $ = (selector, root) => (root || document).querySelector(selector);
+
+  async function get(path, allowUnavailable) {
+    const response = await fetch(path, { headers: { "X-Admin-Token": sessionStorage.getItem(tokenKey) || "" } });
+    if (response.status === 401 || response.status === 403) {
+      signOut();
+      throw new Error("The admin token was refused");
+    }
+    // /health/detail answers 503 with its full body while degraded
+    if (!response.ok && !(allowUnavailable && response.status === 503)) {
+      const body = await response.json().catch(() => ({}));
+      throw new Error(body.error_description || response.statusText);
+    }
+    return response.json();
+  }
+
+  function cell(text, className) {
+    const td = document.createElement("td");
+    td.textContent = text === undefined || text === null ? "" : String(text);
+    if (className) {
+      td.className = className;
+    }
+    return td;
+  }
+
+  function fillTable(table, rows, render) {
+    const body = $("tbody", table);
+    body.replaceChildren();
+    for (const row of rows) {
+      const tr = document.createElement("tr");
+      for (const td of render(row)) {
+        tr.appendChild(td);
+      }
+      body.appendChild(tr);
+    }
+  }
+
+  function time(value) {
+    return value ? new Date(value).toLocaleString() : "";
+  }
+
+  function list(values) {
+    return (values || []).join(" ");
+  }
+
+  // Filters rows already on the page by their text
+  function bindFilter(section) {
+    const input = $("input.filter", section);
+    input.addEventListener("input", () => {
+      const needle = input.value.toLowerCase();
+      for (const tr of section.querySelectorAll("tbody tr")) {
+        tr.hidden = needle !== "" && !tr.textContent.toLowerCase().includes(needle);
+      }
+    });
+  }
+
+  async function loadClients() {
+    const { clients } = await get(api + "/clients");
+    fillTable($("#clients table"), clients, (client) => [
+      cell(client.client_id, "mono"),
+      cell(client.name),
+      cell(client.access_token_ttl),
+      cell(list(client.allowed_scopes)),
+      cell(list(client.default_scopes)),
+    ]);
+  }
+
+  async function loadEndpoints() {
+    const { endpoints } = await get(api + "/endpoints");
+    fillTable($("#endpoints table"), endpoints, (endpoint) => {
+      const active = endpoint.active === 1;
+      return [
+        cell(endpoint.api_url, "mono" + (active ? "" : " inactive")),
+        cell(endpoint.method),
+        cell(endpoint.required_scopes && endpoint.required_scopes.length ? list(endpoint.required_scopes) : endpoint.scope),
+        cell(endpoint.scope_mode || "ANY"),
+        cell(endpoint.client_id, "mono"),
+        cell(active ? "yes" : "no"),
+        cell(endpoint.description),
+      ];
+    });
+  }
+
+  async function loadTokens() {
+    const form = $("#tokens form");
+    const query = new URLSearchParams();
+    if (form.client_id.value) {
+      query.set("client_id", form.client_id.value);
+    }
+    query.set("limit", form.limit.value || "50");
+    const { tokens } = await get(api + "/tokens?" + query);
+    fillTable($("#tokens table"), tokens, (token) => [
+      cell(token.token_id, "mono"),
+      cell(token.token_type),
+      cell(token.client_id, "mono"),
+      cell(time(token.issued_at)),
+      cell(time(token.expires_at)),
+      token.revoked
+        ? cell(time(token.revoked_at) + (token.revocation_reason ? " (" + token.revocation_reason + ")" : ""), "revoked")
+        : cell(""),
+    ]);
+  }
+
+  async function loadStatus() {
+    const detail = await get("/auth-server/health/detail", true);
+    $("#status-summary").textContent =
+      "Status " + detail.status + " at " + time(detail.time) + (detail.region ? " in " + detail.region : "");
+
+    const caches = Object.entries(detail.caches || {}).sort(([a], [b]) => a.localeCompare(b));
+    fillTable($("#status-caches"), caches, ([name, cache]) => [
+      cell(name),
+      cell(cache.size),
+      cell(cache.refreshed_at ? time(cache.refreshed_at) + " (" + Math.round(cache.age_seconds) + "s ago)" : ""),
+    ]);
+
+    const batcher = detail.token_batcher || {};
+    const flush = batcher.last_flush;
+    fillTable($("#status-batcher"), [
+      ["Pending", batcher.pending],
+      ["Journaled", batcher.journaled],
+      ["Last flush", flush ? time(flush.at) + ", " + flush.batch_size + " tokens" + (flush.error ? ", failed: " + flush.error : "") : "none yet"],
+    ], ([label, value]) => [cell(label), cell(value)]);
+
+    const db = detail.database || {};
+    fillTable($("#status-database"), [
+      ["Status", db.status + (db.error ? ": " + db.error : "")],
+      ["Active", db.active],
+      ["Ping", db.ping_latency_ms + " ms"],
+      ["Connections", db.in_use + " in use, " + db.idle + " idle, " + db.max_open_connections + " max"],
+      ["Waits", db.wait_count + " (" + db.wait_duration_ms + " ms)"],
+    ], ([label, value]) => [cell(label), cell(value)]);
+  }
+
+  async function show(tab) {
+    for (const button of document.querySelectorAll("#tabs button[data-tab]")) {
+      button.classList.toggle("active", button.dataset.tab === tab);
+    }
+    for (const name of Object.keys(loaders)) {
+      $("#" + name).hidden = name !== tab;
+    }
+    const error = $("#error");
+    error.hidden = true;
+    try {
+      await loaders[tab]();
+    } catch (err) {
+      error.textContent = err.message;
+      error.hidden = false;
+    }
+  }
+
+  function signOut() {
+    sessionStorage.removeItem(tokenKey);
+    $("#tabs").hidden = true;
+    for (const name of Object.keys(loaders)) {
+      $("#" + name).hidden = true;
+    }
+    $("#sign-in").hidden = false;
+  }
+
+  function signedIn() {
+    $("#sign-in").hidden = true;
+    $("#tabs").hidden = false;
+    show("clients");
+  }
+
+  $("#sign-in").addEventListener("submit", (event) => {
+    event.preventDefault();
+    sessionStorage.setItem(tokenKey, $("#admin-token").value);
+    $("#admin-token").value = "";
+    signedIn();
+  });
+  $("#sign-out").addEventListener("click", signOut);
+  for (const button of document.querySelectorAll("#tabs button[data-tab]")) {
+    button.addEventListener("click", () => show(button.dataset.tab));
+  }
+  $("#tokens form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    show("tokens");
+  });
+  bindFilter($("#clients"));
+  bindFilter($("#endpoints"));
+
+  if (sessionStorage.getItem(tokenKey)) {
+    signedIn();
+  }
+})();
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// errDatabaseUnavailable is returned instead of querying while the health monitor has the database marked down
+var errDatabaseUnavailable = errors.New("database is unavailable")
+
+// dbHealthMonitor pings the database on an interval and marks it down after consecutive failures, so
+// request paths can fail fast instead of each one waiting out its query timeout, and /readyz takes the
+// instance out of rotation. A single successful ping marks it up again. Each check also exports the
+// connection pool's stats
+type dbHealthMonitor struct {
+	ping     func(ctx context.Context) error
+	stats    func() sql.DBStats // nil when there is no pool to report
+	cfg      database_health
+	label    string // db label of the gauges: the database driver
+	down     atomic.Bool
+	failures int // consecutive failed pings; only touched by Check
+
+	status            *prometheus.GaugeVec
+	connectionsActive *prometheus.GaugeVec
+	connectionsIdle   *prometheus.GaugeVec
+	done              chan struct{}
+}
+
+func newDBHealthMonitor(ping func(ctx context.Context) error, stats func() sql.DBStats, cfg database_health) *dbHealthMonitor {
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 5
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.RetryAfterSeconds <= 0 {
+		cfg.RetryAfterSeconds = cfg.IntervalSeconds
+	}
+	return &dbHealthMonitor{
+		ping:  ping,
+		stats: stats,
+		cfg:   cfg,
+		label: databaseDriver(),
+		done:  make(chan struct{}),
+	}
+}
+
+// Start pings in the background until Stop is called. Metrics must be registered first
+func (hm *dbHealthMonitor) Start(status, connectionsActive, connectionsIdle *prometheus.GaugeVec) {
+	if hm == nil {
+		return
+	}
+	hm.status = status
+	hm.connectionsActive = connectionsActive
+	hm.connectionsIdle = connectionsIdle
+	hm.Check()
+	go func() {
+		ticker := time.NewTicker(time.Duration(hm.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hm.done:
+				return
+			case <-ticker.C:
+				hm.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops the background pings
+func (hm *dbHealthMonitor) Stop() {
+	if hm == nil {
+		return
+	}
+	close(hm.done)
+}
+
+// Check pings the database once and updates the up/down state
+func (hm *dbHealthMonitor) Check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := hm.ping(ctx)
+
+	if err == nil {
+		hm.failures = 0
+		if hm.down.Swap(false) {
+			log.Info().Msg("Database reachable again, leaving fail-fast mode")
+		}
+	} else {
+		hm.failures++
+		if hm.failures >= hm.cfg.FailureThreshold && !hm.down.Swap(true) {
+			log.Error().Err(err).Int("failures", hm.failures).Msg("Database marked down, failing fast until it recovers")
+		}
+	}
+
+	if hm.status != nil {
+		value := 1.0
+		if hm.down.Load() {
+			value = 0
+		}
+		hm.status.WithLabelValues(hm.label).Set(value)
+	}
+	if hm.stats != nil && hm.connectionsActive != nil {
+		stats := hm.stats()
+		hm.connectionsActive.WithLabelValues(hm.label).Set(float64(stats.InUse))
+		hm.connectionsIdle.WithLabelValues(hm.label).Set(float64(stats.Idle))
+	}
+}
+
+// Down reports whether the database is currently marked down; a nil monitor never is
+func (hm *dbHealthMonitor) Down() bool {
+	return hm != nil && hm.down.Load()
+}
+
+// SetRetryAfter tells the caller when the database's state will next be checked
+func (hm *dbHealthMonitor) SetRetryAfter(c *gin.Context) {
+	if hm == nil {
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(hm.cfg.RetryAfterSeconds))
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"auth/auth/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// errTokenNotFound is returned by getTokenInfo when the token has no row; unlike a failed lookup it
+// says nothing about the database's health
+var errTokenNotFound = store.ErrTokenNotFound
+
+// revocationBreaker is the circuit breaker in front of /validate's revocation lookup. After enough
+// consecutive failed lookups it opens, and validation stops asking the database: tokens are accepted
+// on signature and expiry plus whatever the token cache knows, and the response is flagged degraded.
+// After OpenSeconds one lookup is let through; if it succeeds the breaker closes again
+type revocationBreaker struct {
+	cfg degraded_mode
+
+	mu       sync.Mutex
+	failures int       // consecutive failed lookups while closed
+	openedAt time.Time // zero while closed
+	probing  bool      // a half-open trial lookup is in flight
+	state    *prometheus.GaugeVec
+}
+
+// newRevocationBreaker returns nil unless validation.degraded_mode is enabled
+func newRevocationBreaker(cfg degraded_mode) *revocationBreaker {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.OpenSeconds <= 0 {
+		cfg.OpenSeconds = 30
+	}
+	return &revocationBreaker{cfg: cfg}
+}
+
+// Start publishes the breaker's state. Metrics must be registered first
+func (rb *revocationBreaker) Start(state *prometheus.GaugeVec) {
+	if rb == nil {
+		return
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.state = state
+	rb.publish()
+}
+
+// Allow reports whether the revocation lookup may be attempted. While open only one trial lookup is
+// allowed once OpenSeconds have passed
+func (rb *revocationBreaker) Allow() bool {
+	if rb == nil {
+		return true
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.openedAt.IsZero() {
+		return true
+	}
+	if rb.probing || time.Since(rb.openedAt) < time.Duration(rb.cfg.OpenSeconds)*time.Second {
+		return false
+	}
+	rb.probing = true
+	return true
+}
+
+// Success closes the breaker
+func (rb *revocationBreaker) Success() {
+	if rb == nil {
+		return
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.failures = 0
+	rb.probing = false
+	if !rb.openedAt.IsZero() {
+		rb.openedAt = time.Time{}
+		log.Info().Msg("Revocation lookups succeeding again, leaving degraded validation mode")
+		rb.publish()
+	}
+}
+
+// Failure counts a failed lookup, opening the breaker at the threshold or reopening it after a failed trial
+func (rb *revocationBreaker) Failure(err error) {
+	if rb == nil {
+		return
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.openedAt.IsZero() {
+		if rb.probing {
+			rb.probing = false
+			rb.openedAt = time.Now()
+		}
+		return
+	}
+	rb.failures++
+	if rb.failures >= rb.cfg.FailureThreshold {
+		rb.openedAt = time.Now()
+		log.Error().Err(err).Int("failures", rb.failures).Msg("Revocation lookups failing, entering degraded validation mode")
+		rb.publish()
+	}
+}
+
+// Open reports whether validation is currently degraded
+func (rb *revocationBreaker) Open() bool {
+	if rb == nil {
+		return false
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return !rb.openedAt.IsZero()
+}
+
+func (rb *revocationBreaker) publish() {
+	if rb.state == nil {
+		return
+	}
+	value := 0.0
+	if !rb.openedAt.IsZero() {
+		value = 1
+	}
+	rb.state.WithLabelValues("oracle").Set(value)
+}
+
+// tokenStatus looks up whether a signature-valid token is revoked. When degraded mode is enabled and
+// the lookup cannot reach the database, or the breaker is open, the token is accepted as unrevoked
+// with degraded set. One-time tokens are still refused, since single use cannot be enforced without
+// the database
+func (as *authServer) tokenStatus(ctx context.Context, claims *Claims) (revoked bool, tokenType string, degraded bool, err error) {
+	// Cached tokens never need the database, breaker or not
+	if cached, found := as.tokenCache.Get(claims.TokenID); found && cached != nil {
+		logger := GetContextLogger(ctx)
+		logger.Debug().Str("token_id", claims.TokenID).Msg("Token found in cache (hit)")
+		return cached.Revoked, cached.TokenType, false, nil
+	}
+
+	if !as.revocationBreaker.Allow() {
+		err = errDatabaseUnavailable
+	} else {
+		revoked, tokenType, err = as.getTokenInfo(ctx, claims.TokenID)
+		if err == nil && as.stateless != nil {
+			tokenType = claims.TokenType
+		}
+		if err == nil || errors.Is(err, errTokenNotFound) {
+			as.revocationBreaker.Success()
+			return revoked, tokenType, false, err
+		}
+		as.revocationBreaker.Failure(err)
+	}
+
+	if as.revocationBreaker == nil || (!as.revocationBreaker.Open() && !errors.Is(err, errDatabaseUnavailable)) {
+		return false, "", false, err
+	}
+	if claims.TokenType == "O" {
+		return false, "", false, err
+	}
+	return false, claims.TokenType, true, nil
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Delegation modes stored in delegation_policies.mode
+const (
+	DelegationModeDelegate    = "delegation"    // issued token carries an act claim naming the actor
+	DelegationModeImpersonate = "impersonation" // issued token is indistinguishable from one issued to the subject
+)
+
+// DelegationPolicy allows an actor client to obtain tokens on behalf of a subject client
+type DelegationPolicy struct {
+	ActorClientID   string   `json:"actor_client_id"`
+	SubjectClientID string   `json:"subject_client_id"`
+	Mode            string   `json:"mode"`
+	AllowedScopes   []string `json:"allowed_scopes"` // empty means all of the subject's scopes
+}
+
+// Actor identifies the client acting on behalf of the token subject (RFC 8693 act claim)
+type Actor struct {
+	ClientID string `json:"client_id"`
+}
+
+type delegationCache struct {
+	mu    sync.RWMutex
+	cache map[string]*DelegationPolicy // actor|subject -> policy
+}
+
+func delegationKey(actorID, subjectID string) string {
+	return actorID + "|" + subjectID
+}
+
+func newDelegationCache() *delegationCache {
+	return &delegationCache{
+		cache: make(map[string]*DelegationPolicy),
+	}
+}
+
+// Get retrieves the policy allowing actorID to act for subjectID
+func (dc *delegationCache) Get(actorID, subjectID string) (*DelegationPolicy, bool) {
+	dc.mu.RLock()
+	cached, exists := dc.cache[delegationKey(actorID, subjectID)]
+	dc.mu.RUnlock()
+	if !exists || cached == nil {
+		return nil, false
+	}
+	return cached, true
+}
+
+// Set stores a delegation policy in cache
+func (dc *delegationCache) Set(policy *DelegationPolicy) {
+	if policy == nil {
+		log.Warn().Msg("Attempted to cache nil delegation policy, skipping")
+		return
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.cache[delegationKey(policy.ActorClientID, policy.SubjectClientID)] = policy
+}
+
+// Invalidate removes a specific policy from cache
+func (dc *delegationCache) Invalidate(actorID, subjectID string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	delete(dc.cache, delegationKey(actorID, subjectID))
+}
+
+// Replace atomically swaps the cache contents, dropping policies no longer present or active
+func (dc *delegationCache) Replace(policies map[string]*DelegationPolicy) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.cache = policies
+}
+
+// Clear removes all policies from cache
+func (dc *delegationCache) Clear() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.cache = make(map[string]*DelegationPolicy)
+}
+
+// GetSize returns current number of entries in cache
+func (dc *delegationCache) GetSize() int {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	return len(dc.cache)
+}
+
+// populateDelegationCache loads active delegation policies and replaces the cache, so calling it
+// again acts as a refresh that drops policies deactivated or removed since the last load
+func (s *authServer) populateDelegationCache() {
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Minute)
+	defer cancel()
+
+	query := `SELECT actor_client_id, subject_client_id, mode, allowed_scopes FROM delegation_policies WHERE active = 1`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to populate delegation cache")
+		return
+	}
+	defer rows.Close()
+
+	if s.delegationCache == nil {
+		s.delegationCache = newDelegationCache()
+	}
+
+	loaded := newDelegationCache()
+	for rows.Next() {
+		policy := &DelegationPolicy{}
+		var scope scopeList
+		if err = rows.Scan(&policy.ActorClientID, &policy.SubjectClientID, &policy.Mode, &scope); err != nil {
+			log.Error().Str("actor_client_id", policy.ActorClientID).Msgf("failed to retrieve row while populating delegation cache: %s", err)
+			continue
+		}
+		policy.AllowedScopes = scope
+		loaded.Set(policy)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Error().Err(err).Msg("rows iteration error in populating delegation cache")
+		return
+	}
+	s.delegationCache.Replace(loaded.cache)
+	s.cacheRefreshes.Mark("delegation")
+}
+
+func (as *authServer) delegationPolicy(actorID, subjectID string) (*DelegationPolicy, error) {
+	log.Trace().Str("actor_client_id", actorID).Str("subject_client_id", subjectID).Msg("Looking up delegation policy in database")
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	var policy DelegationPolicy
+	var scope scopeList
+
+	query := "SELECT actor_client_id, subject_client_id, mode, allowed_scopes FROM delegation_policies WHERE actor_client_id = :1 AND subject_client_id = :2 AND active = 1"
+	stmt, err := as.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if err := stmt.QueryRowContext(ctx, actorID, subjectID).Scan(&policy.ActorClientID, &policy.SubjectClientID, &policy.Mode, &scope); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("delegationPolicy %s -> %s: %v", actorID, subjectID, err)
+	}
+
+	policy.AllowedScopes = scope
+	return &policy, nil
+}
+
+// resolveDelegation checks that actor may act for subjectID and returns the subject client
+// with scopes narrowed by the policy, plus the actor to embed in the token (nil when impersonating)
+func (as *authServer) resolveDelegation(ctx context.Context, actor *Clients, subjectID string) (*Clients, *Actor, error) {
+	logger := GetContextLogger(ctx)
+	policy, found := as.delegationCache.Get(actor.ClientID, subjectID)
+	if !found {
+		var err error
+		policy, err = as.delegationPolicy(actor.ClientID, subjectID)
+		if err != nil {
+			logger.Error().Err(err).Str("actor_client_id", actor.ClientID).Str("subject_client_id", subjectID).Msg("Database error while fetching delegation policy")
+			return nil, nil, ErrInternalServerError("Failed to lookup delegation policy").WithOriginalError(err)
+		}
+		if policy == nil {
+			logger.Warn().Str("actor_client_id", actor.ClientID).Str("subject_client_id", subjectID).Msg("No delegation policy for actor and subject")
+			return nil, nil, ErrForbiddenError("Client is not permitted to act on behalf of the requested subject")
+		}
+		as.delegationCache.Set(policy)
+	}
+
+	subject, found := as.clientCache.Get(subjectID)
+	if !found {
+		var err error
+		subject, err = as.clientByID(ctx, subjectID)
+		if err != nil || subject == nil {
+			logger.Warn().Err(err).Str("subject_client_id", subjectID).Msg("Delegation subject not found")
+			return nil, nil, ErrBadRequest("Unknown on_behalf_of client")
+		}
+		as.clientCache.Set(subjectID, subject)
+	}
+
+	// Copy the subject so narrowing scopes doesn't mutate the cached client
+	delegated := *subject
+	if len(policy.AllowedScopes) > 0 {
+		delegated.AllowedScopes = make([]string, 0, len(subject.AllowedScopes))
+		for _, scope := range subject.AllowedScopes {
+			if slices.Contains(policy.AllowedScopes, scope) {
+				delegated.AllowedScopes = append(delegated.AllowedScopes, scope)
+			}
+		}
+	}
+
+	logger.Info().
+		Str("actor_client_id", actor.ClientID).
+		Str("subject_client_id", subjectID).
+		Str("mode", policy.Mode).
+		Msg("Delegated token request authorized")
+
+	if policy.Mode == DelegationModeImpersonate {
+		return &delegated, nil, nil
+	}
+	return &delegated, &Actor{ClientID: actor.ClientID}, nil
+}
+
+// delegationFailureReason is the api_errors_total reason for an error returned by resolveDelegation
+func delegationFailureReason(err *APIError) string {
+	switch err.Code {
+	case ErrForbidden:
+		return "delegation_denied"
+	case ErrInvalidRequest:
+		return "unknown_subject"
+	default:
+		return "delegation_lookup_failed"
+	}
+}
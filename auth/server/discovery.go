@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metadataPath is where RFC 8414 clients look for authorization server metadata
+const metadataPath = "/.well-known/oauth-authorization-server"
+
+// AuthorizationServerMetadata is the RFC 8414 document gateways and SDKs configure themselves from.
+// This server has no authorization endpoint, so response_types_supported is always empty
+type AuthorizationServerMetadata struct {
+	Issuer                               string   `json:"issuer"`
+	TokenEndpoint                        string   `json:"token_endpoint"`
+	RevocationEndpoint                   string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint                string   `json:"introspection_endpoint"`
+	GrantTypesSupported                  []string `json:"grant_types_supported"`
+	ResponseTypesSupported               []string `json:"response_types_supported"`
+	TokenEndpointAuthMethodsSupported    []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgs         []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	DPoPSigningAlgs                      []string `json:"dpop_signing_alg_values_supported"` // RFC 9449 section 5.1
+	JWKSURI                              string   `json:"jwks_uri,omitempty"`
+	AccessTokenSigningAlgValuesSupported []string `json:"access_token_signing_alg_values_supported"` // not in RFC 8414, which allows additions
+	ServiceDocumentation                 string   `json:"service_documentation"`
+}
+
+// signingAlgorithms lists the algorithms access tokens are signed with, or still accepted in
+func signingAlgorithms() []string {
+	algorithm := AppConfig.Signing.Algorithm
+	if algorithm == "" || algorithm == SigningHS256 {
+		return []string{SigningHS256}
+	}
+	if AppConfig.Signing.AcceptHMAC {
+		return []string{algorithm, SigningHS256}
+	}
+	return []string{algorithm}
+}
+
+// issuerURL is discovery.issuer or, when it is unset, the scheme and host the request reached
+func issuerURL(c *gin.Context) string {
+	if issuer := strings.TrimSuffix(AppConfig.Discovery.Issuer, "/"); issuer != "" {
+		return issuer
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// authorizationServerMetadata describes this server as seen by the caller of c
+func authorizationServerMetadata(c *gin.Context) AuthorizationServerMetadata {
+	issuer := issuerURL(c)
+	metadata := AuthorizationServerMetadata{
+		Issuer:                               issuer,
+		TokenEndpoint:                        issuer + "/auth-server/v1/oauth/token",
+		RevocationEndpoint:                   issuer + "/auth-server/v1/oauth/revoke",
+		IntrospectionEndpoint:                issuer + "/auth-server/v1/oauth/validate",
+		GrantTypesSupported:                  []string{"client_credentials"},
+		ResponseTypesSupported:               []string{},
+		TokenEndpointAuthMethodsSupported:    []string{"client_secret_basic", "client_secret_post", "private_key_jwt"},
+		TokenEndpointAuthSigningAlgs:         []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"},
+		DPoPSigningAlgs:                      dpopMethods,
+		AccessTokenSigningAlgValuesSupported: signingAlgorithms(),
+		ServiceDocumentation:                 issuer + "/auth-server/v1/openapi.json",
+	}
+	if algorithm := AppConfig.Signing.Algorithm; algorithm != "" && algorithm != SigningHS256 {
+		metadata.JWKSURI = issuer + jwksPath
+	}
+	return metadata
+}
+
+// clientAssertionAudiences are the aud values a private_key_jwt assertion may carry: the issuer, or the
+// token endpoint it is presented to (RFC 7523 section 3)
+func clientAssertionAudiences(c *gin.Context) []string {
+	issuer := issuerURL(c)
+	return []string{issuer, issuer + c.Request.URL.Path, issuer + "/auth-server/v1/oauth/token"}
+}
+
+// Authorization server metadata handler (RFC 8414)
+func metadataHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, authorizationServerMetadata(c))
+}
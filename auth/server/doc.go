@@ -0,0 +1,7 @@
+// Package server is the token server: configuration, the HTTP handlers, token issuance and
+// validation, and the SQL Store they share through authServer, backed by Oracle by default.
+//
+// It builds on the other auth packages: the models and Store interface in auth/store, the caches in
+// auth/cache, the Prometheus registry in auth/metrics and the HTTP middleware in auth/middleware.
+// A Store implemented elsewhere replaces the database for the request path
+package server
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DPoP (RFC 9449) binds a token to a key the client holds: each request carries a proof JWT signed with
+// that key, so a token copied out of a log is useless without it
+const (
+	dpopHeader    = "DPoP"
+	dpopProofType = "dpop+jwt"
+	dpopScheme    = "DPoP" // Authorization scheme of DPoP-bound tokens, and token_type of their responses
+)
+
+// dpopMethods are the proof algorithms accepted; proofs must be signed with a public key
+var dpopMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// Confirmation is the cnf claim (RFC 7800) binding a token to a proof-of-possession key
+type Confirmation struct {
+	JKT string `json:"jkt"` // RFC 7638 thumbprint of the DPoP key
+}
+
+// dpopClaims are the claims of a DPoP proof
+type dpopClaims struct {
+	HTM string `json:"htm"`           // HTTP method of the request the proof is for
+	HTU string `json:"htu"`           // its URL, without query and fragment
+	ATH string `json:"ath,omitempty"` // base64url SHA-256 of the access token presented with it
+	jwt.RegisteredClaims
+}
+
+// verifyDPoPProof checks proof for a request of method to uri, presenting accessToken when it is not
+// empty, and returns the thumbprint of the key that signed it. A proof is accepted once, within
+// dpop.proof_max_age_seconds of its iat
+func (as *authServer) verifyDPoPProof(proof, method, uri, accessToken string) (string, error) {
+	var jwk JSONWebKey
+	claims := &dpopClaims{}
+	_, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (any, error) {
+		if typ, _ := token.Header["typ"].(string); typ != dpopProofType {
+			return nil, fmt.Errorf("typ must be %s", dpopProofType)
+		}
+		header, ok := token.Header["jwk"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("no jwk header")
+		}
+		if _, private := header["d"]; private {
+			return nil, fmt.Errorf("jwk header holds a private key")
+		}
+		raw, _ := json.Marshal(header)
+		if err := json.Unmarshal(raw, &jwk); err != nil {
+			return nil, fmt.Errorf("jwk header: %v", err)
+		}
+		return jwk.PublicKey()
+	}, jwt.WithValidMethods(dpopMethods), jwt.WithIssuedAt(), jwt.WithLeeway(clientAssertionLeeway))
+	if err != nil {
+		return "", err
+	}
+
+	maxAge := time.Duration(AppConfig.DPoP.ProofMaxAgeSeconds) * time.Second
+	if maxAge <= 0 {
+		maxAge = time.Minute
+	}
+	switch {
+	case claims.ID == "":
+		return "", fmt.Errorf("proof has no jti")
+	case claims.IssuedAt == nil || time.Since(claims.IssuedAt.Time) > maxAge+clientAssertionLeeway:
+		return "", fmt.Errorf("proof iat is missing or older than %s", maxAge)
+	case !strings.EqualFold(claims.HTM, method):
+		return "", fmt.Errorf("proof htm %q does not match %s", claims.HTM, method)
+	case !sameHTU(claims.HTU, uri):
+		return "", fmt.Errorf("proof htu %q does not match %s", claims.HTU, uri)
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", fmt.Errorf("proof ath does not match the access token")
+		}
+	}
+
+	jkt := jwk.Thumbprint()
+	if !as.usedDPoPProofs.Use(jkt, claims.ID, claims.IssuedAt.Time.Add(maxAge+2*clientAssertionLeeway)) {
+		return "", fmt.Errorf("proof %s was already used", claims.ID)
+	}
+	return jkt, nil
+}
+
+// sameHTU compares a proof's htu with the request URL, ignoring query, fragment and the case of the
+// scheme and host (RFC 9449 section 4.3)
+func sameHTU(htu, uri string) bool {
+	a, errA := url.Parse(htu)
+	b, errB := url.Parse(uri)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
+
+// dpopTokenBinding verifies the DPoP proof of a token request and returns the thumbprint to bind the
+// token to, or "" for a bearer token. With dpop.required every token request must carry a proof
+func (as *authServer) dpopTokenBinding(c *gin.Context) (string, *APIError) {
+	proof := c.GetHeader(dpopHeader)
+	if proof == "" {
+		if AppConfig.DPoP.Required {
+			return "", NewAPIError(ErrInvalidDPoPProof, "A DPoP proof is required", http.StatusBadRequest)
+		}
+		return "", nil
+	}
+	jkt, err := as.verifyDPoPProof(proof, c.Request.Method, issuerURL(c)+c.Request.URL.Path, "")
+	if err != nil {
+		return "", NewAPIError(ErrInvalidDPoPProof, "Invalid DPoP proof", http.StatusBadRequest).WithDetails(err.Error()).WithOriginalError(err)
+	}
+	return jkt, nil
+}
+
+// checkDPoPBinding enforces a token's cnf claim on validation: a bound token must be presented under
+// the DPoP scheme with a proof from its key for the resource request, and only a bound token may use
+// that scheme. scheme is "" for tokens supplied in the request body
+func (as *authServer) checkDPoPBinding(c *gin.Context, claims *Claims, scheme, accessToken, method, resource string) *APIError {
+	if claims.Cnf == nil || claims.Cnf.JKT == "" {
+		if scheme == dpopScheme {
+			return NewAPIError(ErrInvalidDPoPProof, "Token is not DPoP-bound", http.StatusUnauthorized)
+		}
+		return nil
+	}
+	if scheme != "" && scheme != dpopScheme {
+		return NewAPIError(ErrInvalidDPoPProof, "DPoP-bound token presented as a bearer token", http.StatusUnauthorized)
+	}
+	proof := c.GetHeader(dpopHeader)
+	if proof == "" {
+		return NewAPIError(ErrInvalidDPoPProof, "A DPoP proof is required for this token", http.StatusUnauthorized)
+	}
+	if method == "" {
+		return ErrBadRequest("Missing X-Forwarded-Method header (needed to verify the DPoP proof)")
+	}
+	jkt, err := as.verifyDPoPProof(proof, method, resource, accessToken)
+	if err != nil {
+		return NewAPIError(ErrInvalidDPoPProof, "Invalid DPoP proof", http.StatusUnauthorized).WithDetails(err.Error()).WithOriginalError(err)
+	}
+	if jkt != claims.Cnf.JKT {
+		return NewAPIError(ErrInvalidDPoPProof, "DPoP proof is not signed by the token's key", http.StatusUnauthorized)
+	}
+	return nil
+}
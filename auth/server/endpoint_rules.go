@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// EndpointRule maps every URL matching Pattern to Scope. Patterns must match the whole URL;
+// rules are evaluated in ascending Priority order and the first match wins. Method restricts the
+// rule to one HTTP method; empty or "*" applies to every method, as for endpoint entries
+type EndpointRule struct {
+	ID          int64  `json:"id"`
+	Pattern     string `json:"pattern"`
+	Scope       string `json:"scope"`
+	Method      string `json:"method"`
+	Priority    int    `json:"priority"`
+	Description string `json:"description"`
+	Active      int    `json:"active"`
+	re          *regexp.Regexp
+}
+
+type endpointRuleMatcher struct {
+	mu    sync.RWMutex
+	rules []*EndpointRule // sorted by priority, compiled
+}
+
+func newEndpointRuleMatcher() *endpointRuleMatcher {
+	return &endpointRuleMatcher{}
+}
+
+// Load compiles rules and atomically replaces the matcher's rule set; invalid patterns are skipped
+func (m *endpointRuleMatcher) Load(rules []*EndpointRule) int {
+	compiled := make([]*EndpointRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil {
+			log.Error().Err(err).Int64("rule_id", rule.ID).Str("pattern", rule.Pattern).Msg("Invalid endpoint rule pattern, skipping")
+			continue
+		}
+		rule.re = re
+		compiled = append(compiled, rule)
+	}
+
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return compiled[i].Priority < compiled[j].Priority
+	})
+
+	m.mu.Lock()
+	m.rules = compiled
+	m.mu.Unlock()
+
+	log.Info().Int("rules", len(compiled)).Msg("Endpoint rules compiled")
+	return len(compiled)
+}
+
+// Match returns the highest-priority rule matching url and method, or errNoEndpointRule. Without a
+// method (the caller did not forward one) rules resolve like endpoint entries in
+// selectEndpointForMethod: a URL matched by a single rule uses it, otherwise only a "*" rule applies
+// and errEndpointMethodRequired is returned when there is none
+func (m *endpointRuleMatcher) Match(url, method string) (*EndpointRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []*EndpointRule
+	for _, rule := range m.rules {
+		if !rule.re.MatchString(url) {
+			continue
+		}
+		if method != "" {
+			if methodMatches(rule.Method, method) {
+				return rule, nil
+			}
+			continue
+		}
+		matched = append(matched, rule)
+	}
+	if len(matched) == 0 {
+		return nil, errNoEndpointRule
+	}
+	if len(matched) == 1 {
+		return matched[0], nil
+	}
+	for _, rule := range matched {
+		if normalizeMethod(rule.Method) == "*" {
+			return rule, nil
+		}
+	}
+	return nil, errEndpointMethodRequired
+}
+
+// Rules returns the current compiled rule set in evaluation order
+func (m *endpointRuleMatcher) Rules() []*EndpointRule {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]*EndpointRule, len(m.rules))
+	copy(out, m.rules)
+	return out
+}
+
+func (s *authServer) loadEndpointRules() ([]*EndpointRule, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	query := `SELECT id, pattern, scope, method, priority, description, active FROM endpoint_rules WHERE active = 1`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]*EndpointRule, 0)
+	for rows.Next() {
+		rule := &EndpointRule{}
+		var description sql.NullString
+		if err := rows.Scan(&rule.ID, &rule.Pattern, &rule.Scope, &rule.Method, &rule.Priority, &description, &rule.Active); err != nil {
+			log.Error().Msgf("failed to retrieve row while loading endpoint rules: %s", err)
+			continue
+		}
+		rule.Description = description.String
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *authServer) populateEndpointRules() {
+	rules, err := s.loadEndpointRules()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to populate endpoint rules")
+		return
+	}
+
+	if s.endpointRules == nil {
+		s.endpointRules = newEndpointRuleMatcher()
+	}
+	s.endpointRules.Load(rules)
+	s.cacheRefreshes.Mark("endpoint_rules")
+}
+
+// Errors returned by resolveEndpoint when an URL is known but the method can't be mapped
+var (
+	errNoEndpointRule           = errors.New("no endpoint rule matches")
+	errEndpointInactive         = errors.New("endpoint is not active")
+	errEndpointMethodRequired   = errors.New("endpoint has method-specific scopes but no method was provided")
+	errEndpointMethodNotAllowed = errors.New("no endpoint entry for method")
+)
+
+// EndpointResolution describes how a resource URL was mapped to a scope
+type EndpointResolution struct {
+	URL            string        `json:"url"`
+	Method         string        `json:"method,omitempty"`
+	Scope          string        `json:"scope"`
+	RequiredScopes []string      `json:"required_scopes"`
+	ScopeMode      string        `json:"scope_mode"`
+	Source         string        `json:"source"` // "endpoint" for exact matches, "rule" for regex rules
+	Rule           *EndpointRule `json:"rule,omitempty"`
+}
+
+func endpointResolution(requestURL, method string, endpoint *Endpoints) *EndpointResolution {
+	mode := endpoint.ScopeMode
+	if mode == "" {
+		mode = ScopeModeAny
+	}
+	return &EndpointResolution{
+		URL:            requestURL,
+		Method:         method,
+		Scope:          endpoint.Scope,
+		RequiredScopes: endpoint.Scopes(),
+		ScopeMode:      mode,
+		Source:         "endpoint",
+	}
+}
+
+// normalizeMethod upper-cases an HTTP method, treating empty as "*" (any method)
+func normalizeMethod(method string) string {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	if method == "" {
+		return "*"
+	}
+	return method
+}
+
+// methodMatches reports whether an endpoint or rule configured for configured applies to method
+func methodMatches(configured, method string) bool {
+	configured = normalizeMethod(configured)
+	return configured == "*" || configured == normalizeMethod(method)
+}
+
+// selectEndpointForMethod picks the entry for method among all active entries registered for one URL.
+// An exact method entry wins over a "*" entry. Without a method, a single entry is used as-is and
+// otherwise only a "*" entry applies; endpointRuleMatcher.Match resolves rules the same way
+func selectEndpointForMethod(entries []*Endpoints, method string) (*Endpoints, error) {
+	candidates := make([]*Endpoints, 0, len(entries))
+	for _, endpoint := range entries {
+		if endpoint.Active == 1 {
+			candidates = append(candidates, endpoint)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errEndpointInactive
+	}
+
+	if method == "" {
+		if len(candidates) == 1 {
+			return candidates[0], nil
+		}
+		for _, endpoint := range candidates {
+			if normalizeMethod(endpoint.Method) == "*" {
+				return endpoint, nil
+			}
+		}
+		return nil, errEndpointMethodRequired
+	}
+
+	var wildcard *Endpoints
+	for _, endpoint := range candidates {
+		configured := normalizeMethod(endpoint.Method)
+		if configured == normalizeMethod(method) {
+			return endpoint, nil
+		}
+		if configured == "*" && wildcard == nil {
+			wildcard = endpoint
+		}
+	}
+	if wildcard != nil {
+		return wildcard, nil
+	}
+	return nil, errEndpointMethodNotAllowed
+}
+
+// defaultResourceHeaders carry the protected resource URL when validation.resource_headers is unset
+var defaultResourceHeaders = []string{"X-Resource-URL", "X-Original-URL"}
+
+// resourceURL returns the URL of the resource being authorized and the header it was read from.
+// X-Forwarded-For is only consulted as a legacy fallback since proxies use it for the client IP chain
+func resourceURL(c *gin.Context) (string, string) {
+	headers := AppConfig.Validation.ResourceHeaders
+	if len(headers) == 0 {
+		headers = defaultResourceHeaders
+	}
+	for _, header := range headers {
+		if value := strings.TrimSpace(c.Request.Header.Get(header)); value != "" {
+			return value, header
+		}
+	}
+
+	if !AppConfig.Validation.DisableForwardedForFallback {
+		if value := strings.TrimSpace(c.Request.Header.Get("X-Forwarded-For")); value != "" {
+			return value, "X-Forwarded-For"
+		}
+	}
+	return "", ""
+}
+
+// requestMethod returns the original HTTP method of the request being authorized, as forwarded by the gateway
+func requestMethod(c *gin.Context) string {
+	method := c.Request.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = c.Request.Header.Get("X-Original-Method")
+	}
+	return strings.ToUpper(strings.TrimSpace(method))
+}
+
+// resolveEndpoint maps a resource URL and method to its required scope: exact endpoint entries take
+// precedence, then regex rules in priority order, then a direct endpoints table lookup
+func (as *authServer) resolveEndpoint(ctx context.Context, requestURL, method string) (*EndpointResolution, error) {
+	logger := GetContextLogger(ctx)
+	lookupStart := time.Now()
+	cached, found := as.endpointCache.Get(requestURL)
+	traceCacheLookup(ctx, "endpoint", lookupStart, found)
+	if found {
+		logger.Info().Str("endpoint_url", requestURL).Str("method", method).Msg("[CACHE HIT] Endpoint found in cache")
+		endpoint, err := selectEndpointForMethod(cached, method)
+		if err != nil {
+			return nil, err
+		}
+		return endpointResolution(requestURL, method, endpoint), nil
+	}
+
+	if as.endpointRules != nil {
+		rule, err := as.endpointRules.Match(requestURL, method)
+		if errors.Is(err, errEndpointMethodRequired) {
+			return nil, err
+		}
+		if err == nil {
+			logger.Info().Str("endpoint_url", requestURL).Str("method", method).Int64("rule_id", rule.ID).Msg("[RULE MATCH] Endpoint matched regex rule")
+			return &EndpointResolution{
+				URL:            requestURL,
+				Method:         method,
+				Scope:          rule.Scope,
+				RequiredScopes: []string{rule.Scope},
+				ScopeMode:      ScopeModeAny,
+				Source:         "rule",
+				Rule:           rule,
+			}, nil
+		}
+	}
+
+	logger.Warn().Str("endpoint_url", requestURL).Msg("[CACHE MISS] Endpoint not in cache, querying DB")
+	endpoints, err := as.getEndpointsByURL(ctx, requestURL)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := selectEndpointForMethod(endpoints, method)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info().Str("endpoint_url", requestURL).Str("method", method).Strs("scopes", endpoint.Scopes()).Msg("[DB QUERY] Retrieved scope from database")
+	return endpointResolution(requestURL, method, endpoint), nil
+}
+
+// Resolve endpoint rule handler (admin): reports which rule or endpoint a URL (and optional method) resolves to
+func (as *authServer) resolveEndpointRuleHandler(c *gin.Context) {
+	url := c.Query("url")
+	if url == "" {
+		RespondWithError(c, ErrBadRequest("url query parameter is required"))
+		return
+	}
+
+	resolution, err := as.resolveEndpoint(c.Request.Context(), url, strings.ToUpper(c.Query("method")))
+	if err != nil {
+		if errors.Is(err, errEndpointMethodRequired) {
+			RespondWithError(c, ErrBadRequest("method query parameter is required for this url"))
+			return
+		}
+		RespondWithError(c, ErrNotFoundError(fmt.Sprintf("No endpoint or rule matches %s", url)).WithOriginalError(err))
+		return
+	}
+	c.JSON(http.StatusOK, resolution)
+}
+
+// List endpoint rules handler (admin)
+func (as *authServer) listEndpointRulesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"rules": as.endpointRules.Rules()})
+}
+
+// Reload endpoint rules handler (admin): recompiles rules from the database
+func (as *authServer) reloadEndpointRulesHandler(c *gin.Context) {
+	rules, err := as.loadEndpointRules()
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	loaded := as.endpointRules.Load(rules)
+	c.JSON(http.StatusOK, gin.H{"loaded": loaded, "skipped": len(rules) - loaded})
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// endpointColumns are the endpoints columns read by scanEndpoint
+const endpointColumns = "id, client_id, scope, method, endpoint_url, description, active, required_scopes, scope_mode"
+
+func scanEndpoint(row interface{ Scan(dest ...any) error }) (*Endpoints, error) {
+	endpoint := &Endpoints{}
+	var description, scopeMode sql.NullString
+	var requiredScopes scopeList
+	if err := row.Scan(&endpoint.ID, &endpoint.ClientID, &endpoint.Scope, &endpoint.Method, &endpoint.Url, &description, &endpoint.Active, &requiredScopes, &scopeMode); err != nil {
+		return nil, err
+	}
+	endpoint.Description = description.String
+	endpoint.RequiredScopes = requiredScopes
+	endpoint.ScopeMode = scopeMode.String
+	return endpoint, nil
+}
+
+// EndpointRequest creates an endpoint mapping or replaces an existing one. Each URL has at most one
+// mapping per method
+type EndpointRequest struct {
+	ClientID       string   `json:"client_id"` // owner of the mapping
+	Url            string   `json:"api_url"`
+	Method         string   `json:"method"` // empty or "*" for every method
+	Scope          string   `json:"scope"`  // defaults to the first required scope
+	RequiredScopes []string `json:"required_scopes,omitempty"`
+	ScopeMode      string   `json:"scope_mode,omitempty"`
+	Description    string   `json:"description"`
+	Active         *int     `json:"active,omitempty"` // 1 when omitted
+}
+
+func (r *EndpointRequest) Validate() error {
+	if r.ClientID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	if r.Url == "" {
+		return fmt.Errorf("api_url is required")
+	}
+	if len(r.Url) > 500 {
+		return fmt.Errorf("api_url exceeds maximum length (500 characters)")
+	}
+	if method := normalizeMethod(r.Method); method != "*" && (len(method) > 10 || strings.ContainsFunc(method, func(c rune) bool { return c < 'A' || c > 'Z' })) {
+		return fmt.Errorf("method must be an HTTP method or *")
+	}
+	if r.Scope == "" && len(r.RequiredScopes) == 0 {
+		return fmt.Errorf("scope or required_scopes is required")
+	}
+	if r.Scope != "" {
+		if err := validateRequestScopes("scope", []string{r.Scope}); err != nil {
+			return err
+		}
+	}
+	if err := validateRequestScopes("required_scopes", r.RequiredScopes); err != nil {
+		return err
+	}
+	switch strings.ToUpper(r.ScopeMode) {
+	case "", ScopeModeAny, ScopeModeAll:
+	default:
+		return fmt.Errorf("scope_mode must be %s or %s", ScopeModeAny, ScopeModeAll)
+	}
+	if len(r.Description) > 500 {
+		return fmt.Errorf("description exceeds maximum length (500 characters)")
+	}
+	if r.Active != nil && *r.Active != 0 && *r.Active != 1 {
+		return fmt.Errorf("active must be 0 or 1")
+	}
+	return nil
+}
+
+// endpoint is the mapping the request describes
+func (r *EndpointRequest) endpoint() *Endpoints {
+	endpoint := &Endpoints{
+		ClientID:       r.ClientID,
+		Url:            r.Url,
+		Method:         normalizeMethod(r.Method),
+		Scope:          r.Scope,
+		RequiredScopes: r.RequiredScopes,
+		ScopeMode:      strings.ToUpper(r.ScopeMode),
+		Description:    r.Description,
+		Active:         1,
+	}
+	if endpoint.Scope == "" {
+		endpoint.Scope = r.RequiredScopes[0]
+	}
+	if endpoint.ScopeMode == "" {
+		endpoint.ScopeMode = ScopeModeAny
+	}
+	if r.Active != nil {
+		endpoint.Active = *r.Active
+	}
+	return endpoint
+}
+
+// errEndpointExists is returned when another mapping already covers the URL and method
+var errEndpointExists = errors.New("endpoint already exists")
+
+func (as *authServer) endpointByID(ctx context.Context, id int64) (*Endpoints, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return scanEndpoint(as.db.QueryRowContext(ctx, "SELECT "+endpointColumns+" FROM endpoints WHERE id = :1", id))
+}
+
+// endpointTaken reports whether a mapping other than id covers the URL and method of endpoint
+func (as *authServer) endpointTaken(ctx context.Context, endpoint *Endpoints, id int64) (bool, error) {
+	var count int
+	err := as.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM endpoints WHERE endpoint_url = :1 AND method = :2 AND id <> :3", endpoint.Url, endpoint.Method, id).Scan(&count)
+	return count > 0, err
+}
+
+// insertEndpoint stores endpoint and sets its ID
+func (as *authServer) insertEndpoint(ctx context.Context, endpoint *Endpoints) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if taken, err := as.endpointTaken(ctx, endpoint, 0); err != nil {
+		return fmt.Errorf("insertEndpoint: %v", err)
+	} else if taken {
+		return errEndpointExists
+	}
+	requiredScopes, err := nullableJSON(endpoint.RequiredScopes, len(endpoint.RequiredScopes))
+	if err != nil {
+		return err
+	}
+	if _, err := as.db.ExecContext(ctx, "INSERT INTO endpoints (client_id, scope, method, endpoint_url, required_scopes, scope_mode, description, active) VALUES (:1, :2, :3, :4, :5, :6, :7, :8)",
+		endpoint.ClientID, endpoint.Scope, endpoint.Method, endpoint.Url, requiredScopes, endpoint.ScopeMode, endpoint.Description, endpoint.Active); err != nil {
+		return fmt.Errorf("insertEndpoint: %v", err)
+	}
+	// The ID is generated by the database; the URL and method identify the new row
+	if err := as.db.QueryRowContext(ctx, "SELECT id FROM endpoints WHERE endpoint_url = :1 AND method = :2", endpoint.Url, endpoint.Method).Scan(&endpoint.ID); err != nil {
+		return fmt.Errorf("insertEndpoint: %v", err)
+	}
+	as.endpointCache.Set(endpoint.Url, endpoint)
+	return nil
+}
+
+// updateEndpoint replaces the mapping previous with endpoint
+func (as *authServer) updateEndpoint(ctx context.Context, previous, endpoint *Endpoints) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if taken, err := as.endpointTaken(ctx, endpoint, previous.ID); err != nil {
+		return fmt.Errorf("updateEndpoint: %v", err)
+	} else if taken {
+		return errEndpointExists
+	}
+	requiredScopes, err := nullableJSON(endpoint.RequiredScopes, len(endpoint.RequiredScopes))
+	if err != nil {
+		return err
+	}
+	result, err := as.db.ExecContext(ctx, "UPDATE endpoints SET client_id = :1, scope = :2, method = :3, endpoint_url = :4, required_scopes = :5, scope_mode = :6, description = :7, active = :8 WHERE id = :9",
+		endpoint.ClientID, endpoint.Scope, endpoint.Method, endpoint.Url, requiredScopes, endpoint.ScopeMode, endpoint.Description, endpoint.Active, previous.ID)
+	if err != nil {
+		return fmt.Errorf("updateEndpoint: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	endpoint.ID = previous.ID
+	as.uncacheEndpoint(previous)
+	as.endpointCache.Set(endpoint.Url, endpoint)
+	return nil
+}
+
+func (as *authServer) deleteEndpoint(ctx context.Context, endpoint *Endpoints) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.db.ExecContext(ctx, "DELETE FROM endpoints WHERE id = :1", endpoint.ID)
+	if err != nil {
+		return fmt.Errorf("deleteEndpoint: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	as.uncacheEndpoint(endpoint)
+	return nil
+}
+
+// uncacheEndpoint drops the cached entry for endpoint's URL and method. Other instances pick up
+// changes with their next endpoint cache refresh
+func (as *authServer) uncacheEndpoint(endpoint *Endpoints) {
+	as.endpointCache.Set(endpoint.Url, &Endpoints{Url: endpoint.Url, Method: endpoint.Method, Active: 0})
+}
+
+// endpointFromPath loads the endpoint named by the :id path parameter, responding when it cannot
+func (as *authServer) endpointFromPath(c *gin.Context) (*Endpoints, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		RespondWithError(c, ErrBadRequest("Endpoint id must be a positive integer"))
+		return nil, false
+	}
+	endpoint, err := as.endpointByID(c.Request.Context(), id)
+	if err == sql.ErrNoRows {
+		RespondWithError(c, ErrNotFoundError("Endpoint not found"))
+		return nil, false
+	}
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return nil, false
+	}
+	return endpoint, true
+}
+
+// Create endpoint handler (admin)
+func (as *authServer) createEndpointHandler(c *gin.Context) {
+	var req EndpointRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	endpoint := req.endpoint()
+	if err := as.insertEndpoint(c.Request.Context(), endpoint); err != nil {
+		if err == errEndpointExists {
+			RespondWithError(c, ErrConflictError("An endpoint with that URL and method already exists"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	logger := GetRequestLogger(c)
+	logger.Info().Int64("id", endpoint.ID).Str("endpoint_url", endpoint.Url).Str("method", endpoint.Method).Msg("endpoint created")
+	c.JSON(http.StatusCreated, endpoint)
+}
+
+// Get endpoint handler (admin)
+func (as *authServer) getEndpointHandler(c *gin.Context) {
+	if endpoint, ok := as.endpointFromPath(c); ok {
+		c.JSON(http.StatusOK, endpoint)
+	}
+}
+
+// Update endpoint handler (admin): replaces the mapping; set active to 0 to deactivate it
+func (as *authServer) updateEndpointHandler(c *gin.Context) {
+	previous, ok := as.endpointFromPath(c)
+	if !ok {
+		return
+	}
+	var req EndpointRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	endpoint := req.endpoint()
+	if err := as.updateEndpoint(c.Request.Context(), previous, endpoint); err != nil {
+		switch {
+		case err == errEndpointExists:
+			RespondWithError(c, ErrConflictError("An endpoint with that URL and method already exists"))
+		case err == sql.ErrNoRows:
+			RespondWithError(c, ErrNotFoundError("Endpoint not found"))
+		default:
+			RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		}
+		return
+	}
+	logger := GetRequestLogger(c)
+	logger.Info().Int64("id", endpoint.ID).Str("endpoint_url", endpoint.Url).Str("method", endpoint.Method).Int("active", endpoint.Active).Msg("endpoint updated")
+	c.JSON(http.StatusOK, endpoint)
+}
+
+// Delete endpoint handler (admin)
+func (as *authServer) deleteEndpointHandler(c *gin.Context) {
+	endpoint, ok := as.endpointFromPath(c)
+	if !ok {
+		return
+	}
+	if err := as.deleteEndpoint(c.Request.Context(), endpoint); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Endpoint not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	logger := GetRequestLogger(c)
+	logger.Info().Int64("id", endpoint.ID).Str("endpoint_url", endpoint.Url).Str("method", endpoint.Method).Msg("endpoint deleted")
+	c.JSON(http.StatusOK, gin.H{"message": "Endpoint deleted successfully"})
+}
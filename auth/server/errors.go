@@ -1,4 +1,4 @@
-package auth
+package server
 
 import (
 	"fmt"
@@ -17,25 +17,37 @@ const (
 	ErrInvalidClient    ErrorCode = "invalid_client"
 	ErrInvalidGrant     ErrorCode = "invalid_grant"
 	ErrInvalidScope     ErrorCode = "invalid_scope"
+	ErrInvalidTarget    ErrorCode = "invalid_target"     // RFC 8707: the requested audience is not allowed
+	ErrInvalidDPoPProof ErrorCode = "invalid_dpop_proof" // RFC 9449: missing, malformed or replayed DPoP proof
 	ErrUnauthorized     ErrorCode = "unauthorized"
 	ErrForbidden        ErrorCode = "forbidden"
 	ErrNotFound         ErrorCode = "not_found"
 	ErrConflict         ErrorCode = "conflict"
 	ErrValidationFailed ErrorCode = "validation_failed"
+	ErrRateLimited      ErrorCode = "rate_limit_exceeded"
 
 	// Server errors
 	ErrInternalServer     ErrorCode = "internal_server_error"
 	ErrServiceUnavailable ErrorCode = "service_unavailable"
+	ErrRequestTimeout     ErrorCode = "request_timeout"
 	ErrDatabaseError      ErrorCode = "database_error"
 )
 
+// Error response verbosity modes, set by error_responses.verbosity
+const (
+	ErrorVerbosityProduction  = "production"  // server error details are logged but never returned
+	ErrorVerbosityDevelopment = "development" // responses also carry the underlying error in debug
+)
+
 // APIError represents a structured API error
 type APIError struct {
 	Code        ErrorCode `json:"error"`
 	Message     string    `json:"error_description"`
+	ErrorURI    string    `json:"error_uri,omitempty"`
 	StatusCode  int       `json:"-"`
 	RequestID   string    `json:"request_id,omitempty"`
 	Details     string    `json:"details,omitempty"`
+	Debug       string    `json:"debug,omitempty"`
 	originalErr error     `json:"-"`
 }
 
@@ -88,7 +100,28 @@ func RespondWithError(c *gin.Context, apiErr *APIError) {
 
 	// Return error response
 	c.Header("Content-Type", "application/json")
-	c.JSON(apiErr.StatusCode, apiErr)
+	c.JSON(apiErr.StatusCode, shapeErrorResponse(apiErr))
+}
+
+// shapeErrorResponse applies error_responses to the body sent to the client; the full error has
+// already been logged. In production, details of server errors are dropped since they describe
+// internals rather than the request, while development mode adds the wrapped error as debug
+func shapeErrorResponse(apiErr *APIError) *APIError {
+	shaped := *apiErr
+	if base := AppConfig.ErrorResponses.DocsBaseURL; base != "" {
+		shaped.ErrorURI = base + "#" + string(apiErr.Code)
+	}
+	if AppConfig.ErrorResponses.Verbosity == ErrorVerbosityDevelopment {
+		if apiErr.originalErr != nil {
+			shaped.Debug = apiErr.originalErr.Error()
+		}
+		return &shaped
+	}
+	if apiErr.StatusCode >= http.StatusInternalServerError {
+		shaped.Details = ""
+	}
+	shaped.Debug = ""
+	return &shaped
 }
 
 // ValidateRequest validates request data and returns an error if validation fails
@@ -159,6 +192,11 @@ func ErrConflictError(message string) *APIError {
 	return NewAPIError(ErrConflict, message, http.StatusConflict)
 }
 
+// ErrTooManyRequestsError creates a 429 Too Many Requests error
+func ErrTooManyRequestsError(message string) *APIError {
+	return NewAPIError(ErrRateLimited, message, http.StatusTooManyRequests)
+}
+
 // ErrInternalServerError creates a 500 Internal Server Error
 func ErrInternalServerError(message string) *APIError {
 	return NewAPIError(ErrInternalServer, message, http.StatusInternalServerError)
@@ -168,3 +206,8 @@ func ErrInternalServerError(message string) *APIError {
 func ErrServiceUnavailableError(message string) *APIError {
 	return NewAPIError(ErrServiceUnavailable, message, http.StatusServiceUnavailable)
 }
+
+// ErrGatewayTimeoutError creates a 504 Gateway Timeout error
+func ErrGatewayTimeoutError(message string) *APIError {
+	return NewAPIError(ErrRequestTimeout, message, http.StatusGatewayTimeout)
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Databases a dbFailover switches between, also the db label of db_active
+const (
+	dbPrimary = "primary"
+	dbStandby = "standby"
+)
+
+// Data Guard role of a database open for read-write
+const databaseRolePrimary = "PRIMARY"
+
+// databaseRoleQuery works for any user, unlike v$database
+const databaseRoleQuery = "SELECT SYS_CONTEXT('USERENV', 'DATABASE_ROLE') FROM DUAL"
+
+// dbFailover probes the primary and standby databases and points the connection pool at whichever one
+// is currently serving as the Data Guard primary. A database only counts as healthy when it answers
+// and reports the PRIMARY role, so after a switchover the auth tier follows the new primary without a
+// restart, and after a failover it moves back once the original primary has been healthy for a while
+type dbFailover struct {
+	db    *instrumentedDB
+	pools map[string]*sql.DB
+	cfg   database_failover
+	probe func(ctx context.Context, pool *sql.DB) (string, error)
+
+	mu        sync.Mutex
+	active    string
+	failures  int // consecutive unhealthy probes of the active database
+	recovered int // consecutive healthy probes of the primary while on the standby
+	status    *prometheus.GaugeVec
+	switches  *prometheus.CounterVec
+	done      chan struct{}
+}
+
+// newDBFailover returns nil when no standby is configured
+func newDBFailover(db *instrumentedDB, primary, standby *sql.DB, active string, cfg database_failover) *dbFailover {
+	if standby == nil {
+		return nil
+	}
+	if cfg.ProbeIntervalSeconds <= 0 {
+		cfg.ProbeIntervalSeconds = 5
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.FailbackThreshold <= 0 {
+		cfg.FailbackThreshold = 6
+	}
+	df := &dbFailover{
+		db:     db,
+		pools:  map[string]*sql.DB{dbPrimary: primary, dbStandby: standby},
+		cfg:    cfg,
+		probe:  probeDatabaseRole,
+		active: active,
+		done:   make(chan struct{}),
+	}
+	db.swap(df.pools[active])
+	return df
+}
+
+// openDatabases connects to the primary and, when database.standby is configured, the standby. If the
+// primary is unreachable at boot the standby serves first, so a switchover that happened while the
+// auth tier was down doesn't stop it from starting
+func openDatabases() (primary, standby *sql.DB, active string, err error) {
+	if AppConfig.Database.Standby.Host == "" {
+		primary, err = newDbClient(databaseURL())
+		return primary, nil, dbPrimary, err
+	}
+	if databaseDriver() != DriverOracle {
+		return nil, nil, "", fmt.Errorf("database.standby needs Data Guard and is only supported with the oracle driver")
+	}
+
+	if primary, err = openDbPool(databaseURL()); err != nil {
+		return nil, nil, "", err
+	}
+	if standby, err = openDbPool(standbyDatabaseURL()); err != nil {
+		primary.Close()
+		return nil, nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	primaryRole, primaryErr := probeDatabaseRole(ctx, primary)
+	if primaryErr == nil && primaryRole == databaseRolePrimary {
+		return primary, standby, dbPrimary, nil
+	}
+	standbyRole, standbyErr := probeDatabaseRole(ctx, standby)
+	if standbyErr == nil && standbyRole == databaseRolePrimary {
+		log.Warn().Err(primaryErr).Str("primary_role", primaryRole).Msg("Primary database not serving, starting on the standby")
+		return primary, standby, dbStandby, nil
+	}
+
+	primary.Close()
+	standby.Close()
+	return nil, nil, "", fmt.Errorf("neither database is serving as primary: primary: %v (role %q), standby: %v (role %q)", primaryErr, primaryRole, standbyErr, standbyRole)
+}
+
+func probeDatabaseRole(ctx context.Context, pool *sql.DB) (string, error) {
+	var role string
+	err := pool.QueryRowContext(ctx, databaseRoleQuery).Scan(&role)
+	return role, err
+}
+
+// Start probes in the background until Stop is called. Metrics must be registered first
+func (df *dbFailover) Start(status *prometheus.GaugeVec, switches *prometheus.CounterVec) {
+	if df == nil {
+		return
+	}
+	df.mu.Lock()
+	df.status = status
+	df.switches = switches
+	df.publish()
+	df.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(df.cfg.ProbeIntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-df.done:
+				return
+			case <-ticker.C:
+				df.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops probing and closes the pool that isn't in use; the active one is closed with the server's db
+func (df *dbFailover) Stop() {
+	if df == nil {
+		return
+	}
+	close(df.done)
+	df.mu.Lock()
+	defer df.mu.Unlock()
+	for name, pool := range df.pools {
+		if name != df.active {
+			pool.Close()
+		}
+	}
+}
+
+// Active names the database currently serving
+func (df *dbFailover) Active() string {
+	if df == nil {
+		return dbPrimary
+	}
+	df.mu.Lock()
+	defer df.mu.Unlock()
+	return df.active
+}
+
+// healthy probes one database, which must answer and hold the primary role
+func (df *dbFailover) healthy(name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	role, err := df.probe(ctx, df.pools[name])
+	if err != nil {
+		return false, err
+	}
+	if role != databaseRolePrimary {
+		return false, fmt.Errorf("database role is %s", role)
+	}
+	return true, nil
+}
+
+// Check probes both databases once and switches when the thresholds are reached
+func (df *dbFailover) Check() {
+	primaryOK, primaryErr := df.healthy(dbPrimary)
+	standbyOK, standbyErr := df.healthy(dbStandby)
+
+	df.mu.Lock()
+	defer df.mu.Unlock()
+
+	activeOK, activeErr := primaryOK, primaryErr
+	other, otherOK := dbStandby, standbyOK
+	if df.active == dbStandby {
+		activeOK, activeErr = standbyOK, standbyErr
+		other, otherOK = dbPrimary, primaryOK
+	}
+
+	if !activeOK {
+		df.recovered = 0
+		df.failures++
+		log.Warn().Err(activeErr).Str("db", df.active).Int("failures", df.failures).Msg("Active database probe failed")
+		if df.failures >= df.cfg.FailureThreshold && otherOK {
+			df.switchTo(other, activeErr)
+		}
+		return
+	}
+	df.failures = 0
+
+	// Fail back only once the primary has stayed healthy, so a flapping primary doesn't bounce the pool
+	if df.active == dbStandby && primaryOK {
+		df.recovered++
+		if df.recovered >= df.cfg.FailbackThreshold {
+			df.switchTo(dbPrimary, nil)
+		}
+		return
+	}
+	df.recovered = 0
+}
+
+func (df *dbFailover) switchTo(name string, cause error) {
+	log.Error().Err(cause).Str("from", df.active).Str("to", name).Msg("Switching database")
+	df.active = name
+	df.failures = 0
+	df.recovered = 0
+	df.db.swap(df.pools[name])
+	if df.switches != nil {
+		df.switches.WithLabelValues(name).Inc()
+	}
+	df.publish()
+}
+
+func (df *dbFailover) publish() {
+	if df.status == nil {
+		return
+	}
+	for name := range df.pools {
+		value := 0.0
+		if name == df.active {
+			value = 1
+		}
+		df.status.WithLabelValues(name).Set(value)
+	}
+}
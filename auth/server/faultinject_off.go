@@ -0,0 +1,6 @@
+//go:build !faultinject
+
+package server
+
+// faultInjectionBuild keeps fault_injection inert in regular builds, whatever the configuration says
+const faultInjectionBuild = false
@@ -0,0 +1,6 @@
+//go:build faultinject
+
+package server
+
+// faultInjectionBuild enables fault_injection; this file is only compiled with -tags faultinject
+const faultInjectionBuild = true
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errInjectedFault is returned by database operations failed on purpose by fault_injection
+var errInjectedFault = errors.New("injected fault")
+
+// faultInjector adds artificial database latency and errors and drops token batch flushes so chaos
+// tests can exercise failure handling. It only exists in binaries built with -tags faultinject
+type faultInjector struct {
+	cfg fault_injection
+}
+
+func newFaultInjector(cfg fault_injection) *faultInjector {
+	if !cfg.Enabled {
+		return nil
+	}
+	if !faultInjectionBuild {
+		log.Warn().Msg("fault_injection is configured but this binary was built without -tags faultinject, ignoring")
+		return nil
+	}
+	log.Warn().
+		Int("db_latency_ms", cfg.DBLatencyMs).
+		Float64("db_error_rate", cfg.DBErrorRate).
+		Float64("drop_flush_rate", cfg.DropFlushRate).
+		Strs("operations", cfg.Operations).
+		Msg("FAULT INJECTION ENABLED - not for production use")
+	return &faultInjector{cfg: cfg}
+}
+
+func (fi *faultInjector) targets(operation string) bool {
+	return len(fi.cfg.Operations) == 0 || slices.Contains(fi.cfg.Operations, operation)
+}
+
+// delay sleeps for the configured database latency, or until ctx is done
+func (fi *faultInjector) delay(ctx context.Context, operation string) error {
+	if fi == nil || fi.cfg.DBLatencyMs <= 0 || !fi.targets(operation) {
+		return nil
+	}
+	select {
+	case <-time.After(time.Duration(fi.cfg.DBLatencyMs) * time.Millisecond):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// beforeQuery delays operation and then fails it at db_error_rate
+func (fi *faultInjector) beforeQuery(ctx context.Context, operation string) error {
+	if fi == nil {
+		return nil
+	}
+	if err := fi.delay(ctx, operation); err != nil {
+		return err
+	}
+	if fi.cfg.DBErrorRate > 0 && fi.targets(operation) && rand.Float64() < fi.cfg.DBErrorRate {
+		return errInjectedFault
+	}
+	return nil
+}
+
+// dropFlush reports whether a token batch flush should be discarded instead of written
+func (fi *faultInjector) dropFlush() bool {
+	return fi != nil && fi.cfg.DropFlushRate > 0 && rand.Float64() < fi.cfg.DropFlushRate
+}
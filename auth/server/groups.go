@@ -0,0 +1,408 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ClientGroup grants its scopes to every member client, in addition to the client's own allowed scopes
+type ClientGroup struct {
+	GroupID string   `json:"group_id"`
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	Members []string `json:"members"`
+}
+
+type CreateClientGroupRequest struct {
+	GroupID string   `json:"group_id"`
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+}
+
+func (r *CreateClientGroupRequest) Validate() error {
+	if r.GroupID == "" {
+		return fmt.Errorf("group_id is required")
+	}
+	if len(r.GroupID) > 100 {
+		return fmt.Errorf("group_id exceeds maximum length (100 characters)")
+	}
+	if len(r.Name) > 255 {
+		return fmt.Errorf("name exceeds maximum length (255 characters)")
+	}
+	return validateRequestScopes("scopes", r.Scopes)
+}
+
+type clientGroupCache struct {
+	mu       sync.RWMutex
+	groups   map[string]*ClientGroup // group_id -> group
+	memberOf map[string][]string     // client_id -> group ids
+}
+
+func newClientGroupCache() *clientGroupCache {
+	return &clientGroupCache{
+		groups:   make(map[string]*ClientGroup),
+		memberOf: make(map[string][]string),
+	}
+}
+
+// Replace atomically swaps the cached groups and rebuilds the membership index
+func (gc *clientGroupCache) Replace(groups []*ClientGroup) {
+	byID := make(map[string]*ClientGroup, len(groups))
+	memberOf := make(map[string][]string)
+	for _, group := range groups {
+		byID[group.GroupID] = group
+		for _, clientID := range group.Members {
+			memberOf[clientID] = append(memberOf[clientID], group.GroupID)
+		}
+	}
+
+	gc.mu.Lock()
+	gc.groups = byID
+	gc.memberOf = memberOf
+	gc.mu.Unlock()
+}
+
+// Get retrieves a group from cache
+func (gc *clientGroupCache) Get(groupID string) (*ClientGroup, bool) {
+	gc.mu.RLock()
+	defer gc.mu.RUnlock()
+	group, exists := gc.groups[groupID]
+	return group, exists
+}
+
+// Set stores or replaces a group, keeping the membership index in sync
+func (gc *clientGroupCache) Set(group *ClientGroup) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	if previous, exists := gc.groups[group.GroupID]; exists {
+		for _, clientID := range previous.Members {
+			gc.memberOf[clientID] = slices.DeleteFunc(gc.memberOf[clientID], func(id string) bool { return id == group.GroupID })
+		}
+	}
+	gc.groups[group.GroupID] = group
+	for _, clientID := range group.Members {
+		gc.memberOf[clientID] = append(gc.memberOf[clientID], group.GroupID)
+	}
+}
+
+// List returns all groups sorted by id
+func (gc *clientGroupCache) List() []*ClientGroup {
+	gc.mu.RLock()
+	defer gc.mu.RUnlock()
+
+	out := make([]*ClientGroup, 0, len(gc.groups))
+	for _, group := range gc.groups {
+		out = append(out, group)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GroupID < out[j].GroupID })
+	return out
+}
+
+// ScopesFor returns the scopes clientID inherits from its groups
+func (gc *clientGroupCache) ScopesFor(clientID string) []string {
+	if gc == nil {
+		return nil
+	}
+	gc.mu.RLock()
+	defer gc.mu.RUnlock()
+
+	var scopes []string
+	for _, groupID := range gc.memberOf[clientID] {
+		if group, exists := gc.groups[groupID]; exists {
+			scopes = append(scopes, group.Scopes...)
+		}
+	}
+	return scopes
+}
+
+// Clear removes all groups from cache
+func (gc *clientGroupCache) Clear() {
+	gc.Replace(nil)
+}
+
+// GetSize returns current number of groups in cache
+func (gc *clientGroupCache) GetSize() int {
+	gc.mu.RLock()
+	defer gc.mu.RUnlock()
+	return len(gc.groups)
+}
+
+// applyGroupScopes adds scopes inherited from the client's groups to its allowed scopes
+func (as *authServer) applyGroupScopes(client *Clients) {
+	for _, scope := range as.clientGroups.ScopesFor(client.ClientID) {
+		if !slices.Contains(client.AllowedScopes, scope) {
+			client.AllowedScopes = append(client.AllowedScopes, scope)
+		}
+	}
+}
+
+// invalidateGroupMembers drops member clients from the client cache so inherited scopes are recomputed
+func (as *authServer) invalidateGroupMembers(members []string) {
+	for _, clientID := range members {
+		as.clientCache.Invalidate(clientID)
+	}
+}
+
+func (as *authServer) loadClientGroups() ([]*ClientGroup, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := as.db.QueryContext(ctx, `SELECT group_id, name, allowed_scopes FROM client_groups`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*ClientGroup)
+	for rows.Next() {
+		group := &ClientGroup{}
+		var name sql.NullString
+		var scope scopeList
+		if err := rows.Scan(&group.GroupID, &name, &scope); err != nil {
+			log.Error().Str("group_id", group.GroupID).Msgf("failed to retrieve row while loading client groups: %s", err)
+			continue
+		}
+		group.Name = name.String
+		group.Scopes = scope
+		groups[group.GroupID] = group
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	members, err := as.db.QueryContext(ctx, `SELECT group_id, client_id FROM client_group_members`)
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+
+	for members.Next() {
+		var groupID, clientID string
+		if err := members.Scan(&groupID, &clientID); err != nil {
+			log.Error().Msgf("failed to retrieve row while loading client group members: %s", err)
+			continue
+		}
+		if group, exists := groups[groupID]; exists {
+			group.Members = append(group.Members, clientID)
+		}
+	}
+	if err := members.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]*ClientGroup, 0, len(groups))
+	for _, group := range groups {
+		out = append(out, group)
+	}
+	return out, nil
+}
+
+// populateClientGroups loads groups before the client cache so inherited scopes apply on first load
+func (s *authServer) populateClientGroups() {
+	groups, err := s.loadClientGroups()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to populate client groups")
+		return
+	}
+	if s.clientGroups == nil {
+		s.clientGroups = newClientGroupCache()
+	}
+	s.clientGroups.Replace(groups)
+	s.cacheRefreshes.Mark("client_groups")
+	log.Info().Int("groups", len(groups)).Msg("Client groups loaded")
+}
+
+func (as *authServer) insertClientGroup(group *ClientGroup) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	scopes, err := json.Marshal(group.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = as.db.ExecContext(ctx, "INSERT INTO client_groups (group_id, name, allowed_scopes) VALUES (:1, :2, :3)", group.GroupID, group.Name, string(scopes))
+	return err
+}
+
+func (as *authServer) updateClientGroupScopes(groupID string, scopes []string) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	encoded, err := json.Marshal(scopes)
+	if err != nil {
+		return err
+	}
+	result, err := as.db.ExecContext(ctx, "UPDATE client_groups SET allowed_scopes = :1 WHERE group_id = :2", string(encoded), groupID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (as *authServer) addClientGroupMember(groupID, clientID string) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := as.db.ExecContext(ctx, "INSERT INTO client_group_members (group_id, client_id) VALUES (:1, :2)", groupID, clientID)
+	return err
+}
+
+func (as *authServer) removeClientGroupMember(groupID, clientID string) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.db.ExecContext(ctx, "DELETE FROM client_group_members WHERE group_id = :1 AND client_id = :2", groupID, clientID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// List client groups handler (admin)
+func (as *authServer) listClientGroupsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"groups": as.clientGroups.List()})
+}
+
+// Create client group handler (admin)
+func (as *authServer) createClientGroupHandler(c *gin.Context) {
+	var req CreateClientGroupRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if _, exists := as.clientGroups.Get(req.GroupID); exists {
+		RespondWithError(c, ErrConflictError("Client group already exists"))
+		return
+	}
+
+	group := &ClientGroup{GroupID: req.GroupID, Name: req.Name, Scopes: req.Scopes}
+	if err := as.insertClientGroup(group); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	as.clientGroups.Set(group)
+	c.JSON(http.StatusCreated, group)
+}
+
+// Update client group scopes handler (admin): replaces the scopes inherited by every member
+func (as *authServer) updateClientGroupScopesHandler(c *gin.Context) {
+	groupID := c.Param("group_id")
+	group, exists := as.clientGroups.Get(groupID)
+	if !exists {
+		RespondWithError(c, ErrNotFoundError("Client group not found"))
+		return
+	}
+
+	var req struct {
+		Scopes []string `json:"scopes"`
+	}
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, func() error { return validateRequestScopes("scopes", req.Scopes) }); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	if err := as.updateClientGroupScopes(groupID, req.Scopes); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Client group not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	updated := *group
+	updated.Scopes = req.Scopes
+	as.clientGroups.Set(&updated)
+	as.invalidateGroupMembers(updated.Members)
+	logger := GetRequestLogger(c)
+	logger.Info().Str("group_id", groupID).Strs("scopes", req.Scopes).Int("members", len(updated.Members)).Msg("client group scopes updated")
+	c.JSON(http.StatusOK, &updated)
+}
+
+// Add client group member handler (admin)
+func (as *authServer) addClientGroupMemberHandler(c *gin.Context) {
+	groupID := c.Param("group_id")
+	group, exists := as.clientGroups.Get(groupID)
+	if !exists {
+		RespondWithError(c, ErrNotFoundError("Client group not found"))
+		return
+	}
+
+	var req struct {
+		ClientID string `json:"client_id"`
+	}
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if req.ClientID == "" || len(req.ClientID) > 255 {
+		RespondWithError(c, ErrBadRequest("client_id is required and must not exceed 255 characters"))
+		return
+	}
+	if slices.Contains(group.Members, req.ClientID) {
+		RespondWithError(c, ErrConflictError("Client is already a member of the group"))
+		return
+	}
+
+	if err := as.addClientGroupMember(groupID, req.ClientID); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	updated := *group
+	updated.Members = append(slices.Clone(group.Members), req.ClientID)
+	as.clientGroups.Set(&updated)
+	as.invalidateGroupMembers([]string{req.ClientID})
+	c.JSON(http.StatusOK, &updated)
+}
+
+// Remove client group member handler (admin)
+func (as *authServer) removeClientGroupMemberHandler(c *gin.Context) {
+	groupID := c.Param("group_id")
+	clientID := c.Param("client_id")
+	group, exists := as.clientGroups.Get(groupID)
+	if !exists {
+		RespondWithError(c, ErrNotFoundError("Client group not found"))
+		return
+	}
+
+	if err := as.removeClientGroupMember(groupID, clientID); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Client is not a member of the group"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	updated := *group
+	updated.Members = slices.DeleteFunc(slices.Clone(group.Members), func(id string) bool { return id == clientID })
+	as.clientGroups.Set(&updated)
+	as.invalidateGroupMembers([]string{clientID})
+	c.JSON(http.StatusOK, &updated)
+}
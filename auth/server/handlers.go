@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+func (as *authServer) validateClient(ctx context.Context, clientID, clientSecret string) (*Clients, error) {
+	logger := GetContextLogger(ctx)
+	if clientID == "" || clientSecret == "" {
+		logger.Error().Msg("Missing client credentials")
+		return nil, ErrUnauthorizedError("Missing client credentials")
+	}
+	return as.authenticateClient(ctx, clientID, func(client *Clients) bool {
+		// Clients registered for private_key_jwt never authenticate with a shared secret
+		return client.PublicKey == nil && as.verifiedSecrets.Verify(client, clientSecret)
+	})
+}
+
+// errClientCredential is the original error of the 401 authenticateClient returns when a registered
+// client presented a wrong credential, as opposed to an unknown client ID
+var errClientCredential = errors.New("credential rejected for registered client")
+
+// rejectedRegisteredClient reports whether err is authenticateClient refusing a registered client's
+// credential. Per-client failure tracking uses it to ignore client IDs that exist only in a request
+func rejectedRegisteredClient(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.originalErr == errClientCredential
+}
+
+// authenticateClient looks clientID up, from the cache when possible, and returns it if verify accepts
+// the credential it presented
+func (as *authServer) authenticateClient(ctx context.Context, clientID string, verify func(*Clients) bool) (*Clients, error) {
+	logger := GetContextLogger(ctx)
+	lookupStart := time.Now()
+	cachedClient, found := as.clientCache.Get(clientID)
+	traceCacheLookup(ctx, "client", lookupStart, found)
+	if found {
+		if !verify(cachedClient) {
+			logger.Error().Msg("Invalid client credentials")
+			return nil, ErrUnauthorizedError("Invalid client credentials").WithOriginalError(errClientCredential)
+		}
+		return cachedClient, nil
+	}
+	if as.stateless != nil {
+		// The bundle is loaded whole, so a client missing from the cache does not exist
+		logger.Error().Str("client_id", clientID).Msg("Invalid client credentials")
+		return nil, ErrUnauthorizedError("Invalid client credentials")
+	}
+
+	client, err := as.clientByID(ctx, clientID)
+	if err != nil {
+		logger.Error().Err(err).Str("client_id", clientID).Msg("Database error while fetching client")
+		return nil, ErrInternalServerError("Failed to lookup client").WithOriginalError(err)
+	}
+
+	if client == nil {
+		logger.Error().Str("client_id", clientID).Msg("Invalid client credentials")
+		return nil, ErrUnauthorizedError("Invalid client credentials")
+	}
+	if !verify(client) {
+		logger.Error().Str("client_id", clientID).Msg("Invalid client credentials")
+		return nil, ErrUnauthorizedError("Invalid client credentials").WithOriginalError(errClientCredential)
+	}
+
+	as.clientCache.Set(clientID, client)
+	return client, nil
+}
+
+func (as *authServer) validateGrantType(grantType string) error {
+	if grantType != "client_credentials" {
+		log.Error().Msg("unsupported grant_type")
+		return ErrBadRequest("Unsupported grant type")
+	}
+	return nil
+}
+
+func (as *authServer) tokenHandler(c *gin.Context) {
+	as.issueToken(c, normalTokenPolicy)
+}
+
+func (as *authServer) ottHandler(c *gin.Context) {
+	as.issueToken(c, oneTimeTokenPolicy)
+}
+
+// authenticateCredential resolves the caller's credential into claims: a Bearer JWT,
+// or an API key sent as "Authorization: ApiKey <key>" or in the X-API-Key header
+func (as *authServer) authenticateCredential(c *gin.Context) (*Claims, *APIError) {
+	if apiKey := c.Request.Header.Get("X-API-Key"); apiKey != "" {
+		return as.authenticateAPIKey(c.Request.Context(), apiKey)
+	}
+
+	authHeader := c.Request.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, ErrUnauthorizedError("Missing Authorization header")
+	}
+
+	return as.authenticateHeaderValue(c.Request.Context(), authHeader)
+}
+
+// authenticateToken resolves a bare credential supplied in a JSON body: API keys are
+// recognised by their prefix, anything else is treated as a JWT
+func (as *authServer) authenticateToken(ctx context.Context, token string) (*Claims, *APIError) {
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return as.authenticateHeaderValue(ctx, "ApiKey "+token)
+	}
+	return as.authenticateHeaderValue(ctx, "Bearer "+token)
+}
+
+// authenticateAPIKey resolves an API key into claims, whichever header carried it
+func (as *authServer) authenticateAPIKey(ctx context.Context, apiKey string) (*Claims, *APIError) {
+	claims, err := as.validateAPIKey(ctx, apiKey)
+	if errors.Is(err, errDatabaseUnavailable) {
+		return nil, ErrServiceUnavailableError("API key status cannot be checked while the database is unavailable").WithOriginalError(err)
+	}
+	if err != nil {
+		return nil, ErrUnauthorizedError("Invalid or expired API key").WithOriginalError(err)
+	}
+	return claims, nil
+}
+
+func (as *authServer) authenticateHeaderValue(ctx context.Context, authHeader string) (*Claims, *APIError) {
+	if apiKey, ok := strings.CutPrefix(authHeader, "ApiKey "); ok {
+		return as.authenticateAPIKey(ctx, apiKey)
+	}
+
+	_, tokenString := authorizationToken(authHeader)
+	if tokenString == "" {
+		return nil, ErrUnauthorizedError("Bearer token required")
+	}
+
+	// Validate token
+	claims, err := as.validateJWT(ctx, tokenString)
+	if errors.Is(err, errDatabaseUnavailable) {
+		return nil, ErrServiceUnavailableError("Token status cannot be checked while the database is unavailable").WithOriginalError(err)
+	}
+	var revokedErr *tokenRevokedError
+	if errors.As(err, &revokedErr) {
+		apiErr := ErrUnauthorizedError("Token has been revoked").WithOriginalError(err)
+		if revokedErr.Reason != "" {
+			apiErr = apiErr.WithDetails("revocation_reason: " + revokedErr.Reason)
+		}
+		return nil, apiErr
+	}
+	var hookErr *pipelineHookError
+	if errors.As(err, &hookErr) {
+		return nil, hookErr.apiError(ErrForbiddenError("Token rejected by policy"))
+	}
+	if err != nil {
+		return nil, ErrUnauthorizedError("Invalid or expired token").WithOriginalError(err)
+	}
+	return claims, nil
+}
+
+// authorizationToken splits an Authorization header carrying a JWT under the Bearer or DPoP scheme
+func authorizationToken(authHeader string) (scheme, token string) {
+	for _, scheme := range []string{"Bearer", dpopScheme} {
+		if token, ok := strings.CutPrefix(authHeader, scheme+" "); ok {
+			return scheme, token
+		}
+	}
+	return "", ""
+}
+
+// setExpiryHints tells gateways how long they may reuse a positive validation: X-Token-Expires-In is
+// the token's remaining lifetime, and Cache-Control's max-age is that capped at
+// validation.cache_hint_max_seconds, which bounds how long a revocation can go unnoticed by them.
+// One-time and degraded results must be checked every time
+func setExpiryHints(c *gin.Context, claims *Claims, showExpiry bool) {
+	if claims.ExpiresAt == nil {
+		c.Header("Cache-Control", "no-store")
+		return
+	}
+	remaining := int(time.Until(claims.ExpiresAt.Time) / time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if showExpiry {
+		c.Header("X-Token-Expires-In", strconv.Itoa(remaining))
+	}
+	maxAge := min(remaining, AppConfig.Validation.CacheHintMaxSeconds)
+	if maxAge <= 0 || claims.TokenType == "O" || claims.degraded {
+		c.Header("Cache-Control", "no-store")
+		return
+	}
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+}
+
+// Validate token handler
+func (as *authServer) validateHandler(c *gin.Context) {
+	// Failures are never cacheable; a positive result replaces this with its expiry hints
+	c.Header("Cache-Control", "no-store")
+
+	// The caller is identified before anything about the token is looked at
+	resourceServer, visibleClaims, apiErr := as.resourceServers.authenticate(c)
+	if apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	var body TokenValidationRequest
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		if apiErr := decodeOptionalJSONBody(c, &body); apiErr != nil {
+			RespondWithError(c, apiErr)
+			return
+		}
+		if apiErr := ValidateRequest(c, body.Validate); apiErr != nil {
+			RespondWithError(c, apiErr)
+			return
+		}
+	}
+
+	requestURL, source := body.Resource, "body"
+	if requestURL == "" {
+		requestURL, source = resourceURL(c)
+	}
+	if requestURL == "" {
+		RespondWithError(c, ErrBadRequest("Missing X-Resource-URL header (resource endpoint)"))
+		return
+	}
+	if source == "X-Forwarded-For" {
+		log.Debug().Str("endpoint_url", requestURL).Msg("Resource URL read from legacy X-Forwarded-For header")
+	}
+
+	method := strings.ToUpper(strings.TrimSpace(body.Method))
+	if method == "" {
+		method = requestMethod(c)
+	}
+	resolution, err := as.resolveEndpoint(c.Request.Context(), requestURL, method)
+	if err != nil {
+		log.Error().Str("endpoint_url", requestURL).Str("method", method).Err(err).Msg("Failed to get scope for endpoint")
+		switch {
+		case errors.Is(err, errEndpointMethodRequired):
+			RespondWithError(c, ErrBadRequest("Missing X-Forwarded-Method header (endpoint scopes depend on method)"))
+			return
+		case errors.Is(err, errEndpointMethodNotAllowed):
+			RespondWithError(c, ErrForbiddenError("Method not permitted for endpoint"))
+			return
+		}
+		RespondWithError(c, ErrUnauthorizedError("Unauthorized scope for endpoint"))
+		return
+	}
+
+	var claims *Claims
+	if body.Token != "" {
+		claims, apiErr = as.authenticateToken(c.Request.Context(), body.Token)
+	} else {
+		claims, apiErr = as.authenticateCredential(c)
+	}
+	if apiErr != nil {
+		if errors.Is(apiErr.originalErr, errDatabaseUnavailable) {
+			as.dbHealth.SetRetryAfter(c)
+		}
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	// Token type is now available in claims
+	tokenType := claims.TokenType
+
+	if !claims.validInRegion(AppConfig.Region.Name) {
+		as.recordClientValidation(claims.ClientID, tokenType, "denied")
+		as.usage.Denied(claims.ClientID)
+		as.activity.Denied(claims.ClientID)
+		as.recordAuthEvent(AuthEvent{Type: AuthEventValidationDenied, ClientID: claims.ClientID, TokenID: claims.TokenID, TokenType: tokenType, IP: c.ClientIP()})
+		RespondWithError(c, ErrForbiddenError("Token is not valid in this region"))
+		return
+	}
+
+	scheme, accessToken := "", body.Token
+	if accessToken == "" {
+		scheme, accessToken = authorizationToken(c.GetHeader("Authorization"))
+	}
+	if accessToken != "" {
+		if apiErr := as.checkDPoPBinding(c, claims, scheme, accessToken, method, requestURL); apiErr != nil {
+			as.recordClientValidation(claims.ClientID, tokenType, "denied")
+			as.usage.Denied(claims.ClientID)
+			as.activity.Denied(claims.ClientID)
+			as.recordAuthEvent(AuthEvent{Type: AuthEventValidationDenied, ClientID: claims.ClientID, TokenID: claims.TokenID, TokenType: tokenType, IP: c.ClientIP()})
+			if apiErr.StatusCode == http.StatusUnauthorized {
+				c.Header("WWW-Authenticate", fmt.Sprintf(`DPoP algs="%s", error="invalid_dpop_proof"`, strings.Join(dpopMethods, " ")))
+			}
+			RespondWithError(c, apiErr)
+			return
+		}
+	}
+
+	audience := body.Audience
+	if audience == "" {
+		audience = c.GetHeader(audienceHeader)
+	}
+	if !claims.validForAudience(audience, requestURL) {
+		as.recordClientValidation(claims.ClientID, tokenType, "denied")
+		as.usage.Denied(claims.ClientID)
+		as.activity.Denied(claims.ClientID)
+		as.recordAuthEvent(AuthEvent{Type: AuthEventValidationDenied, ClientID: claims.ClientID, TokenID: claims.TokenID, TokenType: tokenType, IP: c.ClientIP()})
+		RespondWithError(c, ErrForbiddenError("Token is not valid for this audience"))
+		return
+	}
+
+	log.Info().Strs("required_scopes", resolution.RequiredScopes).Str("scope_mode", resolution.ScopeMode).Strs("token_scopes", claims.Scopes).Msg("[VALIDATION] Checking if required scopes in token scopes")
+
+	if !as.scopeHierarchy.Authorizes(claims.Scopes, resolution.RequiredScopes, resolution.ScopeMode) {
+		as.recordClientValidation(claims.ClientID, tokenType, "denied")
+		as.usage.Denied(claims.ClientID)
+		as.activity.Denied(claims.ClientID)
+		as.recordAuthEvent(AuthEvent{Type: AuthEventValidationDenied, ClientID: claims.ClientID, TokenID: claims.TokenID, TokenType: tokenType, IP: c.ClientIP()})
+		RespondWithError(c, ErrForbiddenError("Resource not in token scopes"))
+		return
+	}
+
+	// Success - increment metrics
+	as.validateTokenSuccessCount.WithLabelValues(tokenType).Inc()
+	as.recordClientValidation(claims.ClientID, tokenType, "allowed")
+	as.usage.Validated(claims.ClientID)
+	as.activity.Validated(claims.ClientID)
+	as.recordAuthEvent(AuthEvent{Type: AuthEventValidationAllowed, ClientID: claims.ClientID, TokenID: claims.TokenID, TokenType: tokenType, IP: c.ClientIP()})
+
+	response := TokenValidationResponse{
+		Valid:    true,
+		ClientID: claims.ClientID,
+		Scopes:   claims.Scopes,
+		TokenID:  claims.TokenID,
+		Degraded: claims.degraded,
+	}
+	if claims.ExpiresAt != nil {
+		response.ExpiresAt = claims.ExpiresAt.Time
+	}
+	if claims.Act != nil {
+		response.Actor = claims.Act.ClientID
+	}
+	response = visibleValidationResponse(response, visibleClaims)
+	if resourceServer != "" {
+		log.Debug().Str("resource_server", resourceServer).Str("client_id", claims.ClientID).Msg("Token validated for resource server")
+	}
+
+	// Identity headers let proxies in header-forwarding mode pass the caller upstream without parsing JSON
+	if response.ClientID != "" {
+		c.Header("X-Auth-Client-ID", response.ClientID)
+	}
+	if response.Scopes != nil {
+		c.Header("X-Auth-Scopes", strings.Join(response.Scopes, " "))
+	}
+	if response.TokenID != "" {
+		c.Header("X-Auth-Token-ID", response.TokenID)
+	}
+	if claims.degraded {
+		as.validateDegradedCount.WithLabelValues(tokenType).Inc()
+		c.Header("X-Auth-Degraded", "true")
+	}
+	setExpiryHints(c, claims, !response.ExpiresAt.IsZero())
+	c.Header("Content-Type", "application/json")
+	encoder := json.NewEncoder(c.Writer)
+	if err := encoder.Encode(response); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+	}
+}
+
+// Revoke token handler
+func (as *authServer) revokeHandler(c *gin.Context) {
+	logger := GetRequestLogger(c)
+	requestID := GetRequestID(c)
+	if c.Request.Method != http.MethodPost {
+		logger.Warn().Str("request_id", requestID).Str("method", c.Request.Method).Msg("Invalid HTTP method for revoke endpoint")
+		RespondWithError(c, NewAPIError(ErrInvalidRequest, "Method not allowed", http.StatusMethodNotAllowed))
+		return
+	}
+
+	start := time.Now()
+	as.revokeRequestsCount.WithLabelValues("revoke").Inc()
+
+	authHeader := c.Request.Header.Get("Authorization")
+	if authHeader == "" {
+		logger.Error().Str("request_id", requestID).Msg("Missing Authorization header for token revocation")
+		RespondWithError(c, ErrUnauthorizedError("Authorization header required"))
+		return
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		logger.Error().Str("request_id", requestID).Msg("Invalid Bearer token format for revocation")
+		RespondWithError(c, ErrUnauthorizedError("Bearer token required"))
+		return
+	}
+
+	var req RevokeTokenRequest
+	if apiErr := decodeOptionalJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = RevocationReasonUserRequested
+	}
+
+	// Validate token first
+	claims, err := as.validateJWT(c.Request.Context(), tokenString)
+	if err != nil {
+		logger.Error().Str("request_id", requestID).Err(err).Msg("JWT token validation failed during revocation")
+		RespondWithError(c, ErrUnauthorizedError("Invalid or expired token").WithOriginalError(err))
+		return
+	}
+
+	// Add to revoked tokens
+	revokedToken := RevokedToken{
+		ClientID:  claims.ClientID,
+		TokenID:   claims.TokenID,
+		RevokedAt: time.Now(),
+		Reason:    req.Reason,
+	}
+	if claims.ExpiresAt != nil {
+		revokedToken.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	if err := as.revokeToken(c.Request.Context(), revokedToken); err != nil {
+		logger.Error().Str("request_id", requestID).Str("client_id", claims.ClientID).Str("token_id", claims.TokenID).Err(err).Msg("Failed to revoke token")
+		RespondWithError(c, ErrInternalServerError("Failed to revoke token").WithOriginalError(err))
+		return
+	}
+
+	as.revokeSuccessCount.WithLabelValues("revoked").Inc()
+	as.usage.Revoked(claims.ClientID)
+	as.recordAuthEvent(AuthEvent{Type: AuthEventTokenRevoked, ClientID: claims.ClientID, TokenID: claims.TokenID, TokenType: claims.TokenType, IP: c.ClientIP()})
+
+	as.revokeTokenLatency.WithLabelValues("revoked").Observe(float64(time.Since(start).Seconds()))
+
+	c.Header("Content-Type", "application/json")
+	encoder := json.NewEncoder(c.Writer)
+	if err := encoder.Encode(map[string]string{
+		"message": "Token revoked successfully",
+		"reason":  req.Reason,
+	}); err != nil {
+		logger.Error().Str("request_id", requestID).Err(err).Msg("Failed to encode revocation response")
+		c.AbortWithError(http.StatusBadRequest, err)
+	}
+}
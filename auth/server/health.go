@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheRefreshTracker remembers when each cache was last loaded from the database
+type cacheRefreshTracker struct {
+	mu    sync.RWMutex
+	times map[string]time.Time
+}
+
+func newCacheRefreshTracker() *cacheRefreshTracker {
+	return &cacheRefreshTracker{times: make(map[string]time.Time)}
+}
+
+// Mark records a successful refresh of the named cache
+func (rt *cacheRefreshTracker) Mark(name string) {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.times[name] = time.Now()
+}
+
+// Get returns when the named cache was last refreshed
+func (rt *cacheRefreshTracker) Get(name string) (time.Time, bool) {
+	if rt == nil {
+		return time.Time{}, false
+	}
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	at, ok := rt.times[name]
+	return at, ok
+}
+
+// CacheHealth reports a cache's size and, for caches loaded from the database, how stale it is
+type CacheHealth struct {
+	Size        int        `json:"size"`
+	RefreshedAt *time.Time `json:"refreshed_at,omitempty"`
+	AgeSeconds  *float64   `json:"age_seconds,omitempty"`
+}
+
+// DatabaseHealth reports connectivity and connection pool usage
+type DatabaseHealth struct {
+	Status           string  `json:"status"`
+	Error            string  `json:"error,omitempty"`
+	Active           string  `json:"active"` // primary, standby after a failover, or stateless (the revocation list is pinged)
+	PingLatencyMs    float64 `json:"ping_latency_ms"`
+	MaxOpen          int     `json:"max_open_connections"`
+	Open             int     `json:"open_connections"`
+	InUse            int     `json:"in_use"`
+	Idle             int     `json:"idle"`
+	WaitCount        int64   `json:"wait_count"`
+	WaitDurationMs   float64 `json:"wait_duration_ms"`
+	MaxIdleClosed    int64   `json:"max_idle_closed"`
+	MaxLifetimeClose int64   `json:"max_lifetime_closed"`
+}
+
+// BatcherHealth reports the token batch writer's backlog and last insert
+type BatcherHealth struct {
+	Pending   int               `json:"pending"`
+	Journaled int               `json:"journaled"` // write_behind tokens not yet confirmed in the database
+	LastFlush *BatchFlushResult `json:"last_flush,omitempty"`
+}
+
+// HealthDetail is the body of /auth-server/health/detail
+type HealthDetail struct {
+	Status   string                 `json:"status"`
+	Time     time.Time              `json:"time"`
+	Region   string                 `json:"region,omitempty"`
+	Zone     string                 `json:"zone,omitempty"`
+	Database DatabaseHealth         `json:"database"`
+	Caches   map[string]CacheHealth `json:"caches"`
+	Batcher  *BatcherHealth         `json:"token_batcher,omitempty"`
+	Canary   *CanaryResult          `json:"canary,omitempty"` // latest synthetic token probe, when enabled
+}
+
+// healthHandler reports whether the server can reach its database
+func (s *authServer) healthHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := s.pingStore(ctx); err != nil {
+		logger := GetRequestLogger(c)
+		logger.Warn().Err(err).Msg("Health check failed: database unreachable")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "database": "unreachable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "database": "ok"})
+}
+
+// Readiness is the body of /readyz: the overall status and the outcome of each check
+type Readiness struct {
+	Status string            `json:"status"` // ready or unavailable
+	Checks map[string]string `json:"checks"`
+}
+
+// readiness runs the /readyz checks. The database is judged by the health monitor's last pings rather
+// than a ping per probe
+func (s *authServer) readiness() Readiness {
+	readiness := Readiness{Status: "ready", Checks: map[string]string{"database": "ok", "caches": "ok", "token_batcher": "ok"}}
+	if s.dbHealth.Down() {
+		readiness.Checks["database"] = "down"
+	}
+	for _, cache := range []string{"clients", "endpoints"} {
+		if _, ok := s.cacheRefreshes.Get(cache); !ok {
+			readiness.Checks["caches"] = cache + " not loaded"
+			break
+		}
+	}
+	if s.tokenBatcher != nil && !s.tokenBatcher.Running() {
+		readiness.Checks["token_batcher"] = "stopped"
+	}
+	for _, outcome := range readiness.Checks {
+		if outcome != "ok" {
+			readiness.Status = "unavailable"
+		}
+	}
+	return readiness
+}
+
+// healthzHandler is the liveness probe. It checks no dependencies, so an outage of the database does
+// not get every instance restarted
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyzHandler is the readiness probe: 503 until the client and endpoint caches are loaded, while the
+// health monitor has the database marked down and once the token batcher has stopped, so the instance
+// is kept out of rotation instead of failing requests
+func (s *authServer) readyzHandler(c *gin.Context) {
+	readiness := s.readiness()
+	if readiness.Status != "ready" {
+		if readiness.Checks["database"] != "ok" {
+			s.dbHealth.SetRetryAfter(c)
+		}
+		c.JSON(http.StatusServiceUnavailable, readiness)
+		return
+	}
+	c.JSON(http.StatusOK, readiness)
+}
+
+// healthDetailHandler reports database, cache and batcher state for diagnosis
+func (s *authServer) healthDetailHandler(c *gin.Context) {
+	detail := HealthDetail{
+		Status:   "ok",
+		Time:     time.Now().UTC(),
+		Region:   AppConfig.Region.Name,
+		Zone:     AppConfig.Region.Zone,
+		Database: s.databaseHealth(c.Request.Context()),
+		Caches:   s.cacheHealth(),
+	}
+	if detail.Database.Status != "ok" {
+		detail.Status = "degraded"
+	}
+
+	if s.tokenBatcher != nil {
+		batcher := &BatcherHealth{Pending: s.tokenBatcher.GetPendingCount(), Journaled: s.tokenPersistence.Journal().Pending()}
+		if last := s.tokenBatcher.LastFlush(); !last.At.IsZero() {
+			batcher.LastFlush = &last
+		}
+		detail.Batcher = batcher
+	}
+
+	if last := s.canary.Last(); last != nil {
+		detail.Canary = last
+		if !last.OK {
+			detail.Status = "degraded"
+		}
+	}
+
+	status := http.StatusOK
+	if detail.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, detail)
+}
+
+func (s *authServer) databaseHealth(parent context.Context) DatabaseHealth {
+	ctx, cancel := context.WithTimeout(parent, 2*time.Second)
+	defer cancel()
+
+	health := DatabaseHealth{Status: "ok", Active: s.dbFailover.Active()}
+	if s.stateless != nil {
+		health.Active = "stateless"
+	}
+	start := time.Now()
+	if err := s.pingStore(ctx); err != nil {
+		health.Status = "unreachable"
+		health.Error = err.Error()
+	}
+	health.PingLatencyMs = float64(time.Since(start).Microseconds()) / 1000
+
+	stats := s.db.Stats()
+	health.MaxOpen = stats.MaxOpenConnections
+	health.Open = stats.OpenConnections
+	health.InUse = stats.InUse
+	health.Idle = stats.Idle
+	health.WaitCount = stats.WaitCount
+	health.WaitDurationMs = float64(stats.WaitDuration.Microseconds()) / 1000
+	health.MaxIdleClosed = stats.MaxIdleClosed
+	health.MaxLifetimeClose = stats.MaxLifetimeClosed
+	return health
+}
+
+func (s *authServer) cacheHealth() map[string]CacheHealth {
+	now := time.Now()
+	caches := make(map[string]CacheHealth)
+	add := func(name string, size int) {
+		health := CacheHealth{Size: size}
+		if at, ok := s.cacheRefreshes.Get(name); ok {
+			age := now.Sub(at).Seconds()
+			health.RefreshedAt = &at
+			health.AgeSeconds = &age
+		}
+		caches[name] = health
+	}
+
+	if s.clientCache != nil {
+		add("clients", s.clientCache.GetSize())
+	}
+	if s.endpointCache != nil {
+		add("endpoints", s.endpointCache.GetSize())
+	}
+	if s.endpointRules != nil {
+		add("endpoint_rules", len(s.endpointRules.Rules()))
+	}
+	if s.clientGroups != nil {
+		add("client_groups", s.clientGroups.GetSize())
+	}
+	if s.delegationCache != nil {
+		add("delegation", s.delegationCache.GetSize())
+	}
+	if s.tokenCache != nil {
+		add("tokens", s.tokenCache.GetSize())
+	}
+	if s.apiKeyCache != nil {
+		add("api_keys", s.apiKeyCache.GetSize())
+	}
+	if _, ok := s.cacheRefreshes.Get("scope_hierarchy"); ok {
+		add("scope_hierarchy", len(AppConfig.Scopes.Hierarchy))
+	}
+	return caches
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// inspectorPage lets integrators paste a token and see what /dev/inspect reports about it
+//
+//go:embed inspector.html
+var inspectorPage []byte
+
+// TokenInspectRequest is the body of /dev/inspect
+type TokenInspectRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *TokenInspectRequest) Validate() error {
+	if strings.TrimSpace(r.Token) == "" {
+		return fmt.Errorf("token is required")
+	}
+	return nil
+}
+
+// TokenInspection is everything the server can tell about a token. Header and claims are decoded
+// whether or not the token verifies, so a rejected token can still be debugged
+type TokenInspection struct {
+	Header           map[string]any   `json:"header"`
+	Claims           map[string]any   `json:"claims"`
+	SignatureValid   bool             `json:"signature_valid"`
+	Valid            bool             `json:"valid"` // signature, expiry and not-before all pass
+	Error            string           `json:"error,omitempty"`
+	Revocation       *InspectedStatus `json:"revocation,omitempty"`
+	GrantedEndpoints []InspectedGrant `json:"granted_endpoints"`
+	GrantedRules     []InspectedGrant `json:"granted_rules"`
+}
+
+// InspectedStatus is the token's revocation state: active, revoked, unknown (never stored, or
+// purged) or unavailable (the database could not be asked)
+type InspectedStatus struct {
+	Status    string `json:"status"`
+	TokenType string `json:"token_type,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// InspectedGrant is an endpoint, or an endpoint rule's pattern, that the token's scopes authorize
+type InspectedGrant struct {
+	Method    string   `json:"method"`
+	URL       string   `json:"url,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Scopes    []string `json:"scopes"`
+	ScopeMode string   `json:"scope_mode,omitempty"`
+}
+
+// inspectToken decodes tokenString and reports its signature, revocation state and the endpoints
+// its scopes grant. Unlike validateJWT it never revokes a one-time token
+func (as *authServer) inspectToken(ctx context.Context, tokenString string) (*TokenInspection, error) {
+	if isOpaqueToken(tokenString) {
+		resolved, err := as.resolveOpaqueToken(ctx, tokenString)
+		if err != nil {
+			return nil, err
+		}
+		tokenString = resolved
+	}
+	parser := jwt.NewParser()
+	raw := jwt.MapClaims{}
+	unverified, _, err := parser.ParseUnverified(tokenString, raw)
+	if err != nil {
+		return nil, err
+	}
+	inspection := &TokenInspection{
+		Header:           unverified.Header,
+		Claims:           raw,
+		GrantedEndpoints: make([]InspectedGrant, 0),
+		GrantedRules:     make([]InspectedGrant, 0),
+	}
+
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(tokenString, claims, as.verificationKey)
+	inspection.Valid = err == nil
+	// Time-based failures are only reported once the signature has been verified
+	inspection.SignatureValid = err == nil || !(errors.Is(err, jwt.ErrTokenMalformed) || errors.Is(err, jwt.ErrTokenUnverifiable) || errors.Is(err, jwt.ErrTokenSignatureInvalid))
+	if err != nil {
+		inspection.Error = err.Error()
+	}
+	if !inspection.SignatureValid {
+		// Nothing in an unverified token can be trusted to look anything up
+		return inspection, nil
+	}
+
+	if claims.TokenID != "" {
+		revoked, tokenType, err := as.getTokenInfo(ctx, claims.TokenID)
+		switch {
+		case errors.Is(err, errTokenNotFound):
+			inspection.Revocation = &InspectedStatus{Status: "unknown"}
+		case err != nil:
+			inspection.Revocation = &InspectedStatus{Status: "unavailable"}
+		case revoked:
+			inspection.Revocation = &InspectedStatus{Status: "revoked", TokenType: tokenType, Reason: as.cachedRevocationReason(claims.TokenID)}
+		default:
+			inspection.Revocation = &InspectedStatus{Status: "active", TokenType: tokenType}
+		}
+	}
+
+	for _, endpoint := range as.endpointCache.All() {
+		if as.scopeHierarchy.Authorizes(claims.Scopes, endpoint.Scopes(), endpoint.ScopeMode) {
+			inspection.GrantedEndpoints = append(inspection.GrantedEndpoints, InspectedGrant{Method: endpoint.Method, URL: endpoint.Url, Scopes: endpoint.Scopes(), ScopeMode: endpoint.ScopeMode})
+		}
+	}
+	for _, rule := range as.endpointRules.Rules() {
+		if as.scopeHierarchy.HasScope(claims.Scopes, rule.Scope) {
+			inspection.GrantedRules = append(inspection.GrantedRules, InspectedGrant{Method: rule.Method, Pattern: rule.Pattern, Scopes: []string{rule.Scope}})
+		}
+	}
+	return inspection, nil
+}
+
+// Token inspector page handler (dev mode only)
+func (as *authServer) tokenInspectorPageHandler(c *gin.Context) {
+	if !AppConfig.DevMode {
+		RespondWithError(c, ErrNotFoundError("The token inspector is only available in dev_mode"))
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", inspectorPage)
+}
+
+// Token inspect handler (dev mode only)
+func (as *authServer) tokenInspectHandler(c *gin.Context) {
+	if !AppConfig.DevMode {
+		RespondWithError(c, ErrNotFoundError("The token inspector is only available in dev_mode"))
+		return
+	}
+
+	var req TokenInspectRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	inspection, err := as.inspectToken(c.Request.Context(), strings.TrimPrefix(strings.TrimSpace(req.Token), "Bearer "))
+	if err != nil {
+		RespondWithError(c, ErrBadRequest("The token is not a decodable JWT").WithDetails(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, inspection)
+}
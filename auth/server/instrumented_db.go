@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// instrumentedDB wraps the connection pool so every query, exec, prepared statement and transaction
+// is timed into dbQueryDuration and its failures counted in dbQueryErrorCount. The operation label is
+// derived from the statement (e.g. "select:clients") so call sites don't need to name it; the Store
+// operations on the request path are instead timed whole under names like "token_info". It is also
+// where fault_injection applies: errors are injected into Exec, Query, Prepare and BeginTx, while
+// QueryRow and prepared statement executions, which cannot carry a synthetic error, only get latency.
+// Statements are written with Oracle bind variables and rebound here for other drivers.
+// The pool behind it can be swapped at runtime by dbFailover
+type instrumentedDB struct {
+	pool   atomic.Pointer[sql.DB]
+	as     *authServer
+	marker byte // bind variable marker other drivers use instead of Oracle's colon; 0 for Oracle
+}
+
+func newInstrumentedDB(db *sql.DB, as *authServer) *instrumentedDB {
+	idb := &instrumentedDB{as: as, marker: bindMarker(databaseDriver())}
+	idb.pool.Store(db)
+	return idb
+}
+
+// bind rewrites query's bind variables for the driver
+func (db *instrumentedDB) bind(query string) string {
+	if db.marker == 0 {
+		return query
+	}
+	return rebindVars(query, db.marker)
+}
+
+// DB returns the pool statements currently go to
+func (db *instrumentedDB) DB() *sql.DB {
+	return db.pool.Load()
+}
+
+// swap points new statements at pool; statements and transactions already started finish on the old one
+func (db *instrumentedDB) swap(pool *sql.DB) {
+	db.pool.Store(pool)
+}
+
+func (db *instrumentedDB) PingContext(ctx context.Context) error {
+	return db.DB().PingContext(ctx)
+}
+
+func (db *instrumentedDB) Stats() sql.DBStats {
+	return db.DB().Stats()
+}
+
+func (db *instrumentedDB) Close() error {
+	return db.DB().Close()
+}
+
+// namedOperationKey carries the name of the data layer operation a context's statements belong to
+type namedOperationKey struct{}
+
+// Data layer operations timed as a whole under their own operation label
+const (
+	queryOpClientByID  = "client_by_id"
+	queryOpTokenInfo   = "token_info"
+	queryOpInsertBatch = "insert_batch"
+	queryOpRevoke      = "revoke"
+	queryOpScopeLookup = "scope_lookup"
+)
+
+// startOperation names the data layer operation ctx's statements belong to. dbQueryDuration observes
+// the operation once under name, transaction and all, instead of each statement under its derived
+// label; statements are still traced one by one. The returned func records the operation's outcome
+func (db *instrumentedDB) startOperation(ctx context.Context, name string) (context.Context, func(err error)) {
+	start := time.Now()
+	return context.WithValue(ctx, namedOperationKey{}, name), func(err error) {
+		if errors.Is(err, errTokenNotFound) || errors.Is(err, errNoSuchClient) || errors.Is(err, errNoSuchEndpoint) {
+			err = nil
+		}
+		db.as.observeOperation(name, start, err)
+	}
+}
+
+// observeQuery records one statement, and traces it when ctx belongs to a traced request. Statements of
+// a named operation are left to the operation's own observation
+func (as *authServer) observeQuery(ctx context.Context, operation string, start time.Time, err error) {
+	if as == nil {
+		return
+	}
+	traceQuery(ctx, operation, start, err)
+	if _, named := ctx.Value(namedOperationKey{}).(string); named {
+		return
+	}
+	as.observeOperation(operation, start, err)
+}
+
+// observeOperation records one database operation. Metrics are registered in Start, so operations
+// made before then (initial cache loads) are not counted
+func (as *authServer) observeOperation(operation string, start time.Time, err error) {
+	if as == nil {
+		return
+	}
+	if as.dbQueryDuration != nil {
+		as.dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && err != sql.ErrNoRows && as.dbQueryErrorCount != nil {
+		as.dbQueryErrorCount.WithLabelValues(operation).Inc()
+	}
+}
+
+// traceQuery records a database operation as a client span named the OpenTelemetry way, e.g. "SELECT tokens"
+func traceQuery(ctx context.Context, operation string, start time.Time, err error) {
+	if spanFromContext(ctx) == nil {
+		return
+	}
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	verb, table, _ := strings.Cut(operation, ":")
+	name := strings.ToUpper(verb)
+	attrs := []otlpAttribute{otlpAttr("db.system.name", dbSystemName(databaseDriver())), otlpAttr("db.operation.name", strings.ToUpper(verb))}
+	if table != "" {
+		name += " " + table
+		attrs = append(attrs, otlpAttr("db.collection.name", table))
+	}
+	recordSpan(ctx, name, spanKindClient, start, err, attrs...)
+}
+
+// dbSystemName is the OpenTelemetry db.system.name of a database driver
+func dbSystemName(driver string) string {
+	if driver == DriverPostgres {
+		return "postgresql"
+	}
+	return driver
+}
+
+// queryOperation labels a statement by verb and first table, e.g. "update:tokens" or "merge:client_usage_daily"
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	verb := strings.ToLower(fields[0])
+	for i := 0; i < len(fields)-1; i++ {
+		switch strings.ToUpper(fields[i]) {
+		case "FROM", "INTO", "UPDATE":
+			table, _, _ := strings.Cut(fields[i+1], "(")
+			return verb + ":" + strings.ToLower(strings.Trim(table, "),;"))
+		}
+	}
+	return verb
+}
+
+func (db *instrumentedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	if err := db.as.faults.beforeQuery(ctx, queryOperation(query)); err != nil {
+		db.as.observeQuery(ctx, queryOperation(query), start, err)
+		return nil, err
+	}
+	result, err := db.DB().ExecContext(ctx, db.bind(query), args...)
+	db.as.observeQuery(ctx, queryOperation(query), start, err)
+	return result, err
+}
+
+func (db *instrumentedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	if err := db.as.faults.beforeQuery(ctx, queryOperation(query)); err != nil {
+		db.as.observeQuery(ctx, queryOperation(query), start, err)
+		return nil, err
+	}
+	rows, err := db.DB().QueryContext(ctx, db.bind(query), args...)
+	db.as.observeQuery(ctx, queryOperation(query), start, err)
+	return rows, err
+}
+
+func (db *instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	start := time.Now()
+	db.as.faults.delay(ctx, queryOperation(query))
+	row := db.DB().QueryRowContext(ctx, db.bind(query), args...)
+	db.as.observeQuery(ctx, queryOperation(query), start, row.Err())
+	return row
+}
+
+func (db *instrumentedDB) PrepareContext(ctx context.Context, query string) (*instrumentedStmt, error) {
+	if err := db.as.faults.beforeQuery(ctx, queryOperation(query)); err != nil {
+		db.as.observeQuery(ctx, queryOperation(query), time.Now(), err)
+		return nil, err
+	}
+	stmt, err := db.DB().PrepareContext(ctx, db.bind(query))
+	if err != nil {
+		db.as.observeQuery(ctx, queryOperation(query), time.Now(), err)
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, as: db.as, operation: queryOperation(query)}, nil
+}
+
+func (db *instrumentedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*instrumentedTx, error) {
+	start := time.Now()
+	if err := db.as.faults.beforeQuery(ctx, "begin"); err != nil {
+		db.as.observeQuery(ctx, "begin", start, err)
+		return nil, err
+	}
+	tx, err := db.DB().BeginTx(ctx, opts)
+	if err != nil {
+		db.as.observeQuery(ctx, "begin", start, err)
+		return nil, err
+	}
+	return &instrumentedTx{Tx: tx, as: db.as, db: db, ctx: ctx}, nil
+}
+
+// instrumentedStmt times executions of a prepared statement under the statement's operation label
+type instrumentedStmt struct {
+	*sql.Stmt
+	as        *authServer
+	operation string
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args ...any) (sql.Result, error) {
+	start := time.Now()
+	s.as.faults.delay(ctx, s.operation)
+	result, err := s.Stmt.ExecContext(ctx, args...)
+	s.as.observeQuery(ctx, s.operation, start, err)
+	return result, err
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	s.as.faults.delay(ctx, s.operation)
+	rows, err := s.Stmt.QueryContext(ctx, args...)
+	s.as.observeQuery(ctx, s.operation, start, err)
+	return rows, err
+}
+
+func (s *instrumentedStmt) QueryRowContext(ctx context.Context, args ...any) *sql.Row {
+	start := time.Now()
+	s.as.faults.delay(ctx, s.operation)
+	row := s.Stmt.QueryRowContext(ctx, args...)
+	s.as.observeQuery(ctx, s.operation, start, row.Err())
+	return row
+}
+
+// instrumentedTx times statements run inside a transaction, and the commit itself
+type instrumentedTx struct {
+	*sql.Tx
+	as  *authServer
+	db  *instrumentedDB // binds the transaction's statements
+	ctx context.Context // BeginTx's, which the commit is traced under
+}
+
+func (tx *instrumentedTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	if err := tx.as.faults.beforeQuery(ctx, queryOperation(query)); err != nil {
+		tx.as.observeQuery(ctx, queryOperation(query), start, err)
+		return nil, err
+	}
+	result, err := tx.Tx.ExecContext(ctx, tx.db.bind(query), args...)
+	tx.as.observeQuery(ctx, queryOperation(query), start, err)
+	return result, err
+}
+
+func (tx *instrumentedTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	if err := tx.as.faults.beforeQuery(ctx, queryOperation(query)); err != nil {
+		tx.as.observeQuery(ctx, queryOperation(query), start, err)
+		return nil, err
+	}
+	rows, err := tx.Tx.QueryContext(ctx, tx.db.bind(query), args...)
+	tx.as.observeQuery(ctx, queryOperation(query), start, err)
+	return rows, err
+}
+
+func (tx *instrumentedTx) PrepareContext(ctx context.Context, query string) (*instrumentedStmt, error) {
+	if err := tx.as.faults.beforeQuery(ctx, queryOperation(query)); err != nil {
+		tx.as.observeQuery(ctx, queryOperation(query), time.Now(), err)
+		return nil, err
+	}
+	stmt, err := tx.Tx.PrepareContext(ctx, tx.db.bind(query))
+	if err != nil {
+		tx.as.observeQuery(ctx, queryOperation(query), time.Now(), err)
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, as: tx.as, operation: queryOperation(query)}, nil
+}
+
+func (tx *instrumentedTx) Commit() error {
+	start := time.Now()
+	err := tx.Tx.Commit()
+	tx.as.observeQuery(tx.ctx, "commit", start, err)
+	return err
+}
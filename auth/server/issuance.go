@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// tokenPolicy describes how one token type is issued; every issuance endpoint runs the same
+// pipeline and only differs by policy
+type tokenPolicy struct {
+	TokenType string        // stored in tokens.token_type and the token_type claim
+	Name      string        // used in logs
+	TTL       time.Duration // lifetime of issued tokens for clients without access_token_ttl; one-time tokens never outlive it
+	Grant     string        // rate_limiting.grants key; empty means the request's grant_type
+}
+
+var (
+	normalTokenPolicy  = tokenPolicy{TokenType: "N", Name: "normal", TTL: 1 * time.Hour}
+	oneTimeTokenPolicy = tokenPolicy{TokenType: "O", Name: "one-time", TTL: 30 * time.Minute, Grant: "ott"}
+)
+
+// rateLimitGrant is the rate_limiting.grants key a request issued under policy counts against
+func (p tokenPolicy) rateLimitGrant(grantType string) string {
+	if p.Grant != "" {
+		return p.Grant
+	}
+	return grantType
+}
+
+// lifetime is how long a token issued to client lives: the client's access_token_ttl, capped at the
+// policy's TTL for one-time tokens, then clamped to token_lifetime's bounds
+func (p tokenPolicy) lifetime(client *Clients) time.Duration {
+	ttl := p.TTL
+	if client.AccessTokenTTL > 0 {
+		ttl = time.Duration(client.AccessTokenTTL) * time.Second
+		if p.TokenType == oneTimeTokenPolicy.TokenType {
+			ttl = min(ttl, p.TTL)
+		}
+	}
+	bounds := AppConfig.TokenLifetime
+	if bounds.MinSeconds > 0 {
+		ttl = max(ttl, time.Duration(bounds.MinSeconds)*time.Second)
+	}
+	if bounds.MaxSeconds > 0 {
+		ttl = min(ttl, time.Duration(bounds.MaxSeconds)*time.Second)
+	}
+	return ttl
+}
+
+// tokenPolicyFor returns the issuance policy for a stored token type
+func tokenPolicyFor(tokenType string) tokenPolicy {
+	if tokenType == oneTimeTokenPolicy.TokenType {
+		return oneTimeTokenPolicy
+	}
+	return normalTokenPolicy
+}
+
+// issueToken is the shared token issuance pipeline: decode and validate the request,
+// authenticate the client, check the grant, resolve delegation, then sign and respond
+func (as *authServer) issueToken(c *gin.Context, policy tokenPolicy) {
+	logger := GetRequestLogger(c)
+	requestID := GetRequestID(c)
+	tokenType := policy.TokenType
+	if c.Request.Method != http.MethodPost {
+		logger.Warn().Str("request_id", requestID).Str("method", c.Request.Method).Msg("Invalid HTTP method for token endpoint")
+		as.errorCount.WithLabelValues(string(ErrInvalidRequest), "invalid_method").Inc()
+		RespondWithError(c, ErrBadRequest("Only POST method is allowed"))
+		return
+	}
+
+	start := time.Now()
+	as.tokenRequestsCount.WithLabelValues(tokenType).Inc()
+
+	// Issuance needs the database to authenticate clients and persist tokens, so don't queue up behind it
+	if as.dbHealth.Down() {
+		as.errorCount.WithLabelValues(string(ErrServiceUnavailable), "database_down").Inc()
+		as.dbHealth.SetRetryAfter(c)
+		RespondWithError(c, ErrServiceUnavailableError("Token issuance is temporarily unavailable").WithOriginalError(errDatabaseUnavailable))
+		return
+	}
+
+	var tokenReq TokenRequest
+	bodySource, apiErr := decodeTokenRequest(c, &tokenReq)
+	if apiErr != nil {
+		logger.Error().Str("request_id", requestID).Str("details", apiErr.Details).Msg("Failed to decode token request body")
+		as.errorCount.WithLabelValues(string(ErrInvalidRequest), "decode_error").Inc()
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	candidates := append(requestCredentials(c), clientCredential{ClientID: tokenReq.ClientID, ClientSecret: tokenReq.ClientSecret, Source: bodySource})
+	if tokenReq.ClientAssertionType != "" || tokenReq.ClientAssertion != "" {
+		assertion, err := clientAssertionCredential(tokenReq.ClientAssertionType, tokenReq.ClientAssertion)
+		if err != nil {
+			logger.Warn().Str("request_id", requestID).Err(err).Msg("Invalid client assertion")
+			as.errorCount.WithLabelValues(string(ErrInvalidRequest), "invalid_client_assertion").Inc()
+			RespondWithError(c, ErrBadRequest(err.Error()))
+			return
+		}
+		candidates = append(candidates, assertion)
+	}
+	credential, err := resolveClientCredential(candidates)
+	if err != nil {
+		logger.Warn().Str("request_id", requestID).Err(err).Msg("Conflicting client credentials")
+		as.errorCount.WithLabelValues(string(ErrInvalidRequest), "credential_conflict").Inc()
+		RespondWithError(c, ErrBadRequest(err.Error()))
+		return
+	}
+	tokenReq.ClientID, tokenReq.ClientSecret = credential.ClientID, credential.ClientSecret
+
+	if err := tokenReq.Validate(); err != nil {
+		logger.Warn().Str("request_id", requestID).Err(err).Msg("Token request validation failed")
+		as.errorCount.WithLabelValues(string(ErrInvalidRequest), "validation_error").Inc()
+		RespondWithError(c, ErrBadRequest(err.Error()))
+		return
+	}
+
+	// Callers that keep failing authentication are throttled before their credentials are checked again.
+	// The attempt is claimed here and only spent if authentication fails
+	retryAfter, attemptDone := as.failedAuth.Reserve(tokenReq.ClientID, c.ClientIP())
+	if retryAfter > 0 {
+		logger.Warn().Str("request_id", requestID).Str("client_id", tokenReq.ClientID).Dur("retry_after", retryAfter).Msg("Failed authentication rate limit exceeded")
+		as.errorCount.WithLabelValues(string(ErrRateLimited), "failed_auth_throttled").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		RespondWithError(c, ErrTooManyRequestsError("Too many failed authentication attempts. Please try again later."))
+		return
+	}
+
+	// validate client
+	var client *Clients
+	if credential.Assertion != "" {
+		client, err = as.validateClientAssertion(c.Request.Context(), tokenReq.ClientID, credential.Assertion, clientAssertionAudiences(c))
+	} else {
+		client, err = as.validateClient(c.Request.Context(), tokenReq.ClientID, tokenReq.ClientSecret)
+	}
+	attemptDone(err != nil)
+	if err != nil {
+		logger.Warn().Str("request_id", requestID).Str("client_id", tokenReq.ClientID).Msg("Client validation failed")
+		as.errorCount.WithLabelValues(string(ErrUnauthorized), "invalid_credentials").Inc()
+		if rejectedRegisteredClient(err) {
+			as.usage.AuthFailed(tokenReq.ClientID)
+			as.activity.AuthFailed(tokenReq.ClientID, c.ClientIP())
+		}
+		as.recordAuthEvent(AuthEvent{Type: AuthEventAuthFailed, ClientID: tokenReq.ClientID, IP: c.ClientIP()})
+		if credential.Source == CredentialSourceBasic {
+			c.Header("WWW-Authenticate", basicAuthChallenge)
+		}
+		RespondWithError(c, ErrUnauthorizedError("Invalid client credentials"))
+		return
+	}
+
+	// validate grant type
+	if err := as.validateGrantType(tokenReq.GrantType); err != nil {
+		logger.Warn().Str("request_id", requestID).Str("grant_type", tokenReq.GrantType).Msg("Invalid grant type")
+		as.errorCount.WithLabelValues(string(ErrInvalidRequest), "invalid_grant_type").Inc()
+		RespondWithError(c, ErrBadRequest("Unsupported grant type"))
+		return
+	}
+
+	if retryAfter := as.usage.QuotaRetryAfter(client.ClientID); retryAfter > 0 {
+		logger.Warn().Str("request_id", requestID).Str("client_id", client.ClientID).Dur("retry_after", retryAfter).Msg("Token issuance quota exhausted")
+		as.errorCount.WithLabelValues(string(ErrRateLimited), "quota_exceeded").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		RespondWithError(c, ErrTooManyRequestsError("Token issuance quota exhausted. Please try again later."))
+		return
+	}
+
+	grant := policy.rateLimitGrant(tokenReq.GrantType)
+	if retryAfter := as.grantLimits.Reserve(grant, client.ClientID); retryAfter > 0 {
+		logger.Warn().Str("request_id", requestID).Str("client_id", client.ClientID).Str("grant", grant).Dur("retry_after", retryAfter).Msg("Grant rate limit exceeded")
+		as.errorCount.WithLabelValues(string(ErrRateLimited), "grant_rate_limited").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		RespondWithError(c, ErrTooManyRequestsError("Too many token requests for this grant type. Please try again later."))
+		return
+	}
+
+	var actor *Actor
+	if tokenReq.OnBehalfOf != "" {
+		client, actor, err = as.resolveDelegation(c.Request.Context(), client, tokenReq.OnBehalfOf)
+		if err != nil {
+			apiErr := err.(*APIError)
+			logger.Warn().Str("request_id", requestID).Str("client_id", tokenReq.ClientID).Str("on_behalf_of", tokenReq.OnBehalfOf).Msg("Delegation rejected")
+			as.errorCount.WithLabelValues(string(apiErr.Code), delegationFailureReason(apiErr)).Inc()
+			RespondWithError(c, apiErr)
+			return
+		}
+	}
+
+	scopes, err := grantedScopes(client, tokenReq.Scope, as.scopeHierarchy)
+	if err != nil {
+		logger.Warn().Str("request_id", requestID).Str("client_id", tokenReq.ClientID).Str("scope", tokenReq.Scope).Err(err).Msg("Requested scope not allowed")
+		as.errorCount.WithLabelValues(string(ErrInvalidScope), "invalid_scope").Inc()
+		RespondWithError(c, NewAPIError(ErrInvalidScope, err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	regions, err := tokenRegions(tokenReq.Regions)
+	if err != nil {
+		logger.Warn().Str("request_id", requestID).Str("client_id", tokenReq.ClientID).Str("regions", tokenReq.Regions).Err(err).Msg("Requested regions not allowed")
+		as.errorCount.WithLabelValues(string(ErrInvalidRequest), "invalid_region").Inc()
+		RespondWithError(c, ErrBadRequest(err.Error()))
+		return
+	}
+
+	audiences, err := tokenAudiences(client, tokenReq.Audience)
+	if err != nil {
+		logger.Warn().Str("request_id", requestID).Str("client_id", tokenReq.ClientID).Str("audience", tokenReq.Audience).Err(err).Msg("Requested audience not allowed")
+		as.errorCount.WithLabelValues(string(ErrInvalidTarget), "invalid_target").Inc()
+		RespondWithError(c, NewAPIError(ErrInvalidTarget, err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	dpopKey, apiErr := as.dpopTokenBinding(c)
+	if apiErr != nil {
+		logger.Warn().Str("request_id", requestID).Str("client_id", tokenReq.ClientID).Str("details", apiErr.Details).Msg("DPoP proof rejected")
+		as.errorCount.WithLabelValues(string(ErrInvalidDPoPProof), "invalid_dpop_proof").Inc()
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	token, tokenInfo, err := as.generateRestrictedJWT(c.Request.Context(), client, actor, scopes, tokenType, tokenRestrictions{Regions: regions, Audiences: audiences, DPoPKey: dpopKey})
+	var hookErr *pipelineHookError
+	if errors.As(err, &hookErr) {
+		as.errorCount.WithLabelValues(string(ErrForbidden), "issuance_refused").Inc()
+		RespondWithError(c, hookErr.apiError(ErrForbiddenError("Token issuance was refused by policy")))
+		return
+	}
+	if err != nil {
+		logger.Error().Str("request_id", requestID).Str("client_id", tokenReq.ClientID).Err(err).Msg("Failed to generate JWT token")
+		as.tokenErrorCount.WithLabelValues(tokenType, "signing_error").Inc()
+		RespondWithError(c, ErrInternalServerError("Failed to generate token").WithOriginalError(err))
+		return
+	}
+	log.Info().Str("client_id", tokenReq.ClientID).Str("token_id", tokenInfo.TokenID).Str("policy", policy.Name).Str("credential_source", credential.Source).Msg("JWT token generated successfully")
+
+	as.tokenSuccessCount.WithLabelValues(tokenType).Inc()
+	as.recordClientIssuance(client.ClientID, tokenType)
+	as.usage.TokenIssued(client.ClientID)
+	as.activity.TokenIssued(client.ClientID, c.ClientIP())
+	as.recordAuthEvent(AuthEvent{Type: AuthEventTokenIssued, ClientID: client.ClientID, TokenID: tokenInfo.TokenID, TokenType: tokenType, IP: c.ClientIP()})
+
+	as.tokenGenerationDuration.WithLabelValues(tokenType).Observe(float64(time.Since(start).Seconds()))
+
+	c.Header("Content-Type", "application/json")
+	encoder := json.NewEncoder(c.Writer)
+	if err := encoder.Encode(TokenResponse{
+		AccessToken: token,
+		TokenType:   responseTokenType(dpopKey),
+		ExpiresIn:   int64(time.Until(tokenInfo.ExpiresAt).Round(time.Second).Seconds()),
+		Scope:       strings.Join(tokenInfo.Scopes, " "),
+		TokenID:     tokenInfo.TokenID,
+	}); err != nil {
+		logger.Error().Str("request_id", requestID).Err(err).Msg("Failed to encode token response")
+		c.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
+
+// responseTokenType is the token_type of a token response: DPoP for tokens bound to a DPoP key
+func responseTokenType(dpopKey string) string {
+	if dpopKey != "" {
+		return dpopScheme
+	}
+	return "Bearer"
+}
+
+// grantedScopes returns the scopes to embed in a token. Explicitly requested scopes must each be
+// allowed, or implied by an allowed scope under hierarchy, so a client holding read:* can ask for just
+// read:ltp; duplicates are dropped. With no request the client's default scopes are used, falling back
+// to every allowed scope
+func grantedScopes(client *Clients, requested string, hierarchy *scopeHierarchy) ([]string, error) {
+	if requested = strings.TrimSpace(requested); requested != "" {
+		var scopes []string
+		for _, scope := range strings.Fields(requested) {
+			if slices.Contains(scopes, scope) {
+				continue
+			}
+			if !hierarchy.HasScope(client.AllowedScopes, scope) {
+				return nil, fmt.Errorf("scope %q is not allowed for this client", scope)
+			}
+			scopes = append(scopes, scope)
+		}
+		return scopes, nil
+	}
+
+	if len(client.DefaultScopes) == 0 {
+		return client.AllowedScopes, nil
+	}
+	// Defaults may not exceed allowed scopes, e.g. after delegation narrowed them
+	scopes := make([]string, 0, len(client.DefaultScopes))
+	for _, scope := range client.DefaultScopes {
+		if slices.Contains(client.AllowedScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+	go_ora "github.com/sijms/go-ora/v2"
+)
+
+// reservedJWTHeaders describe how the token is signed, so only the signer sets them. kid can still
+// be configured for JWT_SECRET through jwt_headers.kid
+var reservedJWTHeaders = []string{"alg", "kid"}
+
+// jwtHeaders scans clients.jwt_headers, a JSON object of header names to string values. NULL is none
+type jwtHeaders map[string]string
+
+// Scan implements sql.Scanner
+func (h *jwtHeaders) Scan(src any) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	case go_ora.Clob:
+		raw = v.String
+	case go_ora.NClob:
+		raw = v.String
+	default:
+		return fmt.Errorf("cannot read jwt headers from %T", src)
+	}
+	if raw == "" {
+		*h = nil
+		return nil
+	}
+	headers := map[string]string{}
+	if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+		return fmt.Errorf("jwt headers must be a JSON object of strings: %v", err)
+	}
+	if err := validateJWTHeaders(headers); err != nil {
+		return err
+	}
+	*h = headers
+	return nil
+}
+
+func validateJWTHeaders(headers map[string]string) error {
+	for name := range headers {
+		if name == "" {
+			return fmt.Errorf("jwt header names must not be empty")
+		}
+		if slices.Contains(reservedJWTHeaders, name) {
+			return fmt.Errorf("jwt header %q is set by the signer and cannot be configured", name)
+		}
+	}
+	return nil
+}
+
+// configuredJWTHeaders is jwt_headers from the configuration as one map, typ included
+func configuredJWTHeaders() map[string]string {
+	cfg := AppConfig.JWTHeaders
+	headers := make(map[string]string, len(cfg.Extra)+1)
+	for _, header := range cfg.Extra {
+		headers[header.Name] = header.Value
+	}
+	if cfg.Typ != "" {
+		headers["typ"] = cfg.Typ
+	}
+	return headers
+}
+
+// applyJWTHeaders adds the deployment's jwt_headers and then the client's own, which win, to a token
+// about to be signed. kid is only taken from jwt_headers.kid, and only when signing with JWT_SECRET:
+// managed signing keys always send their own kid, which validation uses to find the key
+func applyJWTHeaders(token *jwt.Token, client *Clients) {
+	for name, value := range configuredJWTHeaders() {
+		token.Header[name] = value
+	}
+	for name, value := range client.JWTHeaders {
+		if !slices.Contains(reservedJWTHeaders, name) {
+			token.Header[name] = value
+		}
+	}
+	if _, managed := token.Header["kid"]; !managed && AppConfig.JWTHeaders.Kid != "" {
+		token.Header["kid"] = AppConfig.JWTHeaders.Kid
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Key rotation steps, the values of signing_key_rotations_total's step label
+const (
+	RotationCreated   = "created"
+	RotationActivated = "activated"
+	RotationRetired   = "retired"
+)
+
+// keyRotator rotates the managed signing keys on signing.rotation's schedule, doing what an operator
+// would through the admin API: a new key is created pending, promoted once every instance has had time
+// to load it, and the key it replaced keeps verifying until the tokens it signed have expired. Every
+// instance runs it; the database's conditional updates keep concurrent instances from stepping twice
+type keyRotator struct {
+	as   *authServer
+	cfg  signing_rotation
+	done chan struct{}
+
+	rotations *prometheus.CounterVec // signing_key_rotations_total by step
+}
+
+func newKeyRotator(as *authServer, cfg signing_rotation) *keyRotator {
+	if cfg.IntervalHours <= 0 {
+		return nil
+	}
+	if cfg.PromoteAfterMinutes <= 0 {
+		cfg.PromoteAfterMinutes = 10
+	}
+	// Promoting sooner would sign with a key other instances may not have loaded
+	cfg.PromoteAfterMinutes = max(cfg.PromoteAfterMinutes, int(minSigningKeyPending/time.Minute))
+	if cfg.RetireAfterHours <= 0 {
+		cfg.RetireAfterHours = 24
+	}
+	return &keyRotator{as: as, cfg: cfg, done: make(chan struct{})}
+}
+
+// Start checks the schedule every minute until Stop is called. Metrics must be registered first
+func (kr *keyRotator) Start(rotations *prometheus.CounterVec) {
+	if kr == nil {
+		return
+	}
+	kr.rotations = rotations
+	log.Info().Int("interval_hours", kr.cfg.IntervalHours).Int("retire_after_hours", kr.cfg.RetireAfterHours).Msg("Signing key rotation started")
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-kr.done:
+				return
+			case <-ticker.C:
+				// Another instance may have stepped since the last reload
+				kr.as.populateSigningKeys()
+				kr.Rotate(time.Now())
+			}
+		}
+	}()
+}
+
+// Stop stops rotating
+func (kr *keyRotator) Stop() {
+	if kr == nil {
+		return
+	}
+	close(kr.done)
+}
+
+// Rotate takes whichever steps are due at now and returns them
+func (kr *keyRotator) Rotate(now time.Time) []string {
+	var active, pending *SigningKey
+	var inactive []*SigningKey
+	for _, key := range kr.as.signingKeys.List() {
+		switch key.Status {
+		case SigningKeyActive:
+			active = key
+		case SigningKeyPending:
+			// List is newest first, so this ends on the oldest pending key
+			pending = key
+		case SigningKeyInactive:
+			inactive = append(inactive, key)
+		}
+	}
+
+	var steps []string
+	// Every inactive key was replaced no later than the active key was activated
+	if active != nil && active.ActivatedAt != nil && now.Sub(*active.ActivatedAt) >= time.Duration(kr.cfg.RetireAfterHours)*time.Hour {
+		for _, key := range inactive {
+			if err := kr.as.retireSigningKey(key.KeyID, now); err != nil {
+				log.Warn().Err(err).Str("kid", key.KeyID).Msg("Signing key rotation failed to retire key")
+				continue
+			}
+			kr.as.signingKeys.Retire(key.KeyID, now)
+			kr.as.validationResults.Clear()
+			kr.record(RotationRetired, key.KeyID)
+			steps = append(steps, RotationRetired)
+		}
+	}
+
+	if pending != nil {
+		if now.Sub(pending.CreatedAt) < time.Duration(kr.cfg.PromoteAfterMinutes)*time.Minute {
+			return steps
+		}
+		if err := kr.as.activateSigningKey(pending.KeyID, now); err != nil {
+			log.Warn().Err(err).Str("kid", pending.KeyID).Msg("Signing key rotation failed to activate key")
+			return steps
+		}
+		kr.as.signingKeys.Activate(pending.KeyID, now)
+		kr.record(RotationActivated, pending.KeyID)
+		return append(steps, RotationActivated)
+	}
+
+	if active != nil && active.ActivatedAt != nil && now.Sub(*active.ActivatedAt) < time.Duration(kr.cfg.IntervalHours)*time.Hour {
+		return steps
+	}
+	key, err := newSigningKey()
+	if err != nil {
+		log.Warn().Err(err).Msg("Signing key rotation failed to generate key")
+		return steps
+	}
+	key.CreatedAt = now
+	created, err := kr.as.insertPendingSigningKey(key)
+	if err != nil {
+		log.Warn().Err(err).Msg("Signing key rotation failed to store key")
+		return steps
+	}
+	if !created {
+		// Another instance created one first; it arrives with the next reload
+		return steps
+	}
+	kr.as.signingKeys.Set(key)
+	kr.record(RotationCreated, key.KeyID)
+	return append(steps, RotationCreated)
+}
+
+func (kr *keyRotator) record(step, kid string) {
+	if kr.rotations != nil {
+		kr.rotations.WithLabelValues(step).Inc()
+	}
+	log.Info().Str("step", step).Str("kid", kid).Msg("Signing key rotated")
+}
+
+// insertPendingSigningKey stores key unless a pending key already exists, reporting whether it did
+func (as *authServer) insertPendingSigningKey(key *SigningKey) (bool, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	sealed, err := as.sealSigningKey(key.secret)
+	if err != nil {
+		return false, err
+	}
+	result, err := as.db.ExecContext(ctx, `INSERT INTO signing_keys (kid, algorithm, key_material, status, created_at)
+SELECT :1, :2, :3, :4, :5`+fromDual()+` WHERE NOT EXISTS (SELECT 1 FROM signing_keys WHERE status = :6)`,
+		key.KeyID, key.Algorithm, sealed, key.Status, key.CreatedAt, SigningKeyPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
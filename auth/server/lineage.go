@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// LineageToken is an access token in a family, without the signed JWT itself
+type LineageToken struct {
+	TokenID   string     `json:"token_id"`
+	TokenType string     `json:"token_type"`
+	ClientID  string     `json:"client_id"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	Revoked   bool       `json:"revoked"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Reason    string     `json:"revocation_reason,omitempty"`
+}
+
+// TokenFamily is an access token and the refresh tokens issued for it
+type TokenFamily struct {
+	FamilyID      string          `json:"family_id"`
+	Tokens        []*LineageToken `json:"tokens"`
+	RefreshTokens []*RefreshToken `json:"refresh_tokens"`
+}
+
+// lineageTokenColumns are the tokens columns read by scanLineageToken
+const lineageTokenColumns = "token_id, token_type, client_id, issued_at, expires_at, revoked, revoked_at, revocation_reason"
+
+func scanLineageToken(rows *sql.Rows) (*LineageToken, error) {
+	token := &LineageToken{}
+	var revokedInt int
+	var revokedAt sql.NullTime
+	var reason sql.NullString
+	if err := rows.Scan(&token.TokenID, &token.TokenType, &token.ClientID, &token.IssuedAt, &token.ExpiresAt, &revokedInt, &revokedAt, &reason); err != nil {
+		return nil, err
+	}
+	token.Revoked = revokedInt == 1
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	token.Reason = reason.String
+	return token, nil
+}
+
+// tokenFamilyID returns the family of an access or refresh token
+func (as *authServer) tokenFamilyID(ctx context.Context, tokenID string) (string, error) {
+	if cached, found := as.tokenCache.Get(tokenID); found && cached.FamilyID != "" {
+		return cached.FamilyID, nil
+	}
+
+	var familyID sql.NullString
+	err := as.db.QueryRowContext(ctx, "SELECT family_id FROM tokens WHERE token_id = :1", tokenID).Scan(&familyID)
+	if err == sql.ErrNoRows {
+		refresh, refreshErr := as.refreshTokens.Get(ctx, tokenID)
+		if refreshErr != nil {
+			return "", refreshErr
+		}
+		return refresh.FamilyID, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("tokenFamilyID %s: %v", tokenID, err)
+	}
+	// Tokens issued before lineage tracking are a family of their own
+	if !familyID.Valid || familyID.String == "" {
+		return tokenID, nil
+	}
+	return familyID.String, nil
+}
+
+func (as *authServer) tokenFamily(ctx context.Context, familyID string) (*TokenFamily, error) {
+	rows, err := as.db.QueryContext(ctx, "SELECT "+lineageTokenColumns+" FROM tokens WHERE family_id = :1 OR token_id = :2 ORDER BY issued_at", familyID, familyID)
+	if err != nil {
+		return nil, fmt.Errorf("tokenFamily %s: %v", familyID, err)
+	}
+	defer rows.Close()
+
+	family := &TokenFamily{FamilyID: familyID, Tokens: make([]*LineageToken, 0)}
+	for rows.Next() {
+		token, err := scanLineageToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("tokenFamily %s: %v", familyID, err)
+		}
+		family.Tokens = append(family.Tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("tokenFamily %s: %v", familyID, err)
+	}
+
+	if family.RefreshTokens, err = as.refreshTokens.ListFamily(ctx, familyID); err != nil {
+		return nil, fmt.Errorf("tokenFamily %s: refresh tokens: %v", familyID, err)
+	}
+	return family, nil
+}
+
+// revokeTokenFamily revokes every access and refresh token in familyID, usually because one of them
+// is found compromised. It returns how many access tokens were newly revoked
+func (as *authServer) revokeTokenFamily(ctx context.Context, familyID, reason string) (int, error) {
+	// Tokens still queued for the batch writer would otherwise be inserted unrevoked afterwards
+	if err := as.tokenBatcher.FlushNow(); err != nil {
+		return 0, fmt.Errorf("revokeTokenFamily %s: writing pending tokens: %v", familyID, err)
+	}
+
+	family, err := as.tokenFamily(ctx, familyID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	if _, err := as.db.ExecContext(ctx, "UPDATE tokens SET revoked = 1, revoked_at = :1, revocation_reason = :2 WHERE (family_id = :3 OR token_id = :4) AND revoked = 0", now, reason, familyID, familyID); err != nil {
+		return 0, fmt.Errorf("revokeTokenFamily %s: %v", familyID, err)
+	}
+	if _, err := as.refreshTokens.RevokeFamily(ctx, familyID, now, reason); err != nil {
+		return 0, fmt.Errorf("revokeTokenFamily %s: refresh tokens: %v", familyID, err)
+	}
+
+	revoked := 0
+	for _, token := range family.Tokens {
+		if token.Revoked {
+			continue
+		}
+		event := RevocationEvent{TokenID: token.TokenID, ClientID: token.ClientID, RevokedAt: now, Reason: reason}
+		as.applyRevocation(event)
+		as.notifyRevocationPeers(event)
+		as.webhooks.Notify(event.ClientID, WebhookEventTokenRevoked, event)
+		revoked++
+	}
+	as.countRevocations(reason, revoked)
+
+	log.Info().Str("family_id", familyID).Str("reason", reason).Int("tokens_revoked", revoked).Msg("token family revoked")
+	return revoked, nil
+}
+
+// Token family handler (admin): traces the lineage of an access or refresh token
+func (as *authServer) tokenFamilyHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	familyID, err := as.tokenFamilyID(ctx, c.Param("token_id"))
+	if err == sql.ErrNoRows {
+		RespondWithError(c, ErrNotFoundError("Token not found"))
+		return
+	}
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	family, err := as.tokenFamily(ctx, familyID)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, family)
+}
+
+// Revoke token family handler (admin): revokes the whole lineage of an access or refresh token. The
+// reason defaults to compromise, the usual cause
+func (as *authServer) revokeTokenFamilyHandler(c *gin.Context) {
+	var req RevokeTokenFamilyRequest
+	if apiErr := decodeOptionalJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = RevocationReasonCompromise
+	}
+
+	ctx, cancel := context.WithTimeout(as.ctx, 10*time.Second)
+	defer cancel()
+
+	familyID, err := as.tokenFamilyID(ctx, c.Param("token_id"))
+	if err == sql.ErrNoRows {
+		RespondWithError(c, ErrNotFoundError("Token not found"))
+		return
+	}
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	revoked, err := as.revokeTokenFamily(ctx, familyID, req.Reason)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"family_id": familyID, "tokens_revoked": revoked, "reason": req.Reason})
+}
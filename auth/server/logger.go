@@ -0,0 +1,305 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog/pkgerrors"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var onceLog sync.Once
+
+func GetLogger() zerolog.Logger {
+	onceLog.Do(func() {
+		zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+		zerolog.TimeFieldFormat = time.RFC3339Nano
+
+		rotatingLog := &lumberjack.Logger{
+			Filename:   AppConfig.Logging.Path,
+			MaxSize:    AppConfig.Logging.MaxSizeMB,
+			MaxBackups: 10,
+			MaxAge:     14, //days
+			Compress:   true,
+		}
+
+		// The level is set globally rather than on the logger, so a configuration reload can change it
+		zerolog.SetGlobalLevel(zerolog.Level(AppConfig.Logging.Level))
+		logger := zerolog.New(rotatingLog).
+			With().
+			Timestamp().
+			Str("service", "auth_server").
+			Logger()
+
+		log.Logger = logger
+		log.Info().
+			Str("log_path", AppConfig.Logging.Path).
+			Int("log_level", AppConfig.Logging.Level).
+			Msg("Logger initialized for auth_server")
+	})
+
+	return log.Logger
+}
+
+// requestIDHeader carries the request ID in both directions: a caller's own ID is used when it is safe
+// to log, and every response echoes the ID the request was logged under
+const requestIDHeader = "X-Request-ID"
+
+// incomingRequestID accepts up to 128 characters that cannot forge log fields or response headers
+var incomingRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,128}$`)
+
+func LoggingMiddleware() gin.HandlerFunc {
+	hostname, _ := os.Hostname()
+	processID := os.Getpid()
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(requestIDHeader)
+		if !incomingRequestID.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		logContext := log.With().
+			Str("request_id", requestID).
+			Str("client_ip", c.ClientIP()).
+			Str("host", hostname).
+			Int("pid", processID).
+			Str("user_agent", c.Request.UserAgent())
+		// Traced requests log their trace ID, so a slow span leads to its log lines
+		if sp := spanFromContext(c.Request.Context()); sp != nil {
+			logContext = logContext.Str("trace_id", sp.TraceID())
+		}
+		logger := logContext.Logger()
+
+		c.Set("logger", logger)
+		c.Set("request_id", requestID)
+		// Store, cache and batcher calls get the request's context, so their log lines carry request_id too
+		ctx := context.WithValue(logger.WithContext(c.Request.Context()), requestIDKey{}, requestID)
+		c.Request = c.Request.WithContext(ctx)
+
+		logger.Debug().
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Msg("Incoming request")
+
+		c.Next()
+
+		duration := time.Since(start)
+		statusCode := c.Writer.Status()
+
+		logEvent := getLogEventLevel(statusCode)
+
+		logEvent().
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Int("status", statusCode).
+			Float64("duration_ms", float64(duration.Microseconds())).
+			Msg("Request completed")
+	}
+}
+
+func getLogEventLevel(statusCode int) func() *zerolog.Event {
+	switch {
+	case statusCode >= 500:
+		return log.Error
+	case statusCode >= 400:
+		return log.Warn
+	case statusCode >= 300:
+		return log.Debug
+	default:
+		return log.Info
+	}
+}
+
+// defaultCORSOrigins are allowed when cors.allowed_origins is not set - configure in production
+var defaultCORSOrigins = []string{
+	"http://localhost:3000",      // Development
+	"http://localhost:8080",      // Development
+	"https://trusted-domain.com", // Production example
+}
+
+// corsOrigins is the origin whitelist CORSMiddleware checks. A configuration reload swaps it whole
+type corsOrigins struct {
+	allowed atomic.Pointer[map[string]bool]
+}
+
+func newCORSOrigins(origins []string) *corsOrigins {
+	co := &corsOrigins{}
+	co.Set(origins)
+	return co
+}
+
+// Set replaces the whitelist; an empty list means defaultCORSOrigins
+func (co *corsOrigins) Set(origins []string) {
+	if len(origins) == 0 {
+		origins = defaultCORSOrigins
+	}
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	co.allowed.Store(&allowed)
+}
+
+// Allowed reports whether origin is whitelisted
+func (co *corsOrigins) Allowed(origin string) bool {
+	return (*co.allowed.Load())[origin]
+}
+
+func CORSMiddleware(origins *corsOrigins) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// SECURITY FIX: Use origin whitelist instead of wildcard (*)
+		// Prevents CSRF attacks
+		origin := c.Request.Header.Get("Origin")
+
+		// Only set CORS headers if origin is allowed (not wildcard)
+		if origins.Allowed(origin) {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+			c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+		}
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// PanicAlert is sent to recovery.webhook_url when a handler panics
+type PanicAlert struct {
+	Route     string    `json:"route"`
+	Method    string    `json:"method"`
+	RequestID string    `json:"request_id,omitempty"`
+	Panic     string    `json:"panic"`
+	Stack     string    `json:"stack"`
+	Time      time.Time `json:"time"`
+}
+
+// RecoveryMiddleware turns handler panics into the standard 500 error body. Each panic is counted in
+// http_panics_total, logged with its stack trace and, when configured, posted to the recovery webhook
+func RecoveryMiddleware() gin.HandlerFunc {
+	panicCount, err := registerCounterVecMetric("http_panics_total",
+		"total number of recovered handler panics",
+		"",
+		[]string{"route", "method"})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create prometheus counter vector metric for http_panics_total")
+	}
+
+	return func(c *gin.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				route := c.FullPath()
+				if route == "" {
+					route = "unmatched"
+				}
+				stack := string(debug.Stack())
+
+				if panicCount != nil {
+					panicCount.WithLabelValues(route, c.Request.Method).Inc()
+				}
+
+				requestLogger := GetRequestLogger(c)
+				requestLogger.Error().
+					Interface("panic", err).
+					Str("route", route).
+					Str("path", c.Request.URL.Path).
+					Str("method", c.Request.Method).
+					Str("stack", stack).
+					Msg("Request panic recovered")
+
+				postWebhook(AppConfig.Recovery.WebhookURL, "panic", PanicAlert{
+					Route:     route,
+					Method:    c.Request.Method,
+					RequestID: GetRequestID(c),
+					Panic:     fmt.Sprint(err),
+					Stack:     stack,
+					Time:      time.Now().UTC(),
+				})
+
+				if c.Writer.Written() {
+					// Headers are already on the wire; all that's left is to stop the chain
+					c.Abort()
+					return
+				}
+				RespondWithError(c, ErrInternalServerError("An unexpected error occurred"))
+				c.Abort()
+			}
+		}()
+		c.Next()
+	}
+}
+
+// GetRequestLogger retrieves the request-specific logger from context
+func GetRequestLogger(c *gin.Context) zerolog.Logger {
+	logger, ok := c.Get("logger")
+	if !ok {
+		return log.Logger
+	}
+	return logger.(zerolog.Logger)
+}
+
+// GetContextLogger returns the request logger carried by ctx, or the global logger when ctx doesn't
+// belong to a request, e.g. in background jobs
+func GetContextLogger(ctx context.Context) zerolog.Logger {
+	if logger := zerolog.Ctx(ctx); logger.GetLevel() != zerolog.Disabled {
+		return *logger
+	}
+	return log.Logger
+}
+
+type requestIDKey struct{}
+
+// contextRequestID returns the request ID carried by ctx, or "" outside a request
+func contextRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func GetRequestID(c *gin.Context) string {
+	requestID, ok := c.Get("request_id")
+	if !ok {
+		return ""
+	}
+	return requestID.(string)
+}
+
+// SECURITY FIX: Sanitize sensitive headers before logging
+// Prevents credentials, API keys, and tokens from being exposed in logs
+func sanitizeHeaders(h map[string][]string) map[string]string {
+	safe := make(map[string]string)
+	sensitiveHeaders := map[string]bool{
+		"authorization": true,
+		"x-api-key":     true,
+		"cookie":        true,
+		"x-auth-token":  true,
+		"client-secret": true,
+	}
+
+	for key, values := range h {
+		keyLower := strings.ToLower(key)
+		if sensitiveHeaders[keyLower] {
+			safe[key] = "***REDACTED***"
+		} else if len(values) > 0 {
+			safe[key] = values[0]
+		}
+	}
+	return safe
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// memorySample is the process memory the watchdog compares against its limits
+type memorySample struct {
+	Heap uint64 // bytes of allocated heap objects
+	RSS  uint64 // resident set size in bytes; 0 where it cannot be read
+}
+
+// readMemory samples the Go heap and, on Linux, the resident set size
+func readMemory() memorySample {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return memorySample{Heap: stats.HeapAlloc, RSS: readRSS()}
+}
+
+// readRSS reads the resident set size from /proc/self/statm, which counts pages
+func readRSS() uint64 {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := bytes.Fields(statm)
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+// memoryWatchdog samples process memory and, past memory_watchdog's heap or RSS limit, evicts the
+// least recently used share of the token and client caches and drops cached validation results, so a
+// burst of distinct tokens cannot grow the caches until the process is OOM-killed. Evicted entries are
+// read from the database again when next needed
+type memoryWatchdog struct {
+	as         *authServer
+	cfg        memory_watchdog
+	readMemory func() memorySample
+	done       chan struct{}
+
+	memoryBytes   *prometheus.GaugeVec   // memory_watchdog_bytes by kind (heap, rss)
+	pressureCount *prometheus.CounterVec // memory_pressure_events_total by trigger (heap, rss)
+	evictedCount  *prometheus.CounterVec // cache_pressure_evictions_total by cache
+}
+
+func newMemoryWatchdog(as *authServer, cfg memory_watchdog) *memoryWatchdog {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.HeapLimitMB <= 0 && cfg.RSSLimitMB <= 0 {
+		log.Warn().Msg("memory_watchdog has no heap_limit_mb or rss_limit_mb, memory watchdog disabled")
+		return nil
+	}
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 10
+	}
+	if cfg.EvictPercent <= 0 || cfg.EvictPercent > 100 {
+		cfg.EvictPercent = 25
+	}
+	return &memoryWatchdog{
+		as:         as,
+		cfg:        cfg,
+		readMemory: readMemory,
+		done:       make(chan struct{}),
+	}
+}
+
+// Start samples memory in the background until Stop is called. Metrics must be registered first
+func (mw *memoryWatchdog) Start(memoryBytes *prometheus.GaugeVec, pressureCount, evictedCount *prometheus.CounterVec) {
+	if mw == nil {
+		return
+	}
+	mw.memoryBytes = memoryBytes
+	mw.pressureCount = pressureCount
+	mw.evictedCount = evictedCount
+	go func() {
+		ticker := time.NewTicker(time.Duration(mw.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-mw.done:
+				return
+			case <-ticker.C:
+				mw.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops background sampling
+func (mw *memoryWatchdog) Stop() {
+	if mw == nil {
+		return
+	}
+	close(mw.done)
+}
+
+// trigger returns which limit sample exceeds, heap before rss, or "" when it is within both
+func (mw *memoryWatchdog) trigger(sample memorySample) string {
+	const mb = 1 << 20
+	switch {
+	case mw.cfg.HeapLimitMB > 0 && sample.Heap > uint64(mw.cfg.HeapLimitMB)*mb:
+		return "heap"
+	case mw.cfg.RSSLimitMB > 0 && sample.RSS > uint64(mw.cfg.RSSLimitMB)*mb:
+		return "rss"
+	}
+	return ""
+}
+
+// Check samples memory once and shrinks the caches if it is over a limit, returning the entries
+// evicted from each cache
+func (mw *memoryWatchdog) Check() map[string]int {
+	sample := mw.readMemory()
+	if mw.memoryBytes != nil {
+		mw.memoryBytes.WithLabelValues("heap").Set(float64(sample.Heap))
+		mw.memoryBytes.WithLabelValues("rss").Set(float64(sample.RSS))
+	}
+	trigger := mw.trigger(sample)
+	if trigger == "" {
+		return nil
+	}
+
+	share := func(size int) int {
+		return (size*mw.cfg.EvictPercent + 99) / 100
+	}
+	evicted := map[string]int{}
+	if tc := mw.as.tokenCache; tc != nil {
+		evicted["token"] = tc.EvictLRU(share(tc.GetSize()))
+	}
+	if cc := mw.as.clientCache; cc != nil {
+		evicted["client"] = cc.EvictLRU(share(cc.GetSize()))
+	}
+	evicted["validation_result"] = mw.as.validationResults.GetSize()
+	mw.as.validationResults.Clear()
+
+	// Hand the freed memory back now rather than at the next GC cycle
+	debug.FreeOSMemory()
+
+	if mw.pressureCount != nil {
+		mw.pressureCount.WithLabelValues(trigger).Inc()
+	}
+	if mw.evictedCount != nil {
+		for cache, n := range evicted {
+			mw.evictedCount.WithLabelValues(cache).Add(float64(n))
+		}
+	}
+	log.Warn().
+		Str("trigger", trigger).
+		Uint64("heap_bytes", sample.Heap).
+		Uint64("rss_bytes", sample.RSS).
+		Int("token_cache_evicted", evicted["token"]).
+		Int("client_cache_evicted", evicted["client"]).
+		Int("validation_results_dropped", evicted["validation_result"]).
+		Msg("Memory limit exceeded, shrank caches")
+	return evicted
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"sync"
+
+	metricreg "auth/auth/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are kept in the auth/metrics package, imported as metricreg since metrics is a config
+// section here. These wrappers make sure every metric carries the region labels, which are only
+// known once the configuration is loaded
+
+var metricLabelsOnce sync.Once
+
+func metricCollector() {
+	metricLabelsOnce.Do(func() { metricreg.SetConstLabels(regionMetricLabels()) })
+}
+
+func getMetricRegistry() *prometheus.Registry {
+	metricCollector()
+	return metricreg.Registry()
+}
+
+func RegisterGaugeMetric(name, help, namespace string) (prometheus.Gauge, error) {
+	metricCollector()
+	return metricreg.RegisterGauge(name, help, namespace)
+}
+
+func RegisterCounterMetric(name, help, namespace string) (prometheus.Counter, error) {
+	metricCollector()
+	return metricreg.RegisterCounter(name, help, namespace)
+}
+
+func RegisterHistogramMetric(name, help, namespace string, buckets []float64) (prometheus.Histogram, error) {
+	metricCollector()
+	return metricreg.RegisterHistogram(name, help, namespace, buckets)
+}
+
+func registerGaugeVecMetric(name, help, namespace string, labels []string) (*prometheus.GaugeVec, error) {
+	metricCollector()
+	return metricreg.RegisterGaugeVec(name, help, namespace, labels)
+}
+
+func registerCounterVecMetric(name, help, namespace string, labels []string) (*prometheus.CounterVec, error) {
+	metricCollector()
+	return metricreg.RegisterCounterVec(name, help, namespace, labels)
+}
+
+func registerHistogramVecMetric(name, help, namespace string, buckets []float64, labels []string) (*prometheus.HistogramVec, error) {
+	metricCollector()
+	return metricreg.RegisterHistogramVec(name, help, namespace, buckets, labels)
+}
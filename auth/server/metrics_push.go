@@ -0,0 +1,309 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+)
+
+// Metrics push protocols
+const (
+	MetricsPushOTLP        = "otlp"        // OTLP/HTTP with JSON encoding, e.g. to an OpenTelemetry collector's /v1/metrics
+	MetricsPushPushgateway = "pushgateway" // OpenMetrics text to a Prometheus Pushgateway
+)
+
+// metricsPusher pushes the registry to a collector on an interval, for deployments where nothing can
+// scrape the internal listener. The scrape endpoint keeps working alongside it
+type metricsPusher struct {
+	cfg      metrics_push
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	started  time.Time
+	done     chan struct{}
+	stopped  chan struct{}
+
+	pushCount *prometheus.CounterVec // metrics_push_total by result (success, failure)
+}
+
+func newMetricsPusher(cfg metrics_push) *metricsPusher {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.URL == "" {
+		log.Warn().Msg("metrics.push has no url, metrics push disabled")
+		return nil
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = MetricsPushOTLP
+	}
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 15
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 10
+	}
+	if cfg.Job == "" {
+		cfg.Job = "auth-server"
+	}
+	return &metricsPusher{
+		cfg:      cfg,
+		gatherer: getMetricRegistry(),
+		client:   &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+		started:  time.Now(),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Start pushes in the background until Stop is called. Metrics must be registered first
+func (mp *metricsPusher) Start(pushCount *prometheus.CounterVec) {
+	if mp == nil {
+		return
+	}
+	mp.pushCount = pushCount
+	log.Info().Str("protocol", mp.cfg.Protocol).Str("url", mp.cfg.URL).Int("interval_seconds", mp.cfg.IntervalSeconds).Msg("Metrics push started")
+	go func() {
+		defer close(mp.stopped)
+		ticker := time.NewTicker(time.Duration(mp.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-mp.done:
+				// Push once more so the last interval's counts are not lost
+				mp.pushOnce()
+				return
+			case <-ticker.C:
+				mp.pushOnce()
+			}
+		}
+	}()
+}
+
+// Stop makes a final push and stops pushing
+func (mp *metricsPusher) Stop() {
+	if mp == nil {
+		return
+	}
+	close(mp.done)
+	<-mp.stopped
+}
+
+func (mp *metricsPusher) pushOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(mp.cfg.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	result := "success"
+	if err := mp.Push(ctx); err != nil {
+		result = "failure"
+		log.Warn().Err(err).Str("protocol", mp.cfg.Protocol).Str("url", mp.cfg.URL).Msg("Metrics push failed")
+	}
+	if mp.pushCount != nil {
+		mp.pushCount.WithLabelValues(result).Inc()
+	}
+}
+
+// Push sends the current value of every metric once
+func (mp *metricsPusher) Push(ctx context.Context) error {
+	if mp.cfg.Protocol == MetricsPushPushgateway {
+		pusher := push.New(mp.cfg.URL, mp.cfg.Job).
+			Gatherer(mp.gatherer).
+			Client(mp.client).
+			Format(expfmt.NewFormat(expfmt.TypeOpenMetrics)).
+			Header(mp.headers())
+		if hostname, err := os.Hostname(); err == nil {
+			pusher = pusher.Grouping("instance", hostname)
+		}
+		return pusher.PushContext(ctx)
+	}
+
+	families, err := mp.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gathering metrics: %v", err)
+	}
+	body, err := json.Marshal(mp.otlpRequest(families, time.Now()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, mp.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = mp.headers()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := mp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+func (mp *metricsPusher) headers() http.Header {
+	header := make(http.Header, len(mp.cfg.Headers))
+	for name, value := range mp.cfg.Headers {
+		header.Set(name, value)
+	}
+	return header
+}
+
+// OTLP JSON messages (opentelemetry/proto/collector/metrics/v1). 64-bit integers are strings in the
+// JSON mapping, and cumulative temporality matches Prometheus counters and histograms
+type (
+	otlpMetricsRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpMetric struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Gauge       *otlpData       `json:"gauge,omitempty"`
+		Sum         *otlpData       `json:"sum,omitempty"`
+		Histogram   *otlpData       `json:"histogram,omitempty"`
+		Summary     *otlpData       `json:"summary,omitempty"`
+		points      []otlpDataPoint // gathered before the metric's kind is known
+	}
+	otlpData struct {
+		DataPoints             []otlpDataPoint `json:"dataPoints"`
+		AggregationTemporality int             `json:"aggregationTemporality,omitempty"`
+		IsMonotonic            bool            `json:"isMonotonic,omitempty"`
+	}
+	otlpDataPoint struct {
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		AsDouble          *float64        `json:"asDouble,omitempty"`
+		Count             string          `json:"count,omitempty"`
+		Sum               *float64        `json:"sum,omitempty"`
+		BucketCounts      []string        `json:"bucketCounts,omitempty"`
+		ExplicitBounds    []float64       `json:"explicitBounds,omitempty"`
+		QuantileValues    []otlpQuantile  `json:"quantileValues,omitempty"`
+	}
+	otlpQuantile struct {
+		Quantile float64 `json:"quantile"`
+		Value    float64 `json:"value"`
+	}
+)
+
+const otlpCumulative = 2 // AGGREGATION_TEMPORALITY_CUMULATIVE
+
+func otlpAttr(key, value string) otlpAttribute {
+	attr := otlpAttribute{Key: key}
+	attr.Value.StringValue = value
+	return attr
+}
+
+// otlpRequest converts gathered Prometheus families to an OTLP export request
+func (mp *metricsPusher) otlpRequest(families []*dto.MetricFamily, now time.Time) otlpMetricsRequest {
+	resource := []otlpAttribute{otlpAttr("service.name", mp.cfg.Job)}
+	if hostname, err := os.Hostname(); err == nil {
+		resource = append(resource, otlpAttr("service.instance.id", hostname))
+	}
+	start := strconv.FormatInt(mp.started.UnixNano(), 10)
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, family := range families {
+		metric := otlpMetric{Name: family.GetName(), Description: family.GetHelp()}
+		for _, m := range family.GetMetric() {
+			point := otlpDataPoint{StartTimeUnixNano: start, TimeUnixNano: timestamp}
+			for _, label := range m.GetLabel() {
+				point.Attributes = append(point.Attributes, otlpAttr(label.GetName(), label.GetValue()))
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				point.AsDouble = floatPtr(m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				point.AsDouble = floatPtr(m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				point.AsDouble = floatPtr(m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				point.Count = strconv.FormatUint(h.GetSampleCount(), 10)
+				point.Sum = floatPtr(h.GetSampleSum())
+				// Prometheus buckets are cumulative; OTLP counts each bucket on its own, plus +Inf
+				var previous uint64
+				for _, bucket := range h.GetBucket() {
+					if math.IsInf(bucket.GetUpperBound(), 1) {
+						continue
+					}
+					point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+					point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(bucket.GetCumulativeCount()-previous, 10))
+					previous = bucket.GetCumulativeCount()
+				}
+				point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				point.Count = strconv.FormatUint(s.GetSampleCount(), 10)
+				point.Sum = floatPtr(s.GetSampleSum())
+				for _, q := range s.GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, otlpQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+				}
+			default:
+				continue
+			}
+			metric.points = append(metric.points, point)
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Sum = &otlpData{DataPoints: metric.points, AggregationTemporality: otlpCumulative, IsMonotonic: true}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			for i := range metric.points {
+				metric.points[i].StartTimeUnixNano = ""
+			}
+			metric.Gauge = &otlpData{DataPoints: metric.points}
+		case dto.MetricType_HISTOGRAM:
+			metric.Histogram = &otlpData{DataPoints: metric.points, AggregationTemporality: otlpCumulative}
+		case dto.MetricType_SUMMARY:
+			metric.Summary = &otlpData{DataPoints: metric.points}
+		default:
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: resource},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "auth-server"}, Metrics: metrics}},
+	}}}
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// migration is one versioned schema change, with the statements for each supported driver. Oracle
+// commits DDL implicitly, so a migration cannot run in a transaction: one that fails halfway is run
+// again from the start, and its statements must tolerate objects a previous attempt created
+type migration struct {
+	Version     int
+	Description string
+	Statements  map[string][]string
+}
+
+// migrations are applied in order and recorded in schema_migrations. A released migration is never
+// edited; schema changes go in a new one with the next version, for every driver in schemaDDL
+var migrations = []migration{
+	{Version: 1, Description: "baseline: clients, tokens, endpoints and supporting tables", Statements: schemaDDL},
+	{Version: 2, Description: "tokens_archive for token_purge.archive", Statements: map[string][]string{
+		DriverOracle: {
+			`CREATE TABLE tokens_archive (
+    token_id VARCHAR2(255) PRIMARY KEY,
+    token_type VARCHAR2(20) NOT NULL,
+    client_id VARCHAR2(100) NOT NULL,
+    issued_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked NUMBER(1),
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR2(20),
+    family_id VARCHAR2(255),
+    archived_at TIMESTAMP DEFAULT SYSTIMESTAMP
+)`,
+			`CREATE INDEX idx_tokens_archive_client_id ON tokens_archive(client_id)`,
+		},
+		DriverPostgres: tokensArchiveDDL,
+		DriverSQLite:   tokensArchiveDDL,
+	}},
+}
+
+// tokensArchiveDDL is migration 2 for Postgres and SQLite. The archive has no foreign key to clients,
+// so it outlives the clients it records
+var tokensArchiveDDL = []string{
+	`CREATE TABLE tokens_archive (
+    token_id VARCHAR(255) PRIMARY KEY,
+    token_type VARCHAR(20) NOT NULL,
+    client_id VARCHAR(100) NOT NULL,
+    issued_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked SMALLINT,
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR(20),
+    family_id VARCHAR(255),
+    archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`,
+	`CREATE INDEX idx_tokens_archive_client_id ON tokens_archive(client_id)`,
+}
+
+// migrationsTableDDL creates the table recording which migrations a database has had
+var migrationsTableDDL = map[string]string{
+	DriverOracle:   `CREATE TABLE schema_migrations (version NUMBER(10) PRIMARY KEY, description VARCHAR2(255), applied_at TIMESTAMP DEFAULT SYSTIMESTAMP)`,
+	DriverPostgres: `CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, description VARCHAR(255), applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+	DriverSQLite:   `CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, description VARCHAR(255), applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+}
+
+// Errors meaning the row already exists, here because another instance recorded the same migration
+var duplicateKeyErrors = []string{
+	"ORA-00001",                // unique constraint violated
+	"SQLSTATE 23505",           // Postgres unique_violation
+	"UNIQUE constraint failed", // SQLite
+}
+
+// Errors meaning schema_migrations does not exist yet, so no migration has been applied
+var missingTableErrors = []string{
+	"ORA-00942",      // table or view does not exist
+	"SQLSTATE 42P01", // Postgres undefined_table
+	"no such table",  // SQLite
+}
+
+// appliedMigrations returns the versions schema_migrations records. With create set the table is
+// created when missing; otherwise a missing table means none are applied
+func appliedMigrations(ctx context.Context, db *sql.DB, driver string, create bool) (map[int]bool, error) {
+	ddl, ok := migrationsTableDDL[driver]
+	if !ok {
+		return nil, fmt.Errorf("no migrations available for database driver %q", driver)
+	}
+	if create {
+		if _, _, err := execDDL(ctx, db, []string{ddl}); err != nil {
+			return nil, err
+		}
+	}
+
+	applied := make(map[int]bool)
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		if !create && hasErrorCode(err, missingTableErrors) {
+			return applied, nil
+		}
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("reading schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// pendingMigrations are the migrations not yet recorded in applied, in version order
+func pendingMigrations(applied map[int]bool) []migration {
+	var pending []migration
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// migrateSchema applies the pending migrations in order and returns those it applied. A database
+// created before migrations existed, or by db init, gets the baseline recorded without changes since
+// its objects already exist. Instances migrating concurrently both run the statements, which
+// tolerate each other, and the second to record a version takes the first's row as its own
+func migrateSchema(ctx context.Context, db *sql.DB, driver string) ([]migration, error) {
+	applied, err := appliedMigrations(ctx, db, driver, true)
+	if err != nil {
+		return nil, err
+	}
+
+	record := rebindVars("INSERT INTO schema_migrations (version, description) VALUES (:1, :2)", bindMarker(driver))
+	var done []migration
+	for _, m := range pendingMigrations(applied) {
+		statements, ok := m.Statements[driver]
+		if !ok {
+			return done, fmt.Errorf("migration %d has no statements for database driver %q", m.Version, driver)
+		}
+		if _, _, err := execDDL(ctx, db, statements); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		if _, err := db.ExecContext(ctx, record, m.Version, m.Description); err != nil && !hasErrorCode(err, duplicateKeyErrors) {
+			return done, fmt.Errorf("recording migration %d: %w", m.Version, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// runStartupMigrations brings the schema up to date before the server checks it, when
+// database.migrate_on_startup is set or the database is a SQLite development one
+func runStartupMigrations(ctx context.Context, db *sql.DB, driver string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	done, err := migrateSchema(ctx, db, driver)
+	for _, m := range done {
+		log.Info().Int("version", m.Version).Str("description", m.Description).Msg("Applied schema migration")
+	}
+	return err
+}
+
+// MigrateDatabase connects to the configured database and applies the pending migrations. It backs
+// the "db migrate" command; with status set it lists every migration and whether it is applied, and
+// with dryRun it writes the pending migrations' statements to out instead of executing them
+func MigrateDatabase(status, dryRun bool, out io.Writer) error {
+	driver := databaseDriver()
+	db, err := newDbClient(databaseURL())
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if status || dryRun {
+		applied, err := appliedMigrations(ctx, db, driver, false)
+		if err != nil {
+			return err
+		}
+		if status {
+			for _, m := range migrations {
+				state := "pending"
+				if applied[m.Version] {
+					state = "applied"
+				}
+				fmt.Fprintf(out, "%4d  %-8s %s\n", m.Version, state, m.Description)
+			}
+			return nil
+		}
+		for _, m := range pendingMigrations(applied) {
+			fmt.Fprintf(out, "-- migration %d: %s\n", m.Version, m.Description)
+			for _, statement := range m.Statements[driver] {
+				fmt.Fprintf(out, "%s;\n\n", statement)
+			}
+		}
+		return nil
+	}
+
+	done, err := migrateSchema(ctx, db, driver)
+	for _, m := range done {
+		fmt.Fprintf(out, "applied migration %d: %s\n", m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Fprintln(out, "schema is up to date")
+	}
+	return checkSchema(ctx, db, driver)
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"auth/auth/cache"
+	"auth/auth/middleware"
+	"auth/auth/store"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+type authServer struct {
+	jwtSecret          []byte
+	previousJWTSecret  []byte         // JWT_SECRET_PREVIOUS: still verifies and unseals during the grace window after a rotation
+	signing            signingBackend // signs issued tokens as signing.algorithm configures
+	ctx                context.Context
+	cancel             context.CancelFunc
+	httpSrv            *http.Server
+	adminSrv           *http.Server // internal listener for admin, health, pprof and metrics
+	redirectSrv        *http.Server // HTTP to HTTPS redirect when HTTPS is enabled
+	db                 *instrumentedDB
+	store              Store              // Clients, endpoints and tokens; the database behind db when nil
+	dbHealth           *dbHealthMonitor   // Marks the database down after failed pings so requests fail fast
+	dbFailover         *dbFailover        // Switches the pool between primary and standby; nil without a standby
+	revocationBreaker  *revocationBreaker // Degrades /validate to signature-only checks while revocation lookups fail
+	clientCache        *clientCache
+	clientGroups       *clientGroupCache
+	endpointCache      *endpointCache
+	endpointRules      *endpointRuleMatcher
+	scopeHierarchy     *scopeHierarchy
+	tokenCache         *tokenCache
+	validationResults  *validationResultCache // Short-lived successful validateJWT results
+	delegationCache    *delegationCache
+	apiKeyCache        *apiKeyCache
+	refreshTokens      refreshTokenStore       // Hashed refresh tokens
+	signingKeys        *signingKeyRing         // Managed JWT signing keys; JWT_SECRET signs when none is active
+	apiKeyUsage        *apiKeyUsageTracker     // Buffered last-used tracking for API keys
+	usage              *usageRecorder          // Buffered per-client daily usage counters
+	activity           *clientActivityTracker  // Recent per-client activity observed by this instance
+	analytics          *analyticsExporter      // Auth events batched into a warehouse; nil unless enabled
+	audit              *auditPublisher         // Security events published to a SIEM webhook or Kafka topic; nil unless enabled
+	memoryWatchdog     *memoryWatchdog         // Shrinks the caches under memory pressure; nil unless enabled
+	metricsPusher      *metricsPusher          // Pushes metrics to an OTLP collector or Pushgateway; nil unless enabled
+	tracer             *tracer                 // Exports request, query and cache lookup spans over OTLP; nil unless enabled
+	shadowValidation   *shadowValidator        // Compares a candidate verification configuration; nil unless enabled
+	keyRotator         *keyRotator             // Rotates the managed signing keys on a schedule; nil unless enabled
+	verifiedSecrets    *verifiedSecrets        // Client secrets recently verified against their hashes
+	usedAssertions     *jtiCache               // private_key_jwt assertions already accepted, until they expire
+	usedDPoPProofs     *jtiCache               // DPoP proofs already accepted, until they are too old to present
+	stateless          *statelessStore         // Replaces the database in stateless mode; nil otherwise
+	tokenState         *sharedTokenState       // Revocations and one-time token use shared through Redis; nil unless enabled
+	resourceServers    *resourceServers        // Callers registered for the validation endpoint
+	anomalies          *anomalyDetector        // Rolling per-client rate baselines
+	webhooks           *webhookNotifier        // Client webhook subscriptions to token events; nil unless enabled
+	revocationProbe    *revocationProbe        // Canary measurement of revocation propagation; nil unless enabled
+	canary             *canaryProbe            // Synthetic issue/validate/revoke probe; nil unless enabled
+	faults             *faultInjector          // Chaos testing faults; nil unless built with -tags faultinject
+	corsOrigins        *corsOrigins            // Origins browsers may call the public API from
+	configReload       configReloader          // Settings last applied by a configuration reload
+	globalLimiter      *rate.Limiter           // Request limit shared by every public route
+	clientLimits       *middleware.RateLimiter // Per-client request limits applied to every public route
+	failedAuth         *FailedAuthLimiter      // Stricter throttle for token requests failing authentication
+	grantLimits        *GrantRateLimiter       // Per-client issuance limits by grant type
+	rateLimitSnapshots *rateLimitSnapshots     // Carries rate limit budgets across restarts; nil unless enabled
+	cacheRefreshes     *cacheRefreshTracker    // Last successful load time per cache
+	tokenBatcher       *TokenBatchWriter       // Batch token writer for async writes
+	tokenPersistence   *tokenPersistence       // token_store policy per token type
+	tokenPurges        tokenPurger             // On-demand purge of expired and revoked tokens
+	tokenJanitor       *tokenJanitor           // Scheduled purge through tokenPurges; nil unless enabled
+	revocationImports  revocationImports       // Bulk revocation import jobs running on this instance
+	shutdownHooks      shutdownHooks           // Extension callbacks registered with RegisterOnShutdown
+	pipelineHooks      pipelineHooks           // Issuance and validation hooks registered with RegisterPipelineHook
+
+	// token metrics
+	tokenRequestsCount      *prometheus.CounterVec
+	tokenSuccessCount       *prometheus.CounterVec
+	tokenErrorCount         *prometheus.CounterVec
+	tokenGenerationDuration *prometheus.HistogramVec
+
+	// validate token metrics
+	validateTokenRequestsCount *prometheus.CounterVec
+	validateTokenSuccessCount  *prometheus.CounterVec
+	validateTokenErrorCount    *prometheus.CounterVec
+	validateTokenLatency       *prometheus.HistogramVec
+
+	// revoke token metrics
+	revokeRequestsCount *prometheus.CounterVec
+	revokeSuccessCount  *prometheus.CounterVec
+	revokeErrorCount    *prometheus.CounterVec
+	revokeTokenLatency  *prometheus.HistogramVec
+	tokensRevokedCount  *prometheus.CounterVec
+
+	// degraded validation metrics
+	validateDegradedCount *prometheus.CounterVec
+	validateDegradedMode  *prometheus.GaugeVec
+
+	// cache metrics
+	clientCacheHitRate   *prometheus.CounterVec
+	endpointCacheHitRate *prometheus.CounterVec
+	cacheSize            *prometheus.GaugeVec
+	cacheLookups         *prometheus.CounterVec
+	cacheEvictions       *prometheus.CounterVec
+
+	// database metrics
+	dbStatus            *prometheus.GaugeVec
+	dbActive            *prometheus.GaugeVec
+	dbFailovers         *prometheus.CounterVec
+	dbConnectionsActive *prometheus.GaugeVec
+	dbConnectionsIdle   *prometheus.GaugeVec
+	dbQueryDuration     *prometheus.HistogramVec
+	dbQueryErrorCount   *prometheus.CounterVec
+
+	// error metrics
+	errorCount          *prometheus.CounterVec
+	clientLabels        *clientLabeler
+	clientTokenCount    *prometheus.CounterVec
+	clientValidateCount *prometheus.CounterVec
+	anomalyCount        *prometheus.CounterVec
+
+	// revocation propagation metrics
+	revocationPropagation *prometheus.HistogramVec
+	revocationProbeRuns   *prometheus.CounterVec
+
+	// token purge metrics
+	tokensPurged *prometheus.CounterVec
+
+	// canary probe metrics
+	canaryStepDuration *prometheus.HistogramVec
+	canaryRuns         *prometheus.CounterVec
+
+	// analytics export metrics
+	analyticsEvents *prometheus.CounterVec
+	auditEvents     *prometheus.CounterVec
+
+	// client webhook metrics
+	webhookDeliveries       *prometheus.CounterVec
+	webhookDeliveryDuration *prometheus.HistogramVec
+
+	// memory watchdog metrics
+	memoryBytes            *prometheus.GaugeVec
+	memoryPressureEvents   *prometheus.CounterVec
+	cachePressureEvictions *prometheus.CounterVec
+
+	// metrics push
+	metricsPushCount *prometheus.CounterVec
+	tracingSpans     *prometheus.CounterVec
+
+	// shadow validation metrics
+	shadowValidations *prometheus.CounterVec
+
+	// signing key rotation metrics
+	signingKeyRotations *prometheus.CounterVec
+
+	// configuration reload metrics
+	configReloads *prometheus.CounterVec
+}
+
+type clientCache struct {
+	entries *cache.TTL[string, *Clients]
+	stats   *cacheStats
+}
+
+type endpointCache struct {
+	mu    sync.RWMutex
+	cache map[string][]*Endpoints // endpoint_url -> one entry per method
+	stats *cacheStats
+}
+
+type tokenCache struct {
+	entries *cache.TTL[string, *Token] // token_id -> token
+	stats   *cacheStats
+}
+
+// The models the Store exchanges, under the names the server has always used
+type (
+	Clients      = store.Clients
+	Endpoints    = store.Endpoints
+	Token        = store.Token
+	RevokedToken = store.RevokedToken
+)
+
+// Scope modes for endpoints declaring several required scopes
+const (
+	ScopeModeAny = store.ScopeModeAny
+	ScopeModeAll = store.ScopeModeAll
+)
+
+// JWT Claims
+type Claims struct {
+	ClientID  string        `json:"client_id"`
+	TokenID   string        `json:"token_id"`
+	TokenType string        `json:"token_type"`
+	Scopes    []string      `json:"scopes"`
+	Act       *Actor        `json:"act,omitempty"`
+	Region    string        `json:"region,omitempty"`          // region of the issuing instance
+	Zone      string        `json:"zone,omitempty"`            // zone of the issuing instance
+	Regions   []string      `json:"allowed_regions,omitempty"` // when set, /validate only accepts the token in these regions
+	Cnf       *Confirmation `json:"cnf,omitempty"`             // DPoP key the token is bound to
+	Roles     []string      `json:"roles,omitempty"`           // the client's roles when the token was issued
+	jwt.RegisteredClaims
+
+	degraded bool // validated without the revocation lookup; see tokenStatus
+}
+
+type TokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	OnBehalfOf   string `json:"on_behalf_of,omitempty"`
+	Scope        string `json:"scope,omitempty"`    // requested scopes, space-separated
+	Regions      string `json:"regions,omitempty"`  // regions the token is restricted to, space-separated
+	Audience     string `json:"audience,omitempty"` // services the token is restricted to, space-separated
+
+	ClientAssertionType string `json:"client_assertion_type,omitempty"` // private_key_jwt instead of client_secret
+	ClientAssertion     string `json:"client_assertion,omitempty"`
+}
+
+// SECURITY FIX: Validate input parameters to prevent injection attacks
+func (tr *TokenRequest) Validate() error {
+	if tr.ClientID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	if len(tr.ClientID) > 255 {
+		return fmt.Errorf("client_id exceeds maximum length (255 characters)")
+	}
+	if tr.ClientSecret == "" && tr.ClientAssertion == "" {
+		return fmt.Errorf("client_secret or client_assertion is required")
+	}
+	if len(tr.ClientAssertion) > 8192 {
+		return fmt.Errorf("client_assertion exceeds maximum length (8192 characters)")
+	}
+	if len(tr.ClientSecret) > 255 {
+		return fmt.Errorf("client_secret exceeds maximum length (255 characters)")
+	}
+	if tr.GrantType == "" {
+		return fmt.Errorf("grant_type is required")
+	}
+	if tr.GrantType != "client_credentials" {
+		return fmt.Errorf("invalid grant_type: only 'client_credentials' is supported")
+	}
+	if len(tr.OnBehalfOf) > 255 {
+		return fmt.Errorf("on_behalf_of exceeds maximum length (255 characters)")
+	}
+	if tr.OnBehalfOf != "" && tr.OnBehalfOf == tr.ClientID {
+		return fmt.Errorf("on_behalf_of must differ from client_id")
+	}
+	if len(tr.Scope) > 4096 {
+		return fmt.Errorf("scope exceeds maximum length (4096 characters)")
+	}
+	if len(tr.Regions) > 1024 {
+		return fmt.Errorf("regions exceeds maximum length (1024 characters)")
+	}
+	if len(tr.Audience) > 1024 {
+		return fmt.Errorf("audience exceeds maximum length (1024 characters)")
+	}
+	return nil
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"` // granted scopes, space-separated
+	TokenID     string `json:"jti"`
+	// AuthCode string `json:"auth_code"`
+	// Method       string `json:"method"`
+	// Audience     string `json:"aud"`
+	// RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// TokenValidationRequest is the optional JSON body of /validate; fields left empty fall back to
+// the Authorization, resource URL and method headers
+type TokenValidationRequest struct {
+	Token    string `json:"token,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Audience string `json:"audience,omitempty"` // service the token is being used with; defaults to matching the resource
+}
+
+// Validate validates the validation request body
+func (vr *TokenValidationRequest) Validate() error {
+	if len(vr.Token) > 8192 {
+		return fmt.Errorf("token exceeds maximum length (8192 characters)")
+	}
+	if len(vr.Resource) > 4096 {
+		return fmt.Errorf("resource exceeds maximum length (4096 characters)")
+	}
+	if len(vr.Method) > 10 {
+		return fmt.Errorf("method exceeds maximum length (10 characters)")
+	}
+	if len(vr.Audience) > 255 {
+		return fmt.Errorf("audience exceeds maximum length (255 characters)")
+	}
+	return nil
+}
+
+// TokenValidationResponse fields other than valid and degraded are only returned to callers whose
+// resource server registration lists them
+type TokenValidationResponse struct {
+	Valid     bool      `json:"valid"`
+	ClientID  string    `json:"client_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	TokenID   string    `json:"token_id,omitempty"`
+	Degraded  bool      `json:"degraded,omitempty"` // revocation was not checked because the database is unavailable
+	// Role      string    `json:"role"`
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec is maintained by hand alongside the handlers; TestOpenAPISpec_CoversRoutes fails
+// when a route is registered without being documented
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPI specification handler
+func openAPIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpec)
+}
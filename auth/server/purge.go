@@ -0,0 +1,375 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultPurgeBatchSize = 5000
+
+// Token purge job states
+const (
+	PurgeStatusRunning   = "running"
+	PurgeStatusCompleted = "completed"
+	PurgeStatusFailed    = "failed"
+)
+
+// purgeableTokensCondition matches tokens expired or revoked before the cutoff (:1 and :2)
+const purgeableTokensCondition = "(expires_at < :1 OR (revoked = 1 AND revoked_at < :2))"
+
+// tokenArchiveColumns are copied to tokens_archive when token_purge.archive is on. The JWT itself is
+// left behind: an archived token is a record of what was issued, not a credential
+const tokenArchiveColumns = "token_id, token_type, client_id, issued_at, expires_at, revoked, revoked_at, revocation_reason, family_id"
+
+// Token purge triggers, the trigger label of tokens_purged_total
+const (
+	PurgeTriggerAdmin     = "admin"
+	PurgeTriggerScheduled = "scheduled"
+)
+
+// purgeBatchLimit limits a query on purgeableTokensCondition to :3 rows. ROWNUM is Oracle's; the
+// other drivers take LIMIT
+func purgeBatchLimit() string {
+	if databaseDriver() == DriverOracle {
+		return " AND ROWNUM <= :3"
+	}
+	return " LIMIT :3"
+}
+
+type TokenPurgeRequest struct {
+	OlderThanDays int  `json:"older_than_days"`
+	DryRun        bool `json:"dry_run,omitempty"`
+	BatchSize     int  `json:"batch_size,omitempty"`
+}
+
+func (r *TokenPurgeRequest) Validate() error {
+	if r.OlderThanDays < 1 {
+		return fmt.Errorf("older_than_days must be at least 1")
+	}
+	if r.BatchSize < 0 || r.BatchSize > 100000 {
+		return fmt.Errorf("batch_size must be between 1 and 100000")
+	}
+	return nil
+}
+
+// TokenPurgeJob reports a purge of expired and revoked tokens. Deleted and Batches grow while it runs
+type TokenPurgeJob struct {
+	ID            string     `json:"id"`
+	Status        string     `json:"status"`
+	Trigger       string     `json:"trigger"` // admin, or scheduled for the token_purge job
+	DryRun        bool       `json:"dry_run"`
+	OlderThanDays int        `json:"older_than_days"`
+	Cutoff        time.Time  `json:"cutoff"`
+	Matched       int64      `json:"matched"` // tokens eligible when the job started; scheduled purges don't count them
+	Deleted       int64      `json:"deleted"`
+	Batches       int        `json:"batches"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// tokenPurger runs at most one on-demand purge at a time and remembers the last one for progress polling
+type tokenPurger struct {
+	mu   sync.Mutex
+	last *TokenPurgeJob
+}
+
+// Snapshot returns a copy of the current or most recent job
+func (tp *tokenPurger) Snapshot() (TokenPurgeJob, bool) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if tp.last == nil {
+		return TokenPurgeJob{}, false
+	}
+	return *tp.last, true
+}
+
+// start records job as the current purge unless another one is still running
+func (tp *tokenPurger) start(job *TokenPurgeJob) bool {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if tp.last != nil && tp.last.Status == PurgeStatusRunning {
+		return false
+	}
+	tp.last = job
+	return true
+}
+
+func (tp *tokenPurger) update(fn func(job *TokenPurgeJob)) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	fn(tp.last)
+}
+
+func (as *authServer) countPurgeableTokens(cutoff time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 30*time.Second)
+	defer cancel()
+
+	var count int64
+	query := "SELECT COUNT(*) FROM tokens WHERE " + purgeableTokensCondition
+	if err := as.db.QueryRowContext(ctx, query, cutoff, cutoff).Scan(&count); err != nil {
+		return 0, fmt.Errorf("countPurgeableTokens: %v", err)
+	}
+	return count, nil
+}
+
+// deletePurgeableTokens deletes up to limit tokens expired or revoked before cutoff, returning how many went
+func (as *authServer) deletePurgeableTokens(cutoff time.Time, limit int) (int64, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 60*time.Second)
+	defer cancel()
+
+	if AppConfig.TokenPurge.Archive {
+		return as.archivePurgeableTokens(ctx, cutoff, limit)
+	}
+	query := "DELETE FROM tokens WHERE " + purgeableTokensCondition + " AND ROWNUM <= :3"
+	if databaseDriver() != DriverOracle {
+		query = "DELETE FROM tokens WHERE token_id IN (SELECT token_id FROM tokens WHERE " + purgeableTokensCondition + " LIMIT :3)"
+	}
+	result, err := as.db.ExecContext(ctx, query, cutoff, cutoff, limit)
+	if err != nil {
+		return 0, fmt.Errorf("deletePurgeableTokens: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// archivePurgeableTokens moves up to limit purgeable tokens to tokens_archive in one transaction, so a
+// token is never deleted without its archive row
+func (as *authServer) archivePurgeableTokens(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("archivePurgeableTokens: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT token_id FROM tokens WHERE "+purgeableTokensCondition+purgeBatchLimit(), cutoff, cutoff, limit)
+	if err != nil {
+		return 0, fmt.Errorf("archivePurgeableTokens: %v", err)
+	}
+	var ids []any
+	for rows.Next() {
+		var tokenID string
+		if err := rows.Scan(&tokenID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("archivePurgeableTokens: %v", err)
+		}
+		ids = append(ids, tokenID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("archivePurgeableTokens: %v", err)
+	}
+
+	// Oracle takes at most 1000 expressions in an IN list
+	var moved int64
+	for chunk := range slices.Chunk(ids, 1000) {
+		in := " WHERE token_id IN (" + bindList(len(chunk), 1) + ")"
+		if _, err := tx.ExecContext(ctx, "INSERT INTO tokens_archive ("+tokenArchiveColumns+") SELECT "+tokenArchiveColumns+" FROM tokens"+in, chunk...); err != nil {
+			return 0, fmt.Errorf("archivePurgeableTokens: %v", err)
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM tokens"+in, chunk...)
+		if err != nil {
+			return 0, fmt.Errorf("archivePurgeableTokens: %v", err)
+		}
+		deleted, _ := result.RowsAffected()
+		moved += deleted
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("archivePurgeableTokens: %v", err)
+	}
+	return moved, nil
+}
+
+// runTokenPurge deletes in batches until nothing eligible remains or maxBatches (0 for no limit) have
+// run, publishing progress after each batch
+func (as *authServer) runTokenPurge(cutoff time.Time, batchSize, maxBatches int, trigger string) {
+	for batches := 0; ; batches++ {
+		if maxBatches > 0 && batches == maxBatches {
+			as.tokenPurges.finish(nil)
+			return
+		}
+		if as.ctx.Err() != nil {
+			as.tokenPurges.finish(as.ctx.Err())
+			return
+		}
+		deleted, err := as.deletePurgeableTokens(cutoff, batchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("Token purge batch failed")
+			as.tokenPurges.finish(err)
+			return
+		}
+		as.tokenPurges.update(func(job *TokenPurgeJob) {
+			job.Deleted += deleted
+			job.Batches++
+		})
+		if as.tokensPurged != nil {
+			as.tokensPurged.WithLabelValues(trigger).Add(float64(deleted))
+		}
+		if deleted < int64(batchSize) {
+			as.tokenPurges.finish(nil)
+			return
+		}
+	}
+}
+
+func (tp *tokenPurger) finish(err error) {
+	tp.update(func(job *TokenPurgeJob) {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Status = PurgeStatusCompleted
+		if err != nil {
+			job.Status = PurgeStatusFailed
+			job.Error = err.Error()
+		}
+		log.Info().Str("job_id", job.ID).Str("trigger", job.Trigger).Str("status", job.Status).Int64("deleted", job.Deleted).Int("batches", job.Batches).Msg("Token purge finished")
+	})
+}
+
+// Token purge handler (admin): deletes tokens expired or revoked more than older_than_days ago.
+// A dry run only counts them; otherwise the purge runs in the background and 202 is returned
+func (as *authServer) purgeTokensHandler(c *gin.Context) {
+	var req TokenPurgeRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if req.BatchSize == 0 {
+		req.BatchSize = defaultPurgeBatchSize
+	}
+
+	if current, ok := as.tokenPurges.Snapshot(); ok && current.Status == PurgeStatusRunning {
+		RespondWithError(c, ErrConflictError("A token purge is already running").WithDetails("job "+current.ID))
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -req.OlderThanDays)
+	matched, err := as.countPurgeableTokens(cutoff)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	job := &TokenPurgeJob{
+		ID:            generateRandomString(8),
+		Status:        PurgeStatusRunning,
+		Trigger:       PurgeTriggerAdmin,
+		DryRun:        req.DryRun,
+		OlderThanDays: req.OlderThanDays,
+		Cutoff:        cutoff,
+		Matched:       matched,
+		StartedAt:     time.Now(),
+	}
+	if req.DryRun {
+		job.Status = PurgeStatusCompleted
+		job.FinishedAt = &job.StartedAt
+		c.JSON(http.StatusOK, job)
+		return
+	}
+
+	snapshot := *job
+	if !as.tokenPurges.start(job) {
+		RespondWithError(c, ErrConflictError("A token purge is already running"))
+		return
+	}
+
+	logger := GetRequestLogger(c)
+	logger.Info().Str("job_id", job.ID).Time("cutoff", cutoff).Int64("matched", matched).Int("batch_size", req.BatchSize).Msg("Token purge started")
+	go as.runTokenPurge(cutoff, req.BatchSize, 0, PurgeTriggerAdmin)
+
+	c.JSON(http.StatusAccepted, snapshot)
+}
+
+// Token purge status handler (admin): progress of the running or most recent purge
+func (as *authServer) purgeTokensStatusHandler(c *gin.Context) {
+	job, ok := as.tokenPurges.Snapshot()
+	if !ok {
+		RespondWithError(c, ErrNotFoundError("No token purge has been run"))
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// tokenJanitor purges tokens expired or revoked more than token_purge.retention_days ago on a
+// schedule. It runs through tokenPurges like an admin purge, so the two never overlap and the scheduled
+// run's progress shows at GET /admin/tokens/purge
+type tokenJanitor struct {
+	as   *authServer
+	cfg  token_purge
+	done chan struct{}
+}
+
+func newTokenJanitor(as *authServer, cfg token_purge) *tokenJanitor {
+	if !cfg.Enabled {
+		return nil
+	}
+	if as.stateless != nil {
+		log.Warn().Msg("token_purge is enabled but stateless mode stores no tokens, scheduled purge disabled")
+		return nil
+	}
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 3600
+	}
+	if cfg.RetentionDays <= 0 {
+		cfg.RetentionDays = 7
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultPurgeBatchSize
+	}
+	return &tokenJanitor{as: as, cfg: cfg, done: make(chan struct{})}
+}
+
+// Start runs the janitor in the background until Stop is called
+func (tj *tokenJanitor) Start() {
+	if tj == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(tj.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tj.done:
+				return
+			case <-ticker.C:
+				tj.Run()
+			}
+		}
+	}()
+}
+
+// Stop stops the background janitor; a purge already running finishes its current batch when the
+// server's context is cancelled
+func (tj *tokenJanitor) Stop() {
+	if tj == nil {
+		return
+	}
+	close(tj.done)
+}
+
+// Run performs one scheduled purge, unless another purge is still running, and waits for it
+func (tj *tokenJanitor) Run() {
+	cutoff := time.Now().AddDate(0, 0, -tj.cfg.RetentionDays)
+	job := &TokenPurgeJob{
+		ID:            generateRandomString(8),
+		Status:        PurgeStatusRunning,
+		Trigger:       PurgeTriggerScheduled,
+		OlderThanDays: tj.cfg.RetentionDays,
+		Cutoff:        cutoff,
+		StartedAt:     time.Now(),
+	}
+	if !tj.as.tokenPurges.start(job) {
+		log.Info().Msg("Skipping scheduled token purge, another purge is running")
+		return
+	}
+	tj.as.runTokenPurge(cutoff, tj.cfg.BatchSize, tj.cfg.MaxBatchesPerRun, PurgeTriggerScheduled)
+}
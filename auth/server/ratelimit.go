@@ -0,0 +1,220 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"auth/auth/middleware"
+
+	"golang.org/x/time/rate"
+)
+
+// maxFailedAuthBuckets bounds the client_id+IP buckets kept between cleanups; both halves of the key
+// come from the request, so a flood of made-up pairs would otherwise grow the map without limit
+const maxFailedAuthBuckets = 100000
+
+// FailedAuthLimiter throttles token requests per client_id+IP once they start failing authentication.
+// Only failures spend the bucket, so a client's legitimate traffic is never slowed by someone else
+// guessing its secret from another address
+type FailedAuthLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	inFlight map[string]int // attempts reserved but not yet known to have failed or succeeded
+	limit    rate.Limit
+	burst    int
+	pruned   time.Time
+	ticker   *time.Ticker
+	done     chan struct{}
+}
+
+// NewFailedAuthLimiter allows burst failed attempts per client_id+IP, refilled at perMinute per minute
+func NewFailedAuthLimiter(perMinute int, burst int) *FailedAuthLimiter {
+	fl := &FailedAuthLimiter{
+		limiters: make(map[string]*rate.Limiter),
+		inFlight: make(map[string]int),
+		limit:    rate.Limit(float64(perMinute) / 60),
+		burst:    burst,
+		ticker:   time.NewTicker(1 * time.Minute),
+		done:     make(chan struct{}),
+	}
+	go fl.cleanup()
+	return fl
+}
+
+func failedAuthKey(clientID, ip string) string {
+	return clientID + "|" + ip
+}
+
+// Reserve claims an attempt from the client_id+IP bucket before the client authenticates, counting
+// attempts still in flight as spent so concurrent requests cannot all pass the check before any of
+// their failures is recorded. It returns how long to wait when no attempt is left; otherwise the
+// caller must call done with the outcome, and only a failure spends the attempt
+func (fl *FailedAuthLimiter) Reserve(clientID, ip string) (retryAfter time.Duration, done func(failed bool)) {
+	if fl == nil {
+		return 0, func(bool) {}
+	}
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	now := time.Now()
+	key := failedAuthKey(clientID, ip)
+	available := float64(fl.burst)
+	if limiter, exists := fl.limiters[key]; exists {
+		available = limiter.TokensAt(now)
+	}
+	if available -= float64(fl.inFlight[key]); available < 1 {
+		return time.Duration((1 - available) / float64(fl.limit) * float64(time.Second)), func(bool) {}
+	}
+	fl.inFlight[key]++
+	return 0, func(failed bool) {
+		fl.mu.Lock()
+		defer fl.mu.Unlock()
+		if fl.inFlight[key]--; fl.inFlight[key] <= 0 {
+			delete(fl.inFlight, key)
+		}
+		if failed {
+			fl.spendLocked(key, time.Now())
+		}
+	}
+}
+
+// spendLocked takes one attempt from the bucket of key, creating it at full allowance if needed
+func (fl *FailedAuthLimiter) spendLocked(key string, now time.Time) {
+	limiter, exists := fl.limiters[key]
+	if !exists {
+		fl.makeRoomLocked(now)
+		limiter = rate.NewLimiter(fl.limit, fl.burst)
+		fl.limiters[key] = limiter
+	}
+	limiter.AllowN(now, 1)
+}
+
+// makeRoomLocked keeps the map under maxFailedAuthBuckets before a bucket is added: refilled buckets
+// go first, at most once a second, then arbitrary ones, which only lets those pairs retry sooner
+func (fl *FailedAuthLimiter) makeRoomLocked(now time.Time) {
+	if len(fl.limiters) < maxFailedAuthBuckets {
+		return
+	}
+	if now.Sub(fl.pruned) >= time.Second {
+		fl.pruneLocked(now)
+	}
+	for key := range fl.limiters {
+		if len(fl.limiters) < maxFailedAuthBuckets {
+			return
+		}
+		delete(fl.limiters, key)
+	}
+}
+
+// pruneLocked forgets buckets that have refilled, which are indistinguishable from new ones
+func (fl *FailedAuthLimiter) pruneLocked(now time.Time) {
+	for key, limiter := range fl.limiters {
+		if limiter.TokensAt(now) >= float64(fl.burst) {
+			delete(fl.limiters, key)
+		}
+	}
+	fl.pruned = now
+}
+
+// SetLimits changes the allowance for every client_id+IP, including those already being throttled
+func (fl *FailedAuthLimiter) SetLimits(perMinute int, burst int) {
+	if fl == nil {
+		return
+	}
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.limit = rate.Limit(float64(perMinute) / 60)
+	fl.burst = burst
+	for _, limiter := range fl.limiters {
+		limiter.SetLimit(fl.limit)
+		limiter.SetBurst(burst)
+	}
+}
+
+func (fl *FailedAuthLimiter) cleanup() {
+	for {
+		select {
+		case <-fl.done:
+			return
+		case now := <-fl.ticker.C:
+			fl.mu.Lock()
+			fl.pruneLocked(now)
+			fl.mu.Unlock()
+		}
+	}
+}
+
+// Stop stops the cleanup goroutine
+func (fl *FailedAuthLimiter) Stop() {
+	if fl == nil {
+		return
+	}
+	fl.ticker.Stop()
+	close(fl.done)
+}
+
+// Snapshot returns the client_id+IP buckets that have been spent by failed attempts
+func (fl *FailedAuthLimiter) Snapshot(now time.Time) []middleware.BucketState {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return middleware.DepletedBuckets(fl.limiters, now)
+}
+
+// Restore recreates saved client_id+IP buckets not already being tracked
+func (fl *FailedAuthLimiter) Restore(states []middleware.BucketState, now time.Time) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	for _, state := range states {
+		if _, exists := fl.limiters[state.Key]; exists || len(fl.limiters) >= maxFailedAuthBuckets {
+			continue
+		}
+		limiter := rate.NewLimiter(fl.limit, fl.burst)
+		middleware.RestoreBucket(limiter, state, now)
+		fl.limiters[state.Key] = limiter
+	}
+}
+
+// GrantRateLimiter applies per-client issuance limits that differ by grant type, configured under
+// rate_limiting.grants. Grant types without an entry are not limited here
+type GrantRateLimiter struct {
+	grants map[string]*middleware.RateLimiter
+}
+
+func NewGrantRateLimiter(grants map[string]grant_rate_limit) *GrantRateLimiter {
+	gl := &GrantRateLimiter{grants: make(map[string]*middleware.RateLimiter)}
+	for grant, limit := range grants {
+		if limit.RPS <= 0 {
+			continue
+		}
+		gl.grants[grant] = middleware.NewRateLimiter(limit.RPS, max(limit.Burst, 1))
+	}
+	return gl
+}
+
+// Reserve spends one request for clientID under grant. It returns zero when the request may proceed,
+// otherwise how long the client should wait
+func (gl *GrantRateLimiter) Reserve(grant, clientID string) time.Duration {
+	if gl == nil {
+		return 0
+	}
+	rl, ok := gl.grants[grant]
+	if !ok {
+		return 0
+	}
+	reservation := rl.Limiter(clientID).Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return delay
+	}
+	return 0
+}
+
+// Stop stops the per-grant limiters
+func (gl *GrantRateLimiter) Stop() {
+	if gl == nil {
+		return
+	}
+	for _, rl := range gl.grants {
+		rl.Stop()
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"auth/auth/middleware"
+
+	"github.com/rs/zerolog/log"
+)
+
+// limiterBuckets is a rate limiter whose per-client budgets can be saved and restored
+type limiterBuckets interface {
+	Snapshot(now time.Time) []middleware.BucketState
+	Restore(states []middleware.BucketState, now time.Time)
+}
+
+// rateLimitStore keeps bucket snapshots somewhere that outlives the process and is shared by instances
+type rateLimitStore interface {
+	Save(ctx context.Context, limiter string, states []middleware.BucketState) error
+	Load(ctx context.Context, limiter string) ([]middleware.BucketState, error)
+}
+
+// rateLimitSnapshots periodically saves depleted per-client buckets and restores them at startup, so
+// a restart doesn't hand a client that was being throttled a full burst again. Only buckets below
+// their burst are saved, each kept only until it would have refilled
+type rateLimitSnapshots struct {
+	store    rateLimitStore
+	interval time.Duration
+
+	mu       sync.Mutex
+	limiters map[string]limiterBuckets
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// newRateLimitSnapshots returns nil unless rate_limiting.persistence is enabled with a usable Redis
+func newRateLimitSnapshots(cfg rate_limit_persistence, redisCfg redis_config) *rateLimitSnapshots {
+	if !cfg.Enabled {
+		return nil
+	}
+	client, err := newRedisClient(redisCfg)
+	if err != nil {
+		log.Error().Err(err).Msg("rate limit persistence disabled")
+		return nil
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "auth:ratelimit:"
+	}
+	return newRateLimitSnapshotsWithStore(&redisRateLimitStore{client: client, prefix: cfg.KeyPrefix}, cfg)
+}
+
+func newRateLimitSnapshotsWithStore(store rateLimitStore, cfg rate_limit_persistence) *rateLimitSnapshots {
+	if cfg.SnapshotIntervalSeconds <= 0 {
+		cfg.SnapshotIntervalSeconds = 5
+	}
+	return &rateLimitSnapshots{
+		store:    store,
+		interval: time.Duration(cfg.SnapshotIntervalSeconds) * time.Second,
+		limiters: make(map[string]limiterBuckets),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Register adds a limiter under a name that must stay the same across restarts
+func (rs *rateLimitSnapshots) Register(name string, limiter limiterBuckets) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.limiters[name] = limiter
+}
+
+// Restore loads saved buckets into every registered limiter. A store that can't be reached only
+// costs the budgets; the server still starts
+func (rs *rateLimitSnapshots) Restore() {
+	if rs == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := time.Now()
+	for name, limiter := range rs.limiters {
+		states, err := rs.store.Load(ctx, name)
+		if err != nil {
+			log.Error().Err(err).Str("limiter", name).Msg("Failed to restore rate limit budgets")
+			continue
+		}
+		limiter.Restore(states, now)
+		if len(states) > 0 {
+			log.Info().Str("limiter", name).Int("clients", len(states)).Msg("Rate limit budgets restored")
+		}
+	}
+}
+
+// Start saves snapshots in the background until Stop is called
+func (rs *rateLimitSnapshots) Start() {
+	if rs == nil {
+		return
+	}
+	go func() {
+		defer close(rs.stopped)
+		ticker := time.NewTicker(rs.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-rs.done:
+				rs.save()
+				return
+			case <-ticker.C:
+				rs.save()
+			}
+		}
+	}()
+}
+
+// Stop takes a last snapshot, so budgets spent since the previous one survive the shutdown
+func (rs *rateLimitSnapshots) Stop() {
+	if rs == nil {
+		return
+	}
+	close(rs.done)
+	<-rs.stopped
+}
+
+func (rs *rateLimitSnapshots) save() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := time.Now()
+	for name, limiter := range rs.limiters {
+		states := limiter.Snapshot(now)
+		if len(states) == 0 {
+			continue
+		}
+		if err := rs.store.Save(ctx, name, states); err != nil {
+			log.Error().Err(err).Str("limiter", name).Int("clients", len(states)).Msg("Failed to save rate limit budgets")
+		}
+	}
+}
+
+// redisRateLimitStore keeps one key per client bucket, <prefix><limiter>:<client>, expiring when the
+// bucket would have refilled so abandoned budgets clean themselves up
+type redisRateLimitStore struct {
+	client *redisClient
+	prefix string
+}
+
+const redisBatchSize = 500
+
+func (s *redisRateLimitStore) Save(ctx context.Context, limiter string, states []middleware.BucketState) error {
+	cmds := make([][]string, 0, min(len(states), redisBatchSize))
+	for i, state := range states {
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		ttl := max(state.FullAt.Sub(state.At).Milliseconds(), 1)
+		cmds = append(cmds, []string{"SET", s.prefix + limiter + ":" + state.Key, string(value), "PX", strconv.FormatInt(ttl, 10)})
+		if len(cmds) == redisBatchSize || i == len(states)-1 {
+			replies, err := s.client.Pipeline(ctx, cmds)
+			if err != nil {
+				return err
+			}
+			for _, reply := range replies {
+				if replyErr, ok := reply.(redisError); ok {
+					return replyErr
+				}
+			}
+			cmds = cmds[:0]
+		}
+	}
+	return nil
+}
+
+func (s *redisRateLimitStore) Load(ctx context.Context, limiter string) ([]middleware.BucketState, error) {
+	keyPrefix := s.prefix + limiter + ":"
+	keys, err := s.client.Scan(ctx, keyPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	var states []middleware.BucketState
+	for start := 0; start < len(keys); start += redisBatchSize {
+		batch := keys[start:min(start+redisBatchSize, len(keys))]
+		reply, err := s.client.Do(ctx, append([]string{"MGET"}, batch...)...)
+		if err != nil {
+			return nil, err
+		}
+		values, _ := reply.([]any)
+		for i, value := range values {
+			raw, err := redisString(value)
+			if err != nil {
+				continue // expired between SCAN and MGET
+			}
+			var state middleware.BucketState
+			if err := json.Unmarshal([]byte(raw), &state); err != nil {
+				log.Warn().Err(err).Str("key", batch[i]).Msg("Skipping unreadable rate limit snapshot")
+				continue
+			}
+			state.Key = strings.TrimPrefix(batch[i], keyPrefix)
+			states = append(states, state)
+		}
+	}
+	return states, nil
+}
+
+// registerRateLimiters adds every per-client limiter to the snapshots, grant limiters by grant type
+func (as *authServer) registerRateLimiters() {
+	if as.rateLimitSnapshots == nil {
+		return
+	}
+	if as.clientLimits != nil {
+		as.rateLimitSnapshots.Register("client", as.clientLimits)
+	}
+	if as.failedAuth != nil {
+		as.rateLimitSnapshots.Register("failed_auth", as.failedAuth)
+	}
+	if as.grantLimits != nil {
+		for grant, limiter := range as.grantLimits.grants {
+			as.rateLimitSnapshots.Register("grant:"+grant, limiter)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisError is an error reply from the server, e.g. WRONGTYPE; the connection stays usable
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// errRedisNil is returned for a null reply, e.g. GET of a missing key
+var errRedisNil = errors.New("redis: nil")
+
+// redisClient speaks just enough RESP2 for the server's own use of Redis: one connection, commands
+// sent one at a time or pipelined, replies decoded into string, int64, nil or []any. A broken
+// connection is dropped and redialed on the next command
+type redisClient struct {
+	cfg redis_config
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func newRedisClient(cfg redis_config) (*redisClient, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("redis.address is required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("redis.address %q is not host:port", cfg.Address)
+	}
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = 2000
+	}
+	return &redisClient{cfg: cfg}, nil
+}
+
+func (rc *redisClient) timeout() time.Duration {
+	return time.Duration(rc.cfg.TimeoutMs) * time.Millisecond
+}
+
+// dialLocked connects and authenticates; callers hold mu
+func (rc *redisClient) dialLocked(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: rc.timeout()}
+	var conn net.Conn
+	var err error
+	if rc.cfg.TLS {
+		host, _, _ := net.SplitHostPort(rc.cfg.Address)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}).DialContext(ctx, "tcp", rc.cfg.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", rc.cfg.Address)
+	}
+	if err != nil {
+		return err
+	}
+	rc.conn = conn
+	rc.rd = bufio.NewReader(conn)
+
+	var setup [][]string
+	if rc.cfg.Password != "" {
+		if rc.cfg.Username != "" {
+			setup = append(setup, []string{"AUTH", rc.cfg.Username, rc.cfg.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", rc.cfg.Password})
+		}
+	}
+	if rc.cfg.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(rc.cfg.DB)})
+	}
+	replies, err := rc.roundTripLocked(ctx, setup)
+	if err == nil {
+		for _, reply := range replies {
+			if replyErr, ok := reply.(redisError); ok {
+				err = replyErr
+				break
+			}
+		}
+	}
+	if err != nil {
+		rc.closeLocked()
+		return err
+	}
+	return nil
+}
+
+func (rc *redisClient) closeLocked() {
+	if rc.conn != nil {
+		rc.conn.Close()
+		rc.conn = nil
+		rc.rd = nil
+	}
+}
+
+// Close drops the connection
+func (rc *redisClient) Close() {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.closeLocked()
+}
+
+// Do sends one command and returns its reply. Error replies are returned as the error
+func (rc *redisClient) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := rc.Pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := replies[0].(redisError); ok {
+		return nil, replyErr
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends every command before reading any reply. Error replies are returned in place as
+// redisError so one failing command doesn't hide the others' results
+func (rc *redisClient) Pipeline(ctx context.Context, cmds [][]string) ([]any, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.conn == nil {
+		if err := rc.dialLocked(ctx); err != nil {
+			return nil, fmt.Errorf("redis: connecting to %s: %w", rc.cfg.Address, err)
+		}
+	}
+	replies, err := rc.roundTripLocked(ctx, cmds)
+	if err != nil {
+		rc.closeLocked()
+		return nil, err
+	}
+	return replies, nil
+}
+
+func (rc *redisClient) roundTripLocked(ctx context.Context, cmds [][]string) ([]any, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	deadline := time.Now().Add(rc.timeout())
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	rc.conn.SetDeadline(deadline)
+
+	writer := bufio.NewWriter(rc.conn)
+	for _, args := range cmds {
+		fmt.Fprintf(writer, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]any, len(cmds))
+	for i := range cmds {
+		reply, err := readRedisReply(rc.rd)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func readRedisReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return redisError(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", payload)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", payload)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, count)
+		for i := range items {
+			if items[i], err = readRedisReply(rd); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
+
+// redisString converts a bulk or simple string reply, with errRedisNil for a null reply
+func redisString(reply any) (string, error) {
+	switch v := reply.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", errRedisNil
+	case redisError:
+		return "", v
+	}
+	return "", fmt.Errorf("redis: unexpected reply %T", reply)
+}
+
+// Scan returns every key matching pattern, following the SCAN cursor until it wraps
+func (rc *redisClient) Scan(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := rc.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		if cursor, err = redisString(page[0]); err != nil {
+			return nil, err
+		}
+		batch, _ := page[1].([]any)
+		for _, key := range batch {
+			if name, err := redisString(key); err == nil {
+				keys = append(keys, name)
+			}
+		}
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Refresh tokens are opaque values of the form rt_<token_id>.<secret>. Like API keys and passwords,
+// only the SHA-256 of the full value is stored, and the raw value is never logged: log token_id instead
+const (
+	refreshTokenPrefix     = "rt_"
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	errRefreshTokenInvalid = errors.New("invalid refresh token")
+	errRefreshTokenUsed    = errors.New("refresh token has already been used")
+	errRefreshTokenRevoked = errors.New("refresh token has been revoked")
+	errRefreshTokenExpired = errors.New("refresh token has expired")
+)
+
+type RefreshToken struct {
+	TokenID       string     `json:"token_id"`
+	TokenHash     string     `json:"-"`
+	ClientID      string     `json:"client_id"`
+	AccessTokenID string     `json:"access_token_id"` // jti of the access token issued alongside
+	FamilyID      string     `json:"family_id"`       // lineage of that access token
+	Scopes        []string   `json:"scopes"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at,omitempty"`
+	Revoked       bool       `json:"revoked"`
+}
+
+// refreshTokenStore persists refresh tokens. Implementations only ever see hashes
+type refreshTokenStore interface {
+	Save(ctx context.Context, token *RefreshToken) error
+	Get(ctx context.Context, tokenID string) (*RefreshToken, error) // sql.ErrNoRows when unknown
+	MarkUsed(ctx context.Context, tokenID string, usedAt time.Time) (bool, error)
+	Revoke(ctx context.Context, tokenID string, revokedAt time.Time) error
+	ListFamily(ctx context.Context, familyID string) ([]*RefreshToken, error)
+	RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time, reason string) (int64, error)
+}
+
+func hashRefreshToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseRefreshToken splits a raw refresh token into its lookup id, reporting false if it isn't one
+func parseRefreshToken(raw string) (string, bool) {
+	rest, ok := strings.CutPrefix(raw, refreshTokenPrefix)
+	if !ok {
+		return "", false
+	}
+	tokenID, secret, ok := strings.Cut(rest, ".")
+	if !ok || tokenID == "" || secret == "" {
+		return "", false
+	}
+	return tokenID, true
+}
+
+// issueRefreshToken stores a new refresh token for accessToken, in the same family, and returns the
+// raw value, which exists only in the response to the client
+func (as *authServer) issueRefreshToken(accessToken *Token, scopes []string) (string, *RefreshToken, error) {
+	clientID := accessToken.ClientID
+	tokenID := generateRandomString(16)
+	raw := refreshTokenPrefix + tokenID + "." + generateRandomString(32)
+	now := time.Now()
+	token := &RefreshToken{
+		TokenID:       tokenID,
+		TokenHash:     hashRefreshToken(raw),
+		ClientID:      clientID,
+		AccessTokenID: accessToken.TokenID,
+		FamilyID:      accessToken.FamilyID,
+		Scopes:        scopes,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(defaultRefreshTokenTTL),
+	}
+
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+	if err := as.refreshTokens.Save(ctx, token); err != nil {
+		log.Error().Err(err).Str("token_id", tokenID).Str("client_id", clientID).Msg("Failed to store refresh token")
+		return "", nil, err
+	}
+	return raw, token, nil
+}
+
+// redeemRefreshToken verifies a raw refresh token presented by clientID and consumes it; each refresh
+// token is single use, so a replayed one is rejected even if it has not expired
+func (as *authServer) redeemRefreshToken(clientID, raw string) (*RefreshToken, error) {
+	tokenID, ok := parseRefreshToken(raw)
+	if !ok {
+		return nil, errRefreshTokenInvalid
+	}
+
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	token, err := as.refreshTokens.Get(ctx, tokenID)
+	if err == sql.ErrNoRows {
+		return nil, errRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRefreshToken(raw)), []byte(token.TokenHash)) != 1 || token.ClientID != clientID {
+		log.Warn().Str("token_id", tokenID).Str("client_id", clientID).Msg("Refresh token presented with wrong secret or client")
+		return nil, errRefreshTokenInvalid
+	}
+	if token.Revoked {
+		return nil, errRefreshTokenRevoked
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, errRefreshTokenExpired
+	}
+
+	// MarkUsed only succeeds for the first of two concurrent redemptions
+	used, err := as.refreshTokens.MarkUsed(ctx, tokenID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		log.Warn().Str("token_id", tokenID).Str("client_id", clientID).Msg("Refresh token replayed")
+		return nil, errRefreshTokenUsed
+	}
+	return token, nil
+}
+
+// dbRefreshTokenStore keeps refresh tokens in the refresh_tokens table
+type dbRefreshTokenStore struct {
+	db *instrumentedDB
+}
+
+func newDBRefreshTokenStore(db *instrumentedDB) *dbRefreshTokenStore {
+	return &dbRefreshTokenStore{db: db}
+}
+
+func (s *dbRefreshTokenStore) Save(ctx context.Context, token *RefreshToken) error {
+	scopes, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO refresh_tokens(token_id, token_hash, client_id, access_token_id, family_id, scopes, created_at, expires_at) VALUES (:1, :2, :3, :4, :5, :6, :7, :8)"
+	_, err = s.db.ExecContext(ctx, query, token.TokenID, token.TokenHash, token.ClientID, token.AccessTokenID, token.FamilyID, string(scopes), token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+func (s *dbRefreshTokenStore) Get(ctx context.Context, tokenID string) (*RefreshToken, error) {
+	query := "SELECT " + refreshTokenColumns + " FROM refresh_tokens WHERE token_id = :1"
+	return scanRefreshToken(s.db.QueryRowContext(ctx, query, tokenID))
+}
+
+const refreshTokenColumns = "token_id, token_hash, client_id, access_token_id, family_id, scopes, created_at, expires_at, used_at, revoked"
+
+func scanRefreshToken(row interface{ Scan(dest ...any) error }) (*RefreshToken, error) {
+	var token RefreshToken
+	var accessTokenID, familyID sql.NullString
+	var scope scopeList
+	var usedAt sql.NullTime
+	var revokedInt int
+
+	if err := row.Scan(&token.TokenID, &token.TokenHash, &token.ClientID, &accessTokenID, &familyID, &scope, &token.CreatedAt, &token.ExpiresAt, &usedAt, &revokedInt); err != nil {
+		return nil, err
+	}
+	token.AccessTokenID = accessTokenID.String
+	token.FamilyID = familyID.String
+	token.Scopes = scope
+	token.Revoked = revokedInt == 1
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return &token, nil
+}
+
+func (s *dbRefreshTokenStore) MarkUsed(ctx context.Context, tokenID string, usedAt time.Time) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = :1 WHERE token_id = :2 AND used_at IS NULL", usedAt, tokenID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *dbRefreshTokenStore) Revoke(ctx context.Context, tokenID string, revokedAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = 1, revoked_at = :1 WHERE token_id = :2", revokedAt, tokenID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *dbRefreshTokenStore) ListFamily(ctx context.Context, familyID string) ([]*RefreshToken, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE family_id = :1 ORDER BY created_at", familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*RefreshToken, 0)
+	for rows.Next() {
+		token, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *dbRefreshTokenStore) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time, reason string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = 1, revoked_at = :1, revocation_reason = :2 WHERE family_id = :3 AND revoked = 0", revokedAt, reason, familyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
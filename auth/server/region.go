@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// regionName guards region names taken from token requests
+var regionName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// regionMetricLabels are constant labels added to every metric once region.name is configured, so
+// dashboards aggregating several data centers can tell instances apart
+func regionMetricLabels() prometheus.Labels {
+	if AppConfig.Region.Name == "" {
+		return nil
+	}
+	labels := prometheus.Labels{"region": AppConfig.Region.Name}
+	if AppConfig.Region.Zone != "" {
+		labels["zone"] = AppConfig.Region.Zone
+	}
+	return labels
+}
+
+// tokenRegions returns the regions a new token may be validated in, from the request's space-separated
+// regions or, with region.restrict_tokens, the issuing region. Nil means the token is valid everywhere
+func tokenRegions(requested string) ([]string, error) {
+	local := AppConfig.Region.Name
+	regions := strings.Fields(requested)
+	if len(regions) == 0 {
+		if AppConfig.Region.RestrictTokens && local != "" {
+			return []string{local}, nil
+		}
+		return nil, nil
+	}
+
+	if local == "" {
+		return nil, fmt.Errorf("region-restricted tokens are not supported by this server")
+	}
+	for _, region := range regions {
+		if !regionName.MatchString(region) {
+			return nil, fmt.Errorf("region %q is not a valid region name", region)
+		}
+		if len(AppConfig.Region.Known) > 0 && region != local && !slices.Contains(AppConfig.Region.Known, region) {
+			return nil, fmt.Errorf("region %q is not a known region", region)
+		}
+	}
+	slices.Sort(regions)
+	return slices.Compact(regions), nil
+}
+
+// validInRegion reports whether a token may be used by this instance. Restricted tokens are refused
+// by instances without a region, which cannot tell where they are
+func (claims *Claims) validInRegion(region string) bool {
+	return len(claims.Regions) == 0 || (region != "" && slices.Contains(claims.Regions, region))
+}
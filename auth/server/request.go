@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits applied to every JSON request body before it reaches a handler's struct
+const (
+	maxRequestBodyBytes = 64 << 10 // no request needs more; larger bodies are rejected unread
+	maxJSONDepth        = 4        // deepest legitimate body is an object holding an array of strings
+	maxRequestScopes    = 100      // scopes named in one admin request
+)
+
+// decodeJSONBody strictly decodes the request body into v: unknown fields, trailing data, excessive
+// nesting and oversized bodies are rejected with an invalid_request error saying exactly what was wrong
+func decodeJSONBody(c *gin.Context, v any) *APIError {
+	body, apiErr := readJSONBody(c)
+	if apiErr != nil {
+		return apiErr
+	}
+	if len(body) == 0 {
+		return ErrBadRequest("Request body is required")
+	}
+	return decodeJSON(body, v)
+}
+
+// decodeOptionalJSONBody is decodeJSONBody for endpoints where every field has a fallback, so an
+// empty body is accepted and leaves v untouched
+func decodeOptionalJSONBody(c *gin.Context, v any) *APIError {
+	body, apiErr := readJSONBody(c)
+	if apiErr != nil || len(body) == 0 {
+		return apiErr
+	}
+	return decodeJSON(body, v)
+}
+
+// decodeTokenRequest reads a token request from a JSON body or, as standard OAuth2 client libraries
+// send it, an application/x-www-form-urlencoded one (RFC 6749 section 4.4.2), and returns the
+// credential source the body's client_id and client_secret count as. Unknown form parameters are
+// ignored as the RFC requires; repeated ones are rejected
+func decodeTokenRequest(c *gin.Context, req *TokenRequest) (string, *APIError) {
+	if c.ContentType() != "application/x-www-form-urlencoded" {
+		return CredentialSourceJSON, decodeJSONBody(c, req)
+	}
+
+	body, apiErr := readJSONBody(c)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	if len(body) == 0 {
+		return "", ErrBadRequest("Request body is required")
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", ErrBadRequest("Invalid form body").WithDetails(err.Error()).WithOriginalError(err)
+	}
+	for name, values := range form {
+		if len(values) > 1 {
+			return "", ErrBadRequest("Invalid form body").WithDetails(fmt.Sprintf("parameter %q is repeated", name))
+		}
+	}
+	*req = TokenRequest{
+		GrantType:    form.Get("grant_type"),
+		ClientID:     form.Get("client_id"),
+		ClientSecret: form.Get("client_secret"),
+		OnBehalfOf:   form.Get("on_behalf_of"),
+		Scope:        form.Get("scope"),
+		Regions:      form.Get("regions"),
+		Audience:     form.Get("audience"),
+
+		ClientAssertionType: form.Get("client_assertion_type"),
+		ClientAssertion:     form.Get("client_assertion"),
+	}
+	return CredentialSourceForm, nil
+}
+
+func readJSONBody(c *gin.Context) ([]byte, *APIError) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, NewAPIError(ErrInvalidRequest, fmt.Sprintf("Request body exceeds %d bytes", maxRequestBodyBytes), http.StatusRequestEntityTooLarge)
+		}
+		return nil, ErrBadRequest("Failed to read request body").WithOriginalError(err)
+	}
+	return bytes.TrimSpace(body), nil
+}
+
+func decodeJSON(body []byte, v any) *APIError {
+	if err := checkJSONDepth(body); err != nil {
+		return ErrBadRequest("Invalid JSON format").WithDetails(err.Error()).WithOriginalError(err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return ErrBadRequest("Invalid JSON format").WithDetails(describeJSONError(err)).WithOriginalError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return ErrBadRequest("Invalid JSON format").WithDetails("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// checkJSONDepth rejects bodies nesting objects or arrays deeper than maxJSONDepth before they are decoded
+func checkJSONDepth(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Syntax errors are reported, with their position, by the real decode
+			return nil
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxJSONDepth {
+				return fmt.Errorf("JSON nesting exceeds %d levels", maxJSONDepth)
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// describeJSONError turns encoding/json errors into messages naming the offending field or position
+func describeJSONError(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Sprintf("body must be %s", jsonTypeName(typeErr.Type.Kind().String()))
+		}
+		return fmt.Sprintf("field %q must be %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind().String()), typeErr.Value)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "JSON body is truncated"
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return "unknown field " + field
+	}
+	return err.Error()
+}
+
+// jsonTypeName names a Go kind the way a JSON client would think of it
+func jsonTypeName(kind string) string {
+	switch kind {
+	case "string":
+		return "a string"
+	case "bool":
+		return "a boolean"
+	case "slice", "array":
+		return "an array"
+	case "struct", "map":
+		return "an object"
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64":
+		return "a number"
+	}
+	return kind
+}
+
+// validateRequestScopes bounds a list of scopes supplied in an admin request
+func validateRequestScopes(field string, scopes []string) error {
+	if len(scopes) > maxRequestScopes {
+		return fmt.Errorf("%s exceeds maximum of %d entries", field, maxRequestScopes)
+	}
+	if _, err := validateScopes(scopes); err != nil {
+		return fmt.Errorf("%s: %v", field, strings.TrimPrefix(err.Error(), "invalid scope list: "))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Validation response fields a resource server can be allowed to see. valid and degraded are always
+// returned, since a caller cannot act on the response without them
+const (
+	IntrospectClaimClientID  = "client_id"
+	IntrospectClaimScopes    = "scopes"
+	IntrospectClaimExpiresAt = "expires_at"
+	IntrospectClaimActor     = "actor"
+	IntrospectClaimTokenID   = "token_id"
+)
+
+// introspectClaims is every claim, which is what anonymous callers see while authentication is optional.
+// The token ID was already sent to them in X-Auth-Token-ID
+var introspectClaims = []string{IntrospectClaimClientID, IntrospectClaimScopes, IntrospectClaimExpiresAt, IntrospectClaimActor, IntrospectClaimTokenID}
+
+// Resource servers authenticate to the validation endpoint with a verified client certificate whose
+// common name is their ID, or with these headers. Authorization is left to the token being validated
+const (
+	resourceServerIDHeader     = "X-Resource-Server-ID"
+	resourceServerSecretHeader = "X-Resource-Server-Secret"
+)
+
+// resourceServers are the callers registered in validation.resource_servers. With
+// validation.require_resource_server_auth only they may validate tokens, and each sees only its claims
+type resourceServers struct {
+	required bool
+	byID     map[string]resource_server
+}
+
+func newResourceServers(cfg validation) *resourceServers {
+	rs := &resourceServers{required: cfg.RequireResourceServerAuth, byID: make(map[string]resource_server, len(cfg.ResourceServers))}
+	for _, server := range cfg.ResourceServers {
+		rs.byID[server.ID] = server
+	}
+	return rs
+}
+
+// authenticate identifies the resource server calling the validation endpoint and returns it with the
+// claims it may see. Anonymous callers get an empty ID while authentication is optional
+func (rs *resourceServers) authenticate(c *gin.Context) (string, []string, *APIError) {
+	if rs == nil {
+		return "", introspectClaims, nil
+	}
+
+	id, secret := c.GetHeader(resourceServerIDHeader), c.GetHeader(resourceServerSecretHeader)
+	mtls := false
+	if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 && len(c.Request.TLS.PeerCertificates) > 0 {
+		certID := c.Request.TLS.PeerCertificates[0].Subject.CommonName
+		if id != "" && id != certID {
+			return "", nil, ErrBadRequest("Resource server ID does not match the client certificate")
+		}
+		id, mtls = certID, true
+	}
+
+	if id == "" {
+		if rs.required {
+			return "", nil, ErrUnauthorizedError("Resource server authentication required").
+				WithDetails(fmt.Sprintf("present a registered client certificate or the %s and %s headers", resourceServerIDHeader, resourceServerSecretHeader))
+		}
+		return "", introspectClaims, nil
+	}
+
+	server, ok := rs.byID[id]
+	if !ok && mtls && !rs.required && secret == "" {
+		// An unregistered certificate, e.g. a client's, is just an anonymous caller here
+		return "", introspectClaims, nil
+	}
+	if !ok || (!mtls && (server.Secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(server.Secret)) != 1)) {
+		return "", nil, ErrUnauthorizedError("Invalid resource server credentials")
+	}
+	return server.ID, server.Claims, nil
+}
+
+// visibleValidationResponse drops the fields of response that claims does not allow
+func visibleValidationResponse(response TokenValidationResponse, claims []string) TokenValidationResponse {
+	visible := TokenValidationResponse{Valid: response.Valid, Degraded: response.Degraded}
+	for _, claim := range claims {
+		switch claim {
+		case IntrospectClaimClientID:
+			visible.ClientID = response.ClientID
+		case IntrospectClaimScopes:
+			visible.Scopes = response.Scopes
+		case IntrospectClaimExpiresAt:
+			visible.ExpiresAt = response.ExpiresAt
+		case IntrospectClaimActor:
+			visible.Actor = response.Actor
+		case IntrospectClaimTokenID:
+			visible.TokenID = response.TokenID
+		}
+	}
+	return visible
+}
+
+// validIntrospectClaim reports whether claim names a validation response field
+func validIntrospectClaim(claim string) bool {
+	return slices.Contains(introspectClaims, claim)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Why a token was revoked. The reason is stored with the token, returned when a revoked token is
+// presented and used as the reason label of tokens_revoked_total
+const (
+	RevocationReasonUserRequested = "user_requested" // the token's holder revoked it through /revoke
+	RevocationReasonAdmin         = "admin"          // an administrator revoked it, e.g. by deleting its client
+	RevocationReasonCompromise    = "compromise"     // the token or its lineage is believed leaked
+	RevocationReasonRotation      = "rotation"       // the holder replaced it with a new token
+	RevocationReasonOTTConsumed   = "ott_consumed"   // a one-time token, revoked by its first validation
+)
+
+// userRevocationReasons are the reasons a token's holder may give on /revoke; the others are set by
+// the server itself or by an administrator
+var userRevocationReasons = []string{RevocationReasonUserRequested, RevocationReasonCompromise, RevocationReasonRotation}
+
+// RevokeTokenRequest is the optional body of /revoke
+type RevokeTokenRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (r *RevokeTokenRequest) Validate() error {
+	if r.Reason != "" && !slices.Contains(userRevocationReasons, r.Reason) {
+		return fmt.Errorf("reason must be one of %v, got %q", userRevocationReasons, r.Reason)
+	}
+	return nil
+}
+
+// RevokeTokenFamilyRequest is the optional body of the admin family revocation
+type RevokeTokenFamilyRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (r *RevokeTokenFamilyRequest) Validate() error {
+	if r.Reason != "" && !slices.Contains([]string{RevocationReasonCompromise, RevocationReasonAdmin, RevocationReasonRotation}, r.Reason) {
+		return fmt.Errorf("reason must be compromise, admin or rotation, got %q", r.Reason)
+	}
+	return nil
+}
+
+// tokenRevokedError is returned by validateJWT for a revoked token, carrying the recorded reason.
+// Tokens revoked before reasons were recorded have none
+type tokenRevokedError struct {
+	Reason string
+}
+
+func (e *tokenRevokedError) Error() string {
+	if e.Reason == "" {
+		return "token has been revoked"
+	}
+	return "token has been revoked: " + e.Reason
+}
+
+// cachedRevocationReason is the reason recorded for a token whose revocation state was just looked up,
+// which leaves it in the token cache
+func (as *authServer) cachedRevocationReason(tokenID string) string {
+	if cached, found := as.tokenCache.Get(tokenID); found && cached != nil {
+		return cached.RevocationReason
+	}
+	return ""
+}
+
+// countRevocations adds tokens newly revoked for reason to tokens_revoked_total
+func (as *authServer) countRevocations(reason string, tokens int) {
+	if as.tokensRevokedCount == nil || tokens <= 0 {
+		return
+	}
+	as.tokensRevokedCount.WithLabelValues(reason).Add(float64(tokens))
+}
@@ -0,0 +1,515 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Revocation import batches are revoked in one transaction with the job's checkpoint, and bound the
+// IN lists, which Oracle caps at 1000 entries
+const (
+	defaultRevocationImportBatchSize = 500
+	maxRevocationImportBatchSize     = 1000
+)
+
+// Revocation import job states. An interrupted job is resumed by uploading the same list again
+const (
+	RevocationImportPending     = "pending"     // created, no list uploaded yet
+	RevocationImportRunning     = "running"     // a list is being uploaded and revoked
+	RevocationImportInterrupted = "interrupted" // the upload stopped early; Processed says where to resume
+	RevocationImportCompleted   = "completed"
+)
+
+// errRevocationImportNotFound is returned for an unknown job ID
+var errRevocationImportNotFound = errors.New("revocation import not found")
+
+// importTokenIDPattern is what a line must look like to be looked up as a token ID
+var importTokenIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,255}$`)
+
+// RevocationImportRequest is the body of the call creating a revocation import
+type RevocationImportRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (r *RevocationImportRequest) Validate() error {
+	if r.Reason != "" && !slices.Contains([]string{RevocationReasonCompromise, RevocationReasonAdmin, RevocationReasonRotation}, r.Reason) {
+		return fmt.Errorf("reason must be compromise, admin or rotation, got %q", r.Reason)
+	}
+	return nil
+}
+
+// RevocationImportJob reports a bulk revocation of uploaded token IDs. Its counters are checkpointed
+// with every batch, so progress survives a restart and a resumed upload skips what was done
+type RevocationImportJob struct {
+	ID             string     `json:"id"`
+	Status         string     `json:"status"`
+	Reason         string     `json:"reason"`
+	Processed      int64      `json:"processed"` // entries read and committed, invalid ones included
+	Revoked        int64      `json:"revoked"`
+	AlreadyRevoked int64      `json:"already_revoked"`
+	NotFound       int64      `json:"not_found"`
+	Invalid        int64      `json:"invalid"` // entries that are not token IDs
+	Batches        int        `json:"batches"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// revocationImports tracks the jobs this instance is running, so one job is never uploaded twice at once
+type revocationImports struct {
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func (ri *revocationImports) begin(jobID string) bool {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	if ri.running[jobID] {
+		return false
+	}
+	if ri.running == nil {
+		ri.running = make(map[string]bool)
+	}
+	ri.running[jobID] = true
+	return true
+}
+
+func (ri *revocationImports) end(jobID string) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	delete(ri.running, jobID)
+}
+
+// tokenIDReader reads token IDs from a newline-delimited list or a CSV export. A header row naming a
+// token_id column selects that column; otherwise the first column is used. Blank lines and lines
+// starting with # are skipped
+type tokenIDReader struct {
+	csv    *csv.Reader
+	column int
+	first  bool
+}
+
+func newTokenIDReader(r io.Reader) *tokenIDReader {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+	return &tokenIDReader{csv: reader, first: true}
+}
+
+// Next returns the next entry, or io.EOF at the end of the list
+func (tr *tokenIDReader) Next() (string, error) {
+	for {
+		record, err := tr.csv.Read()
+		if err != nil {
+			return "", err
+		}
+		if tr.first {
+			tr.first = false
+			if i := slices.IndexFunc(record, func(field string) bool { return strings.EqualFold(strings.TrimSpace(field), "token_id") }); i >= 0 {
+				tr.column = i
+				continue
+			}
+		}
+		if tr.column >= len(record) {
+			return "", nil
+		}
+		return strings.TrimSpace(record[tr.column]), nil
+	}
+}
+
+const revocationImportColumns = "job_id, status, reason, processed, revoked, already_revoked, not_found, invalid, batches, created_at, updated_at, finished_at, last_error"
+
+func (as *authServer) createRevocationImport(ctx context.Context, job *RevocationImportJob) error {
+	query := "INSERT INTO revocation_imports (job_id, status, reason, created_at, updated_at) VALUES (:1, :2, :3, :4, :5)"
+	if _, err := as.db.ExecContext(ctx, query, job.ID, job.Status, job.Reason, job.CreatedAt, job.UpdatedAt); err != nil {
+		return fmt.Errorf("createRevocationImport: %v", err)
+	}
+	return nil
+}
+
+func (as *authServer) revocationImport(ctx context.Context, jobID string) (*RevocationImportJob, error) {
+	job := &RevocationImportJob{}
+	var finishedAt sql.NullTime
+	var lastError sql.NullString
+	query := "SELECT " + revocationImportColumns + " FROM revocation_imports WHERE job_id = :1"
+	err := as.db.QueryRowContext(ctx, query, jobID).Scan(&job.ID, &job.Status, &job.Reason, &job.Processed, &job.Revoked,
+		&job.AlreadyRevoked, &job.NotFound, &job.Invalid, &job.Batches, &job.CreatedAt, &job.UpdatedAt, &finishedAt, &lastError)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errRevocationImportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("revocationImport %s: %v", jobID, err)
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	job.Error = lastError.String
+	return job, nil
+}
+
+// setRevocationImportStatus records job's status and error, and its finish time once completed
+func (as *authServer) setRevocationImportStatus(ctx context.Context, job *RevocationImportJob) error {
+	job.UpdatedAt = time.Now()
+	var finishedAt any
+	if job.FinishedAt != nil {
+		finishedAt = *job.FinishedAt
+	}
+	query := "UPDATE revocation_imports SET status = :1, last_error = :2, updated_at = :3, finished_at = :4 WHERE job_id = :5"
+	if _, err := as.db.ExecContext(ctx, query, job.Status, job.Error, job.UpdatedAt, finishedAt, job.ID); err != nil {
+		return fmt.Errorf("setRevocationImportStatus %s: %v", job.ID, err)
+	}
+	return nil
+}
+
+// revokeImportBatch revokes the active tokens among ids and checkpoints the batch into job in the same
+// transaction, returning the revocations to publish. invalid entries were read but not looked up
+func (as *authServer) revokeImportBatch(ctx context.Context, job *RevocationImportJob, ids []string, invalid int) ([]RevocationEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("revokeImportBatch: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	active := make(map[string]string) // token_id -> client_id of tokens this batch revokes
+	revoked := make(map[string]bool)  // token_id -> already revoked
+	if len(ids) > 0 {
+		args := make([]any, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+
+		rows, err := tx.QueryContext(ctx, "SELECT token_id, client_id, revoked FROM tokens WHERE token_id IN ("+bindList(len(ids), 1)+")", args...)
+		if err != nil {
+			return nil, fmt.Errorf("revokeImportBatch: %v", err)
+		}
+		for rows.Next() {
+			var tokenID, clientID string
+			var revokedInt int
+			if err := rows.Scan(&tokenID, &clientID, &revokedInt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("revokeImportBatch: %v", err)
+			}
+			if revokedInt == 1 {
+				revoked[tokenID] = true
+			} else {
+				active[tokenID] = clientID
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("revokeImportBatch: %v", err)
+		}
+
+		if len(active) > 0 {
+			query := "UPDATE tokens SET revoked = 1, revoked_at = :1, revocation_reason = :2 WHERE revoked = 0 AND token_id IN (" + bindList(len(ids), 3) + ")"
+			if _, err := tx.ExecContext(ctx, query, append([]any{now, job.Reason}, args...)...); err != nil {
+				return nil, fmt.Errorf("revokeImportBatch: %v", err)
+			}
+		}
+	}
+
+	batch := RevocationImportJob{Processed: int64(len(ids) + invalid), Invalid: int64(invalid)}
+	events := make([]RevocationEvent, 0, len(active))
+	for _, id := range ids {
+		switch clientID, ok := active[id]; {
+		case ok:
+			batch.Revoked++
+			events = append(events, RevocationEvent{TokenID: id, ClientID: clientID, RevokedAt: now, Reason: job.Reason})
+			// A repeat of the ID later in the batch finds it revoked
+			delete(active, id)
+			revoked[id] = true
+		case revoked[id]:
+			batch.AlreadyRevoked++
+		default:
+			batch.NotFound++
+		}
+	}
+
+	query := `UPDATE revocation_imports SET processed = processed + :1, revoked = revoked + :2, already_revoked = already_revoked + :3,
+		not_found = not_found + :4, invalid = invalid + :5, batches = batches + 1, updated_at = :6 WHERE job_id = :7`
+	if _, err := tx.ExecContext(ctx, query, batch.Processed, batch.Revoked, batch.AlreadyRevoked, batch.NotFound, batch.Invalid, now, job.ID); err != nil {
+		return nil, fmt.Errorf("revokeImportBatch: checkpoint: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("revokeImportBatch: %v", err)
+	}
+
+	job.Processed += batch.Processed
+	job.Revoked += batch.Revoked
+	job.AlreadyRevoked += batch.AlreadyRevoked
+	job.NotFound += batch.NotFound
+	job.Invalid += batch.Invalid
+	job.Batches++
+	job.UpdatedAt = now
+	return events, nil
+}
+
+// bindList returns n bind placeholders for an IN list, numbered from first
+func bindList(n, first int) string {
+	placeholders := make([]string, n)
+	for i := range placeholders {
+		placeholders[i] = ":" + strconv.Itoa(first+i)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// runRevocationImport revokes the token IDs read from list in batches of batchSize, skipping the
+// entries job has already processed
+func (as *authServer) runRevocationImport(ctx context.Context, job *RevocationImportJob, list io.Reader, batchSize int) error {
+	// Tokens still queued for the batch writer would otherwise be missed, then inserted unrevoked
+	if err := as.tokenBatcher.FlushNow(); err != nil {
+		return fmt.Errorf("writing pending tokens: %v", err)
+	}
+
+	reader := newTokenIDReader(list)
+	skip := job.Processed
+	ids := make([]string, 0, batchSize)
+	invalid := 0
+	flush := func() error {
+		if len(ids)+invalid == 0 {
+			return nil
+		}
+		events, err := as.revokeImportBatch(ctx, job, ids, invalid)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			as.applyRevocation(event)
+			as.notifyRevocationPeers(event)
+			as.webhooks.Notify(event.ClientID, WebhookEventTokenRevoked, event)
+		}
+		as.countRevocations(job.Reason, len(events))
+		log.Info().Str("job_id", job.ID).Int64("processed", job.Processed).Int64("revoked", job.Revoked).Msg("Revocation import batch committed")
+		ids, invalid = ids[:0], 0
+		return nil
+	}
+
+	for {
+		id, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading token IDs after entry %d: %v", job.Processed+int64(len(ids)+invalid), err)
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if importTokenIDPattern.MatchString(id) {
+			ids = append(ids, id)
+		} else {
+			invalid++
+		}
+		if len(ids)+invalid >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// Revocation import create handler (admin): starts a job whose token ID list is uploaded separately,
+// so the job ID is known before the upload and can be used to resume it
+func (as *authServer) createRevocationImportHandler(c *gin.Context) {
+	var req RevocationImportRequest
+	if apiErr := decodeOptionalJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = RevocationReasonCompromise
+	}
+
+	now := time.Now()
+	job := &RevocationImportJob{ID: generateRandomString(8), Status: RevocationImportPending, Reason: req.Reason, CreatedAt: now, UpdatedAt: now}
+	if err := as.createRevocationImport(c.Request.Context(), job); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusCreated, job)
+}
+
+// Revocation import status handler (admin): progress of a job, from any instance
+func (as *authServer) revocationImportStatusHandler(c *gin.Context) {
+	job, err := as.revocationImport(c.Request.Context(), c.Param("job_id"))
+	if errors.Is(err, errRevocationImportNotFound) {
+		RespondWithError(c, ErrNotFoundError("Revocation import not found"))
+		return
+	}
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// Revocation import upload handler (admin): streams a CSV or newline-delimited list of token IDs and
+// revokes them in batches (?batch_size, default 500) as it is read. Re-uploading the same list to an
+// interrupted job resumes after its last committed batch
+func (as *authServer) uploadRevocationImportHandler(c *gin.Context) {
+	logger := GetRequestLogger(c)
+	batchSize := defaultRevocationImportBatchSize
+	if v := c.Query("batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRevocationImportBatchSize {
+			RespondWithError(c, ErrBadRequest(fmt.Sprintf("batch_size must be between 1 and %d", maxRevocationImportBatchSize)))
+			return
+		}
+		batchSize = n
+	}
+
+	jobID := c.Param("job_id")
+	if !as.revocationImports.begin(jobID) {
+		RespondWithError(c, ErrConflictError("The revocation import is already running"))
+		return
+	}
+	defer as.revocationImports.end(jobID)
+
+	job, err := as.revocationImport(c.Request.Context(), jobID)
+	if errors.Is(err, errRevocationImportNotFound) {
+		RespondWithError(c, ErrNotFoundError("Revocation import not found"))
+		return
+	}
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+	if job.Status == RevocationImportCompleted {
+		RespondWithError(c, ErrConflictError("The revocation import has already completed"))
+		return
+	}
+
+	// Status updates outlive the request, so a dropped upload is still recorded as interrupted
+	statusCtx := context.WithoutCancel(c.Request.Context())
+	job.Status, job.Error = RevocationImportRunning, ""
+	if err := as.setRevocationImportStatus(statusCtx, job); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+	logger.Info().Str("job_id", job.ID).Int64("resume_after", job.Processed).Int("batch_size", batchSize).Msg("Revocation import started")
+
+	runErr := as.runRevocationImport(c.Request.Context(), job, c.Request.Body, batchSize)
+	if runErr != nil {
+		job.Status, job.Error = RevocationImportInterrupted, runErr.Error()
+	} else {
+		now := time.Now()
+		job.Status, job.FinishedAt = RevocationImportCompleted, &now
+	}
+	if err := as.setRevocationImportStatus(statusCtx, job); err != nil {
+		logger.Error().Err(err).Str("job_id", job.ID).Msg("Failed to record revocation import status")
+	}
+	logger.Info().Str("job_id", job.ID).Str("status", job.Status).Int64("processed", job.Processed).Int64("revoked", job.Revoked).
+		Int64("already_revoked", job.AlreadyRevoked).Int64("not_found", job.NotFound).Int64("invalid", job.Invalid).Msg("Revocation import finished")
+
+	if runErr != nil {
+		RespondWithError(c, ErrInternalServerError("The revocation import was interrupted; upload the same list again to resume").
+			WithDetails(fmt.Sprintf("job %s stopped after %d entries: %v", job.ID, job.Processed, runErr)).WithOriginalError(runErr))
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// ImportRevocations revokes the token IDs listed in path through a running server's admin API, as
+// `auth tokens import-revocations` does. An empty jobID starts a new job; a job ID printed by an
+// earlier run resumes it. Progress is printed to out while the list is uploaded
+func ImportRevocations(adminURL, path, jobID, reason string, batchSize int, out io.Writer) error {
+	if adminURL == "" {
+		adminURL = adminListenAddress()
+		if strings.HasPrefix(adminURL, ":") {
+			adminURL = "localhost" + adminURL
+		}
+		adminURL = "http://" + adminURL
+	}
+	base := strings.TrimSuffix(adminURL, "/") + "/auth-server/v1/admin/tokens/revocation-imports"
+	client := &http.Client{}
+	call := func(method, url, contentType string, body io.Reader) (*RevocationImportJob, error) {
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Admin-Token", AppConfig.Admin.Token)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			var apiErr APIError
+			json.NewDecoder(resp.Body).Decode(&apiErr)
+			return nil, fmt.Errorf("%s: %s %s", resp.Status, apiErr.Message, apiErr.Details)
+		}
+		job := &RevocationImportJob{}
+		return job, json.NewDecoder(resp.Body).Decode(job)
+	}
+
+	list, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer list.Close()
+
+	if jobID == "" {
+		job, err := call(http.MethodPost, base, "application/json", strings.NewReader(fmt.Sprintf(`{"reason":%q}`, reason)))
+		if err != nil {
+			return fmt.Errorf("creating revocation import: %w", err)
+		}
+		jobID = job.ID
+		fmt.Fprintf(out, "revocation import %s created; if it is interrupted, resume it with --job %s\n", jobID, jobID)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if job, err := call(http.MethodGet, base+"/"+jobID, "application/json", nil); err == nil {
+					fmt.Fprintf(out, "%s: %d processed, %d revoked\n", job.Status, job.Processed, job.Revoked)
+				}
+			}
+		}
+	}()
+
+	job, err := call(http.MethodPut, fmt.Sprintf("%s/%s/ids?batch_size=%d", base, jobID, batchSize), "text/csv", list)
+	if err != nil {
+		return fmt.Errorf("revocation import %s: %w; resume it with --job %s", jobID, err, jobID)
+	}
+	fmt.Fprintf(out, "revocation import %s %s: %d processed, %d revoked, %d already revoked, %d not found, %d invalid\n",
+		job.ID, job.Status, job.Processed, job.Revoked, job.AlreadyRevoked, job.NotFound, job.Invalid)
+	return nil
+}
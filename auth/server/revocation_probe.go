@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Revocation probe outcomes, the result label of revocation_probe_runs_total
+const (
+	ProbeResultOK            = "ok"
+	ProbeResultSetupFailed   = "setup_failed"   // canary could not be issued, accepted everywhere or revoked
+	ProbeResultNotPropagated = "not_propagated" // some instance still accepted the canary at the deadline
+)
+
+// revocationProbe measures how long a revocation takes to reach every instance: each run issues a
+// canary token, waits until /validate accepts it everywhere, revokes it here and times how long each
+// instance keeps accepting it. The per-instance latency is the revocation propagation SLI
+type revocationProbe struct {
+	as        *authServer
+	cfg       revocation_probe
+	client    *http.Client
+	done      chan struct{}
+	latency   *prometheus.HistogramVec // revocation_propagation_seconds by instance
+	runsCount *prometheus.CounterVec   // revocation_probe_runs_total by result
+}
+
+func newRevocationProbe(as *authServer, cfg revocation_probe) *revocationProbe {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.ClientID == "" {
+		log.Error().Msg("revocation_probe.client_id is required, revocation probe disabled")
+		return nil
+	}
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 60
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 30
+	}
+	if cfg.PollIntervalMs <= 0 {
+		cfg.PollIntervalMs = 50
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodGet
+	}
+	if len(cfg.Instances) == 0 {
+		cfg.Instances = []string{"http://localhost:" + AppConfig.ServerPort}
+	}
+	return &revocationProbe{
+		as:     as,
+		cfg:    cfg,
+		client: &http.Client{Timeout: 5 * time.Second},
+		done:   make(chan struct{}),
+	}
+}
+
+// Start runs the probe in the background until Stop is called. Metrics must be registered first
+func (rp *revocationProbe) Start(latency *prometheus.HistogramVec, runsCount *prometheus.CounterVec) {
+	if rp == nil {
+		return
+	}
+	rp.latency = latency
+	rp.runsCount = runsCount
+	go func() {
+		ticker := time.NewTicker(time.Duration(rp.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-rp.done:
+				return
+			case <-ticker.C:
+				rp.Run()
+			}
+		}
+	}()
+}
+
+// Stop stops the background probe
+func (rp *revocationProbe) Stop() {
+	if rp == nil {
+		return
+	}
+	close(rp.done)
+}
+
+// Run performs one probe and returns how long each instance took to reject the revoked canary;
+// instances that never did are missing from the result
+func (rp *revocationProbe) Run() (map[string]time.Duration, error) {
+	ctx, cancel := context.WithTimeout(rp.as.ctx, 2*time.Duration(rp.cfg.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	propagated, result, err := rp.run(ctx)
+	if rp.runsCount != nil {
+		rp.runsCount.WithLabelValues(result).Inc()
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("result", result).Msg("Revocation propagation probe failed")
+	}
+	return propagated, err
+}
+
+func (rp *revocationProbe) run(ctx context.Context) (map[string]time.Duration, string, error) {
+	client, err := rp.as.clientByID(ctx, rp.cfg.ClientID)
+	if err != nil {
+		return nil, ProbeResultSetupFailed, fmt.Errorf("loading canary client %s: %w", rp.cfg.ClientID, err)
+	}
+	tokenString, token, err := rp.as.generateJWT(client, "N")
+	if err != nil {
+		return nil, ProbeResultSetupFailed, fmt.Errorf("issuing canary token: %w", err)
+	}
+	// Other instances read the canary's revocation state from the database, so it must be written first
+	if err := rp.as.tokenBatcher.FlushNow(); err != nil {
+		return nil, ProbeResultSetupFailed, fmt.Errorf("writing canary token: %w", err)
+	}
+
+	deadline := time.Now().Add(time.Duration(rp.cfg.TimeoutSeconds) * time.Second)
+	for _, instance := range rp.cfg.Instances {
+		if !rp.waitFor(ctx, instance, tokenString, http.StatusOK, deadline) {
+			return nil, ProbeResultSetupFailed, fmt.Errorf("canary token was never accepted by %s", instance)
+		}
+	}
+
+	revokedAt := time.Now()
+	if err := rp.as.revokeToken(ctx, RevokedToken{ClientID: client.ClientID, TokenID: token.TokenID, RevokedAt: revokedAt, Reason: RevocationReasonUserRequested}); err != nil {
+		return nil, ProbeResultSetupFailed, fmt.Errorf("revoking canary token: %w", err)
+	}
+
+	deadline = revokedAt.Add(time.Duration(rp.cfg.TimeoutSeconds) * time.Second)
+	propagated := make(map[string]time.Duration, len(rp.cfg.Instances))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, instance := range rp.cfg.Instances {
+		wg.Add(1)
+		go func(instance string) {
+			defer wg.Done()
+			if rp.waitFor(ctx, instance, tokenString, http.StatusUnauthorized, deadline) {
+				elapsed := time.Since(revokedAt)
+				if rp.latency != nil {
+					rp.latency.WithLabelValues(instance).Observe(elapsed.Seconds())
+				}
+				mu.Lock()
+				propagated[instance] = elapsed
+				mu.Unlock()
+			}
+		}(instance)
+	}
+	wg.Wait()
+
+	if len(propagated) < len(rp.cfg.Instances) {
+		var pending []string
+		for _, instance := range rp.cfg.Instances {
+			if _, ok := propagated[instance]; !ok {
+				pending = append(pending, instance)
+			}
+		}
+		return propagated, ProbeResultNotPropagated, fmt.Errorf("not propagated within %ds to %s", rp.cfg.TimeoutSeconds, strings.Join(pending, ", "))
+	}
+	return propagated, ProbeResultOK, nil
+}
+
+// waitFor polls instance's /validate with the canary until it answers with status or the deadline passes
+func (rp *revocationProbe) waitFor(ctx context.Context, instance, tokenString string, status int, deadline time.Time) bool {
+	poll := time.Duration(rp.cfg.PollIntervalMs) * time.Millisecond
+	for {
+		if rp.validateStatus(ctx, instance, tokenString) == status {
+			return true
+		}
+		if time.Now().Add(poll).After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(poll):
+		}
+	}
+}
+
+// validateStatus returns the HTTP status instance's /validate gives the canary, or 0 if it was unreachable
+func (rp *revocationProbe) validateStatus(ctx context.Context, instance, tokenString string) int {
+	body, _ := json.Marshal(TokenValidationRequest{Token: tokenString, Resource: rp.cfg.ResourceURL, Method: rp.cfg.Method})
+	url := strings.TrimSuffix(instance, "/") + "/auth-server/v1/oauth/validate"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		log.Debug().Err(err).Str("instance", instance).Msg("Revocation probe could not reach instance")
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles that open the admin API to a client's access tokens while admin.allow_role_tokens is on.
+// Clients may carry other roles; they are passed through in the roles claim for resource servers
+const (
+	RoleAdmin         = "admin"      // every admin route
+	RoleAdminReadOnly = "admin:read" // admin routes that only read: GET and HEAD
+)
+
+// adminEnabled reports whether any admin credential is configured; until one is the admin API is off
+func adminEnabled() bool {
+	return AppConfig.Admin.Token != "" || AppConfig.Admin.AllowRoleTokens
+}
+
+// adminRoleAllows reports whether roles grant a request with method to an admin route
+func adminRoleAllows(roles []string, method string) bool {
+	if slices.Contains(roles, RoleAdmin) {
+		return true
+	}
+	readOnly := method == http.MethodGet || method == http.MethodHead
+	return readOnly && slices.Contains(roles, RoleAdminReadOnly)
+}
+
+// authenticateAdminToken admits a request to an admin route on an access token carrying an admin
+// role. DPoP-bound tokens need a proof for the admin request itself
+func (as *authServer) authenticateAdminToken(c *gin.Context, authHeader string) *APIError {
+	claims, apiErr := as.authenticateHeaderValue(c.Request.Context(), authHeader)
+	if apiErr != nil {
+		return apiErr
+	}
+	scheme, accessToken := authorizationToken(authHeader)
+	if apiErr := as.checkDPoPBinding(c, claims, scheme, accessToken, c.Request.Method, issuerURL(c)+c.Request.URL.Path); apiErr != nil {
+		return apiErr
+	}
+	if !adminRoleAllows(claims.Roles, c.Request.Method) {
+		return ErrForbiddenError("Token does not carry a role allowed on this admin route")
+	}
+	c.Set("admin_client_id", claims.ClientID)
+	return nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+func routes(r *gin.Engine, s *authServer) {
+	r.GET(metadataPath, metadataHandler)
+	r.GET(jwksPath, s.jwksHandler)
+	r.GET("/healthz", healthzHandler)
+	r.GET("/readyz", s.readyzHandler)
+	r.NoRoute(func(c *gin.Context) {
+		RespondWithError(c, ErrNotFoundError("No such route"))
+	})
+	service := r.Group("auth-server")
+	api := service.Group("/v1")
+	api.GET("/openapi.json", openAPIHandler)
+	dev := api.Group("/dev")
+	dev.GET("/inspector", s.tokenInspectorPageHandler)
+	dev.POST("/inspect", s.tokenInspectHandler)
+	v1 := api.Group("/oauth")
+	v1.POST("/token", s.tokenHandler)
+	v1.POST("/ott", s.ottHandler)
+	v1.POST("/validate", s.validateHandler)
+	v1.POST("/revoke", s.revokeHandler)
+	v1.POST("/webhooks", s.createWebhookSubscriptionHandler)
+	v1.GET("/webhooks", s.listClientWebhookSubscriptionsHandler)
+	v1.DELETE("/webhooks/:subscription_id", s.deleteWebhookSubscriptionHandler)
+	v1.GET("/", func(c *gin.Context) {
+		c.Header("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload") // HSTS
+		c.String(http.StatusOK, "ok")
+	})
+
+}
+
+// adminRoutes registers the internal surface: admin API, health, pprof and metrics. It is served
+// only by the admin listener so none of it is reachable through the public OAuth listener
+func adminRoutes(r *gin.Engine, s *authServer, metrics http.Handler) {
+	service := r.Group("auth-server")
+	service.GET("/health", s.healthHandler)
+	service.GET("/health/detail", s.AdminAuthMiddleware(), s.healthDetailHandler)
+	if metrics != nil {
+		service.GET("/metrics", gin.WrapH(metrics))
+	}
+	dashboard := dashboardHandler()
+	service.GET("/admin", dashboard)
+	service.GET("/admin/*filepath", dashboard)
+
+	admin := service.Group("/v1/admin", s.AdminAuthMiddleware())
+	admin.POST("/api-keys", s.createAPIKeyHandler)
+	admin.GET("/api-keys", s.listAPIKeysHandler)
+	admin.DELETE("/api-keys/:key_id", s.revokeAPIKeyHandler)
+	admin.GET("/endpoint-rules", s.listEndpointRulesHandler)
+	admin.GET("/endpoint-rules/resolve", s.resolveEndpointRuleHandler)
+	admin.POST("/endpoint-rules/reload", s.reloadEndpointRulesHandler)
+	admin.GET("/clients", s.listClientsHandler)
+	admin.POST("/clients", s.createClientHandler)
+	admin.GET("/clients/:client_id", s.getClientHandler)
+	admin.PUT("/clients/:client_id", s.updateClientHandler)
+	admin.DELETE("/clients/:client_id", s.deleteClientHandler)
+	admin.POST("/clients/:client_id/secret", s.regenerateClientSecretHandler)
+	admin.GET("/clients/deleted", s.listDeletedClientsHandler)
+	admin.POST("/clients/:client_id/restore", s.restoreClientHandler)
+	admin.GET("/clients/:client_id/activity", s.clientActivityHandler)
+	admin.GET("/usage", s.usageReportHandler)
+	admin.GET("/usage/rolling", s.rollingUsageHandler)
+	admin.POST("/revocations", s.revocationEventHandler)
+	admin.GET("/endpoints", s.listEndpointsHandler)
+	admin.POST("/endpoints", s.createEndpointHandler)
+	admin.GET("/endpoints/:id", s.getEndpointHandler)
+	admin.PUT("/endpoints/:id", s.updateEndpointHandler)
+	admin.DELETE("/endpoints/:id", s.deleteEndpointHandler)
+	admin.GET("/tokens", s.listRecentTokensHandler)
+	admin.POST("/tokens/purge", s.purgeTokensHandler)
+	admin.GET("/tokens/purge", s.purgeTokensStatusHandler)
+	admin.POST("/tokens/revocation-imports", s.createRevocationImportHandler)
+	admin.GET("/tokens/revocation-imports/:job_id", s.revocationImportStatusHandler)
+	admin.PUT("/tokens/revocation-imports/:job_id/ids", s.uploadRevocationImportHandler)
+	admin.GET("/tokens/:token_id/family", s.tokenFamilyHandler)
+	admin.POST("/tokens/:token_id/family/revoke", s.revokeTokenFamilyHandler)
+	admin.GET("/signing-keys", s.listSigningKeysHandler)
+	admin.POST("/signing-keys", s.createSigningKeyHandler)
+	admin.POST("/signing-keys/:kid/activate", s.activateSigningKeyHandler)
+	admin.POST("/signing-keys/:kid/retire", s.retireSigningKeyHandler)
+	admin.GET("/webhooks", s.listWebhookSubscriptionsHandler)
+	admin.POST("/webhooks/:subscription_id/approve", s.approveWebhookSubscriptionHandler)
+	admin.POST("/webhooks/:subscription_id/disable", s.disableWebhookSubscriptionHandler)
+	admin.GET("/client-groups", s.listClientGroupsHandler)
+	admin.POST("/client-groups", s.createClientGroupHandler)
+	admin.PUT("/client-groups/:group_id/scopes", s.updateClientGroupScopesHandler)
+	admin.POST("/client-groups/:group_id/members", s.addClientGroupMemberHandler)
+	admin.DELETE("/client-groups/:group_id/members/:client_id", s.removeClientGroupMemberHandler)
+
+	debug := r.Group("/debug/pprof", s.AdminAuthMiddleware())
+	debug.GET("/", gin.WrapF(pprof.Index))
+	debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/profile", gin.WrapF(pprof.Profile))
+	debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/trace", gin.WrapF(pprof.Trace))
+	debug.GET("/:profile", func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})
+}
+
+// newAdminRouter builds the internal listener's router. Unlike the public router it has no CORS or
+// rate limiting; access is restricted by network and, for admin and pprof routes, the admin token
+func newAdminRouter(s *authServer, metrics http.Handler) *gin.Engine {
+	router := gin.New()
+	router.Use(
+		LoggingMiddleware(),     // Log all requests
+		HTTPMetricsMiddleware(), // Count and time requests per route
+		AdminNetworkMiddleware(AppConfig.Admin.AllowedNetworks), // Reject callers outside internal networks
+		RecoveryMiddleware(), // Handle panics
+	)
+	adminRoutes(router, s, metrics)
+	return router
+}
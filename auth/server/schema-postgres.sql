@@ -0,0 +1,194 @@
+-- PostgreSQL schema, for database.driver postgres. "auth db init" runs these statements
+
+CREATE TABLE clients (
+    client_id VARCHAR(100) PRIMARY KEY,
+    client_secret VARCHAR(255) NOT NULL,
+    client_name VARCHAR(255),
+    access_token_ttl INTEGER DEFAULT 3600,
+    allowed_scopes TEXT,
+    default_scopes TEXT,
+    jwt_headers TEXT,
+    allowed_audiences TEXT,
+    public_key TEXT,
+    token_format VARCHAR(10) DEFAULT 'jwt' CHECK (token_format IN ('jwt', 'opaque')),
+    roles TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    active SMALLINT DEFAULT 1 CHECK (active IN (0, 1)),
+    deleted_at TIMESTAMP
+);
+
+CREATE TABLE tokens (
+    token_id VARCHAR(255) PRIMARY KEY,
+    token_type VARCHAR(20) NOT NULL,
+    jwt_token VARCHAR(2000) NOT NULL,
+    client_id VARCHAR(100) NOT NULL,
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked SMALLINT DEFAULT 0 CHECK (revoked IN (0, 1)),
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR(20),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    family_id VARCHAR(255),
+    CONSTRAINT fk_tokens_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+CREATE TABLE endpoints (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    client_id VARCHAR(100) NOT NULL,
+    scope VARCHAR(255) NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    endpoint_url VARCHAR(500) NOT NULL,
+    required_scopes TEXT,
+    scope_mode VARCHAR(3) DEFAULT 'ANY' CHECK (scope_mode IN ('ANY', 'ALL')),
+    description VARCHAR(500) DEFAULT '',
+    active SMALLINT DEFAULT 1 CHECK (active IN (0, 1)),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_endpoints_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+CREATE TABLE endpoint_rules (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    pattern VARCHAR(1000) NOT NULL,
+    scope VARCHAR(255) NOT NULL,
+    method VARCHAR(10) DEFAULT '*' NOT NULL,
+    priority INTEGER DEFAULT 100 NOT NULL,
+    description VARCHAR(500),
+    active SMALLINT DEFAULT 1 CHECK (active IN (0, 1)),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE scope_hierarchy (
+    parent_scope VARCHAR(255) NOT NULL,
+    child_scope VARCHAR(255) NOT NULL,
+    CONSTRAINT pk_scope_hierarchy PRIMARY KEY (parent_scope, child_scope)
+);
+
+CREATE TABLE delegation_policies (
+    actor_client_id VARCHAR(100) NOT NULL,
+    subject_client_id VARCHAR(100) NOT NULL,
+    mode VARCHAR(20) DEFAULT 'delegation' CHECK (mode IN ('delegation', 'impersonation')),
+    allowed_scopes TEXT,
+    active SMALLINT DEFAULT 1 CHECK (active IN (0, 1)),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT pk_delegation_policies PRIMARY KEY (actor_client_id, subject_client_id),
+    CONSTRAINT fk_delegation_actor FOREIGN KEY (actor_client_id) REFERENCES clients(client_id) ON DELETE CASCADE,
+    CONSTRAINT fk_delegation_subject FOREIGN KEY (subject_client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+CREATE TABLE api_keys (
+    key_id VARCHAR(32) PRIMARY KEY,
+    key_hash VARCHAR(64) NOT NULL,
+    client_id VARCHAR(100) NOT NULL,
+    name VARCHAR(255),
+    scopes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked SMALLINT DEFAULT 0 CHECK (revoked IN (0, 1)),
+    revoked_at TIMESTAMP,
+    CONSTRAINT fk_api_keys_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+CREATE TABLE refresh_tokens (
+    token_id VARCHAR(32) PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL,
+    client_id VARCHAR(100) NOT NULL,
+    access_token_id VARCHAR(255),
+    family_id VARCHAR(255),
+    scopes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked SMALLINT DEFAULT 0 CHECK (revoked IN (0, 1)),
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR(20),
+    CONSTRAINT fk_refresh_tokens_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+CREATE TABLE client_groups (
+    group_id VARCHAR(100) PRIMARY KEY,
+    name VARCHAR(255),
+    allowed_scopes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE client_group_members (
+    group_id VARCHAR(100) NOT NULL,
+    client_id VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT pk_client_group_members PRIMARY KEY (group_id, client_id),
+    CONSTRAINT fk_group_members_group FOREIGN KEY (group_id) REFERENCES client_groups(group_id) ON DELETE CASCADE,
+    CONSTRAINT fk_group_members_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+CREATE TABLE client_usage_daily (
+    usage_date DATE NOT NULL,
+    client_id VARCHAR(100) NOT NULL,
+    tokens_issued BIGINT DEFAULT 0,
+    validations BIGINT DEFAULT 0,
+    denials BIGINT DEFAULT 0,
+    revocations BIGINT DEFAULT 0,
+    CONSTRAINT pk_client_usage_daily PRIMARY KEY (usage_date, client_id)
+);
+
+CREATE TABLE signing_keys (
+    kid VARCHAR(32) PRIMARY KEY,
+    algorithm VARCHAR(10) NOT NULL,
+    key_material VARCHAR(512) NOT NULL,
+    status VARCHAR(10) DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'inactive', 'retired')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    activated_at TIMESTAMP,
+    retired_at TIMESTAMP
+);
+
+CREATE TABLE webhook_subscriptions (
+    subscription_id VARCHAR(32) PRIMARY KEY,
+    client_id VARCHAR(100) NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    events TEXT,
+    secret VARCHAR(256) NOT NULL,
+    status VARCHAR(10) DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'disabled')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    approved_at TIMESTAMP,
+    expiry_scanned_until TIMESTAMP,
+    CONSTRAINT fk_webhook_subscriptions_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+CREATE TABLE revocation_imports (
+    job_id VARCHAR(32) PRIMARY KEY,
+    status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'interrupted', 'completed')),
+    reason VARCHAR(20) NOT NULL,
+    processed BIGINT DEFAULT 0,
+    revoked BIGINT DEFAULT 0,
+    already_revoked BIGINT DEFAULT 0,
+    not_found BIGINT DEFAULT 0,
+    invalid BIGINT DEFAULT 0,
+    batches INTEGER DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    last_error VARCHAR(4000)
+);
+
+CREATE INDEX idx_tokens_client_id ON tokens(client_id);
+
+CREATE INDEX idx_tokens_expires_at ON tokens(expires_at);
+
+CREATE INDEX idx_tokens_revoked ON tokens(revoked);
+
+CREATE INDEX idx_endpoints_client_id ON endpoints(client_id);
+
+CREATE INDEX idx_endpoints_endpoint_url ON endpoints(endpoint_url);
+
+CREATE INDEX idx_api_keys_client_id ON api_keys(client_id);
+
+CREATE INDEX idx_client_group_members_client ON client_group_members(client_id);
+
+CREATE INDEX idx_refresh_tokens_client_id ON refresh_tokens(client_id);
+
+CREATE INDEX idx_tokens_family_id ON tokens(family_id);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+
+CREATE INDEX idx_webhook_subscriptions_client ON webhook_subscriptions(client_id);
@@ -0,0 +1,345 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Oracle DATA_TYPE, Postgres data_type and SQLite declared type families accepted for each kind of column the server reads or writes
+var (
+	stringColumnTypes = []string{"VARCHAR2", "NVARCHAR2", "CHAR", "NCHAR", "CHARACTER VARYING", "CHARACTER", "TEXT", "VARCHAR"}
+	listColumnTypes   = []string{"CLOB", "NCLOB", "VARCHAR2", "NVARCHAR2", "TEXT", "CHARACTER VARYING", "VARCHAR"} // text that may outgrow VARCHAR2, e.g. JSON scope arrays
+	numberColumnTypes = []string{"NUMBER", "INTEGER", "FLOAT", "SMALLINT", "BIGINT", "NUMERIC"}
+	timeColumnTypes   = []string{"TIMESTAMP", "DATE", "TIMESTAMP WITHOUT TIME ZONE", "TIMESTAMP WITH TIME ZONE"}
+)
+
+type schemaColumn struct {
+	Name  string
+	Types []string
+}
+
+// schemaTable lists the columns a table must have, and the columns that must lead an index
+// because hot queries filter on them
+type schemaTable struct {
+	Name    string
+	Columns []schemaColumn
+	Indexes []string
+}
+
+// requiredSchema is what the server's queries depend on; see schema.sql and schema-postgres.sql
+var requiredSchema = []schemaTable{
+	{
+		Name: "clients",
+		Columns: []schemaColumn{
+			{"client_id", stringColumnTypes},
+			{"client_secret", stringColumnTypes},
+			{"client_name", stringColumnTypes},
+			{"access_token_ttl", numberColumnTypes},
+			{"allowed_scopes", listColumnTypes},
+			{"default_scopes", listColumnTypes},
+			{"jwt_headers", listColumnTypes},
+			{"allowed_audiences", listColumnTypes},
+			{"public_key", listColumnTypes},
+			{"token_format", stringColumnTypes},
+			{"roles", listColumnTypes},
+			{"updated_at", timeColumnTypes},
+			{"deleted_at", timeColumnTypes},
+		},
+		Indexes: []string{"client_id"},
+	},
+	{
+		Name: "tokens",
+		Columns: []schemaColumn{
+			{"token_id", stringColumnTypes},
+			{"token_type", stringColumnTypes},
+			{"jwt_token", listColumnTypes},
+			{"client_id", stringColumnTypes},
+			{"issued_at", timeColumnTypes},
+			{"expires_at", timeColumnTypes},
+			{"revoked", numberColumnTypes},
+			{"revoked_at", timeColumnTypes},
+			{"revocation_reason", stringColumnTypes},
+			{"family_id", stringColumnTypes},
+		},
+		Indexes: []string{"token_id", "client_id", "expires_at", "family_id"},
+	},
+	{
+		Name: "endpoints",
+		Columns: []schemaColumn{
+			{"client_id", stringColumnTypes},
+			{"scope", stringColumnTypes},
+			{"required_scopes", listColumnTypes},
+			{"scope_mode", stringColumnTypes},
+			{"method", stringColumnTypes},
+			{"endpoint_url", stringColumnTypes},
+			{"description", stringColumnTypes},
+			{"active", numberColumnTypes},
+		},
+	},
+}
+
+// schemaCatalog is how checkSchema reads a driver's data dictionary. Both queries return upper-case
+// names, as Oracle stores them, and take the quoted table names in place of %s
+type schemaCatalog struct {
+	upperCase bool   // whether the dictionary stores table names in upper case
+	columns   string // table_name, column_name, data_type
+	indexes   string // table_name, column_name of every column leading an index
+}
+
+var schemaCatalogs = map[string]schemaCatalog{
+	DriverOracle: {
+		upperCase: true,
+		columns:   "SELECT table_name, column_name, data_type FROM user_tab_columns WHERE table_name IN (%s)",
+		indexes:   "SELECT table_name, column_name FROM user_ind_columns WHERE column_position = 1 AND table_name IN (%s)",
+	},
+	DriverPostgres: {
+		columns: "SELECT UPPER(table_name), UPPER(column_name), UPPER(data_type) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name IN (%s)",
+		indexes: "SELECT UPPER(t.relname), UPPER(a.attname) FROM pg_index i JOIN pg_class t ON t.oid = i.indrelid JOIN pg_namespace n ON n.oid = t.relnamespace " +
+			"JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = i.indkey[0] WHERE n.nspname = current_schema() AND t.relname IN (%s)",
+	},
+	DriverSQLite: {
+		columns: "SELECT UPPER(m.name), UPPER(p.name), UPPER(p.type) FROM sqlite_master m JOIN pragma_table_info(m.name) p WHERE m.type = 'table' AND m.name IN (%s)",
+		indexes: "SELECT UPPER(m.name), UPPER(ii.name) FROM sqlite_master m JOIN pragma_index_list(m.name) il JOIN pragma_index_info(il.name) ii " +
+			"WHERE m.type = 'table' AND ii.seqno = 0 AND m.name IN (%s)",
+	},
+}
+
+// checkSchema verifies that the tables, columns and indexes in requiredSchema exist with compatible
+// types. Every problem found is reported in the returned error so one restart is enough to see them all
+func checkSchema(ctx context.Context, db *sql.DB, driver string) error {
+	catalog, ok := schemaCatalogs[driver]
+	if !ok {
+		return fmt.Errorf("schema check: unsupported database driver %q", driver)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tables := make([]string, 0, len(requiredSchema))
+	for _, table := range requiredSchema {
+		name := table.Name
+		if catalog.upperCase {
+			name = strings.ToUpper(name)
+		}
+		tables = append(tables, "'"+name+"'")
+	}
+	inTables := strings.Join(tables, ", ")
+
+	columns := make(map[string]string) // TABLE.COLUMN -> DATA_TYPE
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(catalog.columns, inTables))
+	if err != nil {
+		return fmt.Errorf("schema check: reading columns: %w", err)
+	}
+	for rows.Next() {
+		var table, column, dataType string
+		if err := rows.Scan(&table, &column, &dataType); err != nil {
+			rows.Close()
+			return fmt.Errorf("schema check: reading columns: %w", err)
+		}
+		columns[table+"."+column] = dataType
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("schema check: reading columns: %w", err)
+	}
+
+	indexed := make(map[string]bool) // TABLE.COLUMN leading some index
+	rows, err = db.QueryContext(ctx, fmt.Sprintf(catalog.indexes, inTables))
+	if err != nil {
+		return fmt.Errorf("schema check: reading indexes: %w", err)
+	}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			rows.Close()
+			return fmt.Errorf("schema check: reading indexes: %w", err)
+		}
+		indexed[table+"."+column] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("schema check: reading indexes: %w", err)
+	}
+
+	var problems []string
+	for _, table := range requiredSchema {
+		tableName := strings.ToUpper(table.Name)
+		if !hasTable(columns, tableName) {
+			problems = append(problems, fmt.Sprintf("table %s is missing", table.Name))
+			continue
+		}
+		for _, column := range table.Columns {
+			dataType, ok := columns[tableName+"."+strings.ToUpper(column.Name)]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("column %s.%s is missing", table.Name, column.Name))
+				continue
+			}
+			if !compatibleColumnType(dataType, column.Types) {
+				problems = append(problems, fmt.Sprintf("column %s.%s has type %s, want one of %s", table.Name, column.Name, dataType, strings.Join(column.Types, ", ")))
+			}
+		}
+		for _, column := range table.Indexes {
+			if !indexed[tableName+"."+strings.ToUpper(column)] {
+				problems = append(problems, fmt.Sprintf("no index on %s(%s)", table.Name, column))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("schema check failed: %s", strings.Join(problems, "; "))
+	}
+	log.Info().Int("tables", len(requiredSchema)).Msg("Database schema check passed")
+	return nil
+}
+
+func hasTable(columns map[string]string, table string) bool {
+	for key := range columns {
+		if strings.HasPrefix(key, table+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// compatibleColumnType matches Oracle types by family, so TIMESTAMP(6) and TIMESTAMP(6) WITH TIME ZONE
+// both satisfy TIMESTAMP
+func compatibleColumnType(dataType string, accepted []string) bool {
+	family, _, _ := strings.Cut(dataType, "(")
+	return slices.Contains(accepted, strings.TrimSpace(family))
+}
+
+// The bootstrap DDL is kept in the SQL scripts operators can also run by hand; the statements the
+// server runs are read from them so the two cannot drift apart
+var (
+	//go:embed schema.sql
+	oracleSchemaScript string
+
+	//go:embed schema-postgres.sql
+	postgresSchemaScript string
+)
+
+// oracleSchemaDDL creates every table and index the server uses. Statements are run one at a time
+// because go-ora executes a single statement per call
+var oracleSchemaDDL = schemaStatements(oracleSchemaScript)
+
+// schemaStatements returns the CREATE statements of a SQL script in order, without comments. The
+// sample data some scripts go on to insert is left to whoever runs them by hand
+func schemaStatements(script string) []string {
+	var statements []string
+	for _, chunk := range strings.Split(script, ";\n") {
+		var lines []string
+		for _, line := range strings.Split(chunk, "\n") {
+			if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "--") {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) > 0 && strings.HasPrefix(lines[0], "CREATE ") {
+			statements = append(statements, strings.Join(lines, "\n"))
+		}
+	}
+	return statements
+}
+
+// schemaDDL holds the bootstrap statements for each supported database driver
+var schemaDDL = map[string][]string{
+	DriverOracle:   oracleSchemaDDL,
+	DriverPostgres: postgresSchemaDDL,
+	DriverSQLite:   sqliteSchemaDDL,
+}
+
+// Errors meaning an object from a previous run already exists
+var existingObjectErrors = []string{
+	"ORA-00955",          // name is already used by an existing object
+	"ORA-01408",          // such column list already indexed
+	"SQLSTATE 42P07",     // Postgres duplicate_table, also raised for indexes
+	"already exists (1)", // SQLite SQLITE_ERROR for a table or index
+}
+
+// initSchema runs the driver's bootstrap DDL. Tables and indexes that already exist are skipped,
+// so running it against a partly initialised database completes the schema
+func initSchema(ctx context.Context, db *sql.DB, driver string) (created, skipped int, err error) {
+	statements, ok := schemaDDL[driver]
+	if !ok {
+		return 0, 0, fmt.Errorf("no schema available for database driver %q", driver)
+	}
+	created, skipped, err = execDDL(ctx, db, statements)
+	if err != nil {
+		return created, skipped, fmt.Errorf("initSchema: %w", err)
+	}
+	return created, skipped, nil
+}
+
+// execDDL runs CREATE statements in order, counting objects that already exist as skipped
+func execDDL(ctx context.Context, db *sql.DB, statements []string) (created, skipped int, err error) {
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			if hasErrorCode(err, existingObjectErrors) {
+				skipped++
+				continue
+			}
+			return created, skipped, fmt.Errorf("%s: %w", schemaObjectName(statement), err)
+		}
+		created++
+	}
+	return created, skipped, nil
+}
+
+// hasErrorCode reports whether err's message carries one of the driver error codes in codes
+func hasErrorCode(err error, codes []string) bool {
+	return slices.ContainsFunc(codes, func(code string) bool { return strings.Contains(err.Error(), code) })
+}
+
+// schemaObjectName returns "table clients" or "index idx_tokens_client_id" for a CREATE statement
+func schemaObjectName(statement string) string {
+	fields := strings.Fields(statement)
+	if len(fields) < 3 {
+		return statement
+	}
+	return strings.ToLower(fields[1]) + " " + fields[2]
+}
+
+// InitDatabaseSchema connects to the configured database and creates any missing tables and indexes.
+// It backs the "db init" command; when dryRun is set the DDL is written to out instead of executed.
+// An empty driver means database.driver
+func InitDatabaseSchema(driver string, dryRun bool, out io.Writer) error {
+	if driver == "" {
+		driver = databaseDriver()
+	}
+	if dryRun {
+		statements, ok := schemaDDL[driver]
+		if !ok {
+			return fmt.Errorf("no schema available for database driver %q", driver)
+		}
+		for _, statement := range statements {
+			fmt.Fprintf(out, "%s;\n\n", statement)
+		}
+		return nil
+	}
+
+	if driver != databaseDriver() {
+		return fmt.Errorf("--driver %s does not match database.driver %s", driver, databaseDriver())
+	}
+	db, err := newDbClient(databaseURL())
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	created, skipped, err := initSchema(ctx, db, driver)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "schema initialised: %d objects created, %d already present\n", created, skipped)
+	return checkSchema(ctx, db, driver)
+}
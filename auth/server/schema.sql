@@ -0,0 +1,216 @@
+-- Create CLIENTS table
+CREATE TABLE clients (
+    client_id VARCHAR2(100) PRIMARY KEY,
+    client_secret VARCHAR2(255) NOT NULL,
+    client_name VARCHAR2(255),
+    access_token_ttl NUMBER(10) DEFAULT 3600,
+    allowed_scopes CLOB,
+    default_scopes CLOB,
+    jwt_headers CLOB,
+    allowed_audiences CLOB,
+    public_key CLOB,
+    token_format VARCHAR2(10) DEFAULT 'jwt' CHECK (token_format IN ('jwt', 'opaque')),
+    roles CLOB,
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    updated_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    active NUMBER(1) DEFAULT 1 CHECK (active IN (0, 1)),
+    deleted_at TIMESTAMP
+);
+
+-- Create TOKENS table
+CREATE TABLE tokens (
+    token_id VARCHAR2(255) PRIMARY KEY,
+    token_type VARCHAR2(20) NOT NULL,
+    jwt_token VARCHAR2(2000) NOT NULL,
+    client_id VARCHAR2(100) NOT NULL,
+    issued_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked NUMBER(1) DEFAULT 0 CHECK (revoked IN (0, 1)),
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR2(20),
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    family_id VARCHAR2(255),
+    CONSTRAINT fk_tokens_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+-- Create ENDPOINTS table
+CREATE TABLE endpoints (
+    id NUMBER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    client_id VARCHAR2(100) NOT NULL,
+    scope VARCHAR2(255) NOT NULL,
+    method VARCHAR2(10) NOT NULL,
+    endpoint_url VARCHAR2(500) NOT NULL,
+    required_scopes CLOB,
+    scope_mode VARCHAR2(3) DEFAULT 'ANY' CHECK (scope_mode IN ('ANY', 'ALL')),
+    description VARCHAR2(500) DEFAULT '',
+    active NUMBER(1) DEFAULT 1 CHECK (active IN (0, 1)),
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    CONSTRAINT fk_endpoints_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+-- Create ENDPOINT_RULES table (regex URL patterns, lowest priority value evaluated first)
+CREATE TABLE endpoint_rules (
+    id NUMBER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    pattern VARCHAR2(1000) NOT NULL,
+    scope VARCHAR2(255) NOT NULL,
+    method VARCHAR2(10) DEFAULT '*' NOT NULL,
+    priority NUMBER(10) DEFAULT 100 NOT NULL,
+    description VARCHAR2(500),
+    active NUMBER(1) DEFAULT 1 CHECK (active IN (0, 1)),
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP
+);
+
+-- Create SCOPE_HIERARCHY table (holding parent_scope grants child_scope)
+CREATE TABLE scope_hierarchy (
+    parent_scope VARCHAR2(255) NOT NULL,
+    child_scope VARCHAR2(255) NOT NULL,
+    CONSTRAINT pk_scope_hierarchy PRIMARY KEY (parent_scope, child_scope)
+);
+
+-- Create DELEGATION_POLICIES table (which client may act on behalf of which)
+CREATE TABLE delegation_policies (
+    actor_client_id VARCHAR2(100) NOT NULL,
+    subject_client_id VARCHAR2(100) NOT NULL,
+    mode VARCHAR2(20) DEFAULT 'delegation' CHECK (mode IN ('delegation', 'impersonation')),
+    allowed_scopes CLOB,
+    active NUMBER(1) DEFAULT 1 CHECK (active IN (0, 1)),
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    CONSTRAINT pk_delegation_policies PRIMARY KEY (actor_client_id, subject_client_id),
+    CONSTRAINT fk_delegation_actor FOREIGN KEY (actor_client_id) REFERENCES clients(client_id) ON DELETE CASCADE,
+    CONSTRAINT fk_delegation_subject FOREIGN KEY (subject_client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+-- Create API_KEYS table (long-lived service account credentials, stored hashed)
+CREATE TABLE api_keys (
+    key_id VARCHAR2(32) PRIMARY KEY,
+    key_hash VARCHAR2(64) NOT NULL,
+    client_id VARCHAR2(100) NOT NULL,
+    name VARCHAR2(255),
+    scopes CLOB,
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked NUMBER(1) DEFAULT 0 CHECK (revoked IN (0, 1)),
+    revoked_at TIMESTAMP,
+    CONSTRAINT fk_api_keys_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+-- Create REFRESH_TOKENS table (stored hashed, in the token family of the access token they were issued with)
+CREATE TABLE refresh_tokens (
+    token_id VARCHAR2(32) PRIMARY KEY,
+    token_hash VARCHAR2(64) NOT NULL,
+    client_id VARCHAR2(100) NOT NULL,
+    access_token_id VARCHAR2(255),
+    family_id VARCHAR2(255),
+    scopes CLOB,
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked NUMBER(1) DEFAULT 0 CHECK (revoked IN (0, 1)),
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR2(20),
+    CONSTRAINT fk_refresh_tokens_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+-- Create CLIENT_GROUPS table (scopes inherited by every member client)
+CREATE TABLE client_groups (
+    group_id VARCHAR2(100) PRIMARY KEY,
+    name VARCHAR2(255),
+    allowed_scopes CLOB,
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP
+);
+
+CREATE TABLE client_group_members (
+    group_id VARCHAR2(100) NOT NULL,
+    client_id VARCHAR2(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    CONSTRAINT pk_client_group_members PRIMARY KEY (group_id, client_id),
+    CONSTRAINT fk_group_members_group FOREIGN KEY (group_id) REFERENCES client_groups(group_id) ON DELETE CASCADE,
+    CONSTRAINT fk_group_members_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+-- Create CLIENT_USAGE_DAILY table (per-client daily counters for usage reports)
+CREATE TABLE client_usage_daily (
+    usage_date DATE NOT NULL,
+    client_id VARCHAR2(100) NOT NULL,
+    tokens_issued NUMBER(19) DEFAULT 0,
+    validations NUMBER(19) DEFAULT 0,
+    denials NUMBER(19) DEFAULT 0,
+    revocations NUMBER(19) DEFAULT 0,
+    CONSTRAINT pk_client_usage_daily PRIMARY KEY (usage_date, client_id)
+);
+
+-- Create SIGNING_KEYS table (managed JWT signing keys, key_material sealed with JWT_SECRET)
+CREATE TABLE signing_keys (
+    kid VARCHAR2(32) PRIMARY KEY,
+    algorithm VARCHAR2(10) NOT NULL,
+    key_material VARCHAR2(512) NOT NULL,
+    status VARCHAR2(10) DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'inactive', 'retired')),
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    activated_at TIMESTAMP,
+    retired_at TIMESTAMP
+);
+
+-- Create WEBHOOK_SUBSCRIPTIONS table (client callback URLs for token events, secret sealed with JWT_SECRET)
+CREATE TABLE webhook_subscriptions (
+    subscription_id VARCHAR2(32) PRIMARY KEY,
+    client_id VARCHAR2(100) NOT NULL,
+    url VARCHAR2(2048) NOT NULL,
+    events CLOB,
+    secret VARCHAR2(256) NOT NULL,
+    status VARCHAR2(10) DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'disabled')),
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    approved_at TIMESTAMP,
+    expiry_scanned_until TIMESTAMP,
+    CONSTRAINT fk_webhook_subscriptions_client FOREIGN KEY (client_id) REFERENCES clients(client_id) ON DELETE CASCADE
+);
+
+-- Create REVOCATION_IMPORTS table (bulk revocation jobs, checkpointed per batch so an upload can be resumed)
+CREATE TABLE revocation_imports (
+    job_id VARCHAR2(32) PRIMARY KEY,
+    status VARCHAR2(20) DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'interrupted', 'completed')),
+    reason VARCHAR2(20) NOT NULL,
+    processed NUMBER(19) DEFAULT 0,
+    revoked NUMBER(19) DEFAULT 0,
+    already_revoked NUMBER(19) DEFAULT 0,
+    not_found NUMBER(19) DEFAULT 0,
+    invalid NUMBER(19) DEFAULT 0,
+    batches NUMBER(10) DEFAULT 0,
+    created_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    updated_at TIMESTAMP DEFAULT SYSTIMESTAMP,
+    finished_at TIMESTAMP,
+    last_error VARCHAR2(4000)
+);
+
+-- Create indexes for performance
+CREATE INDEX idx_tokens_client_id ON tokens(client_id);
+CREATE INDEX idx_tokens_expires_at ON tokens(expires_at);
+CREATE INDEX idx_tokens_revoked ON tokens(revoked);
+CREATE INDEX idx_endpoints_client_id ON endpoints(client_id);
+CREATE INDEX idx_endpoints_endpoint_url ON endpoints(endpoint_url);
+CREATE INDEX idx_api_keys_client_id ON api_keys(client_id);
+CREATE INDEX idx_client_group_members_client ON client_group_members(client_id);
+CREATE INDEX idx_refresh_tokens_client_id ON refresh_tokens(client_id);
+CREATE INDEX idx_tokens_family_id ON tokens(family_id);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX idx_webhook_subscriptions_client ON webhook_subscriptions(client_id);
+
+-- Insert sample test data
+INSERT INTO clients (client_id, client_secret, client_name, access_token_ttl, allowed_scopes)
+VALUES ('test-client', 'test-secret-123', 'Test Client Application', 3600, '["read:ltp", "read:quote"]');
+
+INSERT INTO clients (client_id, client_secret, client_name, access_token_ttl, allowed_scopes)
+VALUES ('test-client-2', 'secret-key-456', 'Test Client 2', 7200, '["write:ltp", "write:quote"]');
+
+INSERT INTO endpoints (client_id, scope, method, endpoint_url, description, active)
+VALUES ('test-client', 'read:orders', 'POST', 'http://localhost:8082/resource1', '', 1);
+
+INSERT INTO endpoints (client_id, scope, method, endpoint_url, description, active)
+VALUES ('test-client', 'write:orders', 'POST', 'http://localhost:8082/resource2', '', 0);
+
+-- Commit changes
+COMMIT;
+
+-- Display table information
+SELECT table_name FROM user_tables WHERE table_name IN ('CLIENTS', 'TOKENS', 'ENDPOINTS')
+ORDER BY table_name;
\ No newline at end of file
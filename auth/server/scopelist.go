@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	go_ora "github.com/sijms/go-ora/v2"
+)
+
+// maxScopeLength bounds a single scope token read from the database
+const maxScopeLength = 255
+
+// scopeList scans a scope column (allowed_scopes, default_scopes, required_scopes, ...) into a
+// []string. NULL scans as an empty list. The column may be VARCHAR2 or CLOB holding any encoding
+// parseStringArray accepts, or an Oracle VARRAY / nested table of strings
+type scopeList []string
+
+// Scan implements sql.Scanner
+func (l *scopeList) Scan(src any) error {
+	var (
+		scopes []string
+		err    error
+	)
+	switch v := src.(type) {
+	case nil:
+	case string:
+		scopes, err = parseStringArray(v)
+	case []byte:
+		scopes, err = parseStringArray(string(v))
+	case go_ora.Clob:
+		if v.Valid {
+			scopes, err = parseStringArray(v.String)
+		}
+	case go_ora.NClob:
+		if v.Valid {
+			scopes, err = parseStringArray(v.String)
+		}
+	case []string:
+		scopes, err = validateScopes(v)
+	case []any:
+		items := make([]string, 0, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("scope list element %d has type %T, want string", i, item)
+			}
+			items = append(items, s)
+		}
+		scopes, err = validateScopes(items)
+	default:
+		return fmt.Errorf("cannot read scope list from %T", src)
+	}
+	if err != nil {
+		return err
+	}
+	*l = scopes
+	return nil
+}
+
+// parseStringArray parses a stored scope list. Accepted encodings are a JSON array, the same with
+// single quotes, a bracketed or bare list separated by commas, and a space-delimited OAuth scope
+// string. Every element must be a valid RFC 6749 scope-token: input that would only partially parse
+// is rejected instead of yielding whatever scopes happen to survive
+func parseStringArray(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(s, "[") {
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("invalid scope list %s: unterminated array", quoteScopeInput(s))
+		}
+
+		var out []string
+		if err := json.Unmarshal([]byte(s), &out); err == nil {
+			return validateScopes(out)
+		}
+		if err := json.Unmarshal([]byte(strings.ReplaceAll(s, `'`, `"`)), &out); err == nil {
+			return validateScopes(out)
+		}
+		if strings.ContainsAny(s, `"'`) {
+			return nil, fmt.Errorf("invalid scope list %s: malformed JSON array", quoteScopeInput(s))
+		}
+		s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	}
+
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		out = append(out, trimScopeQuotes(p))
+	}
+	return validateScopes(out)
+}
+
+// trimScopeQuotes strips one pair of matching quotes, leaving stray quotes for validation to reject
+func trimScopeQuotes(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// validateScopes checks every element is a scope-token (RFC 6749 section 3.3: printable ASCII
+// except space, double quote and backslash) of reasonable length
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+	for i, scope := range scopes {
+		if scope == "" {
+			return nil, fmt.Errorf("invalid scope list: element %d is empty", i)
+		}
+		if len(scope) > maxScopeLength {
+			return nil, fmt.Errorf("invalid scope list: element %d exceeds %d characters", i, maxScopeLength)
+		}
+		for _, c := range []byte(scope) {
+			if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+				return nil, fmt.Errorf("invalid scope list: element %d (%s) contains invalid character %q", i, quoteScopeInput(scope), c)
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// quoteScopeInput quotes stored data for error messages, truncating long values
+func quoteScopeInput(s string) string {
+	const max = 64
+	if len(s) > max {
+		return fmt.Sprintf("%q...", s[:max])
+	}
+	return fmt.Sprintf("%q", s)
+}
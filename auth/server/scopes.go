@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// scopeHierarchy decides whether a granted scope satisfies a required one. A scope satisfies
+// itself, a "prefix:*" wildcard satisfies every scope under that prefix, super scopes (e.g. "admin")
+// satisfy everything, and explicit parent -> child edges are followed transitively
+type scopeHierarchy struct {
+	mu          sync.RWMutex
+	superScopes map[string]bool
+	implies     map[string]map[string]bool // parent -> all transitively implied children
+}
+
+func newScopeHierarchy(superScopes []string, edges map[string][]string) *scopeHierarchy {
+	sh := &scopeHierarchy{}
+	sh.Load(superScopes, edges)
+	return sh
+}
+
+// Load replaces the hierarchy with the given super scopes and parent -> children edges
+func (sh *scopeHierarchy) Load(superScopes []string, edges map[string][]string) {
+	supers := make(map[string]bool, len(superScopes))
+	for _, scope := range superScopes {
+		supers[scope] = true
+	}
+
+	implies := make(map[string]map[string]bool, len(edges))
+	for parent := range edges {
+		closure := make(map[string]bool)
+		stack := append([]string(nil), edges[parent]...)
+		for len(stack) > 0 {
+			child := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if closure[child] || child == parent {
+				continue
+			}
+			closure[child] = true
+			stack = append(stack, edges[child]...)
+		}
+		implies[parent] = closure
+	}
+
+	sh.mu.Lock()
+	sh.superScopes = supers
+	sh.implies = implies
+	sh.mu.Unlock()
+}
+
+// Satisfies reports whether holding granted is enough for required
+func (sh *scopeHierarchy) Satisfies(granted, required string) bool {
+	if granted == required || wildcardSatisfies(granted, required) {
+		return true
+	}
+	if sh == nil {
+		return false
+	}
+
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if sh.superScopes[granted] {
+		return true
+	}
+	for child := range sh.implies[granted] {
+		if child == required || wildcardSatisfies(child, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether any of the granted scopes satisfies required
+func (sh *scopeHierarchy) HasScope(granted []string, required string) bool {
+	for _, scope := range granted {
+		if sh.Satisfies(scope, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorizes reports whether granted scopes meet the required set under mode (ANY or ALL)
+func (sh *scopeHierarchy) Authorizes(granted []string, required []string, mode string) bool {
+	if len(required) == 0 {
+		return false
+	}
+	if strings.EqualFold(mode, ScopeModeAll) {
+		for _, scope := range required {
+			if !sh.HasScope(granted, scope) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, scope := range required {
+		if sh.HasScope(granted, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func wildcardSatisfies(granted, required string) bool {
+	prefix, ok := strings.CutSuffix(granted, "*")
+	if !ok {
+		return false
+	}
+	return prefix == "" || strings.HasPrefix(required, prefix)
+}
+
+// populateScopeHierarchy merges config-defined edges with the scope_hierarchy table
+func (s *authServer) populateScopeHierarchy() {
+	edges := make(map[string][]string, len(AppConfig.Scopes.Hierarchy))
+	for parent, children := range AppConfig.Scopes.Hierarchy {
+		edges[parent] = append(edges[parent], children...)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT parent_scope, child_scope FROM scope_hierarchy`)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load scope hierarchy from database, using configuration only")
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var parent, child string
+			if err := rows.Scan(&parent, &child); err != nil {
+				log.Error().Msgf("failed to retrieve row while loading scope hierarchy: %s", err)
+				continue
+			}
+			edges[parent] = append(edges[parent], child)
+		}
+		if err := rows.Err(); err != nil {
+			log.Error().Err(err).Msg("rows iteration error in loading scope hierarchy")
+		}
+	}
+
+	if s.scopeHierarchy == nil {
+		s.scopeHierarchy = newScopeHierarchy(nil, nil)
+	}
+	s.scopeHierarchy.Load(AppConfig.Scopes.SuperScopes, edges)
+	s.cacheRefreshes.Mark("scope_hierarchy")
+	log.Info().Int("parents", len(edges)).Strs("super_scopes", AppConfig.Scopes.SuperScopes).Msg("Scope hierarchy loaded")
+}
@@ -0,0 +1,875 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"auth/auth/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// getJWTSecret loads JWT secret from environment variable (CRITICAL SECURITY FIX)
+func getJWTSecret() []byte {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Fatal().Msg("SECURITY ERROR: JWT_SECRET environment variable not set")
+	}
+	if len(secret) < 32 {
+		log.Fatal().Msg("SECURITY ERROR: JWT_SECRET must be at least 32 characters")
+	}
+	return []byte(secret)
+}
+
+var JWTsecret = getJWTSecret()
+
+// getPreviousJWTSecret loads JWT_SECRET_PREVIOUS, the secret JWT_SECRET replaced. Tokens it signed keep
+// validating, and keys sealed under it are resealed, until it is unset
+func getPreviousJWTSecret() []byte {
+	secret := os.Getenv("JWT_SECRET_PREVIOUS")
+	if secret == "" {
+		return nil
+	}
+	if len(secret) < 32 {
+		log.Fatal().Msg("SECURITY ERROR: JWT_SECRET_PREVIOUS must be at least 32 characters")
+	}
+	return []byte(secret)
+}
+
+func (s *authServer) Start() {
+	var err error
+	// token
+	s.tokenRequestsCount, err = registerCounterVecMetric("token_requests_count",
+		"total number of token requests",
+		"",
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for token_requests_count")
+	}
+
+	s.tokenSuccessCount, err = registerCounterVecMetric("token_success_count",
+		"total number of issued token success",
+		"",
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for token_success_count")
+	}
+
+	s.tokenErrorCount, err = registerCounterVecMetric("token_error_count",
+		"total number of token generation errors",
+		"",
+		[]string{"token", "error_type"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for token_error_count")
+	}
+
+	s.tokenGenerationDuration, err = registerHistogramVecMetric("token_generation_duration_seconds",
+		"duration of each token",
+		"",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus histogram vector metric token_generation_duration_seconds")
+	}
+
+	// validate
+	s.validateTokenRequestsCount, err = registerCounterVecMetric("validate_token_requests_count",
+		"total number of validate token requests",
+		"",
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for validate_token_request_count")
+	}
+
+	s.validateTokenSuccessCount, err = registerCounterVecMetric("validate_token_success_count",
+		"total number of validate token success",
+		"",
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for validate_token_success_count")
+	}
+
+	s.validateTokenErrorCount, err = registerCounterVecMetric("validate_token_error_count",
+		"total number of validate token errors",
+		"",
+		[]string{"token", "error_type"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for validate_token_error_count")
+	}
+
+	s.validateTokenLatency, err = registerHistogramVecMetric("validate_token_latency_seconds",
+		"validated token latency",
+		"",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus histogram vector metric validate_token_latency_seconds")
+	}
+
+	// revoke
+	s.revokeRequestsCount, err = registerCounterVecMetric("revoke_token_requests_count",
+		"total number of revoke token requests",
+		"",
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for revoke_token_requests_count")
+	}
+
+	s.revokeSuccessCount, err = registerCounterVecMetric("revoke_token_success_count",
+		"total number of revoke token success",
+		"",
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for revoke_token_success_count")
+	}
+
+	s.revokeErrorCount, err = registerCounterVecMetric("revoke_token_error_count",
+		"total number of revoke token errors",
+		"",
+		[]string{"token", "error_type"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for revoke_token_error_count")
+	}
+
+	s.revokeTokenLatency, err = registerHistogramVecMetric("revoke_token_latency_seconds",
+		"revoked token latency",
+		"",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus histogram vector metric revoke_token_latency_seconds")
+	}
+
+	s.tokensRevokedCount, err = registerCounterVecMetric("tokens_revoked_total",
+		"total number of tokens revoked by reason, across /revoke, one-time tokens, family revocation and client deletion",
+		"",
+		[]string{"reason"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for tokens_revoked_total")
+	}
+
+	s.validateDegradedCount, err = registerCounterVecMetric("validate_degraded_total",
+		"tokens accepted without the revocation lookup while validation was degraded",
+		"",
+		[]string{"token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for validate_degraded_total")
+	}
+
+	s.validateDegradedMode, err = registerGaugeVecMetric("validate_degraded_mode",
+		"whether validation is degraded to signature-only checks (1=degraded, 0=normal)",
+		"",
+		[]string{"db"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus gauge vector metric for validate_degraded_mode")
+	}
+	s.revocationBreaker.Start(s.validateDegradedMode)
+
+	// database
+	s.dbStatus, err = registerGaugeVecMetric("db_status",
+		"database status from the health monitor's pings (1=healthy, 0=unhealthy)",
+		"",
+		[]string{"db"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus gauge vector metric for db_status")
+	}
+
+	s.dbActive, err = registerGaugeVecMetric("db_active",
+		"database currently serving when a standby is configured (1=active)",
+		"",
+		[]string{"db"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus gauge vector metric for db_active")
+	}
+
+	s.dbFailovers, err = registerCounterVecMetric("db_failovers_total",
+		"switches between the primary and standby database, by database switched to",
+		"",
+		[]string{"db"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for db_failovers_total")
+	}
+	s.dbFailover.Start(s.dbActive, s.dbFailovers)
+
+	s.dbConnectionsActive, err = registerGaugeVecMetric("db_connections_active",
+		"number of active database connections",
+		"",
+		[]string{"db"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus gauge vector metric for db_connections_active")
+	}
+
+	s.dbConnectionsIdle, err = registerGaugeVecMetric("db_connections_idle",
+		"number of idle database connections",
+		"",
+		[]string{"db"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus gauge vector metric for db_connections_idle")
+	}
+	s.dbHealth.Start(s.dbStatus, s.dbConnectionsActive, s.dbConnectionsIdle)
+
+	s.dbQueryDuration, err = registerHistogramVecMetric("db_query_duration_seconds",
+		"duration of database queries, by operation: a store operation such as token_info or client_by_id, or verb:table for other statements",
+		"",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		[]string{"operation"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus histogram vector metric for db_query_duration_seconds")
+	}
+
+	s.dbQueryErrorCount, err = registerCounterVecMetric("db_query_errors_total",
+		"total number of failed database operations",
+		"",
+		[]string{"operation"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for db_query_errors_total")
+	}
+
+	// cache metrics
+	s.clientCacheHitRate, err = registerCounterVecMetric("client_cache_hits_total",
+		"total number of client cache hits; cache_lookups_total also has misses",
+		"",
+		[]string{"cache_type"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for client_cache_hits")
+	}
+
+	s.endpointCacheHitRate, err = registerCounterVecMetric("endpoint_cache_hits_total",
+		"total number of endpoint cache hits; cache_lookups_total also has misses",
+		"",
+		[]string{"cache_type"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for endpoint_cache_hits")
+	}
+
+	s.cacheSize, err = registerGaugeVecMetric("cache_size",
+		"current size of cache entries",
+		"",
+		[]string{"cache_type"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus gauge vector metric for cache_size")
+	}
+
+	s.cacheLookups, err = registerCounterVecMetric("cache_lookups_total",
+		"total number of cache lookups, by cache and result (hit or miss)",
+		"",
+		[]string{"cache_type", "result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for cache_lookups_total")
+	}
+
+	s.cacheEvictions, err = registerCounterVecMetric("cache_evictions_total",
+		"total number of entries removed from a cache, by cache and reason (invalidated, expired, refreshed, memory_pressure)",
+		"",
+		[]string{"cache_type", "reason"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for cache_evictions_total")
+	}
+	s.instrumentCaches()
+
+	// error metrics
+	s.errorCount, err = registerCounterVecMetric("api_errors_total",
+		"total number of API errors by type",
+		"",
+		[]string{"error_code", "error_type"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for api_errors_total")
+	}
+
+	// per-client metrics (label values bounded by clientLabels)
+	s.clientTokenCount, err = registerCounterVecMetric("client_tokens_issued_total",
+		"total number of tokens issued per client",
+		"",
+		[]string{"client_id", "token"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for client_tokens_issued_total")
+	}
+
+	s.clientValidateCount, err = registerCounterVecMetric("client_validations_total",
+		"total number of token validations per client by result",
+		"",
+		[]string{"client_id", "token", "result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for client_validations_total")
+	}
+
+	s.anomalyCount, err = registerCounterVecMetric("client_anomalies_total",
+		"total number of client rate anomalies detected",
+		"",
+		[]string{"client_id", "kind"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for client_anomalies_total")
+	}
+	s.anomalies.Start()
+
+	s.webhookDeliveries, err = registerCounterVecMetric("webhook_deliveries_total",
+		"total number of client webhook deliveries by subscription, event and result",
+		"",
+		[]string{"subscription", "event", "result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for webhook_deliveries_total")
+	}
+
+	s.webhookDeliveryDuration, err = registerHistogramVecMetric("webhook_delivery_duration_seconds",
+		"duration of client webhook delivery attempts",
+		"",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		[]string{"subscription"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus histogram vector metric webhook_delivery_duration_seconds")
+	}
+	s.webhooks.Start(s.webhookDeliveries, s.webhookDeliveryDuration)
+
+	s.revocationPropagation, err = registerHistogramVecMetric("revocation_propagation_seconds",
+		"time from revoking the canary token until an instance's /validate rejects it",
+		"",
+		[]float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		[]string{"instance"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus histogram vector metric revocation_propagation_seconds")
+	}
+
+	s.revocationProbeRuns, err = registerCounterVecMetric("revocation_probe_runs_total",
+		"total number of revocation propagation probe runs",
+		"",
+		[]string{"result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for revocation_probe_runs_total")
+	}
+	s.revocationProbe.Start(s.revocationPropagation, s.revocationProbeRuns)
+
+	s.tokensPurged, err = registerCounterVecMetric("tokens_purged_total",
+		"total number of expired and revoked tokens deleted from the tokens table, by trigger (admin or scheduled)",
+		"",
+		[]string{"trigger"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for tokens_purged_total")
+	}
+	s.tokenJanitor.Start()
+
+	s.canaryStepDuration, err = registerHistogramVecMetric("canary_step_duration_seconds",
+		"duration of each step of the synthetic canary token probe",
+		"",
+		[]float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		[]string{"step"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus histogram vector metric canary_step_duration_seconds")
+	}
+
+	s.canaryRuns, err = registerCounterVecMetric("canary_runs_total",
+		"total number of canary token probe runs, by result (ok or the failed step)",
+		"",
+		[]string{"result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for canary_runs_total")
+	}
+	s.canary.Start(s.canaryStepDuration, s.canaryRuns)
+
+	s.analyticsEvents, err = registerCounterVecMetric("analytics_events_total",
+		"total number of auth events handed to the analytics exporter, by sink and result (exported or dropped)",
+		"",
+		[]string{"sink", "result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for analytics_events_total")
+	}
+	s.analytics.Start(s.analyticsEvents)
+
+	s.auditEvents, err = registerCounterVecMetric("audit_events_total",
+		"total number of auth events handed to the audit publisher, by sink and result (exported or dropped)",
+		"",
+		[]string{"sink", "result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for audit_events_total")
+	}
+	s.audit.Start(s.auditEvents)
+
+	s.memoryBytes, err = registerGaugeVecMetric("memory_watchdog_bytes",
+		"process memory last sampled by the memory watchdog, by kind (heap or rss)",
+		"",
+		[]string{"kind"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus gauge vector metric for memory_watchdog_bytes")
+	}
+
+	s.memoryPressureEvents, err = registerCounterVecMetric("memory_pressure_events_total",
+		"total number of memory watchdog checks over a limit, by the limit exceeded (heap or rss)",
+		"",
+		[]string{"trigger"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for memory_pressure_events_total")
+	}
+
+	s.cachePressureEvictions, err = registerCounterVecMetric("cache_pressure_evictions_total",
+		"total number of cache entries evicted by the memory watchdog, by cache",
+		"",
+		[]string{"cache"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for cache_pressure_evictions_total")
+	}
+	s.memoryWatchdog.Start(s.memoryBytes, s.memoryPressureEvents, s.cachePressureEvictions)
+
+	s.metricsPushCount, err = registerCounterVecMetric("metrics_push_total",
+		"total number of metrics pushes to the configured collector, by result",
+		"",
+		[]string{"result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for metrics_push_total")
+	}
+	s.metricsPusher.Start(s.metricsPushCount)
+
+	s.tracingSpans, err = registerCounterVecMetric("tracing_spans_total",
+		"total number of trace spans handed to the collector, by result (exported, failed, dropped)",
+		"",
+		[]string{"result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for tracing_spans_total")
+	}
+	s.tracer.Start(s.tracingSpans)
+
+	s.shadowValidations, err = registerCounterVecMetric("shadow_validations_total",
+		"total number of token verifications compared against the shadow validation candidate, by outcome",
+		"",
+		[]string{"outcome"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for shadow_validations_total")
+	}
+	s.shadowValidation.Start(s.shadowValidations)
+
+	s.signingKeyRotations, err = registerCounterVecMetric("signing_key_rotations_total",
+		"total number of scheduled signing key rotation steps, by step (created, activated or retired)",
+		"",
+		[]string{"step"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for signing_key_rotations_total")
+	}
+	s.keyRotator.Start(s.signingKeyRotations)
+
+	s.configReloads, err = registerCounterVecMetric("config_reloads_total",
+		"total number of configuration reloads, by trigger (sighup or file) and result (applied, unchanged or failed)",
+		"",
+		[]string{"trigger", "result"})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create prometheus counter vector metric for config_reloads_total")
+	}
+	s.watchConfiguration()
+	s.tokenPersistence.Journal().Start(s)
+
+	// Set Gin to release mode for production (disables debug logging)
+	gin.SetMode(gin.ReleaseMode)
+
+	// Internal listener: admin API, health, pprof and metrics
+	reg := getMetricRegistry()
+	adminAddr := adminListenAddress()
+	s.adminSrv = &http.Server{
+		Addr:    adminAddr,
+		Handler: newAdminRouter(s, promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})),
+	}
+	go func() {
+		log.Info().
+			Str("address", adminAddr).
+			Msg("Starting internal admin server")
+
+		err := s.adminSrv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("Internal admin server failed")
+		}
+	}()
+
+	router := gin.New()
+
+	// SECURITY FIX: Initialize rate limiting from configuration
+	s.globalLimiter = rate.NewLimiter(rate.Limit(AppConfig.RateLimiting.GlobalRPS), AppConfig.RateLimiting.GlobalBurst)
+	s.rateLimitSnapshots.Restore()
+	s.rateLimitSnapshots.Start()
+
+	router.Use(
+		TracingMiddleware(s.tracer),                                   // Start the request's span
+		LoggingMiddleware(),                                           // Log all requests, with a request ID even when rejected
+		HTTPMetricsMiddleware(),                                       // Count and time requests per route
+		middleware.GlobalRateLimit(s.globalLimiter),                   // Apply global rate limiting
+		CORSMiddleware(s.corsOrigins),                                 // Handle CORS (with origin whitelist)
+		middleware.PerClientRateLimit(s.clientLimits),                 // Apply per-client rate limiting
+		middleware.SecurityHeaders(),                                  // Add security headers (HSTS, CSP, etc)
+		RecoveryMiddleware(),                                          // Handle panics
+		TimeoutMiddleware(newRouteTimeouts(AppConfig.RequestTimeout)), // Bound per-route processing time
+	)
+	routes(router, s)
+
+	if s.stateless != nil {
+		if err := s.populateFromBundle(); err != nil {
+			log.Fatal().Err(err).Msg("failed to load stateless bundle - cannot proceed")
+		}
+		s.stateless.Start(s)
+	} else {
+		s.populateClientGroups()
+		s.populateClientCache()
+		s.populateEndpointsCache()
+		s.populateEndpointRules()
+		s.populateScopeHierarchy()
+		s.populateDelegationCache()
+		s.populateSigningKeys()
+		s.populateWebhookSubscriptions()
+	}
+
+	// --- HTTPS server (primary) ---
+	if AppConfig.HTTPSEnabled && AppConfig.HTTPSServerPort != "" && AppConfig.CertFile != "" && AppConfig.KeyFile != "" {
+		httpsPort := AppConfig.HTTPSServerPort
+		if httpsPort == "" {
+			httpsPort = "8443"
+		}
+		httpsAddr := ":" + httpsPort
+
+		s.httpSrv = &http.Server{
+			Addr:    httpsAddr,
+			Handler: router,
+		}
+		go func() {
+			log.Info().
+				Str("address", httpsAddr).
+				Msg("Starting HTTPS server")
+
+			err := s.httpSrv.ListenAndServeTLS(AppConfig.CertFile, AppConfig.KeyFile)
+			if err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("HTTPS server failed")
+			}
+		}()
+
+		// Redirect HTTP to HTTPS
+		redirectRouter := gin.New()
+		redirectRouter.Use(
+			LoggingMiddleware(),
+			RecoveryMiddleware(),
+		)
+		redirectRouter.NoRoute(func(c *gin.Context) {
+			redirectURL := "https://" + c.Request.Host + c.Request.URL.Path
+			if c.Request.URL.RawQuery != "" {
+				redirectURL += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusMovedPermanently, redirectURL)
+		})
+
+		httpAddr := ":" + AppConfig.ServerPort
+		s.redirectSrv = &http.Server{
+			Addr:    httpAddr,
+			Handler: redirectRouter,
+		}
+		go func() {
+			log.Info().
+				Str("address", httpAddr).
+				Msg("Starting HTTP to HTTPS redirect server")
+
+			err := s.redirectSrv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("HTTP redirect server failed")
+			}
+		}()
+	} else {
+		// Fallback to plain HTTP (not recommended for production)
+		log.Warn().Msg("HTTPS not fully configured, falling back to HTTP")
+		httpPort := AppConfig.ServerPort
+		if httpPort == "" {
+			httpPort = "8080"
+		}
+		httpAddr := ":" + httpPort
+
+		s.httpSrv = &http.Server{
+			Addr:    httpAddr,
+			Handler: router,
+		}
+		go func() {
+			log.Info().
+				Str("address", httpAddr).
+				Msg("Starting HTTP server (insecure)")
+
+			err := s.httpSrv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("HTTP server failed")
+			}
+		}()
+	}
+}
+
+func NewAuthServer() *authServer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stateless, err := newStatelessStore(AppConfig.Stateless, AppConfig.Redis)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize stateless mode - cannot proceed")
+	}
+	var tokenState *sharedTokenState
+	if stateless == nil {
+		if tokenState, err = newSharedTokenState(AppConfig.TokenState, AppConfig.Redis); err != nil {
+			log.Fatal().Err(err).Msg("failed to initialize shared token state - cannot proceed")
+		}
+	}
+	var primaryDB, standbyDB, db *sql.DB
+	var activeDB string
+	if stateless != nil {
+		primaryDB = openStatelessDB()
+		db = primaryDB
+		log.Info().Msg("Stateless mode: no database, clients and endpoints come from the signed bundle and revocations from redis")
+	} else {
+		primaryDB, standbyDB, activeDB, err = openDatabases()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to initialize database connection - cannot proceed")
+		}
+		db = primaryDB
+		if activeDB == dbStandby {
+			db = standbyDB
+		}
+		// A standby taken over at startup is read-only; the primary gets migrated once it is back
+		if (AppConfig.Database.MigrateOnStartup || databaseDriver() == DriverSQLite) && activeDB != dbStandby {
+			if err := runStartupMigrations(ctx, db, databaseDriver()); err != nil {
+				log.Fatal().Err(err).Msg("failed to migrate the database schema")
+			}
+		}
+		if !AppConfig.Database.SkipSchemaCheck {
+			if err := checkSchema(ctx, db, databaseDriver()); err != nil {
+				log.Fatal().Err(err).Msg("database schema is incompatible - run db migrate or set database.skip_schema_check")
+			}
+		}
+	}
+
+	clientCache := newClientCache()
+	endpointCache := newEndpointsCache()
+	tokenCache := newTokenCache(tokenCacheTTL(AppConfig.Cache))
+
+	authServer := &authServer{
+		jwtSecret:         JWTsecret,
+		previousJWTSecret: getPreviousJWTSecret(),
+		ctx:               ctx,
+		cancel:            cancel,
+		clientCache:       clientCache,
+		clientGroups:      newClientGroupCache(),
+		clientLabels:      newClientLabeler(AppConfig.Metrics.PerClient),
+		endpointCache:     endpointCache,
+		endpointRules:     newEndpointRuleMatcher(),
+		scopeHierarchy:    newScopeHierarchy(AppConfig.Scopes.SuperScopes, AppConfig.Scopes.Hierarchy),
+		tokenCache:        tokenCache,
+		validationResults: newValidationResultCache(time.Duration(AppConfig.Validation.ResultCacheTTLSeconds) * time.Second),
+		delegationCache:   newDelegationCache(),
+		apiKeyCache:       newAPIKeyCache(),
+		verifiedSecrets:   newVerifiedSecrets(),
+		usedAssertions:    newJTICache(),
+		usedDPoPProofs:    newJTICache(),
+		signingKeys:       newSigningKeyRing(),
+		cacheRefreshes:    newCacheRefreshTracker(),
+		activity:          newClientActivityTracker(),
+		faults:            newFaultInjector(AppConfig.FaultInjection),
+		stateless:         stateless,
+		tokenState:        tokenState,
+		resourceServers:   newResourceServers(AppConfig.Validation),
+		corsOrigins:       newCORSOrigins(AppConfig.CORS.AllowedOrigins),
+		configReload:      configReloader{applied: AppConfig},
+	}
+	authServer.db = newInstrumentedDB(db, authServer)
+	if databaseDriver() == DriverSQLite {
+		if err := authServer.seedDatabase(ctx, AppConfig.Database.SeedFile); err != nil {
+			log.Fatal().Err(err).Msg("failed to seed the database")
+		}
+	}
+	authServer.dbFailover = newDBFailover(authServer.db, primaryDB, standbyDB, activeDB, AppConfig.Database.Failover)
+	authServer.refreshTokens = newDBRefreshTokenStore(authServer.db)
+	authServer.dbHealth = newDBHealthMonitor(authServer.pingStore, authServer.db.Stats, AppConfig.Database.Health)
+	authServer.revocationBreaker = newRevocationBreaker(AppConfig.Validation.DegradedMode)
+
+	authServer.tokenBatcher = NewTokenBatchWriter(authServer, 1000, 5*time.Second)
+	if authServer.tokenPersistence, err = newTokenPersistence(AppConfig.TokenStore); err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize token store")
+	}
+	authServer.apiKeyUsage = newAPIKeyUsageTracker(authServer, 1*time.Minute)
+	authServer.usage = newUsageRecorder(authServer, AppConfig.Usage)
+	if err := authServer.usage.Restore(); err != nil {
+		log.Warn().Err(err).Msg("failed to restore today's client usage, quotas start from zero")
+	}
+	authServer.anomalies = newAnomalyDetector(authServer, AppConfig.Anomaly)
+	authServer.webhooks = newWebhookNotifier(authServer, AppConfig.Webhooks)
+	authServer.revocationProbe = newRevocationProbe(authServer, AppConfig.RevocationProbe)
+	authServer.canary = newCanaryProbe(authServer, AppConfig.Canary)
+	authServer.tokenJanitor = newTokenJanitor(authServer, AppConfig.TokenPurge)
+	authServer.analytics = newAnalyticsExporter(AppConfig.Analytics)
+	authServer.audit = newAuditPublisher(AppConfig.Audit)
+	authServer.memoryWatchdog = newMemoryWatchdog(authServer, AppConfig.MemoryWatchdog)
+	authServer.metricsPusher = newMetricsPusher(AppConfig.Metrics.Push)
+	authServer.tracer = newTracer(AppConfig.Tracing)
+	if authServer.signing, err = newSigningBackend(authServer, AppConfig.Signing); err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize token signing")
+	}
+	if stateless == nil {
+		authServer.keyRotator = newKeyRotator(authServer, AppConfig.Signing.Rotation)
+	}
+	if authServer.shadowValidation, err = newShadowValidator(authServer, AppConfig.ShadowValidation); err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize shadow validation")
+	}
+	authServer.grantLimits = NewGrantRateLimiter(AppConfig.RateLimiting.Grants)
+	authServer.clientLimits = middleware.NewRateLimiter(AppConfig.RateLimiting.ClientRPS, AppConfig.RateLimiting.ClientBurst)
+	if AppConfig.RateLimiting.FailedAuthPerMinute > 0 {
+		authServer.failedAuth = NewFailedAuthLimiter(AppConfig.RateLimiting.FailedAuthPerMinute, max(AppConfig.RateLimiting.FailedAuthBurst, 1))
+	}
+	authServer.rateLimitSnapshots = newRateLimitSnapshots(AppConfig.RateLimiting.Persistence, AppConfig.Redis)
+	authServer.registerRateLimiters()
+
+	// Periodically reload endpoints, delegation policies and signing keys so changes made in the
+	// database take effect.
+	// Stateless mode reloads its bundle instead
+	go func() {
+		if stateless != nil {
+			return
+		}
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				authServer.populateEndpointsCache()
+				authServer.populateDelegationCache()
+				authServer.populateSigningKeys()
+				authServer.populateWebhookSubscriptions()
+			}
+		}
+	}()
+
+	// Start periodic cleanup of expired token cache entries
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			tokenCache.CleanExpired()
+			authServer.validationResults.CleanExpired()
+			authServer.apiKeyCache.CleanExpired()
+		}
+	}()
+
+	log.Info().Msg("Auth server initialized successfully")
+	return authServer
+}
+
+// Shutdown stops the server in phases: drain the public listeners for up to
+// shutdown.drain_timeout_seconds, run shutdown hooks and stop background work including a last token
+// batch flush, close the database, and finally shut the internal listener down
+func (s *authServer) Shutdown() error {
+	// Phase 1: stop accepting connections on the public listeners and let requests in flight finish
+	// against a working server
+	drainTimeout := time.Duration(AppConfig.Shutdown.DrainTimeoutSeconds) * time.Second
+	if drainTimeout <= 0 {
+		drainTimeout = 30 * time.Second
+	}
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	drainErr := errors.Join(drainServer(drainCtx, s.httpSrv, "HTTP"), drainServer(drainCtx, s.redirectSrv, "HTTP redirect"))
+	cancelDrain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Phase 2: extensions, then background work, with the token batcher flushing what the drained
+	// requests issued
+	hookErr := errors.Join(drainErr, s.runShutdownHooks(ctx))
+
+	if s.tokenBatcher != nil {
+		log.Info().Msg("Stopping token batch writer...")
+		s.tokenBatcher.Stop()
+	}
+	s.tokenPersistence.Journal().Stop()
+
+	if s.apiKeyUsage != nil {
+		log.Info().Msg("Stopping api key usage tracker...")
+		s.apiKeyUsage.Stop()
+	}
+
+	if s.usage != nil {
+		log.Info().Msg("Stopping client usage recorder...")
+		s.usage.Stop()
+	}
+
+	s.dbHealth.Stop()
+	s.dbFailover.Stop()
+	s.anomalies.Stop()
+	s.webhooks.Stop()
+	s.revocationProbe.Stop()
+	s.canary.Stop()
+	s.tokenJanitor.Stop()
+	s.analytics.Stop()
+	s.audit.Stop()
+	s.memoryWatchdog.Stop()
+	s.metricsPusher.Stop()
+	s.tracer.Stop()
+	s.keyRotator.Stop()
+	s.stateless.Stop()
+	s.rateLimitSnapshots.Stop()
+	if s.clientLimits != nil {
+		s.clientLimits.Stop()
+	}
+	s.failedAuth.Stop()
+	s.grantLimits.Stop()
+
+	if s.clientCache != nil {
+		log.Info().Msg("Clearing client cache...")
+		s.clientCache.Clear()
+	}
+
+	if s.endpointCache != nil {
+		log.Info().Msg("Clearing endpoint cache...")
+		s.endpointCache.Clear()
+	}
+
+	if s.tokenCache != nil {
+		log.Info().Msg("Clearing token cache...")
+		s.tokenCache.Clear()
+	}
+
+	s.validationResults.Clear()
+
+	if s.delegationCache != nil {
+		log.Info().Msg("Clearing delegation cache...")
+		s.delegationCache.Clear()
+	}
+
+	if s.apiKeyCache != nil {
+		log.Info().Msg("Clearing api key cache...")
+		s.apiKeyCache.Clear()
+	}
+
+	if s.clientGroups != nil {
+		log.Info().Msg("Clearing client group cache...")
+		s.clientGroups.Clear()
+	}
+
+	// Phase 3: nothing uses the database any more
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
+			log.Warn().Err(err).Msg("error closing database connection")
+		}
+	}
+
+	// Phase 4: the internal listener kept serving health and metrics until now
+	return errors.Join(hookErr, drainServer(ctx, s.adminSrv, "internal admin"))
+}
+
+// drainServer shuts srv down, waiting for its requests in flight until ctx ends and then closing the
+// connections still open
+func drainServer(ctx context.Context, srv *http.Server, name string) error {
+	if srv == nil {
+		return nil
+	}
+	log.Info().Str("server", name).Msg("Draining server...")
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Str("server", name).Msg("Server did not drain in time, closing remaining connections")
+		srv.Close()
+		return fmt.Errorf("%s server shutdown error: %w", name, err)
+	}
+	log.Info().Str("server", name).Msg("Server shutdown complete")
+	return nil
+}
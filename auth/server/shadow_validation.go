@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Shadow validation outcomes, the values of shadow_validations_total's outcome label
+const (
+	ShadowAgree         = "agree"          // both configurations accepted, or both rejected, the token
+	ShadowPrimaryOnly   = "primary_only"   // the live configuration accepted a token the candidate would reject
+	ShadowCandidateOnly = "candidate_only" // the candidate would accept a token the live configuration rejects
+)
+
+// shadowValidator verifies tokens a second time with a candidate verification configuration, e.g. the
+// key or algorithm a signing migration moves to, and records where it disagrees with the live result.
+// The live result is always the one served; operators cut over once the disagreements are understood.
+// Validations answered from the validation result cache are not compared again
+type shadowValidator struct {
+	cfg     shadow_validation
+	key     any         // candidate secret or public key
+	methods []string    // algorithms the candidate accepts
+	current jwt.Keyfunc // the live key lookup, when the candidate also accepts current tokens
+
+	results *prometheus.CounterVec // shadow_validations_total by outcome
+}
+
+func newShadowValidator(as *authServer, cfg shadow_validation) (*shadowValidator, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.SamplePercent <= 0 || cfg.SamplePercent > 100 {
+		cfg.SamplePercent = 100
+	}
+	sv := &shadowValidator{cfg: cfg}
+
+	switch {
+	case cfg.PublicKeyFile != "":
+		pem, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("shadow_validation.public_key_file: %v", err)
+		}
+		if sv.key, err = parseBundlePublicKey(pem); err != nil {
+			return nil, fmt.Errorf("shadow_validation.public_key_file: %v", err)
+		}
+		sv.methods = bundleMethods(sv.key)
+	case cfg.SecretEnv != "":
+		secret := os.Getenv(cfg.SecretEnv)
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("shadow_validation.secret_env: %s must hold a secret of at least 32 characters", cfg.SecretEnv)
+		}
+		sv.key = []byte(secret)
+		sv.methods = []string{jwt.SigningMethodHS256.Alg()}
+	case !cfg.AcceptCurrentKeys:
+		return nil, fmt.Errorf("shadow_validation needs a public_key_file, a secret_env or accept_current_keys")
+	}
+	if len(cfg.Algorithms) > 0 {
+		sv.methods = cfg.Algorithms
+	}
+	if cfg.AcceptCurrentKeys {
+		sv.current = as.verificationKey
+		if !slices.Contains(sv.methods, jwt.SigningMethodHS256.Alg()) {
+			sv.methods = append(sv.methods, jwt.SigningMethodHS256.Alg())
+		}
+	}
+	return sv, nil
+}
+
+// Start records outcomes in results. Metrics must be registered first
+func (sv *shadowValidator) Start(results *prometheus.CounterVec) {
+	if sv == nil {
+		return
+	}
+	sv.results = results
+}
+
+// candidateKey is the jwt.Keyfunc of the candidate configuration. HMAC tokens are checked against both
+// the candidate secret and, with accept_current_keys, the key the live configuration would use
+func (sv *shadowValidator) candidateKey(token *jwt.Token) (any, error) {
+	_, hmac := token.Method.(*jwt.SigningMethodHMAC)
+	if !hmac || sv.current == nil {
+		if sv.key == nil {
+			return nil, fmt.Errorf("candidate has no key for %v", token.Header["alg"])
+		}
+		return sv.key, nil
+	}
+	current, err := sv.current(token)
+	if secret, ok := sv.key.([]byte); ok {
+		keys := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{secret}}
+		if err == nil {
+			keys.Keys = append(keys.Keys, current)
+		}
+		return keys, nil
+	}
+	return current, err
+}
+
+// Compare verifies tokenString with the candidate configuration and records whether it agrees with
+// primaryErr, the live configuration's verdict on the token's signature and claims. It returns the
+// outcome, or "" when the token was not sampled
+func (sv *shadowValidator) Compare(ctx context.Context, tokenString string, primaryErr error) string {
+	if sv == nil || (sv.cfg.SamplePercent < 100 && rand.Float64()*100 >= sv.cfg.SamplePercent) {
+		return ""
+	}
+	claims := &Claims{}
+	_, candidateErr := jwt.ParseWithClaims(tokenString, claims, sv.candidateKey, jwt.WithValidMethods(sv.methods))
+
+	outcome := ShadowAgree
+	switch {
+	case primaryErr == nil && candidateErr != nil:
+		outcome = ShadowPrimaryOnly
+	case primaryErr != nil && candidateErr == nil:
+		outcome = ShadowCandidateOnly
+	}
+	if sv.results != nil {
+		sv.results.WithLabelValues(outcome).Inc()
+	}
+
+	if outcome != ShadowAgree {
+		// The claims may not have verified, but they identify the token well enough to investigate
+		var alg, kid string
+		if unverified, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{}); err == nil {
+			alg, _ = unverified.Header["alg"].(string)
+			kid, _ = unverified.Header["kid"].(string)
+		}
+		logger := GetContextLogger(ctx)
+		event := logger.Warn().
+			Str("outcome", outcome).
+			Str("client_id", claims.ClientID).
+			Str("token_id", claims.TokenID).
+			Str("alg", alg).
+			Str("kid", kid)
+		if primaryErr != nil {
+			event = event.Str("primary_error", primaryErr.Error())
+		}
+		if candidateErr != nil {
+			event = event.Str("candidate_error", candidateErr.Error())
+		}
+		event.Msg("Shadow validation disagreed with the live result")
+	}
+	return outcome
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// shutdownHooks are extension callbacks run by Shutdown
+type shutdownHooks struct {
+	mu    sync.Mutex
+	hooks []func(ctx context.Context) error
+}
+
+// RegisterOnShutdown adds hook to the graceful shutdown sequence, for extensions such as exporters,
+// dispatchers or custom stores. Hooks run once the public listeners have drained, before the
+// server's own components stop, so they can still use the database, caches and token batcher. They run one at a
+// time in reverse order of registration, like deferred calls, so an extension registered after one
+// it depends on stops first. Every hook gets the same ctx, carrying Shutdown's deadline; a failing
+// hook does not stop the others and its error is returned by Shutdown
+func (s *authServer) RegisterOnShutdown(hook func(ctx context.Context) error) {
+	s.shutdownHooks.mu.Lock()
+	defer s.shutdownHooks.mu.Unlock()
+	s.shutdownHooks.hooks = append(s.shutdownHooks.hooks, hook)
+}
+
+// runShutdownHooks runs the registered hooks, newest first, and joins their errors
+func (s *authServer) runShutdownHooks(ctx context.Context) error {
+	s.shutdownHooks.mu.Lock()
+	hooks := s.shutdownHooks.hooks
+	s.shutdownHooks.hooks = nil
+	s.shutdownHooks.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := runShutdownHook(ctx, hooks[i]); err != nil {
+			log.Error().Err(err).Int("hook", i).Msg("Shutdown hook failed")
+			errs = append(errs, fmt.Errorf("shutdown hook %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runShutdownHook turns a panicking hook into an error so the rest of the shutdown still happens
+func runShutdownHook(ctx context.Context, hook func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return hook(ctx)
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token signing algorithms, set by signing.algorithm
+const (
+	SigningHS256 = "HS256" // JWT_SECRET or the active managed signing key; validators need the secret
+	SigningRS256 = "RS256" // RSA private key from signing.private_key_file; validators need only the JWKS
+	SigningES256 = "ES256" // P-256 ECDSA private key from signing.private_key_file
+)
+
+// jwksPath is where validators fetch the public keys of asymmetrically signed tokens
+const jwksPath = "/.well-known/jwks.json"
+
+// signingBackend signs issued tokens and verifies the tokens it signed
+type signingBackend interface {
+	// SigningKey returns the method, kid and key to sign a new token with; kid is empty for none
+	SigningKey() (jwt.SigningMethod, string, any)
+	// VerificationKey is the jwt.Keyfunc for tokens signed by this backend
+	VerificationKey(token *jwt.Token) (any, error)
+	// PublicKeys are published at the JWKS endpoint; HMAC has none
+	PublicKeys() []JSONWebKey
+}
+
+// hmacBackend signs with the active managed signing key, or JWT_SECRET when none is active
+type hmacBackend struct {
+	as *authServer
+}
+
+func (hb hmacBackend) SigningKey() (jwt.SigningMethod, string, any) {
+	kid, secret := hb.as.signWith()
+	return jwt.SigningMethodHS256, kid, secret
+}
+
+func (hb hmacBackend) VerificationKey(token *jwt.Token) (any, error) {
+	return hb.as.hmacVerificationKey(token)
+}
+
+func (hb hmacBackend) PublicKeys() []JSONWebKey {
+	return nil
+}
+
+// asymmetricBackend signs with a private key loaded at startup, so only this server can issue tokens
+// while anyone holding the published public key can verify them
+type asymmetricBackend struct {
+	method jwt.SigningMethod
+	kid    string
+	key    crypto.Signer
+}
+
+func (ab *asymmetricBackend) SigningKey() (jwt.SigningMethod, string, any) {
+	return ab.method, ab.kid, ab.key
+}
+
+func (ab *asymmetricBackend) VerificationKey(token *jwt.Token) (any, error) {
+	if token.Method.Alg() != ab.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	if kid, ok := token.Header["kid"].(string); ok && kid != ab.kid {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return ab.key.Public(), nil
+}
+
+func (ab *asymmetricBackend) PublicKeys() []JSONWebKey {
+	jwk, _ := newJSONWebKey(ab.key.Public(), ab.method.Alg(), ab.kid)
+	return []JSONWebKey{jwk}
+}
+
+// newSigningBackend builds the backend signing.algorithm names
+func newSigningBackend(as *authServer, cfg signing) (signingBackend, error) {
+	if cfg.Algorithm == "" || cfg.Algorithm == SigningHS256 {
+		return hmacBackend{as: as}, nil
+	}
+	if cfg.PrivateKeyFile == "" {
+		return nil, fmt.Errorf("signing.private_key_file is required for %s", cfg.Algorithm)
+	}
+	pem, err := os.ReadFile(cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("signing.private_key_file: %v", err)
+	}
+
+	backend := &asymmetricBackend{kid: cfg.KeyID}
+	switch cfg.Algorithm {
+	case SigningRS256:
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("signing.private_key_file is not a PEM RSA private key: %v", err)
+		}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("signing.private_key_file: RS256 needs an RSA key of at least 2048 bits, got %d", key.N.BitLen())
+		}
+		backend.method, backend.key = jwt.SigningMethodRS256, key
+	case SigningES256:
+		key, err := jwt.ParseECPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("signing.private_key_file is not a PEM ECDSA private key: %v", err)
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("signing.private_key_file: ES256 needs a P-256 key, got %s", key.Curve.Params().Name)
+		}
+		backend.method, backend.key = jwt.SigningMethodES256, key
+	default:
+		return nil, fmt.Errorf("signing.algorithm must be HS256, RS256 or ES256, got %q", cfg.Algorithm)
+	}
+
+	if backend.kid == "" {
+		jwk, err := newJSONWebKey(backend.key.Public(), backend.method.Alg(), "")
+		if err != nil {
+			return nil, err
+		}
+		backend.kid = jwk.Thumbprint()
+	}
+	return backend, nil
+}
+
+// signingBackend returns the configured backend; servers built without one sign with HMAC
+func (as *authServer) signingBackend() signingBackend {
+	if as.signing == nil {
+		return hmacBackend{as: as}
+	}
+	return as.signing
+}
+
+// verificationKey is the jwt.Keyfunc for validateJWT. HS256 tokens stay verifiable after switching to
+// an asymmetric algorithm while signing.accept_hmac is on, so tokens issued before the switch expire
+// naturally
+func (as *authServer) verificationKey(token *jwt.Token) (any, error) {
+	backend := as.signingBackend()
+	if _, isHMAC := backend.(hmacBackend); !isHMAC {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			if !AppConfig.Signing.AcceptHMAC {
+				return nil, fmt.Errorf("HS256 tokens are no longer accepted")
+			}
+			return as.hmacVerificationKey(token)
+		}
+	}
+	return backend.VerificationKey(token)
+}
+
+// JSONWebKey is a public key in RFC 7517 form
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // EC curve
+	X         string `json:"x,omitempty"`   // EC coordinates
+	Y         string `json:"y,omitempty"`
+}
+
+func newJSONWebKey(public crypto.PublicKey, alg, kid string) (JSONWebKey, error) {
+	jwk := JSONWebKey{Use: "sig", Algorithm: alg, KeyID: kid}
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encode(key.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecdhKey, err := key.ECDH()
+		if err != nil {
+			return JSONWebKey{}, err
+		}
+		// Uncompressed point: 0x04 || X || Y, each coordinate padded to the curve size
+		point := ecdhKey.Bytes()[1:]
+		jwk.KeyType, jwk.Curve = "EC", key.Curve.Params().Name
+		jwk.X, jwk.Y = encode(point[:len(point)/2]), encode(point[len(point)/2:])
+	default:
+		return JSONWebKey{}, fmt.Errorf("unsupported public key type %T", public)
+	}
+	return jwk, nil
+}
+
+// PublicKey is the key jwk describes; RSA and NIST-curve EC keys are supported
+func (jwk JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch jwk.KeyType {
+	case "RSA":
+		n, errN := decode(jwk.N)
+		e, errE := decode(jwk.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA key")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key of %d bits is too short", key.N.BitLen())
+		}
+		return key, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, errX := decode(jwk.X)
+		y, errY := decode(jwk.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+}
+
+// Thumbprint is the RFC 7638 SHA-256 thumbprint of the key, used as its default kid
+func (jwk JSONWebKey) Thumbprint() string {
+	// Only the required members, in lexicographic order
+	var members []byte
+	if jwk.KeyType == "RSA" {
+		members, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N})
+	} else {
+		members, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y})
+	}
+	sum := sha256.Sum256(members)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS handler: the public keys validators need for asymmetrically signed tokens. It is empty under
+// HS256, whose tokens can only be verified with the shared secret
+func (as *authServer) jwksHandler(c *gin.Context) {
+	keys := as.signingBackend().PublicKeys()
+	if keys == nil {
+		keys = []JSONWebKey{}
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,523 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Signing key states. A pending key is accepted for verification but not yet used to sign, so it can
+// reach every instance before promotion; an inactive key was replaced but still verifies the tokens it
+// signed; a retired key is rejected outright
+const (
+	SigningKeyPending  = "pending"
+	SigningKeyActive   = "active"
+	SigningKeyInactive = "inactive"
+	SigningKeyRetired  = "retired"
+)
+
+const signingKeyBytes = 32
+
+// minSigningKeyPending is how long a new key must stay pending before it can sign: the periodic
+// reload interval, so every instance has loaded it and accepts the tokens it signs
+const minSigningKeyPending = 5 * time.Minute
+
+// SigningKey is an HS256 key identified by the kid header of the tokens it signs. The secret never
+// leaves the server: it is stored sealed with JWT_SECRET and omitted from API responses
+type SigningKey struct {
+	KeyID       string     `json:"kid"`
+	Algorithm   string     `json:"alg"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+	secret      []byte
+}
+
+// verifies reports whether tokens carrying this key's kid are still accepted
+func (k *SigningKey) verifies() bool {
+	return k.Status != SigningKeyRetired
+}
+
+// signingKeyRing holds the keys loaded from signing_keys. When it has no active key, tokens are signed
+// with JWT_SECRET and no kid, as they were before keys were managed
+type signingKeyRing struct {
+	mu   sync.RWMutex
+	keys map[string]*SigningKey // kid -> key
+}
+
+func newSigningKeyRing() *signingKeyRing {
+	return &signingKeyRing{
+		keys: make(map[string]*SigningKey),
+	}
+}
+
+// Replace atomically swaps the loaded keys
+func (kr *signingKeyRing) Replace(keys []*SigningKey) {
+	byID := make(map[string]*SigningKey, len(keys))
+	for _, key := range keys {
+		byID[key.KeyID] = key
+	}
+	kr.mu.Lock()
+	kr.keys = byID
+	kr.mu.Unlock()
+}
+
+// Set stores or replaces a key
+func (kr *signingKeyRing) Set(key *SigningKey) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys[key.KeyID] = key
+}
+
+// Get retrieves a key by kid
+func (kr *signingKeyRing) Get(kid string) (*SigningKey, bool) {
+	if kr == nil {
+		return nil, false
+	}
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, exists := kr.keys[kid]
+	return key, exists
+}
+
+// Active returns the key new tokens are signed with, if one has been promoted
+func (kr *signingKeyRing) Active() (*SigningKey, bool) {
+	if kr == nil {
+		return nil, false
+	}
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	for _, key := range kr.keys {
+		if key.Status == SigningKeyActive {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Activate marks kid as the active key in the ring, demoting the one it replaces, and returns the
+// replaced key's kid
+func (kr *signingKeyRing) Activate(kid string, at time.Time) string {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	previous := ""
+	for id, key := range kr.keys {
+		if key.Status == SigningKeyActive && id != kid {
+			demoted := *key
+			demoted.Status = SigningKeyInactive
+			kr.keys[id] = &demoted
+			previous = id
+		}
+	}
+	if key, ok := kr.keys[kid]; ok {
+		activated := *key
+		activated.Status = SigningKeyActive
+		activated.ActivatedAt = &at
+		kr.keys[kid] = &activated
+	}
+	return previous
+}
+
+// Retire marks kid as retired in the ring
+func (kr *signingKeyRing) Retire(kid string, at time.Time) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if key, ok := kr.keys[kid]; ok {
+		retired := *key
+		retired.Status = SigningKeyRetired
+		retired.RetiredAt = &at
+		kr.keys[kid] = &retired
+	}
+}
+
+// List returns all keys, newest first
+func (kr *signingKeyRing) List() []*SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	out := make([]*SigningKey, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		out = append(out, key)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// signWith returns the kid and secret to sign a new token with; kid is empty when falling back to JWT_SECRET
+func (as *authServer) signWith() (string, []byte) {
+	if key, ok := as.signingKeys.Active(); ok {
+		return key.KeyID, key.secret
+	}
+	return "", as.jwtSecret
+}
+
+// hmacVerificationKey is the jwt.Keyfunc for HS256 tokens: tokens with a kid need a known, unretired
+// key, tokens without one, or with jwt_headers.kid, are checked against JWT_SECRET and, while it is
+// set after a rotation, JWT_SECRET_PREVIOUS, unless signing.retire_jwt_secret has retired them
+func (as *authServer) hmacVerificationKey(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return as.legacyVerificationKey()
+	}
+	key, exists := as.signingKeys.Get(kid)
+	if !exists && kid == AppConfig.JWTHeaders.Kid {
+		return as.legacyVerificationKey()
+	}
+	if !exists {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if !key.verifies() {
+		return nil, fmt.Errorf("signing key %q has been retired", kid)
+	}
+	return key.secret, nil
+}
+
+// legacyVerificationKey is the key for tokens signed with JWT_SECRET. With signing.retire_jwt_secret
+// they are rejected once a managed key signs instead; until then JWT_SECRET still signs, so its
+// tokens keep verifying
+func (as *authServer) legacyVerificationKey() (any, error) {
+	if AppConfig.Signing.RetireJWTSecret {
+		if _, ok := as.signingKeys.Active(); ok {
+			return nil, errors.New("tokens signed with JWT_SECRET are no longer accepted")
+		}
+	}
+	return as.jwtSecrets(), nil
+}
+
+// jwtSecrets is the verification key for tokens signed with JWT_SECRET, which during the grace window
+// after a rotation also accepts the previous secret
+func (as *authServer) jwtSecrets() any {
+	if as.previousJWTSecret == nil {
+		return as.jwtSecret
+	}
+	return jwt.VerificationKeySet{Keys: []jwt.VerificationKey{as.jwtSecret, as.previousJWTSecret}}
+}
+
+// signingKeyCipher derives the AES-GCM cipher that seals key material at rest from a JWT secret, so a
+// database dump alone cannot be used to mint tokens
+func signingKeyCipher(jwtSecret []byte) (cipher.AEAD, error) {
+	sealKey := sha256.Sum256(jwtSecret)
+	block, err := aes.NewCipher(sealKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSigningKey seals key material under JWT_SECRET
+func (as *authServer) sealSigningKey(secret []byte) (string, error) {
+	gcm, err := signingKeyCipher(as.jwtSecret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, secret, nil)), nil
+}
+
+// openSigningKey unseals key material sealed under JWT_SECRET or, after a rotation, JWT_SECRET_PREVIOUS
+func (as *authServer) openSigningKey(sealed string) ([]byte, error) {
+	secret, _, err := as.openSealed(sealed)
+	return secret, err
+}
+
+// openSealed is openSigningKey also reporting whether the material was sealed under the previous
+// secret, and so should be sealed again before JWT_SECRET_PREVIOUS is removed
+func (as *authServer) openSealed(sealed string) ([]byte, bool, error) {
+	secret, err := openSealedWith(as.jwtSecret, sealed)
+	if err != nil && as.previousJWTSecret != nil {
+		if secret, previousErr := openSealedWith(as.previousJWTSecret, sealed); previousErr == nil {
+			return secret, true, nil
+		}
+	}
+	return secret, false, err
+}
+
+func openSealedWith(jwtSecret []byte, sealed string) ([]byte, error) {
+	gcm, err := signingKeyCipher(jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed key is too short")
+	}
+	return gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+}
+
+func (as *authServer) loadSigningKeys() ([]*SigningKey, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := as.db.QueryContext(ctx, `SELECT kid, algorithm, key_material, status, created_at, activated_at, retired_at FROM signing_keys`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys, stale []*SigningKey
+	for rows.Next() {
+		key := &SigningKey{}
+		var sealed string
+		var previous bool
+		var activatedAt, retiredAt sql.NullTime
+		if err := rows.Scan(&key.KeyID, &key.Algorithm, &sealed, &key.Status, &key.CreatedAt, &activatedAt, &retiredAt); err != nil {
+			log.Error().Str("kid", key.KeyID).Msgf("failed to retrieve row while loading signing keys: %s", err)
+			continue
+		}
+		if key.secret, previous, err = as.openSealed(sealed); err != nil {
+			// Sealed under a different JWT_SECRET; skipping it leaves its tokens unverifiable rather than forgeable
+			log.Error().Err(err).Str("kid", key.KeyID).Msg("failed to unseal signing key, skipping")
+			continue
+		}
+		if activatedAt.Valid {
+			key.ActivatedAt = &activatedAt.Time
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = &retiredAt.Time
+		}
+		if previous && key.Status != SigningKeyRetired {
+			stale = append(stale, key)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Keys sealed before JWT_SECRET was rotated are sealed again under the new secret
+	for _, key := range stale {
+		sealed, err := as.sealSigningKey(key.secret)
+		if err == nil {
+			_, err = as.db.ExecContext(ctx, "UPDATE signing_keys SET key_material = :1 WHERE kid = :2", sealed, key.KeyID)
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("kid", key.KeyID).Msg("failed to reseal signing key under the new JWT_SECRET")
+		}
+	}
+	return keys, nil
+}
+
+// populateSigningKeys reloads the key ring. It runs at startup and with the periodic endpoint reload,
+// which is how keys added or promoted through another instance's admin API reach this one
+func (s *authServer) populateSigningKeys() {
+	keys, err := s.loadSigningKeys()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to populate signing keys")
+		return
+	}
+	if s.signingKeys == nil {
+		s.signingKeys = newSigningKeyRing()
+	}
+	s.signingKeys.Replace(keys)
+	s.cacheRefreshes.Mark("signing_keys")
+
+	active := ""
+	if key, ok := s.signingKeys.Active(); ok {
+		active = key.KeyID
+	}
+	log.Info().Int("keys", len(keys)).Str("active_kid", active).Msg("Signing keys loaded")
+}
+
+// newSigningKey generates a pending key
+func newSigningKey() (*SigningKey, error) {
+	secret := make([]byte, signingKeyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		KeyID:     generateRandomString(8),
+		Algorithm: jwt.SigningMethodHS256.Alg(),
+		Status:    SigningKeyPending,
+		CreatedAt: time.Now(),
+		secret:    secret,
+	}, nil
+}
+
+func (as *authServer) insertSigningKey(key *SigningKey) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	sealed, err := as.sealSigningKey(key.secret)
+	if err != nil {
+		return err
+	}
+	_, err = as.db.ExecContext(ctx, "INSERT INTO signing_keys (kid, algorithm, key_material, status, created_at) VALUES (:1, :2, :3, :4, :5)",
+		key.KeyID, key.Algorithm, sealed, key.Status, key.CreatedAt)
+	return err
+}
+
+// activateSigningKey makes kid the signing key and demotes the previous one to verification only
+func (as *authServer) activateSigningKey(kid string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction for signing key activation")
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE signing_keys SET status = :1 WHERE status = :2", SigningKeyInactive, SigningKeyActive); err != nil {
+		return fmt.Errorf("activateSigningKey %s: demoting active key: %v", kid, err)
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE signing_keys SET status = :1, activated_at = :2 WHERE kid = :3 AND status IN (:4, :5)",
+		SigningKeyActive, at, kid, SigningKeyPending, SigningKeyInactive)
+	if err != nil {
+		return fmt.Errorf("activateSigningKey %s: %v", kid, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error().Err(err).Msg("Failed to commit signing key activation transaction")
+		return fmt.Errorf("failed to commit signing key activation: %w", err)
+	}
+	return nil
+}
+
+func (as *authServer) retireSigningKey(kid string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.db.ExecContext(ctx, "UPDATE signing_keys SET status = :1, retired_at = :2 WHERE kid = :3 AND status IN (:4, :5)",
+		SigningKeyRetired, at, kid, SigningKeyPending, SigningKeyInactive)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// List signing keys handler (admin)
+func (as *authServer) listSigningKeysHandler(c *gin.Context) {
+	active := ""
+	if key, ok := as.signingKeys.Active(); ok {
+		active = key.KeyID
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": as.signingKeys.List(), "active_kid": active})
+}
+
+// Create signing key handler (admin): generates a pending key. Other instances pick it up on their next
+// reload, so it can only be promoted once it has been pending for minSigningKeyPending
+func (as *authServer) createSigningKeyHandler(c *gin.Context) {
+	key, err := newSigningKey()
+	if err != nil {
+		RespondWithError(c, ErrInternalServerError("Failed to generate signing key").WithOriginalError(err))
+		return
+	}
+	if err := as.insertSigningKey(key); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	as.signingKeys.Set(key)
+
+	logger := GetRequestLogger(c)
+	logger.Info().Str("kid", key.KeyID).Msg("signing key created")
+	c.JSON(http.StatusCreated, key)
+}
+
+// Activate signing key handler (admin): new tokens are signed with the key from now on, and the key
+// it replaces keeps verifying the tokens it already signed
+func (as *authServer) activateSigningKeyHandler(c *gin.Context) {
+	kid := c.Param("kid")
+	key, exists := as.signingKeys.Get(kid)
+	if !exists {
+		RespondWithError(c, ErrNotFoundError("Signing key not found"))
+		return
+	}
+	switch key.Status {
+	case SigningKeyActive:
+		RespondWithError(c, ErrConflictError("Signing key is already active"))
+		return
+	case SigningKeyRetired:
+		RespondWithError(c, ErrConflictError("Retired signing keys cannot be activated"))
+		return
+	}
+
+	now := time.Now()
+	if wait := key.CreatedAt.Add(minSigningKeyPending).Sub(now); key.Status == SigningKeyPending && wait > 0 {
+		RespondWithError(c, ErrConflictError("Signing key is too new to activate").
+			WithDetails(fmt.Sprintf("other instances load new keys within %s; retry in %s", minSigningKeyPending, wait.Round(time.Second))))
+		return
+	}
+	if err := as.activateSigningKey(kid, now); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrConflictError("Signing key changed state; reload and retry"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	previous := as.signingKeys.Activate(kid, now)
+	activated, _ := as.signingKeys.Get(kid)
+
+	logger := GetRequestLogger(c)
+	logger.Info().Str("kid", kid).Str("previous_kid", previous).Msg("signing key activated")
+	c.JSON(http.StatusOK, activated)
+}
+
+// Retire signing key handler (admin): tokens carrying the key's kid are rejected from now on, so retire a
+// replaced key only once the tokens it signed have expired. The active key must be replaced first
+func (as *authServer) retireSigningKeyHandler(c *gin.Context) {
+	kid := c.Param("kid")
+	key, exists := as.signingKeys.Get(kid)
+	if !exists {
+		RespondWithError(c, ErrNotFoundError("Signing key not found"))
+		return
+	}
+	switch key.Status {
+	case SigningKeyActive:
+		RespondWithError(c, ErrConflictError("The active signing key cannot be retired").WithDetails("activate another key first"))
+		return
+	case SigningKeyRetired:
+		RespondWithError(c, ErrConflictError("Signing key is already retired"))
+		return
+	}
+
+	now := time.Now()
+	if err := as.retireSigningKey(kid, now); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrConflictError("Signing key changed state; reload and retry"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	as.signingKeys.Retire(kid, now)
+	as.validationResults.Clear()
+	retired, _ := as.signingKeys.Get(kid)
+
+	logger := GetRequestLogger(c)
+	logger.Info().Str("kid", kid).Msg("signing key retired")
+	c.JSON(http.StatusOK, retired)
+}
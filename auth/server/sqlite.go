@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver; pure Go, no cgo
+)
+
+// sqliteURL opens database.path, or a private in-memory database for ":memory:" or no path. In-memory
+// databases use a shared cache so every pooled connection sees the same data, and last as long as the
+// process
+func sqliteURL() string {
+	path := AppConfig.Database.Path
+	query := url.Values{}
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "busy_timeout(5000)")
+	if path == "" || path == ":memory:" {
+		query.Set("mode", "memory")
+		query.Set("cache", "shared")
+		return "file:auth?" + query.Encode()
+	}
+	query.Add("_pragma", "journal_mode(WAL)")
+	return "file:" + path + "?" + query.Encode()
+}
+
+// sqliteSchemaDDL is postgresSchemaDDL with SQLite's auto-increment keys; every other type it accepts
+var sqliteSchemaDDL = sqliteDDL(postgresSchemaDDL)
+
+func sqliteDDL(statements []string) []string {
+	translated := make([]string, len(statements))
+	for i, statement := range statements {
+		translated[i] = strings.ReplaceAll(statement, "BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY", "INTEGER PRIMARY KEY AUTOINCREMENT")
+	}
+	return translated
+}
+
+// databaseFixture is the database.seed_file format: clients and endpoint mappings as the admin API
+// takes them, with the secret each client authenticates with
+type databaseFixture struct {
+	Clients   []fixtureClient   `json:"clients"`
+	Endpoints []EndpointRequest `json:"endpoints"`
+}
+
+type fixtureClient struct {
+	ClientRequest
+	ClientSecret string `json:"client_secret"` // plaintext; stored hashed
+}
+
+// seedDatabase loads the fixture at path into a database without clients. A database that already has
+// clients, e.g. a file kept from an earlier run, is left alone
+func (as *authServer) seedDatabase(ctx context.Context, path string) error {
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("database.seed_file: %w", err)
+	}
+	var fixture databaseFixture
+	if err := json.Unmarshal(raw, &fixture); err != nil {
+		return fmt.Errorf("database.seed_file %s: %w", path, err)
+	}
+
+	var existing int
+	if err := as.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM clients").Scan(&existing); err != nil {
+		return fmt.Errorf("database.seed_file: %w", err)
+	}
+	if existing > 0 {
+		log.Info().Int("clients", existing).Msg("Database already has clients, not seeding it")
+		return nil
+	}
+
+	for i := range fixture.Clients {
+		client := &fixture.Clients[i]
+		if client.ClientID == "" || client.ClientSecret == "" {
+			return fmt.Errorf("database.seed_file: client %d needs a client_id and client_secret", i)
+		}
+		if err := client.Validate(); err != nil {
+			return fmt.Errorf("database.seed_file: client %s: %w", client.ClientID, err)
+		}
+		hash, err := hashClientSecret(client.ClientSecret)
+		if err != nil {
+			return err
+		}
+		if err := as.createClient(ctx, &client.ClientRequest, hash); err != nil {
+			return fmt.Errorf("database.seed_file: %w", err)
+		}
+	}
+	for i := range fixture.Endpoints {
+		req := &fixture.Endpoints[i]
+		if err := req.Validate(); err != nil {
+			return fmt.Errorf("database.seed_file: endpoint %s: %w", req.Url, err)
+		}
+		if err := as.insertEndpoint(ctx, req.endpoint()); err != nil {
+			return fmt.Errorf("database.seed_file: endpoint %s: %w", req.Url, err)
+		}
+	}
+	log.Info().Int("clients", len(fixture.Clients)).Int("endpoints", len(fixture.Endpoints)).Str("file", path).Msg("Seeded the database")
+	return nil
+}
@@ -0,0 +1,417 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// errStatelessNoDatabase is what every database call returns in stateless mode. Features that need
+// the database, such as the admin client API, fail with it instead of reaching for Oracle
+var errStatelessNoDatabase = errors.New("no database in stateless mode")
+
+// statelessConnector stands in for the Oracle pool in stateless mode, so the handful of features
+// without a stateless equivalent fail cleanly rather than dereference a nil pool
+type statelessConnector struct{}
+
+func (statelessConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errStatelessNoDatabase
+}
+
+func (statelessConnector) Driver() driver.Driver {
+	return statelessDriver{}
+}
+
+type statelessDriver struct{}
+
+func (statelessDriver) Open(string) (driver.Conn, error) {
+	return nil, errStatelessNoDatabase
+}
+
+// StatelessBundle is the signed payload a stateless deployment loads its clients and endpoints from.
+// It is a JWS whose claims are this struct, so any JWT tooling can produce one; `auth bundle export`
+// signs one from the database
+type StatelessBundle struct {
+	Clients   []BundleClient `json:"clients"`
+	Endpoints []*Endpoints   `json:"endpoints"`
+	jwt.RegisteredClaims
+}
+
+// BundleClient is a client as carried in a stateless bundle, with its group scopes already applied
+type BundleClient struct {
+	ClientID         string            `json:"client_id"`
+	ClientSecret     string            `json:"client_secret"`
+	AccessTokenTTL   int32             `json:"access_token_ttl"`
+	AllowedScopes    []string          `json:"allowed_scopes"`
+	DefaultScopes    []string          `json:"default_scopes,omitempty"`
+	JWTHeaders       map[string]string `json:"jwt_headers,omitempty"`
+	AllowedAudiences []string          `json:"allowed_audiences,omitempty"`
+	PublicKey        string            `json:"public_key,omitempty"` // PEM, for private_key_jwt
+	Roles            []string          `json:"roles,omitempty"`
+}
+
+// revocationList is where stateless mode records revoked token IDs. Entries expire with the token
+type revocationList interface {
+	Revoked(ctx context.Context, tokenID string) (reason string, revoked bool, err error)
+	Revoke(ctx context.Context, tokenID, reason string, ttl time.Duration) error
+	Ping(ctx context.Context) error
+}
+
+// redisRevocationList keeps one key per revoked token, holding the revocation reason
+type redisRevocationList struct {
+	client *redisClient
+	prefix string
+}
+
+func (rl *redisRevocationList) Revoked(ctx context.Context, tokenID string) (string, bool, error) {
+	reply, err := rl.client.Do(ctx, "GET", rl.prefix+tokenID)
+	if errors.Is(err, errRedisNil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if reply == nil {
+		return "", false, nil
+	}
+	reason, err := redisString(reply)
+	return reason, true, err
+}
+
+func (rl *redisRevocationList) Revoke(ctx context.Context, tokenID, reason string, ttl time.Duration) error {
+	_, err := rl.client.Do(ctx, "SET", rl.prefix+tokenID, reason, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (rl *redisRevocationList) Ping(ctx context.Context) error {
+	_, err := rl.client.Do(ctx, "PING")
+	return err
+}
+
+// statelessStore replaces Oracle for edge deployments that cannot reach it: clients and endpoints come
+// from a signed bundle, tokens are stored nowhere, and revocations go to a shared Redis list
+type statelessStore struct {
+	cfg         stateless_config
+	bundleKey   crypto.PublicKey
+	redis       *redisClient // nil unless the bundle is read from Redis
+	revocations revocationList
+	loaded      time.Time // issued-at of the bundle in use
+	done        chan struct{}
+}
+
+func newStatelessStore(cfg stateless_config, redisCfg redis_config) (*statelessStore, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.BundlePath == "" && cfg.BundleRedisKey == "" {
+		return nil, fmt.Errorf("stateless mode needs stateless.bundle_path or stateless.bundle_redis_key")
+	}
+	pem, err := os.ReadFile(cfg.BundleKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading stateless.bundle_key_file: %w", err)
+	}
+	key, err := parseBundlePublicKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("stateless.bundle_key_file: %w", err)
+	}
+	client, err := newRedisClient(redisCfg)
+	if err != nil {
+		return nil, fmt.Errorf("stateless mode keeps revocations in redis: %w", err)
+	}
+	if cfg.RevocationKeyPrefix == "" {
+		cfg.RevocationKeyPrefix = "auth:revoked:"
+	}
+	if cfg.RefreshIntervalSeconds <= 0 {
+		cfg.RefreshIntervalSeconds = 60
+	}
+	if cfg.RevocationTTLSeconds <= 0 {
+		cfg.RevocationTTLSeconds = 86400
+	}
+	ss := &statelessStore{
+		cfg:         cfg,
+		bundleKey:   key,
+		revocations: &redisRevocationList{client: client, prefix: cfg.RevocationKeyPrefix},
+		done:        make(chan struct{}),
+	}
+	if cfg.BundleRedisKey != "" {
+		ss.redis = client
+	}
+	return ss, nil
+}
+
+// parseBundlePublicKey reads the RSA, ECDSA or Ed25519 public key bundles are verified with
+func parseBundlePublicKey(pem []byte) (crypto.PublicKey, error) {
+	if key, err := jwt.ParseEdPublicKeyFromPEM(pem); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+		return key, nil
+	}
+	key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("not a PEM RSA, ECDSA or Ed25519 public key")
+	}
+	return key, nil
+}
+
+// bundleMethods lists the signing algorithms accepted for a key, so a bundle cannot pick its own
+func bundleMethods(key any) []string {
+	switch key.(type) {
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return []string{jwt.SigningMethodEdDSA.Alg()}
+	case *ecdsa.PublicKey, *ecdsa.PrivateKey:
+		return []string{jwt.SigningMethodES256.Alg(), jwt.SigningMethodES384.Alg(), jwt.SigningMethodES512.Alg()}
+	default:
+		return []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodRS384.Alg(), jwt.SigningMethodRS512.Alg()}
+	}
+}
+
+// readBundle returns the raw bundle from its file or Redis key
+func (ss *statelessStore) readBundle(ctx context.Context) ([]byte, error) {
+	if ss.redis != nil {
+		reply, err := ss.redis.Do(ctx, "GET", ss.cfg.BundleRedisKey)
+		if err != nil {
+			return nil, fmt.Errorf("reading bundle from redis key %s: %w", ss.cfg.BundleRedisKey, err)
+		}
+		raw, err := redisString(reply)
+		return []byte(raw), err
+	}
+	return os.ReadFile(ss.cfg.BundlePath)
+}
+
+// parseBundle verifies a bundle's signature and expiry and returns its contents
+func (ss *statelessStore) parseBundle(raw []byte) (*StatelessBundle, error) {
+	bundle := &StatelessBundle{}
+	_, err := jwt.ParseWithClaims(strings.TrimSpace(string(raw)), bundle, func(*jwt.Token) (any, error) {
+		return ss.bundleKey, nil
+	}, jwt.WithValidMethods(bundleMethods(ss.bundleKey)))
+	if err != nil {
+		return nil, fmt.Errorf("bundle rejected: %w", err)
+	}
+	for _, client := range bundle.Clients {
+		if client.ClientID == "" {
+			return nil, fmt.Errorf("bundle rejected: a client has no client_id")
+		}
+		if err := validateJWTHeaders(client.JWTHeaders); err != nil {
+			return nil, fmt.Errorf("bundle rejected: client %s: %w", client.ClientID, err)
+		}
+		if client.PublicKey != "" {
+			if _, err := parseBundlePublicKey([]byte(client.PublicKey)); err != nil {
+				return nil, fmt.Errorf("bundle rejected: client %s: public_key: %w", client.ClientID, err)
+			}
+		}
+	}
+	return bundle, nil
+}
+
+// populateFromBundle loads the bundle into the client and endpoint caches. A bundle that cannot be
+// read or verified leaves the caches as they were
+func (s *authServer) populateFromBundle() error {
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	raw, err := s.stateless.readBundle(ctx)
+	if err != nil {
+		return err
+	}
+	bundle, err := s.stateless.parseBundle(raw)
+	if err != nil {
+		return err
+	}
+	issued := time.Time{}
+	if bundle.IssuedAt != nil {
+		issued = bundle.IssuedAt.Time
+	}
+	if issued.Before(s.stateless.loaded) {
+		return fmt.Errorf("bundle issued at %s is older than the one in use, issued at %s", issued, s.stateless.loaded)
+	}
+
+	clients := make([]*Clients, 0, len(bundle.Clients))
+	for _, bc := range bundle.Clients {
+		var publicKey clientPublicKey
+		publicKey.Scan(bc.PublicKey) // checked by parseBundle
+		clients = append(clients, &Clients{
+			ClientID:         bc.ClientID,
+			ClientSecret:     bc.ClientSecret,
+			AccessTokenTTL:   bc.AccessTokenTTL,
+			AllowedScopes:    bc.AllowedScopes,
+			DefaultScopes:    bc.DefaultScopes,
+			JWTHeaders:       bc.JWTHeaders,
+			AllowedAudiences: bc.AllowedAudiences,
+			PublicKey:        publicKey.key,
+			Roles:            bc.Roles,
+		})
+	}
+	endpoints := newEndpointsCache()
+	for _, endpoint := range bundle.Endpoints {
+		if endpoint.Active == 1 {
+			endpoints.Set(endpoint.Url, endpoint)
+		}
+	}
+
+	s.clientCache.Replace(clients)
+	s.endpointCache.Replace(endpoints.cache)
+	s.stateless.loaded = issued
+	s.cacheRefreshes.Mark("clients")
+	s.cacheRefreshes.Mark("endpoints")
+	log.Info().Int("clients", len(clients)).Int("endpoints", endpoints.GetSize()).Time("issued_at", issued).Msg("Stateless bundle loaded")
+	return nil
+}
+
+// Start reloads the bundle on stateless.refresh_interval_seconds until Stop is called
+func (ss *statelessStore) Start(s *authServer) {
+	if ss == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(ss.cfg.RefreshIntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ss.done:
+				return
+			case <-ticker.C:
+				if err := s.populateFromBundle(); err != nil {
+					log.Error().Err(err).Msg("Failed to reload stateless bundle, keeping the current one")
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops reloading the bundle
+func (ss *statelessStore) Stop() {
+	if ss == nil {
+		return
+	}
+	close(ss.done)
+}
+
+// pingStore checks where token state lives: the database, or the revocation list in stateless mode
+func (s *authServer) pingStore(ctx context.Context) error {
+	if s.stateless != nil {
+		return s.stateless.Ping(ctx)
+	}
+	return s.db.PingContext(ctx)
+}
+
+// Ping checks the revocation list, which stateless validation cannot do without
+func (ss *statelessStore) Ping(ctx context.Context) error {
+	return ss.revocations.Ping(ctx)
+}
+
+// Revoke lists a token as revoked until it would have expired anyway
+func (ss *statelessStore) Revoke(ctx context.Context, revokedToken RevokedToken) error {
+	ttl := time.Duration(ss.cfg.RevocationTTLSeconds) * time.Second
+	if !revokedToken.ExpiresAt.IsZero() {
+		// Outlive the token by the leeway validators might give an expired one
+		ttl = max(time.Until(revokedToken.ExpiresAt)+time.Minute, time.Second)
+	}
+	return ss.revocations.Revoke(ctx, revokedToken.TokenID, revokedToken.Reason, ttl)
+}
+
+// Revoked reports whether a token is on the revocation list
+func (ss *statelessStore) Revoked(ctx context.Context, tokenID string) (string, bool, error) {
+	return ss.revocations.Revoked(ctx, tokenID)
+}
+
+// ExportStatelessBundle signs the database's clients and endpoints into a bundle for stateless
+// deployments, as `auth bundle export` does. keyPath is a PEM RSA, ECDSA or Ed25519 private key whose
+// public half is the stateless nodes' stateless.bundle_key_file; validFor of zero never expires
+func ExportStatelessBundle(keyPath string, validFor time.Duration, out io.Writer) error {
+	pem, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	var key crypto.Signer
+	var method jwt.SigningMethod
+	if edKey, err := jwt.ParseEdPrivateKeyFromPEM(pem); err == nil {
+		key, method = edKey.(ed25519.PrivateKey), jwt.SigningMethodEdDSA
+	} else if ecKey, err := jwt.ParseECPrivateKeyFromPEM(pem); err == nil {
+		key, method = ecKey, jwt.SigningMethodES256
+		switch ecKey.Curve.Params().BitSize {
+		case 384:
+			method = jwt.SigningMethodES384
+		case 521:
+			method = jwt.SigningMethodES512
+		}
+	} else if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		key, method = rsaKey, jwt.SigningMethodRS256
+	} else {
+		return fmt.Errorf("%s is not a PEM RSA, ECDSA or Ed25519 private key", keyPath)
+	}
+
+	db, err := newDbClient(databaseURL())
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	as := &authServer{
+		ctx:            context.Background(),
+		clientCache:    newClientCache(),
+		clientGroups:   newClientGroupCache(),
+		endpointCache:  newEndpointsCache(),
+		cacheRefreshes: newCacheRefreshTracker(),
+	}
+	as.db = newInstrumentedDB(db, as)
+	as.populateClientGroups()
+	as.populateClientCache()
+	as.populateEndpointsCache()
+	if _, ok := as.cacheRefreshes.Get("clients"); !ok {
+		return fmt.Errorf("clients could not be read from the database")
+	}
+	if _, ok := as.cacheRefreshes.Get("endpoints"); !ok {
+		return fmt.Errorf("endpoints could not be read from the database")
+	}
+
+	now := time.Now()
+	bundle := StatelessBundle{Endpoints: as.endpointCache.All(), RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(now)}}
+	if validFor > 0 {
+		bundle.ExpiresAt = jwt.NewNumericDate(now.Add(validFor))
+	}
+	for _, client := range as.clientCache.All() {
+		var publicKey string
+		if client.PublicKey != nil {
+			if publicKey, err = encodePublicKeyPEM(client.PublicKey); err != nil {
+				return fmt.Errorf("client %s: public key: %w", client.ClientID, err)
+			}
+		}
+		bundle.Clients = append(bundle.Clients, BundleClient{
+			ClientID:         client.ClientID,
+			ClientSecret:     client.ClientSecret,
+			AccessTokenTTL:   client.AccessTokenTTL,
+			AllowedScopes:    client.AllowedScopes,
+			DefaultScopes:    client.DefaultScopes,
+			JWTHeaders:       client.JWTHeaders,
+			AllowedAudiences: client.AllowedAudiences,
+			PublicKey:        publicKey,
+			Roles:            client.Roles,
+		})
+	}
+
+	signed, err := jwt.NewWithClaims(method, bundle).SignedString(key)
+	if err != nil {
+		return fmt.Errorf("signing bundle: %w", err)
+	}
+	_, err = fmt.Fprintln(out, signed)
+	return err
+}
+
+// openStatelessDB returns the stand-in pool used instead of Oracle in stateless mode
+func openStatelessDB() *sql.DB {
+	return sql.OpenDB(statelessConnector{})
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"auth/auth/store"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Store is the persistence interface of package store; see there
+type Store = store.Store
+
+// Lookups of rows that do not exist; callers match them with errors.Is
+var (
+	errNoSuchClient   = store.ErrNoSuchClient
+	errNoSuchEndpoint = store.ErrNoSuchEndpoint
+)
+
+// SetStore serves the request path's client, endpoint and token lookups from s instead of the
+// configured database. Call it before Start
+func (as *authServer) SetStore(s Store) {
+	as.store = s
+}
+
+// dataStore returns the configured store; servers built without one use the database behind as.db
+func (as *authServer) dataStore() Store {
+	if as.store == nil {
+		return sqlStore{db: as.db}
+	}
+	return as.store
+}
+
+// sqlStore is the Store backed by the schema in schema.sql, on Oracle or, with its bind variables
+// rewritten by instrumentedDB, Postgres
+type sqlStore struct {
+	db *instrumentedDB
+}
+
+func (s sqlStore) ClientByID(ctx context.Context, clientID string) (_ *Clients, err error) {
+	logger := GetContextLogger(ctx)
+	ctx, done := s.db.startOperation(ctx, queryOpClientByID)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var client Clients
+	var scope, defaultScopes, audiences, roles scopeList
+	var headers jwtHeaders
+	var publicKey clientPublicKey
+	var tokenFormat sql.NullString
+
+	query := "SELECT client_id, client_secret, access_token_ttl, allowed_scopes, default_scopes, jwt_headers, allowed_audiences, public_key, token_format, roles FROM clients WHERE client_id = :1 AND deleted_at IS NULL"
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	if err := stmt.QueryRowContext(ctx, clientID).Scan(&client.ClientID, &client.ClientSecret, &client.AccessTokenTTL, &scope, &defaultScopes, &headers, &audiences, &publicKey, &tokenFormat, &roles); err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn().Str("client_id", clientID).Msg("Client not found in database")
+			return nil, fmt.Errorf("clientByID %s: %w", clientID, errNoSuchClient)
+		}
+		logger.Error().Err(err).Str("client_id", clientID).Msg("Database query failed")
+		return nil, fmt.Errorf("clientByID %s: %v", clientID, err)
+	}
+
+	client.AllowedScopes = scope
+	client.DefaultScopes = defaultScopes
+	client.JWTHeaders = headers
+	client.AllowedAudiences = audiences
+	client.PublicKey = publicKey.key
+	client.TokenFormat = tokenFormat.String
+	client.Roles = roles
+	return &client, nil
+}
+
+func (s sqlStore) TokenInfo(ctx context.Context, tokenID string) (_ *Token, err error) {
+	logger := GetContextLogger(ctx)
+	ctx, done := s.db.startOperation(ctx, queryOpTokenInfo)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := "SELECT revoked, token_type, revocation_reason FROM tokens WHERE token_id = :1"
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		logger.Error().Err(err).Str("token_id", tokenID).Msg("Failed to prepare token info query")
+		return nil, fmt.Errorf("failed to prepare token info query: %w", err)
+	}
+	defer stmt.Close()
+
+	token := &Token{TokenID: tokenID}
+	var revoked int
+	var reason sql.NullString
+	if err := stmt.QueryRowContext(ctx, tokenID).Scan(&revoked, &token.TokenType, &reason); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("token %s: %w", tokenID, errTokenNotFound)
+		}
+		logger.Error().Err(err).Str("token_id", tokenID).Msg("Failed to fetch token info")
+		return nil, fmt.Errorf("failed to fetch token info: %w", err)
+	}
+	token.Revoked, token.RevocationReason = revoked == 1, reason.String
+	return token, nil
+}
+
+func (s sqlStore) InsertTokens(ctx context.Context, tokens []Token) (err error) {
+	ctx, done := s.db.startOperation(ctx, queryOpInsertBatch)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// Begin transaction for atomic batch insert
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Int("batch_size", len(tokens)).
+			Msg("Failed to begin transaction for batch insert")
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Prepare statement for batch insert (reused for all tokens in batch)
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO tokens(token_id, token_type, jwt_token, client_id, issued_at, expires_at, family_id) VALUES (:1, :2, :3, :4, :5, :6, :7)")
+	if err != nil {
+		log.Error().
+			Err(err).
+			Int("batch_size", len(tokens)).
+			Msg("Failed to prepare batch insert statement")
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	// Execute insert for each token in batch
+	inserted := 0
+	for i, token := range tokens {
+		_, err := stmt.ExecContext(ctx, token.TokenID, token.TokenType, token.JWT_token, token.ClientID, token.IssuedAt, token.ExpiresAt, token.FamilyID)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("token_id", token.TokenID).
+				Str("client_id", token.ClientID).
+				Int("position", i).
+				Int("batch_size", len(tokens)).
+				Msg("Failed to insert token in batch")
+			return fmt.Errorf("failed to insert token at position %d: %w", i, err)
+		}
+		inserted++
+	}
+
+	// Commit transaction (atomicity ensures all or nothing)
+	if err := tx.Commit(); err != nil {
+		log.Error().
+			Err(err).
+			Int("inserted", inserted).
+			Int("batch_size", len(tokens)).
+			Msg("Failed to commit batch insert transaction")
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s sqlStore) RevokeToken(ctx context.Context, revokedToken RevokedToken) (err error) {
+	logger := GetContextLogger(ctx)
+	ctx, done := s.db.startOperation(ctx, queryOpRevoke)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Begin a Tx for making transaction requests.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to begin transaction for token revocation")
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE tokens SET revoked = 1, revoked_at = :1, revocation_reason = :2 WHERE token_id = :3"
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to prepare revoke token statement")
+		return fmt.Errorf("failed to prepare revoke statement: %w", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, revokedToken.RevokedAt, revokedToken.Reason, revokedToken.TokenID); err != nil {
+		logger.Error().Err(err).Str("token_id", revokedToken.TokenID).Msg("Failed to revoke token")
+		return err
+	}
+
+	// Commit the transaction.
+	if err = tx.Commit(); err != nil {
+		logger.Error().Err(err).Msg("Failed to commit token revocation transaction")
+		return fmt.Errorf("failed to commit revocation: %w", err)
+	}
+	return nil
+}
+
+func (s sqlStore) EndpointsByURL(ctx context.Context, url string) (_ []*Endpoints, err error) {
+	ctx, done := s.db.startOperation(ctx, queryOpScopeLookup)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := "SELECT scope, required_scopes, scope_mode, method FROM endpoints WHERE endpoint_url = :1 AND active = 1"
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("getEndpointsByURL %s: %v", url, err)
+	}
+	defer rows.Close()
+
+	endpoints := make([]*Endpoints, 0, 1)
+	for rows.Next() {
+		endpoint := &Endpoints{Url: url, Active: 1}
+		var requiredScopes scopeList
+		var scopeMode, method sql.NullString
+		if err := rows.Scan(&endpoint.Scope, &requiredScopes, &scopeMode, &method); err != nil {
+			return nil, fmt.Errorf("getEndpointsByURL %s: %v", url, err)
+		}
+		endpoint.RequiredScopes = requiredScopes
+		endpoint.ScopeMode = scopeMode.String
+		endpoint.Method = method.String
+		endpoints = append(endpoints, endpoint)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getEndpointsByURL %s: %v", url, err)
+	}
+
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("getEndpointsByURL %s: %w", url, errNoSuchEndpoint)
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,776 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Events a client can subscribe its webhooks to
+const (
+	WebhookEventTokenRevoked  = "token.revoked"    // one of the client's tokens was revoked
+	WebhookEventTokenExpiring = "token.expiring"   // one of the client's tokens expires within webhooks.expiring_window_seconds
+	WebhookEventAnomaly       = "anomaly.detected" // the anomaly detector flagged the client's issuance or failed authentication rate
+)
+
+var webhookEvents = []string{WebhookEventTokenRevoked, WebhookEventTokenExpiring, WebhookEventAnomaly}
+
+// Subscription states; only approved subscriptions receive deliveries
+const (
+	subscriptionPending  = "pending"
+	subscriptionApproved = "approved"
+	subscriptionDisabled = "disabled"
+)
+
+// WebhookSubscription is a callback URL a client registered for events about its own tokens. The
+// secret signs every delivery and is only shown to the client when the subscription is created
+type WebhookSubscription struct {
+	SubscriptionID string     `json:"subscription_id"`
+	ClientID       string     `json:"client_id"`
+	URL            string     `json:"url"`
+	Events         []string   `json:"events"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ApprovedAt     *time.Time `json:"approved_at,omitempty"`
+
+	secret       []byte
+	scannedUntil *time.Time // expiring tokens up to here have been notified
+}
+
+// Wants reports whether the subscription receives eventType; no events means all of them
+func (sub *WebhookSubscription) Wants(eventType string) bool {
+	return len(sub.Events) == 0 || slices.Contains(sub.Events, eventType)
+}
+
+type CreateWebhookSubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func (r *CreateWebhookSubscriptionRequest) Validate() error {
+	if r.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if len(r.URL) > 2048 {
+		return fmt.Errorf("url exceeds maximum length (2048 characters)")
+	}
+	parsed, err := url.Parse(r.URL)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute URL")
+	}
+	switch parsed.Scheme {
+	case "https":
+	case "http":
+		if AppConfig.Webhooks.RequireHTTPS {
+			return fmt.Errorf("url must use https")
+		}
+	default:
+		return fmt.Errorf("url must use https")
+	}
+	for _, event := range r.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// CreateWebhookSubscriptionResponse carries the signing secret, which is not retrievable later
+type CreateWebhookSubscriptionResponse struct {
+	*WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// WebhookEvent is the JSON body of every delivery
+type WebhookEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	ClientID   string    `json:"client_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// ExpiringToken is the data of a token.expiring event
+type ExpiringToken struct {
+	TokenID   string    `json:"token_id"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// webhookSignature is sent as X-Webhook-Signature: an HMAC-SHA256 over the timestamp header, a dot and
+// the body, so receivers can reject replayed deliveries as well as forged ones
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookNotifier delivers token events to the approved subscriptions of the affected client.
+// Revocations and anomalies are sent by the instance that observes them; expiring tokens are found by
+// a periodic scan in which each instance claims a subscription's next window with a conditional
+// update, so a token is notified once however many instances run. Tokens kept cache_only are not
+// in the database and never produce token.expiring
+type webhookNotifier struct {
+	as     *authServer
+	cfg    webhooks
+	client *http.Client
+	slots  chan struct{} // bounds concurrent deliveries; events beyond it are dropped
+
+	mu            sync.RWMutex
+	subscriptions map[string][]*WebhookSubscription // client_id -> approved subscriptions
+
+	deliveries *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	done       chan struct{}
+}
+
+// newWebhookNotifier returns nil unless webhooks.enabled is set
+func newWebhookNotifier(as *authServer, cfg webhooks) *webhookNotifier {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxPerClient <= 0 {
+		cfg.MaxPerClient = 5
+	}
+	if cfg.ExpiringWindowSeconds <= 0 {
+		cfg.ExpiringWindowSeconds = 300
+	}
+	if cfg.ScanIntervalSeconds <= 0 {
+		cfg.ScanIntervalSeconds = 60
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 5
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 3
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = 50
+	}
+	return &webhookNotifier{
+		as:  as,
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second,
+			// Only the approved URL receives deliveries: a redirect is not followed, so signed events
+			// cannot be sent on elsewhere, and the delivery counts as failed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		slots:         make(chan struct{}, cfg.MaxConcurrent),
+		subscriptions: make(map[string][]*WebhookSubscription),
+		done:          make(chan struct{}),
+	}
+}
+
+// Start scans for expiring tokens until Stop is called. Metrics must be registered first
+func (wn *webhookNotifier) Start(deliveries *prometheus.CounterVec, duration *prometheus.HistogramVec) {
+	if wn == nil {
+		return
+	}
+	wn.deliveries = deliveries
+	wn.duration = duration
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(wn.cfg.ScanIntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-wn.done:
+				return
+			case <-ticker.C:
+				wn.scanExpiring(time.Now())
+			}
+		}
+	}()
+}
+
+// Stop stops scanning; deliveries in flight finish on their own
+func (wn *webhookNotifier) Stop() {
+	if wn == nil {
+		return
+	}
+	close(wn.done)
+}
+
+// Replace swaps the approved subscriptions
+func (wn *webhookNotifier) Replace(subs []*WebhookSubscription) {
+	byClient := make(map[string][]*WebhookSubscription)
+	for _, sub := range subs {
+		byClient[sub.ClientID] = append(byClient[sub.ClientID], sub)
+	}
+	wn.mu.Lock()
+	wn.subscriptions = byClient
+	wn.mu.Unlock()
+}
+
+// Set adds or replaces an approved subscription
+func (wn *webhookNotifier) Set(sub *WebhookSubscription) {
+	wn.mu.Lock()
+	defer wn.mu.Unlock()
+	subs := slices.DeleteFunc(wn.subscriptions[sub.ClientID], func(s *WebhookSubscription) bool { return s.SubscriptionID == sub.SubscriptionID })
+	wn.subscriptions[sub.ClientID] = append(subs, sub)
+}
+
+// Remove stops deliveries to a subscription
+func (wn *webhookNotifier) Remove(clientID, subscriptionID string) {
+	wn.mu.Lock()
+	defer wn.mu.Unlock()
+	subs := slices.DeleteFunc(wn.subscriptions[clientID], func(s *WebhookSubscription) bool { return s.SubscriptionID == subscriptionID })
+	if len(subs) == 0 {
+		delete(wn.subscriptions, clientID)
+		return
+	}
+	wn.subscriptions[clientID] = subs
+}
+
+func (wn *webhookNotifier) approved() []*WebhookSubscription {
+	wn.mu.RLock()
+	defer wn.mu.RUnlock()
+	var subs []*WebhookSubscription
+	for _, clientSubs := range wn.subscriptions {
+		subs = append(subs, clientSubs...)
+	}
+	return subs
+}
+
+// Notify sends an event to clientID's subscriptions for eventType without blocking the caller
+func (wn *webhookNotifier) Notify(clientID, eventType string, data any) {
+	if wn == nil || clientID == "" {
+		return
+	}
+	wn.mu.RLock()
+	subs := slices.Clone(wn.subscriptions[clientID])
+	wn.mu.RUnlock()
+
+	for _, sub := range subs {
+		if sub.Wants(eventType) {
+			wn.send(sub, WebhookEvent{
+				ID:         generateRandomString(16),
+				Type:       eventType,
+				ClientID:   clientID,
+				OccurredAt: time.Now(),
+				Data:       data,
+			})
+		}
+	}
+}
+
+func (wn *webhookNotifier) send(sub *WebhookSubscription, event WebhookEvent) {
+	select {
+	case wn.slots <- struct{}{}:
+	default:
+		log.Warn().Str("subscription_id", sub.SubscriptionID).Str("event", event.Type).Msg("Too many webhook deliveries in flight, dropping event")
+		wn.record(sub, event.Type, "dropped")
+		return
+	}
+	go func() {
+		defer func() { <-wn.slots }()
+		wn.deliver(sub, event)
+	}()
+}
+
+// deliver POSTs the event, retrying failed attempts with backoff. Every attempt carries the same
+// X-Webhook-Id so receivers can discard duplicates
+func (wn *webhookNotifier) deliver(sub *WebhookSubscription, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Str("subscription_id", sub.SubscriptionID).Msg("Failed to encode webhook event")
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 1; attempt <= wn.cfg.MaxAttempts; attempt++ {
+		start := time.Now()
+		err = wn.post(sub, event, body, attempt)
+		if wn.duration != nil {
+			wn.duration.WithLabelValues(sub.SubscriptionID).Observe(time.Since(start).Seconds())
+		}
+		if err == nil {
+			wn.record(sub, event.Type, "delivered")
+			return
+		}
+		log.Warn().Err(err).Str("subscription_id", sub.SubscriptionID).Str("event", event.Type).Int("attempt", attempt).Msg("Webhook delivery failed")
+		if attempt < wn.cfg.MaxAttempts {
+			select {
+			case <-wn.done:
+				wn.record(sub, event.Type, "failed")
+				return
+			case <-time.After(backoff):
+				backoff *= 2
+			}
+		}
+	}
+	wn.record(sub, event.Type, "failed")
+}
+
+func (wn *webhookNotifier) post(sub *WebhookSubscription, event WebhookEvent, body []byte, attempt int) error {
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", event.ID)
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-Subscription", sub.SubscriptionID)
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(attempt))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", webhookSignature(sub.secret, timestamp, body))
+
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (wn *webhookNotifier) record(sub *WebhookSubscription, eventType, result string) {
+	if wn.deliveries != nil {
+		wn.deliveries.WithLabelValues(sub.SubscriptionID, eventType, result).Inc()
+	}
+}
+
+// scanExpiring notifies tokens expiring within the window for every approved subscription this
+// instance manages to claim
+func (wn *webhookNotifier) scanExpiring(now time.Time) {
+	if !wn.Wants(WebhookEventTokenExpiring) {
+		return
+	}
+	until := now.Add(time.Duration(wn.cfg.ExpiringWindowSeconds) * time.Second)
+	for _, sub := range wn.approved() {
+		if !sub.Wants(WebhookEventTokenExpiring) {
+			continue
+		}
+		from, claimed, err := wn.as.claimExpiryWindow(sub, now, until)
+		if err != nil {
+			log.Error().Err(err).Str("subscription_id", sub.SubscriptionID).Msg("Failed to claim webhook expiry window")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		tokens, err := wn.as.expiringTokens(sub.ClientID, from, until)
+		if err != nil {
+			log.Error().Err(err).Str("subscription_id", sub.SubscriptionID).Msg("Failed to list expiring tokens")
+			continue
+		}
+		for _, token := range tokens {
+			wn.send(sub, WebhookEvent{
+				ID:         generateRandomString(16),
+				Type:       WebhookEventTokenExpiring,
+				ClientID:   sub.ClientID,
+				OccurredAt: now,
+				Data:       token,
+			})
+		}
+	}
+}
+
+// Wants reports whether any approved subscription receives eventType
+func (wn *webhookNotifier) Wants(eventType string) bool {
+	for _, sub := range wn.approved() {
+		if sub.Wants(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimExpiryWindow moves sub's scan cursor to until if no other instance moved it first, returning
+// where the claimed window starts. A cursor in the past, e.g. after downtime, starts at now rather
+// than notifying tokens that have already expired
+func (as *authServer) claimExpiryWindow(sub *WebhookSubscription, now, until time.Time) (time.Time, bool, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	as.webhooks.mu.RLock()
+	previous := sub.scannedUntil
+	as.webhooks.mu.RUnlock()
+
+	var result sql.Result
+	var err error
+	if previous == nil {
+		result, err = as.db.ExecContext(ctx, "UPDATE webhook_subscriptions SET expiry_scanned_until = :1 WHERE subscription_id = :2 AND expiry_scanned_until IS NULL", until, sub.SubscriptionID)
+	} else {
+		result, err = as.db.ExecContext(ctx, "UPDATE webhook_subscriptions SET expiry_scanned_until = :1 WHERE subscription_id = :2 AND expiry_scanned_until = :3", until, sub.SubscriptionID, *previous)
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		// Another instance claimed it; pick up its cursor for the next scan
+		var current sql.NullTime
+		if err := as.db.QueryRowContext(ctx, "SELECT expiry_scanned_until FROM webhook_subscriptions WHERE subscription_id = :1", sub.SubscriptionID).Scan(&current); err != nil {
+			return time.Time{}, false, err
+		}
+		as.webhooks.mu.Lock()
+		sub.scannedUntil = nil
+		if current.Valid {
+			sub.scannedUntil = &current.Time
+		}
+		as.webhooks.mu.Unlock()
+		return time.Time{}, false, nil
+	}
+
+	as.webhooks.mu.Lock()
+	sub.scannedUntil = &until
+	as.webhooks.mu.Unlock()
+	if previous == nil || previous.Before(now) {
+		return now, true, nil
+	}
+	return *previous, true, nil
+}
+
+func (as *authServer) expiringTokens(clientID string, from, until time.Time) ([]ExpiringToken, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := as.db.QueryContext(ctx, "SELECT token_id, token_type, expires_at FROM tokens WHERE client_id = :1 AND revoked = 0 AND expires_at > :2 AND expires_at <= :3", clientID, from, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []ExpiringToken
+	for rows.Next() {
+		var token ExpiringToken
+		if err := rows.Scan(&token.TokenID, &token.TokenType, &token.ExpiresAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+const webhookSubscriptionColumns = "subscription_id, client_id, url, events, secret, status, created_at, approved_at, expiry_scanned_until"
+
+func (as *authServer) scanWebhookSubscriptions(rows *sql.Rows) ([]*WebhookSubscription, error) {
+	var subs, stale []*WebhookSubscription
+	for rows.Next() {
+		sub := &WebhookSubscription{}
+		var events scopeList
+		var sealed string
+		var approvedAt, scannedUntil sql.NullTime
+		if err := rows.Scan(&sub.SubscriptionID, &sub.ClientID, &sub.URL, &events, &sealed, &sub.Status, &sub.CreatedAt, &approvedAt, &scannedUntil); err != nil {
+			return nil, err
+		}
+		secret, previous, err := as.openSealed(sealed)
+		if err != nil {
+			log.Error().Err(err).Str("subscription_id", sub.SubscriptionID).Msg("Failed to unseal webhook secret, skipping subscription")
+			continue
+		}
+		sub.Events = events
+		sub.secret = secret
+		if approvedAt.Valid {
+			sub.ApprovedAt = &approvedAt.Time
+		}
+		if scannedUntil.Valid {
+			sub.scannedUntil = &scannedUntil.Time
+		}
+		if previous {
+			stale = append(stale, sub)
+		}
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Secrets sealed before JWT_SECRET was rotated are sealed again under the new secret
+	for _, sub := range stale {
+		sealed, err := as.sealSigningKey(sub.secret)
+		if err == nil {
+			_, err = as.db.ExecContext(as.ctx, "UPDATE webhook_subscriptions SET secret = :1 WHERE subscription_id = :2", sealed, sub.SubscriptionID)
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("subscription_id", sub.SubscriptionID).Msg("failed to reseal webhook secret under the new JWT_SECRET")
+		}
+	}
+	return subs, nil
+}
+
+// listWebhookSubscriptions returns subscriptions filtered by client and status; empty filters match all
+func (as *authServer) listWebhookSubscriptions(ctx context.Context, clientID, status string) ([]*WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := "SELECT " + webhookSubscriptionColumns + " FROM webhook_subscriptions WHERE (:1 IS NULL OR client_id = :2) AND (:3 IS NULL OR status = :4) ORDER BY created_at"
+	rows, err := as.db.QueryContext(ctx, query, nullIfEmpty(clientID), clientID, nullIfEmpty(status), status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return as.scanWebhookSubscriptions(rows)
+}
+
+func (as *authServer) webhookSubscription(ctx context.Context, subscriptionID string) (*WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := as.db.QueryContext(ctx, "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE subscription_id = :1", subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subs, err := as.scanWebhookSubscriptions(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return subs[0], nil
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (as *authServer) insertWebhookSubscription(ctx context.Context, sub *WebhookSubscription) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	sealed, err := as.sealSigningKey(sub.secret)
+	if err != nil {
+		return err
+	}
+	events, err := json.Marshal(sub.Events)
+	if err != nil {
+		return err
+	}
+	_, err = as.db.ExecContext(ctx, "INSERT INTO webhook_subscriptions (subscription_id, client_id, url, events, secret, status, created_at) VALUES (:1, :2, :3, :4, :5, :6, :7)",
+		sub.SubscriptionID, sub.ClientID, sub.URL, string(events), sealed, sub.Status, sub.CreatedAt)
+	return err
+}
+
+func (as *authServer) countWebhookSubscriptions(ctx context.Context, clientID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var count int
+	err := as.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM webhook_subscriptions WHERE client_id = :1 AND status <> :2", clientID, subscriptionDisabled).Scan(&count)
+	return count, err
+}
+
+// setWebhookSubscriptionStatus updates a subscription's status, returning sql.ErrNoRows when it doesn't exist
+func (as *authServer) setWebhookSubscriptionStatus(ctx context.Context, subscriptionID, status string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// The approval time is bound rather than SYSTIMESTAMP, which only Oracle has
+	result, err := as.db.ExecContext(ctx, "UPDATE webhook_subscriptions SET status = :1, approved_at = CASE WHEN :2 = 'approved' THEN :3 ELSE approved_at END WHERE subscription_id = :4", status, status, time.Now(), subscriptionID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (as *authServer) deleteWebhookSubscription(ctx context.Context, clientID, subscriptionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.db.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE subscription_id = :1 AND client_id = :2", subscriptionID, clientID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// populateWebhookSubscriptions loads approved subscriptions, picking up approvals made on other instances
+func (s *authServer) populateWebhookSubscriptions() {
+	if s.webhooks == nil {
+		return
+	}
+	subs, err := s.listWebhookSubscriptions(s.ctx, "", subscriptionApproved)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to populate webhook subscriptions")
+		return
+	}
+	s.webhooks.Replace(subs)
+	s.cacheRefreshes.Mark("webhook_subscriptions")
+	log.Info().Int("subscriptions", len(subs)).Msg("Webhook subscriptions loaded")
+}
+
+// webhookClient authenticates the caller of a client webhook route, whose subscriptions are its own
+func (as *authServer) webhookClient(c *gin.Context) (string, bool) {
+	if as.webhooks == nil {
+		RespondWithError(c, ErrNotFoundError("Webhook subscriptions are not enabled"))
+		return "", false
+	}
+	claims, apiErr := as.authenticateCredential(c)
+	if apiErr != nil {
+		RespondWithError(c, apiErr)
+		return "", false
+	}
+	return claims.ClientID, true
+}
+
+// Create webhook subscription handler: registers a callback URL that receives events once an admin approves it
+func (as *authServer) createWebhookSubscriptionHandler(c *gin.Context) {
+	clientID, ok := as.webhookClient(c)
+	if !ok {
+		return
+	}
+	logger := GetRequestLogger(c)
+
+	var req CreateWebhookSubscriptionRequest
+	if apiErr := decodeJSONBody(c, &req); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, req.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	count, err := as.countWebhookSubscriptions(c.Request.Context(), clientID)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+	if count >= as.webhooks.cfg.MaxPerClient {
+		RespondWithError(c, ErrConflictError(fmt.Sprintf("Client already has %d webhook subscriptions", count)))
+		return
+	}
+
+	events := slices.Clone(req.Events)
+	sort.Strings(events)
+	secret := generateRandomString(32)
+	sub := &WebhookSubscription{
+		SubscriptionID: generateRandomString(16),
+		ClientID:       clientID,
+		URL:            req.URL,
+		Events:         slices.Compact(events),
+		Status:         subscriptionPending,
+		CreatedAt:      time.Now(),
+		secret:         []byte(secret),
+	}
+	if err := as.insertWebhookSubscription(c.Request.Context(), sub); err != nil {
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+
+	logger.Info().Str("subscription_id", sub.SubscriptionID).Str("client_id", clientID).Msg("webhook subscription created, awaiting approval")
+	c.JSON(http.StatusCreated, CreateWebhookSubscriptionResponse{WebhookSubscription: sub, Secret: secret})
+}
+
+// List webhook subscriptions handler: the caller's own subscriptions
+func (as *authServer) listClientWebhookSubscriptionsHandler(c *gin.Context) {
+	clientID, ok := as.webhookClient(c)
+	if !ok {
+		return
+	}
+	subs, err := as.listWebhookSubscriptions(c.Request.Context(), clientID, "")
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subs})
+}
+
+// Delete webhook subscription handler: a client may only delete its own subscriptions
+func (as *authServer) deleteWebhookSubscriptionHandler(c *gin.Context) {
+	clientID, ok := as.webhookClient(c)
+	if !ok {
+		return
+	}
+	subscriptionID := c.Param("subscription_id")
+	if err := as.deleteWebhookSubscription(c.Request.Context(), clientID, subscriptionID); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Webhook subscription not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	as.webhooks.Remove(clientID, subscriptionID)
+	c.Status(http.StatusNoContent)
+}
+
+// List webhook subscriptions handler (admin), optionally filtered by client_id and status
+func (as *authServer) listWebhookSubscriptionsHandler(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", subscriptionPending, subscriptionApproved, subscriptionDisabled:
+	default:
+		RespondWithError(c, ErrBadRequest("status must be pending, approved or disabled"))
+		return
+	}
+	subs, err := as.listWebhookSubscriptions(c.Request.Context(), c.Query("client_id"), status)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subs})
+}
+
+// Approve webhook subscription handler (admin): deliveries start immediately on this instance and
+// within the reload interval on the others
+func (as *authServer) approveWebhookSubscriptionHandler(c *gin.Context) {
+	as.setWebhookSubscriptionStatusHandler(c, subscriptionApproved)
+}
+
+// Disable webhook subscription handler (admin)
+func (as *authServer) disableWebhookSubscriptionHandler(c *gin.Context) {
+	as.setWebhookSubscriptionStatusHandler(c, subscriptionDisabled)
+}
+
+func (as *authServer) setWebhookSubscriptionStatusHandler(c *gin.Context, status string) {
+	if as.webhooks == nil {
+		RespondWithError(c, ErrNotFoundError("Webhook subscriptions are not enabled"))
+		return
+	}
+	logger := GetRequestLogger(c)
+	subscriptionID := c.Param("subscription_id")
+	if err := as.setWebhookSubscriptionStatus(c.Request.Context(), subscriptionID, status); err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(c, ErrNotFoundError("Webhook subscription not found"))
+			return
+		}
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+	sub, err := as.webhookSubscription(c.Request.Context(), subscriptionID)
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, logger))
+		return
+	}
+	if status == subscriptionApproved {
+		as.webhooks.Set(sub)
+	} else {
+		as.webhooks.Remove(sub.ClientID, sub.SubscriptionID)
+	}
+	logger.Info().Str("subscription_id", subscriptionID).Str("client_id", sub.ClientID).Str("status", status).Msg("webhook subscription status changed")
+	c.JSON(http.StatusOK, sub)
+}
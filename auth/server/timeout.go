@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeTimeouts resolves the processing budget for a route from request_timeout configuration
+type routeTimeouts struct {
+	defaultBudget time.Duration
+	routes        map[string]time.Duration
+}
+
+func newRouteTimeouts(cfg request_timeout) *routeTimeouts {
+	rt := &routeTimeouts{
+		defaultBudget: time.Duration(cfg.DefaultMs) * time.Millisecond,
+		routes:        make(map[string]time.Duration, len(cfg.Routes)),
+	}
+	for route, ms := range cfg.Routes {
+		rt.routes[route] = time.Duration(ms) * time.Millisecond
+	}
+	return rt
+}
+
+// For returns the budget for a route path as registered with gin; zero means unbounded
+func (rt *routeTimeouts) For(route string) time.Duration {
+	if budget, ok := rt.routes[route]; ok {
+		return budget
+	}
+	return rt.defaultBudget
+}
+
+// TimeoutMiddleware cancels the request context once a route's budget is exceeded and answers 504
+// with the standard error body. Handlers write to a buffer so a late response is discarded instead
+// of racing the 504; the middleware still waits for the handler to return before gin reuses the context
+func TimeoutMiddleware(rt *routeTimeouts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		budget := rt.For(c.FullPath())
+		if budget <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), budget)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		original := c.Writer
+		tw := &timeoutWriter{ResponseWriter: original, header: make(http.Header), code: http.StatusOK}
+		c.Writer = tw
+
+		done := make(chan struct{})
+		var panicValue interface{}
+		go func() {
+			defer func() {
+				panicValue = recover()
+				close(done)
+			}()
+			c.Next()
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			tw.timeout()
+
+			apiErr := ErrGatewayTimeoutError("Request processing exceeded its time budget")
+			apiErr.RequestID = GetRequestID(c)
+			logger := GetRequestLogger(c)
+			logger.Error().
+				Str("route", c.FullPath()).
+				Dur("budget", budget).
+				Msg("Request timed out")
+
+			original.Header().Set("Content-Type", "application/json")
+			original.WriteHeader(apiErr.StatusCode)
+			_ = json.NewEncoder(original).Encode(apiErr)
+			original.Flush()
+
+			<-done
+		}
+
+		c.Writer = original
+		if panicValue != nil {
+			// Re-raise on this goroutine so RecoveryMiddleware handles it
+			panic(panicValue)
+		}
+		tw.flushTo(original)
+	}
+}
+
+// timeoutWriter buffers a handler's response until it completes within its budget
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	code     int
+	written  bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.written {
+		return
+	}
+	tw.code = code
+	tw.written = true
+}
+
+func (tw *timeoutWriter) WriteHeaderNow() {
+	tw.WriteHeader(tw.Status())
+}
+
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.written = true
+	return tw.body.Write(data)
+}
+
+func (tw *timeoutWriter) WriteString(s string) (int, error) {
+	return tw.Write([]byte(s))
+}
+
+func (tw *timeoutWriter) Status() int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.code
+}
+
+func (tw *timeoutWriter) Size() int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.written {
+		return -1
+	}
+	return tw.body.Len()
+}
+
+func (tw *timeoutWriter) Written() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.written
+}
+
+// Flush is a no-op; the buffered response is written once the handler returns
+func (tw *timeoutWriter) Flush() {}
+
+func (tw *timeoutWriter) timeout() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+}
+
+// flushTo copies the buffered response to w unless the request already timed out
+func (tw *timeoutWriter) flushTo(w gin.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || !tw.written {
+		return
+	}
+	for key, values := range tw.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(tw.code)
+	_, _ = w.Write(tw.body.Bytes())
+}
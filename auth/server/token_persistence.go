@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Token persistence policies, selected per token type by token_store
+const (
+	persistWriteThrough = "write_through" // queued on the batch writer; a failed batch is logged and lost
+	persistWriteBehind  = "write_behind"  // journaled to local disk before the token is returned, then batch written until it sticks
+	persistCacheOnly    = "cache_only"    // kept in this instance's token cache only, until it expires; valid nowhere else and lost on restart
+)
+
+func validPersistencePolicy(policy string) bool {
+	switch policy {
+	case "", persistWriteThrough, persistWriteBehind, persistCacheOnly:
+		return true
+	}
+	return false
+}
+
+// tokenPersistence decides how each issued token is stored
+type tokenPersistence struct {
+	defaultPolicy string
+	policies      map[string]string // by upper-cased token type; viper lower-cases map keys
+	journal       *tokenJournal     // nil unless some token type is write_behind
+}
+
+func newTokenPersistence(cfg token_store) (*tokenPersistence, error) {
+	tp := &tokenPersistence{defaultPolicy: cfg.DefaultPolicy, policies: make(map[string]string)}
+	if tp.defaultPolicy == "" {
+		tp.defaultPolicy = persistWriteThrough
+	}
+	writeBehind := tp.defaultPolicy == persistWriteBehind
+	for tokenType, policy := range cfg.Policies {
+		tp.policies[strings.ToUpper(tokenType)] = policy
+		writeBehind = writeBehind || policy == persistWriteBehind
+	}
+	if writeBehind {
+		journal, err := openTokenJournal(cfg.JournalPath, time.Duration(cfg.RetryIntervalSeconds)*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("opening token journal: %w", err)
+		}
+		tp.journal = journal
+	}
+	return tp, nil
+}
+
+// policyFor returns the persistence policy of a token type; a nil receiver keeps the batch writer
+func (tp *tokenPersistence) policyFor(tokenType string) string {
+	if tp == nil {
+		return persistWriteThrough
+	}
+	if policy, ok := tp.policies[tokenType]; ok && policy != "" {
+		return policy
+	}
+	return tp.defaultPolicy
+}
+
+// Journal returns the write_behind journal, or nil when no token type uses it
+func (tp *tokenPersistence) Journal() *tokenJournal {
+	if tp == nil {
+		return nil
+	}
+	return tp.journal
+}
+
+// persistToken stores a newly issued token according to its type's policy. An error means the token
+// could not be made durable and must not be handed out
+func (as *authServer) persistToken(token Token) error {
+	if as.stateless != nil {
+		// Stateless tokens are self-contained; only revocations are ever written
+		return nil
+	}
+	switch as.tokenPersistence.policyFor(token.TokenType) {
+	case persistCacheOnly:
+		// The cache's TTL and eviction would otherwise end the token before its exp
+		as.tokenCache.Keep(token.TokenID, token.ExpiresAt)
+		return nil
+	case persistWriteBehind:
+		if err := as.tokenPersistence.journal.Append(token); err != nil {
+			return err
+		}
+	}
+	as.tokenBatcher.Add(token)
+	return nil
+}
+
+// tokenJournal is the append-only local file behind write_behind. A token is synced to it before
+// being returned and dropped from it once a batch insert has committed it; tokens whose batch failed,
+// and any found in the file at startup, are written again with an idempotent MERGE until they stick
+type tokenJournal struct {
+	path          string
+	retryInterval time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	records int              // lines in the file, to know when compacting is worthwhile
+	pending map[string]Token // journaled and not yet known to be in the database
+	due     map[string]bool  // pending tokens the batch writer failed to insert
+	closed  bool
+	done    chan struct{}
+}
+
+func openTokenJournal(path string, retryInterval time.Duration) (*tokenJournal, error) {
+	if path == "" {
+		return nil, fmt.Errorf("token_store.journal_path is required for write_behind")
+	}
+	if retryInterval <= 0 {
+		retryInterval = 30 * time.Second
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	tj := &tokenJournal{
+		path:          path,
+		retryInterval: retryInterval,
+		pending:       make(map[string]Token),
+		due:           make(map[string]bool),
+		done:          make(chan struct{}),
+	}
+
+	// Anything left from the previous run may or may not have reached the database
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+		for scanner.Scan() {
+			var token Token
+			if err := json.Unmarshal(scanner.Bytes(), &token); err != nil || token.TokenID == "" {
+				log.Warn().Err(err).Str("path", path).Msg("Skipping unreadable token journal record")
+				continue
+			}
+			tj.pending[token.TokenID] = token
+			tj.due[token.TokenID] = true
+		}
+		err = scanner.Err()
+		existing.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := tj.rewriteLocked(); err != nil {
+		return nil, err
+	}
+	if len(tj.pending) > 0 {
+		log.Warn().Int("tokens", len(tj.pending)).Str("path", path).Msg("Recovered journaled tokens from previous run")
+	}
+	return tj, nil
+}
+
+// Append journals token and syncs it to disk
+func (tj *tokenJournal) Append(token Token) error {
+	line, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	if tj.closed {
+		return fmt.Errorf("token journal is closed")
+	}
+	if _, err := tj.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing token journal: %w", err)
+	}
+	if err := tj.file.Sync(); err != nil {
+		return fmt.Errorf("syncing token journal: %w", err)
+	}
+	tj.records++
+	tj.pending[token.TokenID] = token
+	return nil
+}
+
+// Settle drops tokens now committed to the database
+func (tj *tokenJournal) Settle(tokens []Token) {
+	if tj == nil {
+		return
+	}
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	for _, token := range tokens {
+		delete(tj.pending, token.TokenID)
+		delete(tj.due, token.TokenID)
+	}
+	// Compact once most of the file is settled records
+	if tj.closed {
+		return
+	}
+	if tj.records > 2*len(tj.pending)+1000 || (len(tj.pending) == 0 && tj.records > 0) {
+		if err := tj.rewriteLocked(); err != nil {
+			log.Error().Err(err).Str("path", tj.path).Msg("Failed to compact token journal")
+		}
+	}
+}
+
+// Retry marks journaled tokens from a failed batch to be written again
+func (tj *tokenJournal) Retry(tokens []Token) {
+	if tj == nil {
+		return
+	}
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	for _, token := range tokens {
+		if _, ok := tj.pending[token.TokenID]; ok {
+			tj.due[token.TokenID] = true
+		}
+	}
+}
+
+// Pending returns how many journaled tokens are not yet known to be in the database
+func (tj *tokenJournal) Pending() int {
+	if tj == nil {
+		return 0
+	}
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	return len(tj.pending)
+}
+
+// rewriteLocked replaces the file with just the pending tokens
+func (tj *tokenJournal) rewriteLocked() error {
+	tmp := tj.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, token := range tj.pending {
+		line, err := json.Marshal(token)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmp, tj.path); err != nil {
+		return err
+	}
+
+	reopened, err := os.OpenFile(tj.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if tj.file != nil {
+		tj.file.Close()
+	}
+	tj.file = reopened
+	tj.records = len(tj.pending)
+	return nil
+}
+
+// Start retries due tokens in the background until Stop is called
+func (tj *tokenJournal) Start(as *authServer) {
+	if tj == nil {
+		return
+	}
+	go func() {
+		tj.retry(as)
+		ticker := time.NewTicker(tj.retryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tj.done:
+				return
+			case <-ticker.C:
+				tj.retry(as)
+			}
+		}
+	}()
+}
+
+// Stop stops retrying and closes the file; unsettled tokens are picked up on the next start
+func (tj *tokenJournal) Stop() {
+	if tj == nil {
+		return
+	}
+	close(tj.done)
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	tj.closed = true
+	tj.file.Close()
+}
+
+func (tj *tokenJournal) retry(as *authServer) {
+	tj.mu.Lock()
+	batch := make([]Token, 0, len(tj.due))
+	for tokenID := range tj.due {
+		batch = append(batch, tj.pending[tokenID])
+	}
+	tj.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := as.mergeTokenBatch(batch); err != nil {
+		log.Error().Err(err).Int("batch_size", len(batch)).Msg("Failed to write journaled tokens, will retry")
+		return
+	}
+	tj.Settle(batch)
+	log.Info().Int("batch_size", len(batch)).Msg("Journaled tokens written")
+}
+
+// mergeTokenBatch inserts tokens that may already be in the database, e.g. journaled tokens whose
+// batch committed just before a crash
+// mergeTokenStatement inserts a token unless its row exists. MERGE ... FROM dual is Oracle's; the
+// other drivers skip the conflicting row with ON CONFLICT
+func mergeTokenStatement() string {
+	if databaseDriver() != DriverOracle {
+		return `INSERT INTO tokens (token_id, token_type, jwt_token, client_id, issued_at, expires_at, family_id) VALUES (:1, :2, :3, :4, :5, :6, :7)
+		ON CONFLICT (token_id) DO NOTHING`
+	}
+	return `MERGE INTO tokens t
+		USING (SELECT :1 AS token_id FROM dual) s
+		ON (t.token_id = s.token_id)
+		WHEN NOT MATCHED THEN INSERT (token_id, token_type, jwt_token, client_id, issued_at, expires_at, family_id) VALUES (s.token_id, :2, :3, :4, :5, :6, :7)`
+}
+
+func (as *authServer) mergeTokenBatch(tokens []Token) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, mergeTokenStatement())
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, token := range tokens {
+		if _, err := stmt.ExecContext(ctx, token.TokenID, token.TokenType, token.JWT_token, token.ClientID, token.IssuedAt, token.ExpiresAt, token.FamilyID); err != nil {
+			return fmt.Errorf("failed to merge token %s: %w", token.TokenID, err)
+		}
+	}
+	return tx.Commit()
+}
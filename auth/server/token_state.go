@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// sharedTokenState keeps revocations and one-time token use in Redis, so every instance sees them as
+// soon as they happen instead of when its own token cache entry expires. The database stays the
+// durable record; Redis entries expire with the tokens they describe
+type sharedTokenState struct {
+	client *redisClient
+	prefix string
+	ttl    time.Duration // lifetime of entries whose token expiry is unknown
+}
+
+func newSharedTokenState(cfg token_state_config, redisCfg redis_config) (*sharedTokenState, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	client, err := newRedisClient(redisCfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "auth:token:"
+	}
+	if cfg.RevocationTTLSeconds <= 0 {
+		cfg.RevocationTTLSeconds = 86400
+	}
+	return &sharedTokenState{client: client, prefix: cfg.KeyPrefix, ttl: time.Duration(cfg.RevocationTTLSeconds) * time.Second}, nil
+}
+
+func (ts *sharedTokenState) tokenKey(tokenID string) string {
+	return ts.prefix + "revoked:" + tokenID
+}
+
+// clientKey holds when every token of a client was revoked, as Unix seconds
+func (ts *sharedTokenState) clientKey(clientID string) string {
+	return ts.prefix + "client:" + clientID
+}
+
+// expiry is how long an entry about a token expiring at expiresAt must live, outlasting the token by
+// the leeway validators might give an expired one
+func (ts *sharedTokenState) expiry(expiresAt time.Time) string {
+	ttl := ts.ttl
+	if !expiresAt.IsZero() {
+		ttl = max(time.Until(expiresAt)+time.Minute, time.Second)
+	}
+	return strconv.FormatInt(ttl.Milliseconds(), 10)
+}
+
+// Publish records a revocation made on this instance. Events without a token ID revoke every token
+// the client was issued until then
+func (ts *sharedTokenState) Publish(ctx context.Context, event RevocationEvent) error {
+	if event.TokenID != "" {
+		_, err := ts.client.Do(ctx, "SET", ts.tokenKey(event.TokenID), event.Reason, "PX", ts.expiry(event.ExpiresAt))
+		return err
+	}
+	revokedAt := event.RevokedAt
+	if revokedAt.IsZero() {
+		revokedAt = time.Now()
+	}
+	_, err := ts.client.Do(ctx, "SET", ts.clientKey(event.ClientID), strconv.FormatInt(revokedAt.Unix(), 10), "PX", ts.expiry(time.Time{}))
+	return err
+}
+
+// Revoked reports whether the token, or every token its client held when it was issued, has been
+// revoked on any instance
+func (ts *sharedTokenState) Revoked(ctx context.Context, claims *Claims) (reason string, revoked bool, err error) {
+	reply, err := ts.client.Do(ctx, "MGET", ts.tokenKey(claims.TokenID), ts.clientKey(claims.ClientID))
+	if err != nil {
+		return "", false, err
+	}
+	values, _ := reply.([]any)
+	if len(values) != 2 {
+		return "", false, errors.New("redis: unexpected MGET reply")
+	}
+	if values[0] != nil {
+		reason, err := redisString(values[0])
+		return reason, err == nil, err
+	}
+	if values[1] != nil {
+		raw, err := redisString(values[1])
+		if err != nil {
+			return "", false, err
+		}
+		revokedAt, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return "", false, err
+		}
+		if claims.IssuedAt != nil && claims.IssuedAt.Unix() <= revokedAt {
+			return RevocationReasonAdmin, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// Consume marks a one-time token used, reporting false when it was already used or revoked, on this
+// instance or another
+func (ts *sharedTokenState) Consume(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	// SET NX answers OK when it set the key and a null reply when the key already existed
+	reply, err := ts.client.Do(ctx, "SET", ts.tokenKey(tokenID), RevocationReasonOTTConsumed, "PX", ts.expiry(expiresAt), "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// sharedRevocation checks the shared token state for a revocation made on another instance since this
+// one looked the token up. On a Redis failure the local answer stands
+func (as *authServer) sharedRevocation(ctx context.Context, claims *Claims) bool {
+	if as.tokenState == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	reason, revoked, err := as.tokenState.Revoked(ctx, claims)
+	if err != nil {
+		logger := GetContextLogger(ctx)
+		logger.Warn().Err(err).Str("token_id", claims.TokenID).Msg("Shared token state unavailable, using the local revocation status")
+		return false
+	}
+	if revoked {
+		as.validationResults.Invalidate(claims.TokenID)
+		as.tokenCache.Set(claims.TokenID, &Token{TokenID: claims.TokenID, ClientID: claims.ClientID, TokenType: claims.TokenType, Revoked: true, RevocationReason: reason})
+	}
+	return revoked
+}
+
+// consumeOneTimeToken claims a one-time token for this validation. Only one instance can; the others
+// see it revoked. On a Redis failure the token is accepted and single use rests on the database as before
+func (as *authServer) consumeOneTimeToken(ctx context.Context, claims *Claims) bool {
+	if as.tokenState == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	first, err := as.tokenState.Consume(ctx, claims.TokenID, expiresAt)
+	if err != nil {
+		logger := GetContextLogger(ctx)
+		logger.Warn().Err(err).Str("token_id", claims.TokenID).Msg("Shared token state unavailable, one-time token use not coordinated")
+		return true
+	}
+	if !first {
+		as.tokenCache.Set(claims.TokenID, &Token{TokenID: claims.TokenID, ClientID: claims.ClientID, TokenType: "O", Revoked: true, RevocationReason: RevocationReasonOTTConsumed})
+	}
+	return first
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Generate random string
+func generateRandomString(length int) string {
+	bytes := make([]byte, length)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// Generate JWT token
+func (as *authServer) generateJWT(client *Clients, tokenType string) (string, *Token, error) {
+	return as.generateDelegatedJWT(client, nil, client.AllowedScopes, tokenType)
+}
+
+// tokenRestrictions limit where and how an issued token may be used; the zero value restricts nothing
+type tokenRestrictions struct {
+	Regions   []string // regions the token validates in
+	Audiences []string // services it validates for
+	DPoPKey   string   // thumbprint of the DPoP key it is bound to
+}
+
+// Generate JWT token for client carrying scopes, recording actor in the act claim when it is acting on the client's behalf
+func (as *authServer) generateDelegatedJWT(client *Clients, actor *Actor, scopes []string, tokenType string) (string, *Token, error) {
+	return as.generateRestrictedJWT(as.ctx, client, actor, scopes, tokenType, tokenRestrictions{})
+}
+
+// generateRestrictedJWT issues a token limited by restrictions. Each token starts its own family,
+// identified by its ID, which the refresh tokens issued for it join
+func (as *authServer) generateRestrictedJWT(ctx context.Context, client *Clients, actor *Actor, scopes []string, tokenType string, restrictions tokenRestrictions) (string, *Token, error) {
+	logger := GetContextLogger(ctx)
+	tokenID := generateRandomString(16)
+	// An opaque token is handed out in place of the JWT, which is only kept in the tokens table
+	var reference string
+	if client.TokenFormat == TokenFormatOpaque && as.stateless == nil {
+		reference = generateRandomString(opaqueTokenBytes)
+		tokenID = opaqueTokenID(reference)
+	}
+	now := time.Now()
+	expiresAt := now.Add(tokenPolicyFor(tokenType).lifetime(client))
+
+	claims := Claims{
+		ClientID:  client.ClientID,
+		TokenID:   tokenID,
+		TokenType: tokenType,
+		Scopes:    scopes,
+		Act:       actor,
+		Region:    AppConfig.Region.Name,
+		Zone:      AppConfig.Region.Zone,
+		Regions:   restrictions.Regions,
+		Roles:     client.Roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "auth-server",
+			Audience:  restrictions.Audiences,
+		},
+	}
+	if restrictions.DPoPKey != "" {
+		claims.Cnf = &Confirmation{JKT: restrictions.DPoPKey}
+	}
+	signedExpiry := claims.ExpiresAt.Time
+	if err := as.pipelineHooks.PreIssue(ctx, client, &claims); err != nil {
+		return "", nil, err
+	}
+	// The stored row and cache entry are keyed by these, so hooks may not change them
+	claims.ClientID, claims.TokenID, claims.TokenType = client.ClientID, tokenID, tokenType
+	if claims.ExpiresAt != nil && !claims.ExpiresAt.Equal(signedExpiry) {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	// Sign as signing.algorithm configures: under HS256 with the active managed key (identified by kid),
+	// or JWT_SECRET when none is active
+	method, kid, key := as.signingBackend().SigningKey()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	applyJWTHeaders(token, client)
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		logger.Error().Err(err).Str("client_id", client.ClientID).Msg("Failed to sign JWT token")
+		return "", nil, err
+	}
+
+	// Store token info
+	tokenInfo := Token{
+		TokenID:   tokenID,
+		TokenType: tokenType,
+		JWT_token: tokenString,
+		ClientID:  client.ClientID,
+		IssuedAt:  now,
+		ExpiresAt: expiresAt,
+		Revoked:   false,
+		FamilyID:  tokenID,
+		RequestID: contextRequestID(ctx),
+		Scopes:    claims.Scopes,
+	}
+
+	// Add to cache immediately for fast lookup in validate/revoke
+	logger.Debug().Str("token_id", tokenID).Str("client_id", client.ClientID).Msg("Adding token to cache")
+	as.tokenCache.Set(tokenID, &tokenInfo)
+
+	// Then persist it as token_store configures for its type
+	logger.Debug().Str("token_id", tokenID).Msg("Persisting token")
+	if err := as.persistToken(tokenInfo); err != nil {
+		as.tokenCache.Invalidate(tokenID)
+		logger.Error().Err(err).Str("token_id", tokenID).Msg("Failed to persist token")
+		return "", nil, err
+	}
+
+	as.pipelineHooks.PostIssue(ctx, &tokenInfo, &claims)
+	if reference != "" {
+		return reference, &tokenInfo, nil
+	}
+	return tokenString, &tokenInfo, nil
+}
+
+// Validate JWT token
+func (as *authServer) validateJWT(ctx context.Context, tokenString string) (*Claims, error) {
+	logger := GetContextLogger(ctx)
+	if isOpaqueToken(tokenString) {
+		resolved, err := as.resolveOpaqueToken(ctx, tokenString)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Opaque token resolution failed")
+			return nil, err
+		}
+		tokenString = resolved
+	}
+	if err := as.pipelineHooks.PreValidate(ctx, tokenString); err != nil {
+		return nil, err
+	}
+	lookupStart := time.Now()
+	claims, ok := as.validationResults.Get(tokenString)
+	traceCacheLookup(ctx, "validation_result", lookupStart, ok)
+	if ok {
+		if as.sharedRevocation(ctx, claims) {
+			return nil, &tokenRevokedError{Reason: as.cachedRevocationReason(claims.TokenID)}
+		}
+		if err := as.pipelineHooks.PostValidate(ctx, claims); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, as.verificationKey)
+	as.shadowValidation.Compare(ctx, tokenString, err)
+
+	if err != nil {
+		logger.Warn().Err(err).Msg("JWT token parsing failed")
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		revoked, tokenType, degraded, err := as.tokenStatus(ctx, claims)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching token info: %w", err)
+		}
+
+		// Set token type in claims for use in handlers
+		claims.TokenType = tokenType
+		claims.degraded = degraded
+
+		if !revoked && as.sharedRevocation(ctx, claims) {
+			revoked = true
+		}
+		if !revoked && tokenType == "O" && !as.consumeOneTimeToken(ctx, claims) {
+			revoked = true
+		}
+		if revoked {
+			return nil, &tokenRevokedError{Reason: as.cachedRevocationReason(claims.TokenID)}
+		}
+
+		if err := as.pipelineHooks.PostValidate(ctx, claims); err != nil {
+			return nil, err
+		}
+
+		// One-time tokens are revoked on first use, so only reusable tokens may skip these checks next time.
+		// Degraded results are not reused either, so the token is checked properly once the database is back
+		if tokenType != "O" && !degraded {
+			as.validationResults.Set(tokenString, claims)
+		}
+
+		// Handle OTT token auto-revocation asynchronously
+		if tokenType == "O" {
+			revokedToken := RevokedToken{
+				ClientID:  claims.ClientID,
+				TokenID:   claims.TokenID,
+				RevokedAt: time.Now(),
+				Reason:    RevocationReasonOTTConsumed,
+			}
+			if claims.ExpiresAt != nil {
+				revokedToken.ExpiresAt = claims.ExpiresAt.Time
+			}
+			// Queue for async processing instead of blocking; the revocation outlives the request
+			revokeCtx := context.WithoutCancel(ctx)
+			go func() {
+				if err := as.revokeToken(revokeCtx, revokedToken); err != nil {
+					// Silent OTT auto-revocation failure
+				}
+			}()
+		}
+
+		return claims, nil
+	}
+	logger.Warn().Msg("JWT token validation failed - invalid token")
+	return nil, fmt.Errorf("invalid token")
+}
@@ -0,0 +1,403 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// OTLP span kinds (opentelemetry/proto/trace/v1 Span.SpanKind)
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+const otlpStatusError = 2 // STATUS_CODE_ERROR
+
+// tracer records spans for requests, database statements and cache lookups and exports them to an
+// OpenTelemetry collector over OTLP/HTTP JSON, like metricsPusher does for metrics. Spans only exist
+// under a request's server span, so background jobs are not traced
+type tracer struct {
+	cfg      tracing_config
+	client   *http.Client
+	resource []otlpAttribute
+	queue    chan otlpSpan
+	done     chan struct{}
+	stopped  chan struct{}
+
+	spanCount *prometheus.CounterVec // tracing_spans_total by result (exported, failed, dropped)
+}
+
+func newTracer(cfg tracing_config) *tracer {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.URL == "" {
+		log.Warn().Msg("tracing has no url, tracing disabled")
+		return nil
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "auth-server"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 4096
+	}
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 5
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 10
+	}
+	resource := []otlpAttribute{otlpAttr("service.name", cfg.ServiceName)}
+	if hostname, err := os.Hostname(); err == nil {
+		resource = append(resource, otlpAttr("service.instance.id", hostname))
+	}
+	return &tracer{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+		resource: resource,
+		queue:    make(chan otlpSpan, cfg.QueueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Start exports finished spans in the background until Stop is called. Metrics must be registered first
+func (t *tracer) Start(spanCount *prometheus.CounterVec) {
+	if t == nil {
+		return
+	}
+	t.spanCount = spanCount
+	log.Info().Str("url", t.cfg.URL).Float64("sample_ratio", t.cfg.SampleRatio).Msg("Tracing started")
+	go func() {
+		defer close(t.stopped)
+		ticker := time.NewTicker(time.Duration(t.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		batch := make([]otlpSpan, 0, t.cfg.BatchSize)
+		for {
+			select {
+			case <-t.done:
+				// Export what the drained requests left behind
+				for {
+					select {
+					case sp := <-t.queue:
+						batch = append(batch, sp)
+						if len(batch) >= t.cfg.BatchSize {
+							batch = t.exportOnce(batch)
+						}
+					default:
+						t.exportOnce(batch)
+						return
+					}
+				}
+			case sp := <-t.queue:
+				batch = append(batch, sp)
+				if len(batch) >= t.cfg.BatchSize {
+					batch = t.exportOnce(batch)
+				}
+			case <-ticker.C:
+				batch = t.exportOnce(batch)
+			}
+		}
+	}()
+}
+
+// Stop exports the spans still queued and stops exporting
+func (t *tracer) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.done)
+	<-t.stopped
+}
+
+// exportOnce sends batch and returns it emptied for reuse
+func (t *tracer) exportOnce(batch []otlpSpan) []otlpSpan {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(t.cfg.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	result := "exported"
+	if err := t.Export(ctx, batch); err != nil {
+		result = "failed"
+		log.Warn().Err(err).Str("url", t.cfg.URL).Int("spans", len(batch)).Msg("Trace export failed")
+	}
+	t.count(result, len(batch))
+	return batch[:0]
+}
+
+func (t *tracer) count(result string, n int) {
+	if t.spanCount != nil {
+		t.spanCount.WithLabelValues(result).Add(float64(n))
+	}
+}
+
+// Export sends spans to the collector in one request
+func (t *tracer) Export(ctx context.Context, spans []otlpSpan) error {
+	body, err := json.Marshal(otlpTracesRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: t.resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "auth-server"}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range t.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// OTLP JSON messages (opentelemetry/proto/collector/trace/v1). Trace and span IDs are hex in the JSON
+// mapping rather than base64
+type (
+	otlpTracesRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Message string `json:"message,omitempty"`
+		Code    int    `json:"code"`
+	}
+)
+
+// span is one timed operation of a sampled trace. A nil span is a no-op, so call sites need not check
+// whether the request is traced
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for a trace's root span
+	name     string
+	kind     int
+	start    time.Time
+	attrs    []otlpAttribute
+	status   *otlpStatus
+}
+
+type spanKey struct{}
+
+// spanFromContext returns the span ctx belongs to, or nil outside a traced request
+func spanFromContext(ctx context.Context) *span {
+	sp, _ := ctx.Value(spanKey{}).(*span)
+	return sp
+}
+
+// startSpan starts a child of the span carried by ctx and returns a context carrying the child. Outside
+// a traced request it returns ctx and a nil span
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	sp := &span{tracer: parent.tracer, traceID: parent.traceID, parentID: parent.spanID, name: name, kind: kind, start: time.Now()}
+	rand.Read(sp.spanID[:])
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+// recordSpan records a finished leaf operation that began at start under the span carried by ctx, for
+// call sites that time the operation themselves
+func recordSpan(ctx context.Context, name string, kind int, start time.Time, err error, attrs ...otlpAttribute) {
+	_, sp := startSpan(ctx, name, kind)
+	if sp == nil {
+		return
+	}
+	sp.start = start
+	sp.attrs = attrs
+	sp.SetError(err)
+	sp.End()
+}
+
+// traceCacheLookup records a cache lookup that began at start
+func traceCacheLookup(ctx context.Context, cache string, start time.Time, hit bool) {
+	recordSpan(ctx, "cache.get "+cache, spanKindInternal, start, nil,
+		otlpAttr("cache.name", cache), otlpAttr("cache.hit", strconv.FormatBool(hit)))
+}
+
+// TraceID is the span's trace ID in hex, or "" for a nil span
+func (sp *span) TraceID() string {
+	if sp == nil {
+		return ""
+	}
+	return hex.EncodeToString(sp.traceID[:])
+}
+
+func (sp *span) SetAttr(key, value string) {
+	if sp == nil {
+		return
+	}
+	sp.attrs = append(sp.attrs, otlpAttr(key, value))
+}
+
+// SetError marks the span failed, when err is not nil
+func (sp *span) SetError(err error) {
+	if sp == nil || err == nil {
+		return
+	}
+	sp.status = &otlpStatus{Code: otlpStatusError, Message: err.Error()}
+}
+
+// End finishes the span and queues it for export, dropping it when the queue is full
+func (sp *span) End() {
+	if sp == nil {
+		return
+	}
+	finished := otlpSpan{
+		TraceID:           hex.EncodeToString(sp.traceID[:]),
+		SpanID:            hex.EncodeToString(sp.spanID[:]),
+		Name:              sp.name,
+		Kind:              sp.kind,
+		StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        sp.attrs,
+		Status:            sp.status,
+	}
+	if sp.parentID != [8]byte{} {
+		finished.ParentSpanID = hex.EncodeToString(sp.parentID[:])
+	}
+	select {
+	case sp.tracer.queue <- finished:
+	default:
+		sp.tracer.count("dropped", 1)
+	}
+}
+
+// parseTraceparent reads a W3C traceparent header: version-traceid-parentid-flags, all lowercase hex.
+// Unknown future versions are read as version 00, as the specification asks
+func parseTraceparent(header string) (traceID [16]byte, parentID [8]byte, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return traceID, parentID, false, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 || strings.ToLower(header) != header {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags[0]&1 == 1, true
+}
+
+// sampled decides whether a new trace is recorded. The decision is a function of the trace ID, as
+// OpenTelemetry's TraceIdRatioBased sampler makes it, so every service using the ratio agrees
+func (t *tracer) sampled(traceID [16]byte) bool {
+	ratio := t.cfg.SampleRatio
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < uint64(ratio*(1<<63))
+}
+
+// TracingMiddleware starts the server span of every sampled request, continuing the caller's trace
+// when the request carries a traceparent header. It runs first so the spans of everything after it,
+// including rejected requests, belong to the request
+func TracingMiddleware(t *tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if t == nil {
+			c.Next()
+			return
+		}
+
+		sp := &span{tracer: t, kind: spanKindServer, start: time.Now()}
+		traceID, parentID, sampled, ok := parseTraceparent(c.GetHeader("traceparent"))
+		if ok {
+			sp.traceID, sp.parentID = traceID, parentID
+		} else {
+			rand.Read(sp.traceID[:])
+			sampled = t.sampled(sp.traceID)
+		}
+		if !sampled {
+			c.Next()
+			return
+		}
+		rand.Read(sp.spanID[:])
+		// Trace Context Level 2: tell the caller which trace its request was recorded under
+		c.Header("traceresponse", "00-"+sp.TraceID()+"-"+hex.EncodeToString(sp.spanID[:])+"-01")
+
+		route := c.FullPath()
+		sp.name = c.Request.Method
+		if route != "" {
+			sp.name += " " + route
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), spanKey{}, sp))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		sp.attrs = append(sp.attrs,
+			otlpAttr("http.request.method", c.Request.Method),
+			otlpAttr("url.path", c.Request.URL.Path),
+			otlpAttr("http.response.status_code", strconv.Itoa(status)),
+			otlpAttr("client.address", c.ClientIP()),
+			otlpAttr("user_agent.original", c.Request.UserAgent()),
+		)
+		if route != "" {
+			sp.attrs = append(sp.attrs, otlpAttr("http.route", route))
+		}
+		if status >= 500 {
+			sp.status = &otlpStatus{Code: otlpStatusError, Message: http.StatusText(status)}
+		}
+		sp.End()
+	}
+}
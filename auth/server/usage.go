@@ -0,0 +1,477 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// UsageCounts are one client's counters over a day or a rolling window
+type UsageCounts struct {
+	TokensIssued int64 `json:"tokens_issued"`
+	Validations  int64 `json:"validations"`
+	Denials      int64 `json:"denials"`
+	Revocations  int64 `json:"revocations"`
+}
+
+func (u *UsageCounts) add(other UsageCounts) {
+	u.TokensIssued += other.TokensIssued
+	u.Validations += other.Validations
+	u.Denials += other.Denials
+	u.Revocations += other.Revocations
+}
+
+// ClientUsage is one client's activity on one (UTC) day
+type ClientUsage struct {
+	Date     string `json:"date"`
+	ClientID string `json:"client_id"`
+	UsageCounts
+}
+
+type usageKey struct {
+	day      string
+	clientID string
+}
+
+// Rolling windows kept per client: the last hour in 5-minute slots and the last day in hourly slots
+const (
+	usageHourSlot = 5 * time.Minute
+	usageDaySlot  = time.Hour
+)
+
+// usageRing counts over a rolling window split into fixed-width slots; a slot is reset when the
+// window comes round to it again
+type usageRing struct {
+	width time.Duration
+	slots []UsageCounts
+	ids   []int64 // which slot (time / width) each entry currently counts
+}
+
+func newUsageRing(width, window time.Duration) *usageRing {
+	n := int(window / width)
+	return &usageRing{width: width, slots: make([]UsageCounts, n), ids: make([]int64, n)}
+}
+
+func (r *usageRing) slot(at time.Time) *UsageCounts {
+	id := at.UnixNano() / int64(r.width)
+	i := int(id % int64(len(r.slots)))
+	if r.ids[i] != id {
+		r.slots[i], r.ids[i] = UsageCounts{}, id
+	}
+	return &r.slots[i]
+}
+
+// inWindow reports whether slot i still counts at now
+func (r *usageRing) inWindow(i int, now time.Time) bool {
+	return r.ids[i] > now.UnixNano()/int64(r.width)-int64(len(r.slots))
+}
+
+func (r *usageRing) sum(now time.Time) UsageCounts {
+	var total UsageCounts
+	for i := range r.slots {
+		if r.inWindow(i, now) {
+			total.add(r.slots[i])
+		}
+	}
+	return total
+}
+
+// untilIssuanceDrops is how long until the oldest slot with issued tokens leaves the window
+func (r *usageRing) untilIssuanceDrops(now time.Time) time.Duration {
+	var wait time.Duration
+	for i := range r.slots {
+		if !r.inWindow(i, now) || r.slots[i].TokensIssued == 0 {
+			continue
+		}
+		leaves := time.Unix(0, (r.ids[i]+int64(len(r.slots)))*int64(r.width))
+		if d := leaves.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+type clientUsageWindows struct {
+	hour *usageRing
+	day  *usageRing
+}
+
+// RollingUsage is one client's usage over the last hour and day, with the quota it is held to
+type RollingUsage struct {
+	ClientID      string      `json:"client_id"`
+	LastHour      UsageCounts `json:"last_hour"`
+	LastDay       UsageCounts `json:"last_day"`
+	TokensPerHour int64       `json:"tokens_per_hour_quota,omitempty"`
+	TokensPerDay  int64       `json:"tokens_per_day_quota,omitempty"`
+}
+
+// usageRecorder is the shared per-client usage aggregation: every issuance, validation, denial and
+// revocation is counted here once. Daily counters are buffered and merged into client_usage_daily
+// periodically, so request paths never wait on a DB write; rolling hour and day windows are kept in
+// memory for issuance quotas and the rolling usage report, and issuance and failed authentication
+// are passed on to the anomaly detector
+type usageRecorder struct {
+	mu         sync.Mutex
+	counts     map[usageKey]*ClientUsage
+	windows    map[string]*clientUsageWindows
+	cfg        usage_config
+	flushTick  *time.Ticker
+	done       chan struct{}
+	authServer *authServer
+}
+
+func newUsageRecorder(as *authServer, cfg usage_config) *usageRecorder {
+	if cfg.FlushIntervalSeconds <= 0 {
+		cfg.FlushIntervalSeconds = 60
+	}
+	ur := &usageRecorder{
+		counts:     make(map[usageKey]*ClientUsage),
+		windows:    make(map[string]*clientUsageWindows),
+		cfg:        cfg,
+		flushTick:  time.NewTicker(time.Duration(cfg.FlushIntervalSeconds) * time.Second),
+		done:       make(chan struct{}),
+		authServer: as,
+	}
+	go ur.backgroundFlush()
+	return ur
+}
+
+// record adds delta to clientID's counters for today and its rolling windows
+func (ur *usageRecorder) record(clientID string, delta UsageCounts) {
+	if ur == nil || clientID == "" {
+		return
+	}
+	now := time.Now()
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+	ur.addDaily(usageKey{day: now.UTC().Format(time.DateOnly), clientID: clientID}, delta)
+	if windows := ur.clientWindows(clientID); windows != nil {
+		windows.hour.slot(now).add(delta)
+		windows.day.slot(now).add(delta)
+	}
+}
+
+// addDaily adds delta to the buffered counters of key; callers hold mu
+func (ur *usageRecorder) addDaily(key usageKey, delta UsageCounts) {
+	usage, exists := ur.counts[key]
+	if !exists {
+		usage = &ClientUsage{Date: key.day, ClientID: key.clientID}
+		ur.counts[key] = usage
+	}
+	usage.add(delta)
+}
+
+// clientWindows returns clientID's rolling windows, creating them unless maxTrackedClients are
+// already tracked; callers hold mu
+func (ur *usageRecorder) clientWindows(clientID string) *clientUsageWindows {
+	if ur.windows == nil {
+		ur.windows = make(map[string]*clientUsageWindows)
+	}
+	windows, exists := ur.windows[clientID]
+	if !exists {
+		if len(ur.windows) >= maxTrackedClients {
+			return nil
+		}
+		windows = &clientUsageWindows{hour: newUsageRing(usageHourSlot, time.Hour), day: newUsageRing(usageDaySlot, 24*time.Hour)}
+		ur.windows[clientID] = windows
+	}
+	return windows
+}
+
+func (ur *usageRecorder) TokenIssued(clientID string) {
+	ur.record(clientID, UsageCounts{TokensIssued: 1})
+	if ur != nil && ur.authServer != nil {
+		ur.authServer.anomalies.RecordIssued(clientID)
+	}
+}
+
+func (ur *usageRecorder) Validated(clientID string) {
+	ur.record(clientID, UsageCounts{Validations: 1})
+}
+
+func (ur *usageRecorder) Denied(clientID string) {
+	ur.record(clientID, UsageCounts{Denials: 1})
+}
+
+func (ur *usageRecorder) Revoked(clientID string) {
+	ur.record(clientID, UsageCounts{Revocations: 1})
+}
+
+// AuthFailed passes a failed client authentication on to the anomaly detector. Callers only report
+// registered client IDs; it is not counted per client here, as the failure says nothing of the
+// client's own usage
+func (ur *usageRecorder) AuthFailed(clientID string) {
+	if ur != nil && ur.authServer != nil {
+		ur.authServer.anomalies.RecordFailedAuth(clientID)
+	}
+}
+
+// quota is the issuance quota clientID is held to
+func (ur *usageRecorder) quota(clientID string) usage_quota {
+	if quota, ok := ur.cfg.Clients[clientID]; ok {
+		return quota
+	}
+	return ur.cfg.Quota
+}
+
+// QuotaRetryAfter returns how long clientID must wait before its issuance quota allows another
+// token, or 0 if it may be issued one now
+func (ur *usageRecorder) QuotaRetryAfter(clientID string) time.Duration {
+	if ur == nil {
+		return 0
+	}
+	quota := ur.quota(clientID)
+	if quota.TokensPerHour <= 0 && quota.TokensPerDay <= 0 {
+		return 0
+	}
+	now := time.Now()
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+	windows, exists := ur.windows[clientID]
+	if !exists {
+		return 0
+	}
+	var wait time.Duration
+	if quota.TokensPerHour > 0 && windows.hour.sum(now).TokensIssued >= quota.TokensPerHour {
+		wait = max(wait, windows.hour.untilIssuanceDrops(now))
+	}
+	if quota.TokensPerDay > 0 && windows.day.sum(now).TokensIssued >= quota.TokensPerDay {
+		wait = max(wait, windows.day.untilIssuanceDrops(now))
+	}
+	return wait
+}
+
+// Rolling returns the rolling usage of clientID, or of every client with usage in the last day when
+// clientID is empty, ordered by client
+func (ur *usageRecorder) Rolling(clientID string) []RollingUsage {
+	report := make([]RollingUsage, 0)
+	if ur == nil {
+		return report
+	}
+	now := time.Now()
+
+	ur.mu.Lock()
+	for id, windows := range ur.windows {
+		if clientID != "" && id != clientID {
+			continue
+		}
+		quota := ur.quota(id)
+		report = append(report, RollingUsage{
+			ClientID:      id,
+			LastHour:      windows.hour.sum(now),
+			LastDay:       windows.day.sum(now),
+			TokensPerHour: quota.TokensPerHour,
+			TokensPerDay:  quota.TokensPerDay,
+		})
+	}
+	ur.mu.Unlock()
+
+	slices.SortFunc(report, func(a, b RollingUsage) int { return strings.Compare(a.ClientID, b.ClientID) })
+	return report
+}
+
+// Restore seeds the rolling day windows from today's persisted counters, so quotas hold across a
+// restart. They are counted from midnight UTC, which errs on the side of the quota
+func (ur *usageRecorder) Restore() error {
+	if ur == nil || ur.authServer.stateless != nil {
+		return nil
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	report, err := ur.authServer.clientUsage(today, today, "")
+	if err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+	for _, usage := range report {
+		if windows := ur.clientWindows(usage.ClientID); windows != nil {
+			windows.day.slot(today).add(usage.UsageCounts)
+		}
+	}
+	return nil
+}
+
+// Flush merges buffered counters into the database; counters that fail to persist are re-queued.
+// Clients idle for a whole day stop being tracked
+func (ur *usageRecorder) Flush() {
+	if ur == nil {
+		return
+	}
+	now := time.Now()
+	ur.mu.Lock()
+	pending := ur.counts
+	ur.counts = make(map[usageKey]*ClientUsage)
+	for clientID, windows := range ur.windows {
+		if windows.day.sum(now) == (UsageCounts{}) {
+			delete(ur.windows, clientID)
+		}
+	}
+	ur.mu.Unlock()
+
+	// Stateless mode has nowhere to persist daily counters; the rolling windows still hold quotas
+	if ur.authServer.stateless != nil {
+		return
+	}
+	for key, usage := range pending {
+		if err := ur.authServer.mergeClientUsage(usage); err != nil {
+			log.Error().Err(err).Str("client_id", key.clientID).Str("date", key.day).Msg("Failed to persist client usage, will retry")
+			ur.mu.Lock()
+			ur.addDaily(key, usage.UsageCounts)
+			ur.mu.Unlock()
+		}
+	}
+}
+
+func (ur *usageRecorder) backgroundFlush() {
+	for {
+		select {
+		case <-ur.done:
+			ur.flushTick.Stop()
+			ur.Flush()
+			return
+		case <-ur.flushTick.C:
+			ur.Flush()
+		}
+	}
+}
+
+// Stop stops the recorder after a final flush
+func (ur *usageRecorder) Stop() {
+	close(ur.done)
+}
+
+func (as *authServer) mergeClientUsage(usage *ClientUsage) error {
+	ctx, cancel := context.WithTimeout(as.ctx, 5*time.Second)
+	defer cancel()
+
+	day, err := time.Parse(time.DateOnly, usage.Date)
+	if err != nil {
+		return err
+	}
+
+	// MERGE ... FROM dual is Oracle's; the other drivers upsert with ON CONFLICT
+	if databaseDriver() != DriverOracle {
+		query := `INSERT INTO client_usage_daily (usage_date, client_id, tokens_issued, validations, denials, revocations) VALUES (:1, :2, :3, :4, :5, :6)
+		ON CONFLICT (usage_date, client_id) DO UPDATE SET tokens_issued = client_usage_daily.tokens_issued + excluded.tokens_issued, validations = client_usage_daily.validations + excluded.validations, denials = client_usage_daily.denials + excluded.denials, revocations = client_usage_daily.revocations + excluded.revocations`
+		_, err = as.db.ExecContext(ctx, query, day, usage.ClientID, usage.TokensIssued, usage.Validations, usage.Denials, usage.Revocations)
+		return err
+	}
+
+	query := `MERGE INTO client_usage_daily u
+		USING (SELECT :1 AS usage_date, :2 AS client_id FROM dual) s
+		ON (u.usage_date = s.usage_date AND u.client_id = s.client_id)
+		WHEN MATCHED THEN UPDATE SET tokens_issued = u.tokens_issued + :3, validations = u.validations + :4, denials = u.denials + :5, revocations = u.revocations + :6
+		WHEN NOT MATCHED THEN INSERT (usage_date, client_id, tokens_issued, validations, denials, revocations) VALUES (s.usage_date, s.client_id, :7, :8, :9, :10)`
+	_, err = as.db.ExecContext(ctx, query, day, usage.ClientID,
+		usage.TokensIssued, usage.Validations, usage.Denials, usage.Revocations,
+		usage.TokensIssued, usage.Validations, usage.Denials, usage.Revocations)
+	return err
+}
+
+func (as *authServer) clientUsage(from, to time.Time, clientID string) ([]*ClientUsage, error) {
+	ctx, cancel := context.WithTimeout(as.ctx, 30*time.Second)
+	defer cancel()
+
+	query := "SELECT usage_date, client_id, tokens_issued, validations, denials, revocations FROM client_usage_daily WHERE usage_date >= :1 AND usage_date <= :2"
+	args := []any{from, to}
+	if clientID != "" {
+		query += " AND client_id = :3"
+		args = append(args, clientID)
+	}
+	query += " ORDER BY usage_date, client_id"
+
+	rows, err := as.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("clientUsage: %v", err)
+	}
+	defer rows.Close()
+
+	report := make([]*ClientUsage, 0)
+	for rows.Next() {
+		usage := &ClientUsage{}
+		var day time.Time
+		if err := rows.Scan(&day, &usage.ClientID, &usage.TokensIssued, &usage.Validations, &usage.Denials, &usage.Revocations); err != nil {
+			return nil, fmt.Errorf("clientUsage: %v", err)
+		}
+		usage.Date = day.Format(time.DateOnly)
+		report = append(report, usage)
+	}
+	return report, rows.Err()
+}
+
+// Rolling usage handler (admin): each client's usage over the last hour and day, as counted in
+// memory by this instance, with its issuance quota
+func (as *authServer) rollingUsageHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"usage": as.usage.Rolling(c.Query("client_id"))})
+}
+
+// Usage report handler (admin): per-client daily counts as JSON or CSV (?format=csv).
+// from/to are inclusive YYYY-MM-DD dates and default to the last 30 days
+func (as *authServer) usageReportHandler(c *gin.Context) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.DateOnly, v); err != nil {
+			RespondWithError(c, ErrBadRequest("from must be a YYYY-MM-DD date"))
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.DateOnly, v); err != nil {
+			RespondWithError(c, ErrBadRequest("to must be a YYYY-MM-DD date"))
+			return
+		}
+	}
+	if to.Before(from) {
+		RespondWithError(c, ErrBadRequest("to must not be before from"))
+		return
+	}
+
+	// Include counters still buffered in memory
+	as.usage.Flush()
+
+	report, err := as.clientUsage(from, to, c.Query("client_id"))
+	if err != nil {
+		RespondWithError(c, HandleDatabaseError(err, GetRequestLogger(c)))
+		return
+	}
+
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{"from": from.Format(time.DateOnly), "to": to.Format(time.DateOnly), "usage": report})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=usage-%s-%s.csv", from.Format(time.DateOnly), to.Format(time.DateOnly)))
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"date", "client_id", "tokens_issued", "validations", "denials", "revocations"})
+	for _, usage := range report {
+		w.Write([]string{
+			usage.Date,
+			usage.ClientID,
+			strconv.FormatInt(usage.TokensIssued, 10),
+			strconv.FormatInt(usage.Validations, 10),
+			strconv.FormatInt(usage.Denials, 10),
+			strconv.FormatInt(usage.Revocations, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logger := GetRequestLogger(c)
+		logger.Error().Err(err).Msg("Failed to write usage CSV")
+	}
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// maxValidationResults bounds the result cache; once full, new results are simply not cached
+const maxValidationResults = 100000
+
+type validationResult struct {
+	claims    Claims
+	expiresAt time.Time
+}
+
+// validationResultCache remembers successful validateJWT results by token so repeated validations of
+// the same token skip signature checks and the revocation lookup. Only positive results are cached,
+// and revocation events evict them immediately
+type validationResultCache struct {
+	mu        sync.RWMutex
+	results   map[string]*validationResult // sha256(token) -> claims
+	byTokenID map[string]string            // token_id -> results key
+	ttl       atomic.Int64                 // nanoseconds; changed by a configuration reload
+}
+
+func newValidationResultCache(ttl time.Duration) *validationResultCache {
+	vc := &validationResultCache{
+		results:   make(map[string]*validationResult),
+		byTokenID: make(map[string]string),
+	}
+	vc.ttl.Store(int64(ttl))
+	return vc
+}
+
+// SetTTL changes how long results cached from now on are reused; zero stops caching new results
+func (vc *validationResultCache) SetTTL(ttl time.Duration) {
+	if vc == nil {
+		return
+	}
+	vc.ttl.Store(int64(ttl))
+}
+
+func validationResultKey(tokenString string) string {
+	sum := sha256.Sum256([]byte(tokenString))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns a copy of the cached claims for tokenString, if still fresh
+func (vc *validationResultCache) Get(tokenString string) (*Claims, bool) {
+	if vc == nil {
+		return nil, false
+	}
+	vc.mu.RLock()
+	result, exists := vc.results[validationResultKey(tokenString)]
+	vc.mu.RUnlock()
+	if !exists || time.Now().After(result.expiresAt) {
+		return nil, false
+	}
+	claims := result.claims
+	return &claims, true
+}
+
+// Set caches claims for tokenString until the TTL elapses or the token expires, whichever is first
+func (vc *validationResultCache) Set(tokenString string, claims *Claims) {
+	if vc == nil || claims == nil {
+		return
+	}
+	ttl := time.Duration(vc.ttl.Load())
+	if ttl <= 0 {
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	key := validationResultKey(tokenString)
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if _, exists := vc.results[key]; !exists && len(vc.results) >= maxValidationResults {
+		return
+	}
+	vc.results[key] = &validationResult{claims: *claims, expiresAt: expiresAt}
+	vc.byTokenID[claims.TokenID] = key
+}
+
+// Invalidate evicts the result cached for tokenID
+func (vc *validationResultCache) Invalidate(tokenID string) {
+	if vc == nil {
+		return
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if key, exists := vc.byTokenID[tokenID]; exists {
+		delete(vc.results, key)
+		delete(vc.byTokenID, tokenID)
+	}
+}
+
+// InvalidateClient evicts every result cached for clientID's tokens
+func (vc *validationResultCache) InvalidateClient(clientID string) int {
+	if vc == nil {
+		return 0
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	removed := 0
+	for key, result := range vc.results {
+		if result.claims.ClientID == clientID {
+			delete(vc.results, key)
+			delete(vc.byTokenID, result.claims.TokenID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Clear removes all cached results
+func (vc *validationResultCache) Clear() {
+	if vc == nil {
+		return
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.results = make(map[string]*validationResult)
+	vc.byTokenID = make(map[string]string)
+}
+
+// CleanExpired removes stale results
+func (vc *validationResultCache) CleanExpired() int {
+	if vc == nil {
+		return 0
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	removed := 0
+	now := time.Now()
+	for key, result := range vc.results {
+		if now.After(result.expiresAt) {
+			delete(vc.results, key)
+			delete(vc.byTokenID, result.claims.TokenID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// GetSize returns current number of cached results
+func (vc *validationResultCache) GetSize() int {
+	if vc == nil {
+		return 0
+	}
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+	return len(vc.results)
+}
+
+// RevocationEvent tells an instance that a token, every token of a client, or an API key has been
+// revoked
+type RevocationEvent struct {
+	TokenID   string    `json:"token_id,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	APIKeyID  string    `json:"api_key_id,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Reason    string    `json:"reason,omitempty"`
+	ExpiresAt time.Time `json:"-"` // of the token, when known; bounds how long the shared token state keeps it
+}
+
+func (e *RevocationEvent) Validate() error {
+	if e.TokenID == "" && e.ClientID == "" && e.APIKeyID == "" {
+		return fmt.Errorf("token_id, client_id or api_key_id is required")
+	}
+	if len(e.TokenID) > 255 {
+		return fmt.Errorf("token_id exceeds maximum length (255 characters)")
+	}
+	if len(e.ClientID) > 255 {
+		return fmt.Errorf("client_id exceeds maximum length (255 characters)")
+	}
+	if len(e.APIKeyID) > 255 {
+		return fmt.Errorf("api_key_id exceeds maximum length (255 characters)")
+	}
+	return nil
+}
+
+// applyRevocation evicts cached state for a revocation made here or reported by a peer, so the next
+// validation of the token re-reads its revocation status
+func (as *authServer) applyRevocation(event RevocationEvent) {
+	if event.APIKeyID != "" {
+		if as.apiKeyCache != nil {
+			as.apiKeyCache.Invalidate(event.APIKeyID)
+		}
+		return
+	}
+	if event.TokenID != "" {
+		as.validationResults.Invalidate(event.TokenID)
+		if as.tokenCache != nil {
+			as.tokenCache.Invalidate(event.TokenID)
+		}
+		return
+	}
+	if event.ClientID != "" {
+		as.validationResults.InvalidateClient(event.ClientID)
+		if as.tokenCache != nil {
+			as.tokenCache.RevokeClient(event.ClientID)
+		}
+	}
+}
+
+// notifyRevocationPeers forwards a local revocation to the shared token state and to
+// validation.revocation_peers. Delivery is best effort; a peer that misses the event stops accepting
+// the token once its cached result expires
+func (as *authServer) notifyRevocationPeers(event RevocationEvent) {
+	// API keys are never looked up in the shared token state; peers only need to drop the cached key
+	if as.tokenState != nil && event.APIKeyID == "" {
+		ctx, cancel := context.WithTimeout(as.ctx, time.Second)
+		if err := as.tokenState.Publish(ctx, event); err != nil {
+			log.Warn().Err(err).Str("token_id", event.TokenID).Str("client_id", event.ClientID).Msg("Failed to publish revocation to the shared token state")
+		}
+		cancel()
+	}
+	if len(AppConfig.Validation.RevocationPeers) == 0 {
+		return
+	}
+	headers := map[string]string{"X-Admin-Token": AppConfig.Admin.Token}
+	for _, peer := range AppConfig.Validation.RevocationPeers {
+		postJSON(strings.TrimSuffix(peer, "/")+"/auth-server/v1/admin/revocations", "revocation", event, headers)
+	}
+}
+
+// Revocation event handler (admin): receives revocations published by peer instances
+func (as *authServer) revocationEventHandler(c *gin.Context) {
+	var event RevocationEvent
+	if apiErr := decodeJSONBody(c, &event); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+	if apiErr := ValidateRequest(c, event.Validate); apiErr != nil {
+		RespondWithError(c, apiErr)
+		return
+	}
+
+	as.applyRevocation(event)
+	log.Debug().Str("token_id", event.TokenID).Str("client_id", event.ClientID).Msg("Applied revocation event from peer")
+	c.Status(http.StatusNoContent)
+}
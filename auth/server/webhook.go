@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// postWebhook POSTs payload as JSON to url in the background; kind names the alert in log messages.
+// Delivery is best effort: failures are logged and never block the caller
+func postWebhook(url, kind string, payload interface{}) {
+	postJSON(url, kind, payload, nil)
+}
+
+// postJSON is postWebhook with extra request headers, e.g. credentials for another instance's admin API
+func postJSON(url, kind string, payload interface{}, headers map[string]string) {
+	if url == "" {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Str("kind", kind).Msg("Failed to encode webhook payload")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			log.Error().Err(err).Str("kind", kind).Msg("Failed to build webhook request")
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Error().Err(err).Str("kind", kind).Msg("Failed to deliver webhook")
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Error().Int("status", resp.StatusCode).Str("kind", kind).Msg("Webhook rejected delivery")
+		}
+	}()
+}
@@ -0,0 +1,98 @@
+// Package store is the auth server's persistence boundary: the Store interface the request path reads
+// clients, endpoints and tokens through, and the models it exchanges. The server's SQL implementation
+// lives with the server; other backends implement Store and are set on it
+package store
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"time"
+)
+
+// Store persists the clients, endpoints and tokens the request path depends on. The server keeps its
+// caches, stateless mode and degraded mode in front of it, so implementations only talk to their backend
+type Store interface {
+	// ClientByID returns an active client without its group scopes applied; ErrNoSuchClient when there is none
+	ClientByID(ctx context.Context, clientID string) (*Clients, error)
+	// TokenInfo returns a token's type and revocation state; ErrTokenNotFound when it has no row
+	TokenInfo(ctx context.Context, tokenID string) (*Token, error)
+	// InsertTokens stores a batch of issued tokens atomically
+	InsertTokens(ctx context.Context, tokens []Token) error
+	// RevokeToken marks a stored token revoked
+	RevokeToken(ctx context.Context, revokedToken RevokedToken) error
+	// EndpointsByURL returns the active endpoint mappings for a URL, one per method; ErrNoSuchEndpoint when there are none
+	EndpointsByURL(ctx context.Context, url string) ([]*Endpoints, error)
+}
+
+// Lookups of rows that do not exist; callers match them with errors.Is
+var (
+	ErrNoSuchClient   = errors.New("no such client")
+	ErrNoSuchEndpoint = errors.New("no such endpoint")
+	// ErrTokenNotFound says the token has no row; unlike a failed lookup it says nothing about the backend's health
+	ErrTokenNotFound = errors.New("not found")
+)
+
+type Clients struct {
+	ClientID         string
+	ClientSecret     string
+	Name             string
+	AccessTokenTTL   int32
+	AllowedScopes    []string
+	DefaultScopes    []string          // granted when a token request names no scope; empty means all allowed scopes
+	JWTHeaders       map[string]string // header fields added to this client's tokens over jwt_headers
+	AllowedAudiences []string          // services this client's tokens may be restricted to; empty issues tokens without aud
+	PublicKey        crypto.PublicKey  // registered for private_key_jwt; a client with one cannot authenticate with its secret
+	TokenFormat      string            // TokenFormatJWT or TokenFormatOpaque; empty issues JWTs
+	Roles            []string          // carried in the roles claim; RoleAdmin and RoleAdminReadOnly open the admin API
+}
+
+// Scope modes for endpoints declaring several required scopes
+const (
+	ScopeModeAny = "ANY" // any one required scope grants access (default)
+	ScopeModeAll = "ALL" // every required scope must be granted
+)
+
+type Endpoints struct {
+	ID             int64    `json:"id,omitempty"`
+	ClientID       string   `json:"client_id"`
+	Scope          string   `json:"scope"`
+	RequiredScopes []string `json:"required_scopes,omitempty"`
+	ScopeMode      string   `json:"scope_mode,omitempty"`
+	Method         string   `json:"method"`
+	Url            string   `json:"api_url"`
+	Description    string   `json:"description"`
+	Active         int      `json:"active"`
+}
+
+// Scopes returns the scopes the endpoint requires, falling back to the single scope column
+func (e *Endpoints) Scopes() []string {
+	if len(e.RequiredScopes) > 0 {
+		return e.RequiredScopes
+	}
+	return []string{e.Scope}
+}
+
+type Token struct {
+	TokenID   string    `json:"token_id"`
+	TokenType string    `json:"token_type"`
+	JWT_token string    `json:"jwt"`
+	ClientID  string    `json:"client_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked"`
+	RevokedAt time.Time
+	// RevocationReason is one of the server's RevocationReason constants once revoked
+	RevocationReason string   `json:"revocation_reason,omitempty"`
+	FamilyID         string   `json:"family_id"` // lineage shared with the refresh tokens issued for it
+	RequestID        string   `json:"-"`         // request that issued it, for correlating batch writer logs; not stored
+	Scopes           []string `json:"-"`         // as signed, after any pipeline hooks; not stored
+}
+
+type RevokedToken struct {
+	ClientID  string    `json:"client_id"`
+	TokenID   string    `json:"token_id"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"-"` // when the token expires, bounding how long stateless mode lists it
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
-	"auth/auth"
+	"auth/auth/server"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 
@@ -16,17 +18,38 @@ var (
 )
 
 func main() {
-	if err := auth.ReadConfiguration(); err != nil {
-		fmt.Println("failed to load configuration")
+	configPath := flag.String("config", "", "JSON, YAML or TOML config file; defaults to auth-server-config in ./config, ../config or ../../config")
+	flag.Parse()
+
+	if err := server.ReadConfiguration(*configPath); err != nil {
+		fmt.Println("failed to load configuration:", err)
+		// Falling back to defaults is only for when no file was asked for
+		if *configPath != "" {
+			os.Exit(1)
+		}
 	}
 
-	log = auth.GetLogger()
-	log.Debug().Msgf("config loaded successfully: %v", auth.AppConfig)
+	log = server.GetLogger()
+	log.Debug().Interface("config", server.RedactedConfig()).Msg("config loaded successfully")
+
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
 
-	authServer := auth.NewAuthServer()
+	authServer := server.NewAuthServer()
 	authServer.Start()
 	var wg sync.WaitGroup
 
+	// SIGHUP applies configuration changes that don't need a restart
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			log.Info().Msg("Received SIGHUP, reloading configuration")
+			authServer.ReloadConfiguration()
+		}
+	}()
+
 	wg.Go(func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
@@ -40,3 +63,87 @@ func main() {
 
 	wg.Wait()
 }
+
+// runCommand runs a maintenance subcommand instead of the server and returns the exit code
+func runCommand(args []string) int {
+	switch {
+	case len(args) >= 2 && args[0] == "db" && args[1] == "init":
+		flags := flag.NewFlagSet("db init", flag.ExitOnError)
+		driver := flags.String("driver", "", "database driver to create the schema for (oracle, postgres or sqlite); defaults to database.driver")
+		dryRun := flags.Bool("dry-run", false, "print the DDL instead of executing it")
+		flags.Parse(args[2:])
+
+		if err := server.InitDatabaseSchema(*driver, *dryRun, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "db init failed:", err)
+			return 1
+		}
+		return 0
+	case len(args) >= 2 && args[0] == "db" && args[1] == "migrate":
+		flags := flag.NewFlagSet("db migrate", flag.ExitOnError)
+		status := flags.Bool("status", false, "list the migrations and whether each is applied")
+		dryRun := flags.Bool("dry-run", false, "print the pending migrations' DDL instead of executing it")
+		flags.Parse(args[2:])
+
+		if err := server.MigrateDatabase(*status, *dryRun, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "db migrate failed:", err)
+			return 1
+		}
+		return 0
+	case len(args) >= 2 && args[0] == "config" && args[1] == "validate":
+		flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+		path := flags.String("config", "", "config file to validate; defaults to the server's search path")
+		flags.Parse(args[2:])
+
+		if err := server.ValidateConfigFile(*path, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "config validate failed:", err)
+			return 1
+		}
+		return 0
+	case len(args) >= 2 && args[0] == "tokens" && args[1] == "import-revocations":
+		flags := flag.NewFlagSet("tokens import-revocations", flag.ExitOnError)
+		file := flags.String("file", "", "CSV or newline-delimited list of token IDs to revoke")
+		job := flags.String("job", "", "resume this interrupted import instead of starting a new one")
+		reason := flags.String("reason", "compromise", "revocation reason: compromise, admin or rotation")
+		batchSize := flags.Int("batch-size", 500, "token IDs revoked per batch (at most 1000)")
+		adminURL := flags.String("admin-url", "", "base URL of the server's admin listener; defaults to this host's admin.listen_address")
+		flags.Parse(args[2:])
+
+		if *file == "" {
+			fmt.Fprintln(os.Stderr, "tokens import-revocations: --file is required")
+			return 2
+		}
+		if err := server.ImportRevocations(*adminURL, *file, *job, *reason, *batchSize, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "tokens import-revocations failed:", err)
+			return 1
+		}
+		return 0
+	case len(args) >= 2 && args[0] == "clients" && args[1] == "hash-secrets":
+		flags := flag.NewFlagSet("clients hash-secrets", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "list the clients whose secrets are stored unhashed instead of hashing them")
+		flags.Parse(args[2:])
+
+		if err := server.HashClientSecrets(*dryRun, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "clients hash-secrets failed:", err)
+			return 1
+		}
+		return 0
+	case len(args) >= 2 && args[0] == "bundle" && args[1] == "export":
+		flags := flag.NewFlagSet("bundle export", flag.ExitOnError)
+		key := flags.String("key", "", "PEM RSA, ECDSA or Ed25519 private key to sign the bundle with")
+		validFor := flags.Duration("valid-for", 0, "how long stateless nodes accept the bundle, e.g. 168h; 0 never expires")
+		flags.Parse(args[2:])
+
+		if *key == "" {
+			fmt.Fprintln(os.Stderr, "bundle export: --key is required")
+			return 2
+		}
+		if err := server.ExportStatelessBundle(*key, *validFor, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "bundle export failed:", err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\nusage: auth [-config file] [db init [--driver oracle|postgres|sqlite] [--dry-run] | db migrate [--status] [--dry-run] | config validate [--config file] | tokens import-revocations --file ids.csv [--job id] | clients hash-secrets [--dry-run] | bundle export --key signer.pem]\n", strings.Join(args, " "))
+		return 2
+	}
+}